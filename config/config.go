@@ -1,37 +1,205 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	BotToken           string
-	AdminID            int64
-	TGBaseURL          string
-	TGProxy            string
-	GeminiAPIKeys      []string
-	OpenWrtHost        string
-	OpenWrtPort        int
-	OpenWrtUser        string
-	OpenWrtPass        string
-	OpenWrtKeyFile     string
-	OpenClashAPIURL    string
-	OpenClashAPISecret string
-	AdgURL             string
-	AdgUser            string
-	AdgPass            string
-	AdgToken           string
-	AdgLeasesMode      string
-}
-
-var AppConfig *Config
-
-func LoadConfig() {
+	BotToken              string
+	AdminID               int64
+	AdminIDs              []int64
+	TGBaseURL             string
+	TGProxy               string
+	WebhookURL            string
+	WebhookListen         string
+	WebhookSecret         string
+	WebhookCert           string
+	WebhookKey            string
+	WebhookPublicCert     string
+	GeminiAPIKeys         []string
+	Routers               []RouterProfile
+	OpenClashAPIURL       string
+	OpenClashAPISecret    string
+	AdgURL                string
+	AdgUser               string
+	AdgPass               string
+	AdgToken              string
+	AdgLeasesMode         string
+	SessionBackend        string
+	SessionFile           string
+	ConfirmWindow         int
+	IPCheckInterval       time.Duration
+	RouterMonitorInterval time.Duration
+	DriftInterval         time.Duration
+	DriftPackages         []string
+	AlertRules            []AlertRule
+	NotifyChatID          int64
+	FileAllowlist         []string
+	BackupKeep            int
+	BackupMaxAge          time.Duration
+	FirmwareIndexURL      string
+	FirmwareCheckInterval time.Duration
+	NotifyChannels        []NotifyChannel
+	HealthListen          string
+}
+
+// NotifyChannel is one destination for alerts. Which fields apply depends
+// on Type: telegram (chat_id, thread_id), webhook (url, headers), smtp
+// (host, port, username, password, from, to), ntfy (url, topic, token) and
+// gotify (url, token).
+type NotifyChannel struct {
+	Name        string   `yaml:"name"`
+	Type        string   `yaml:"type"`
+	MinSeverity string   `yaml:"min_severity"`
+	Categories  []string `yaml:"categories"`
+
+	ChatID   int64 `yaml:"chat_id"`
+	ThreadID int   `yaml:"thread_id"`
+
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Topic   string            `yaml:"topic"`
+	Token   string            `yaml:"token"`
+
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// RouterProfile is one OpenWrt device. The first profile is the main
+// router: IP monitoring and DHCP leases use it. Transport is "ssh" or
+// "ubus" (JSON-RPC on uhttpd, logging in to rpcd with User and Password).
+// Over SSH, the key file, ssh-agent keys and the password are tried in
+// that order; Jump ("[user@]host[:port]") names a bastion to connect
+// through with the same credentials.
+type RouterProfile struct {
+	Name              string        `yaml:"name"`
+	Host              string        `yaml:"host"`
+	Port              int           `yaml:"port"`
+	User              string        `yaml:"user"`
+	Password          string        `yaml:"password"`
+	KeyFile           string        `yaml:"key_file"`
+	KeyPassphrase     string        `yaml:"key_passphrase"`
+	KeyPassphraseFile string        `yaml:"key_passphrase_file"`
+	Jump              string        `yaml:"jump"`
+	KeepAlive         time.Duration `yaml:"keepalive"`
+	Transport         string        `yaml:"transport"`
+	UbusURL           string        `yaml:"ubus_url"`
+}
+
+// JumpHost returns the user and address of the bastion named by Jump,
+// defaulting to the router's user and port 22.
+func (r RouterProfile) JumpHost() (user, addr string, err error) {
+	user, host := r.User, r.Jump
+	if u, h, ok := strings.Cut(host, "@"); ok {
+		user, host = u, h
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	h, port, err := net.SplitHostPort(host)
+	if err != nil || h == "" || user == "" {
+		return "", "", fmt.Errorf("invalid jump host %q, expected [user@]host[:port]", r.Jump)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", "", fmt.Errorf("invalid jump host port %q", port)
+	}
+	return user, host, nil
+}
+
+// Router returns the profile with the given name.
+func (c *Config) Router(name string) (RouterProfile, bool) {
+	for _, r := range c.Routers {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return RouterProfile{}, false
+}
+
+// MainRouter returns the first router profile.
+func (c *Config) MainRouter() RouterProfile {
+	if len(c.Routers) == 0 {
+		return RouterProfile{}
+	}
+	return c.Routers[0]
+}
+
+var routerNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+var uciNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// AlertRule raises an alert when Metric stays above (Op ">") or below
+// (Op "<") Threshold for at least For. Metrics: temp (°C), mem (% used),
+// load (1-minute load average) and load_per_core.
+type AlertRule struct {
+	Name      string        `yaml:"name"`
+	Metric    string        `yaml:"metric"`
+	Op        string        `yaml:"op"`
+	Threshold float64       `yaml:"threshold"`
+	Recover   *float64      `yaml:"recover"`
+	For       time.Duration `yaml:"for"`
+	Severity  string        `yaml:"severity"`
+}
+
+// RecoverAt returns the value the metric has to get back to before a firing
+// alert clears. Without an explicit recover value it is 10% short of the
+// threshold.
+func (r AlertRule) RecoverAt() float64 {
+	if r.Recover != nil {
+		return *r.Recover
+	}
+	if r.Op == "<" {
+		return r.Threshold * 1.1
+	}
+	return r.Threshold * 0.9
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// DefaultAlertRules are used when monitors.rules is not set.
+func DefaultAlertRules() []AlertRule {
+	return []AlertRule{
+		{Name: "temp", Metric: "temp", Threshold: 80, Recover: ptr(75.0), For: 5 * time.Minute, Severity: "warning"},
+		{Name: "mem", Metric: "mem", Threshold: 90, Recover: ptr(85.0), For: 5 * time.Minute, Severity: "warning"},
+		{Name: "load", Metric: "load_per_core", Threshold: 2, Recover: ptr(1.5), For: 5 * time.Minute, Severity: "warning"},
+	}
+}
+
+var alertMetrics = map[string]bool{"temp": true, "mem": true, "load": true, "load_per_core": true}
+
+var current atomic.Pointer[Config]
+
+// Get returns the running configuration. It may be replaced by Reload at
+// any time, so read it once per operation and use that snapshot throughout.
+func Get() *Config {
+	return current.Load()
+}
+
+// Set replaces the running configuration, e.g. in tests.
+func Set(cfg *Config) {
+	current.Store(cfg)
+}
+
+// ConfigFile is the optional YAML configuration. Environment variables
+// override the values it contains.
+var ConfigFile = "data/config.yaml"
+
+func LoadConfig() error {
 	godotenv.Load(".env")
 
 	// Set timezone to Asia/Shanghai
@@ -46,59 +214,394 @@ func LoadConfig() {
 		}
 	}
 
-	AppConfig = &Config{
-		BotToken:           os.Getenv("TG_BOT_TOKEN"),
-		AdminID:            getEnvAsInt("ADMIN_ID", 0),
-		TGBaseURL:          os.Getenv("TG_BASE_URL"),
-		TGProxy:            os.Getenv("TG_PROXY"),
-		GeminiAPIKeys:      getEnvAsSlice("GEMINI_API_KEY"),
-		OpenWrtHost:        os.Getenv("OPENWRT_HOST"),
-		OpenWrtPort:        int(getEnvAsInt("OPENWRT_PORT", 22)),
-		OpenWrtUser:        getEnvAsIntStr("OPENWRT_USER", "root"),
-		OpenWrtPass:        os.Getenv("OPENWRT_PASS"),
-		OpenWrtKeyFile:     os.Getenv("OPENWRT_KEY_FILE"),
-		OpenClashAPIURL:    getEnvAsIntStr("OPENCLASH_API_URL", "http://127.0.0.1:9090"),
-		OpenClashAPISecret: os.Getenv("OPENCLASH_API_SECRET"),
-		AdgURL:             os.Getenv("ADG_URL"),
-		AdgUser:            os.Getenv("ADG_USER"),
-		AdgPass:            os.Getenv("ADG_PASS"),
-		AdgToken:           os.Getenv("ADG_TOKEN"),
-		AdgLeasesMode:      getEnvAsIntStr("ADG_LEASES_MODE", "auto"),
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		ConfigFile = path
 	}
 
-	if AppConfig.BotToken == "" {
-		AppConfig.BotToken = os.Getenv("BOT_TOKEN")
+	cfg, err := load()
+	if err != nil {
+		return err
 	}
+	current.Store(cfg)
+	return nil
 }
 
-func getEnvAsInt(key string, defaultVal int64) int64 {
+// Reload re-reads the configuration file and environment and returns the
+// previous configuration. The running configuration is only replaced when
+// the new one is valid.
+func Reload() (*Config, error) {
+	cfg, err := load()
+	if err != nil {
+		return nil, err
+	}
+	return current.Swap(cfg), nil
+}
+
+func load() (*Config, error) {
+	cfg := defaults()
+
+	if err := loadFile(ConfigFile, cfg); err != nil {
+		return nil, err
+	}
+
+	env := &envLoader{}
+	env.str("TG_BOT_TOKEN", &cfg.BotToken)
+	if cfg.BotToken == "" {
+		env.str("BOT_TOKEN", &cfg.BotToken)
+	}
+	env.intSlice("ADMIN_ID", &cfg.AdminIDs)
+	env.str("TG_BASE_URL", &cfg.TGBaseURL)
+	env.str("TG_PROXY", &cfg.TGProxy)
+	env.str("TG_WEBHOOK_URL", &cfg.WebhookURL)
+	env.str("TG_WEBHOOK_LISTEN", &cfg.WebhookListen)
+	env.str("TG_WEBHOOK_SECRET", &cfg.WebhookSecret)
+	env.str("TG_WEBHOOK_CERT", &cfg.WebhookCert)
+	env.str("TG_WEBHOOK_KEY", &cfg.WebhookKey)
+	env.str("TG_WEBHOOK_PUBLIC_CERT", &cfg.WebhookPublicCert)
+	env.slice("GEMINI_API_KEY", &cfg.GeminiAPIKeys)
+	// OPENWRT_* configure the main router.
+	if len(cfg.Routers) == 0 {
+		cfg.Routers = []RouterProfile{{Name: "main"}}
+	}
+	primary := &cfg.Routers[0]
+	env.str("OPENWRT_HOST", &primary.Host)
+	env.int("OPENWRT_PORT", &primary.Port)
+	env.str("OPENWRT_USER", &primary.User)
+	env.str("OPENWRT_PASS", &primary.Password)
+	env.str("OPENWRT_KEY_FILE", &primary.KeyFile)
+	env.str("OPENWRT_KEY_PASSPHRASE", &primary.KeyPassphrase)
+	env.str("OPENWRT_KEY_PASSPHRASE_FILE", &primary.KeyPassphraseFile)
+	env.str("OPENWRT_JUMP", &primary.Jump)
+	env.duration("OPENWRT_KEEPALIVE", &primary.KeepAlive)
+	env.str("OPENWRT_TRANSPORT", &primary.Transport)
+	env.str("OPENWRT_UBUS_URL", &primary.UbusURL)
+	for i := range cfg.Routers {
+		r := &cfg.Routers[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("router%d", i+1)
+		}
+		if r.Port == 0 {
+			r.Port = 22
+		}
+		if r.User == "" {
+			r.User = "root"
+		}
+		if r.Transport == "" {
+			r.Transport = "ssh"
+		}
+		if r.KeepAlive == 0 {
+			r.KeepAlive = 30 * time.Second
+		}
+		if r.UbusURL == "" && r.Host != "" {
+			r.UbusURL = "http://" + r.Host + "/ubus"
+		}
+	}
+	env.str("OPENCLASH_API_URL", &cfg.OpenClashAPIURL)
+	env.str("OPENCLASH_API_SECRET", &cfg.OpenClashAPISecret)
+	env.str("ADG_URL", &cfg.AdgURL)
+	env.str("ADG_USER", &cfg.AdgUser)
+	env.str("ADG_PASS", &cfg.AdgPass)
+	env.str("ADG_TOKEN", &cfg.AdgToken)
+	env.str("ADG_LEASES_MODE", &cfg.AdgLeasesMode)
+	env.str("SESSION_BACKEND", &cfg.SessionBackend)
+	env.str("SESSION_FILE", &cfg.SessionFile)
+	env.int("CONFIRM_WINDOW", &cfg.ConfirmWindow)
+	env.duration("IP_CHECK_INTERVAL", &cfg.IPCheckInterval)
+	env.duration("ROUTER_MONITOR_INTERVAL", &cfg.RouterMonitorInterval)
+	env.duration("DRIFT_CHECK_INTERVAL", &cfg.DriftInterval)
+	env.slice("DRIFT_PACKAGES", &cfg.DriftPackages)
+	env.int64("NOTIFY_CHAT_ID", &cfg.NotifyChatID)
+	env.str("HEALTH_LISTEN", &cfg.HealthListen)
+	env.slice("FILE_ALLOWLIST", &cfg.FileAllowlist)
+	env.int("BACKUP_KEEP", &cfg.BackupKeep)
+	env.duration("BACKUP_MAX_AGE", &cfg.BackupMaxAge)
+	env.str("FIRMWARE_INDEX_URL", &cfg.FirmwareIndexURL)
+	env.duration("FIRMWARE_CHECK_INTERVAL", &cfg.FirmwareCheckInterval)
+
+	// The first admin receives notifications such as IP changes.
+	if len(cfg.AdminIDs) > 0 {
+		cfg.AdminID = cfg.AdminIDs[0]
+	}
+	if cfg.NotifyChatID == 0 {
+		cfg.NotifyChatID = cfg.AdminID
+	}
+
+	errs := append(env.errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return cfg, nil
+}
+
+func defaults() *Config {
+	return &Config{
+		WebhookListen:         ":8443",
+		OpenClashAPIURL:       "http://127.0.0.1:9090",
+		AdgLeasesMode:         "auto",
+		SessionBackend:        "file",
+		SessionFile:           "data/sessions.json",
+		ConfirmWindow:         90,
+		IPCheckInterval:       60 * time.Second,
+		RouterMonitorInterval: 60 * time.Second,
+		DriftInterval:         15 * time.Minute,
+		DriftPackages:         []string{"firewall", "network", "dhcp", "wireless"},
+		AlertRules:            DefaultAlertRules(),
+		FileAllowlist:         []string{"/etc/config", "/root/smart"},
+		BackupKeep:            10,
+		BackupMaxAge:          90 * 24 * time.Hour,
+		FirmwareIndexURL:      "https://downloads.openwrt.org/.versions.json",
+		FirmwareCheckInterval: 24 * time.Hour,
+	}
+}
+
+func (c *Config) validate() []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.BotToken == "" {
+		fail("bot.token (TG_BOT_TOKEN) is required")
+	} else if !strings.Contains(c.BotToken, ":") {
+		fail("bot.token (TG_BOT_TOKEN) does not look like a bot token (expected <id>:<secret>)")
+	}
+	if len(c.AdminIDs) == 0 {
+		fail("bot.admin_ids (ADMIN_ID) must list at least one user ID")
+	}
+	routerNames := make(map[string]bool)
+	for i, r := range c.Routers {
+		field := fmt.Sprintf("routers[%d]", i)
+		if !routerNamePattern.MatchString(r.Name) {
+			fail("%s.name may only contain A-Z, a-z, 0-9, _ and - (1-32 chars), got %q", field, r.Name)
+		}
+		if routerNames[r.Name] {
+			fail("%s: duplicate router name %q", field, r.Name)
+		}
+		routerNames[r.Name] = true
+		if r.Port < 1 || r.Port > 65535 {
+			if i == 0 {
+				field += " (OPENWRT_PORT)"
+			}
+			fail("%s.port must be between 1 and 65535, got %d", field, r.Port)
+		}
+		if r.Host == "" && len(c.Routers) > 1 {
+			fail("%s.host is required when several routers are configured", field)
+		}
+		if r.KeyPassphrase != "" && r.KeyPassphraseFile != "" {
+			fail("routers[%d]: key_passphrase and key_passphrase_file are mutually exclusive", i)
+		}
+		if r.Jump != "" {
+			if _, _, err := r.JumpHost(); err != nil {
+				fail("routers[%d].jump: %v", i, err)
+			}
+		}
+		if r.KeepAlive < 5*time.Second {
+			fail("routers[%d].keepalive must be at least 5s, got %s", i, r.KeepAlive)
+		}
+		switch r.Transport {
+		case "ssh":
+		case "ubus":
+			if r.Password == "" {
+				fail("routers[%d]: the ubus transport needs a password for the rpcd login", i)
+			}
+		default:
+			fail("routers[%d].transport must be ssh or ubus, got %q", i, r.Transport)
+		}
+	}
+	switch c.AdgLeasesMode {
+	case "auto", "api":
+	default:
+		fail("adguard.leases_mode (ADG_LEASES_MODE) must be auto or api, got %q", c.AdgLeasesMode)
+	}
+	switch c.SessionBackend {
+	case "file", "memory":
+	default:
+		fail("bot.session.backend (SESSION_BACKEND) must be file or memory, got %q", c.SessionBackend)
+	}
+	if c.ConfirmWindow < 0 {
+		fail("bot.confirm_window (CONFIRM_WINDOW) must not be negative")
+	}
+	if c.IPCheckInterval < 10*time.Second {
+		fail("monitors.ip_check_interval (IP_CHECK_INTERVAL) must be at least 10s, got %s", c.IPCheckInterval)
+	}
+	if c.RouterMonitorInterval != 0 && c.RouterMonitorInterval < 10*time.Second {
+		fail("monitors.router_interval (ROUTER_MONITOR_INTERVAL) must be 0 (off) or at least 10s, got %s", c.RouterMonitorInterval)
+	}
+	if c.DriftInterval != 0 && c.DriftInterval < time.Minute {
+		fail("monitors.drift_interval (DRIFT_CHECK_INTERVAL) must be 0 (off) or at least 1m, got %s", c.DriftInterval)
+	}
+	for i, p := range c.DriftPackages {
+		if !uciNamePattern.MatchString(p) {
+			fail("monitors.drift_packages[%d] (DRIFT_PACKAGES) is not a valid UCI package name: %q", i, p)
+		}
+	}
+	for i, p := range c.FileAllowlist {
+		if !path.IsAbs(p) || path.Clean(p) != p {
+			fail("files.allow[%d] (FILE_ALLOWLIST) must be a clean absolute path, got %q", i, p)
+		}
+	}
+	if c.BackupKeep < 1 {
+		fail("backups.keep (BACKUP_KEEP) must be at least 1, got %d", c.BackupKeep)
+	}
+	if c.BackupMaxAge < 0 {
+		fail("backups.max_age (BACKUP_MAX_AGE) must be 0 (off) or positive, got %s", c.BackupMaxAge)
+	}
+	if c.FirmwareIndexURL == "" {
+		fail("firmware.index_url (FIRMWARE_INDEX_URL) must not be empty")
+	}
+	if c.FirmwareCheckInterval != 0 && c.FirmwareCheckInterval < time.Hour {
+		fail("firmware.check_interval (FIRMWARE_CHECK_INTERVAL) must be 0 (off) or at least 1h, got %s", c.FirmwareCheckInterval)
+	}
+	ruleNames := make(map[string]bool)
+	for i, r := range c.AlertRules {
+		field := fmt.Sprintf("monitors.rules[%d]", i)
+		if !uciNamePattern.MatchString(r.Name) {
+			fail("%s.name may only contain letters, digits, '_' and '-', got %q", field, r.Name)
+		}
+		if ruleNames[r.Name] {
+			fail("%s: duplicate rule name %q", field, r.Name)
+		}
+		ruleNames[r.Name] = true
+		if !alertMetrics[r.Metric] {
+			fail("%s.metric must be temp, mem, load or load_per_core, got %q", field, r.Metric)
+		}
+		switch r.Op {
+		case "", ">", "<":
+		default:
+			fail("%s.op must be > or <, got %q", field, r.Op)
+		}
+		if r.For < 0 {
+			fail("%s.for must not be negative", field)
+		}
+		switch r.Severity {
+		case "", "info", "warning", "critical":
+		default:
+			fail("%s.severity must be info, warning or critical, got %q", field, r.Severity)
+		}
+	}
+	if (c.WebhookCert == "") != (c.WebhookKey == "") {
+		fail("bot.webhook.cert and bot.webhook.key (TG_WEBHOOK_CERT/TG_WEBHOOK_KEY) must be set together")
+	}
+
+	names := make(map[string]bool)
+	for i, ch := range c.NotifyChannels {
+		field := fmt.Sprintf("notifications.channels[%d]", i)
+		if names[ch.Name] {
+			fail("%s: duplicate channel name %q", field, ch.Name)
+		}
+		names[ch.Name] = true
+		switch ch.MinSeverity {
+		case "", "info", "warning", "critical":
+		default:
+			fail("%s.min_severity must be info, warning or critical, got %q", field, ch.MinSeverity)
+		}
+		switch ch.Type {
+		case "telegram":
+		case "webhook":
+			if ch.URL == "" {
+				fail("%s.url is required for webhook channels", field)
+			}
+		case "smtp":
+			if ch.Host == "" || ch.From == "" || len(ch.To) == 0 {
+				fail("%s: host, from and to are required for smtp channels", field)
+			}
+			if ch.Port < 0 || ch.Port > 65535 {
+				fail("%s.port must be between 1 and 65535, got %d", field, ch.Port)
+			}
+		case "ntfy":
+			if ch.URL == "" || ch.Topic == "" {
+				fail("%s: url and topic are required for ntfy channels", field)
+			}
+		case "gotify":
+			if ch.URL == "" || ch.Token == "" {
+				fail("%s: url and token are required for gotify channels", field)
+			}
+		default:
+			fail("%s.type must be telegram, webhook, smtp, ntfy or gotify, got %q", field, ch.Type)
+		}
+	}
+	return errs
+}
+
+// envLoader overrides config values with the environment variables that are
+// set, collecting parse errors instead of silently keeping the default.
+type envLoader struct {
+	errs []error
+}
+
+func (l *envLoader) str(key string, dst *string) {
+	if v := os.Getenv(key); v != "" {
+		*dst = v
+	}
+}
+
+func (l *envLoader) int(key string, dst *int) {
+	var v int64
+	if l.parseInt(key, &v) {
+		*dst = int(v)
+	}
+}
+
+func (l *envLoader) int64(key string, dst *int64) {
+	l.parseInt(key, dst)
+}
+
+func (l *envLoader) parseInt(key string, dst *int64) bool {
 	valueStr := os.Getenv(key)
 	if valueStr == "" {
-		return defaultVal
+		return false
 	}
-	value, err := strconv.ParseInt(valueStr, 10, 64)
+	value, err := strconv.ParseInt(strings.TrimSpace(valueStr), 10, 64)
 	if err != nil {
-		return defaultVal
+		l.errs = append(l.errs, fmt.Errorf("%s: %q is not an integer", key, valueStr))
+		return false
 	}
-	return value
+	*dst = value
+	return true
 }
 
-func getEnvAsIntStr(key string, defaultVal string) string {
+func (l *envLoader) duration(key string, dst *time.Duration) {
 	valueStr := os.Getenv(key)
 	if valueStr == "" {
-		return defaultVal
+		return
+	}
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %q is not a duration (e.g. 60s, 5m)", key, valueStr))
+		return
 	}
-	return valueStr
+	*dst = value
 }
 
-func getEnvAsSlice(key string) []string {
-	valueStr := os.Getenv(key)
+func (l *envLoader) slice(key string, dst *[]string) {
+	if v := splitList(os.Getenv(key)); len(v) > 0 {
+		*dst = v
+	}
+}
+
+func (l *envLoader) intSlice(key string, dst *[]int64) {
+	parts := splitList(os.Getenv(key))
+	if len(parts) == 0 {
+		return
+	}
+	var result []int64
+	for _, p := range parts {
+		value, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s: %q is not a user ID", key, p))
+			continue
+		}
+		result = append(result, value)
+	}
+	*dst = result
+}
+
+func splitList(valueStr string) []string {
 	if valueStr == "" {
-		return []string{}
+		return nil
 	}
-	parts := strings.Split(valueStr, ",")
 	var result []string
-	for _, p := range parts {
+	for _, p := range strings.Split(valueStr, ",") {
 		if trimmed := strings.TrimSpace(p); trimmed != "" {
 			result = append(result, trimmed)
 		}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a configuration that passes validate.
+func validConfig() *Config {
+	cfg := defaults()
+	cfg.BotToken = "123:abc"
+	cfg.AdminIDs = []int64{1}
+	cfg.Routers = []RouterProfile{{Name: "main", Host: "192.168.1.1", Port: 22, User: "root", Transport: "ssh", KeepAlive: 30 * time.Second}}
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(c *Config)
+		want   string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"no token", func(c *Config) { c.BotToken = "" }, "bot.token (TG_BOT_TOKEN) is required"},
+		{"malformed token", func(c *Config) { c.BotToken = "abc" }, "does not look like a bot token"},
+		{"no admins", func(c *Config) { c.AdminIDs = nil }, "bot.admin_ids"},
+		{"router name", func(c *Config) { c.Routers[0].Name = "main router" }, "routers[0].name"},
+		{"duplicate router", func(c *Config) { c.Routers = append(c.Routers, c.Routers[0]) }, "duplicate router name"},
+		{"port", func(c *Config) { c.Routers[0].Port = 70000 }, "routers[0] (OPENWRT_PORT).port"},
+		{"host with several routers", func(c *Config) {
+			second := c.Routers[0]
+			second.Name, second.Host = "edge", ""
+			c.Routers = append(c.Routers, second)
+		}, "routers[1].host is required"},
+		{"passphrase twice", func(c *Config) { c.Routers[0].KeyPassphrase, c.Routers[0].KeyPassphraseFile = "a", "b" }, "mutually exclusive"},
+		{"jump host", func(c *Config) { c.Routers[0].Jump = "bastion:99999" }, "routers[0].jump"},
+		{"keepalive", func(c *Config) { c.Routers[0].KeepAlive = time.Second }, "keepalive must be at least 5s"},
+		{"ubus without password", func(c *Config) { c.Routers[0].Transport = "ubus" }, "needs a password"},
+		{"transport", func(c *Config) { c.Routers[0].Transport = "telnet" }, "must be ssh or ubus"},
+		{"leases mode", func(c *Config) { c.AdgLeasesMode = "dhcp" }, "adguard.leases_mode"},
+		{"session backend", func(c *Config) { c.SessionBackend = "redis" }, "bot.session.backend"},
+		{"confirm window", func(c *Config) { c.ConfirmWindow = -1 }, "bot.confirm_window"},
+		{"ip interval", func(c *Config) { c.IPCheckInterval = time.Second }, "monitors.ip_check_interval"},
+		{"router interval off", func(c *Config) { c.RouterMonitorInterval = 0 }, ""},
+		{"router interval", func(c *Config) { c.RouterMonitorInterval = time.Second }, "monitors.router_interval"},
+		{"drift interval", func(c *Config) { c.DriftInterval = time.Second }, "monitors.drift_interval"},
+		{"drift package", func(c *Config) { c.DriftPackages = []string{"network;reboot"} }, "drift_packages[0]"},
+		{"allowlist", func(c *Config) { c.FileAllowlist = []string{"/etc/../root"} }, "files.allow[0]"},
+		{"relative allowlist", func(c *Config) { c.FileAllowlist = []string{"etc/config"} }, "files.allow[0]"},
+		{"backup keep", func(c *Config) { c.BackupKeep = 0 }, "backups.keep"},
+		{"backup age", func(c *Config) { c.BackupMaxAge = -time.Hour }, "backups.max_age"},
+		{"firmware index", func(c *Config) { c.FirmwareIndexURL = "" }, "firmware.index_url"},
+		{"firmware interval", func(c *Config) { c.FirmwareCheckInterval = time.Minute }, "firmware.check_interval"},
+		{"rule name", func(c *Config) { c.AlertRules[0].Name = "cpu|1h" }, "monitors.rules[0].name"},
+		{"empty rule name", func(c *Config) { c.AlertRules[0].Name = "" }, "monitors.rules[0].name"},
+		{"duplicate rule", func(c *Config) { c.AlertRules[1].Name = c.AlertRules[0].Name }, "duplicate rule name"},
+		{"rule metric", func(c *Config) { c.AlertRules[0].Metric = "disk" }, "monitors.rules[0].metric"},
+		{"rule op", func(c *Config) { c.AlertRules[0].Op = ">=" }, "monitors.rules[0].op"},
+		{"rule for", func(c *Config) { c.AlertRules[0].For = -time.Second }, "monitors.rules[0].for"},
+		{"rule severity", func(c *Config) { c.AlertRules[0].Severity = "fatal" }, "monitors.rules[0].severity"},
+		{"webhook cert without key", func(c *Config) { c.WebhookCert = "cert.pem" }, "must be set together"},
+		{"channel type", func(c *Config) { c.NotifyChannels = []NotifyChannel{{Name: "a", Type: "sms"}} }, "channels[0].type"},
+		{"duplicate channel", func(c *Config) {
+			c.NotifyChannels = []NotifyChannel{{Name: "a", Type: "telegram"}, {Name: "a", Type: "telegram"}}
+		}, "duplicate channel name"},
+		{"webhook channel", func(c *Config) { c.NotifyChannels = []NotifyChannel{{Name: "a", Type: "webhook"}} }, "url is required"},
+		{"ntfy channel", func(c *Config) { c.NotifyChannels = []NotifyChannel{{Name: "a", Type: "ntfy", URL: "https://ntfy.sh"}} }, "url and topic"},
+		{"channel severity", func(c *Config) {
+			c.NotifyChannels = []NotifyChannel{{Name: "a", Type: "telegram", MinSeverity: "loud"}}
+		}, "min_severity"},
+	}
+	for _, tt := range tests {
+		cfg := validConfig()
+		tt.mutate(cfg)
+		errs := cfg.validate()
+		if tt.want == "" {
+			if len(errs) != 0 {
+				t.Errorf("%s: validate = %v, want no errors", tt.name, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.want) {
+			t.Errorf("%s: validate = %v, want one error containing %q", tt.name, errs, tt.want)
+		}
+	}
+}
+
+func writeFile(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeFile(t, `
+bot:
+  token: "123:abc"
+  admin_ids: [1, 2]
+  confirm_window: 0
+routers:
+  - name: main
+    host: 192.168.1.1
+monitors:
+  drift_interval: 0s
+  rules:
+    - metric: temp
+      threshold: 80
+backups:
+  keep: 3
+`)
+	cfg := defaults()
+	if err := loadFile(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BotToken != "123:abc" || len(cfg.AdminIDs) != 2 || cfg.BackupKeep != 3 {
+		t.Errorf("loaded %q %v keep %d, want the file values", cfg.BotToken, cfg.AdminIDs, cfg.BackupKeep)
+	}
+	// Explicit zero values override the defaults; absent keys keep them.
+	if cfg.ConfirmWindow != 0 || cfg.DriftInterval != 0 {
+		t.Errorf("confirm_window %d, drift_interval %s; want both 0", cfg.ConfirmWindow, cfg.DriftInterval)
+	}
+	if cfg.BackupMaxAge != 90*24*time.Hour || cfg.SessionBackend != "file" {
+		t.Errorf("max_age %s, session backend %q; want the defaults", cfg.BackupMaxAge, cfg.SessionBackend)
+	}
+	if len(cfg.AlertRules) != 1 || cfg.AlertRules[0].Name != "temp1" {
+		t.Errorf("rules = %+v, want one rule named temp1", cfg.AlertRules)
+	}
+
+	if err := loadFile(filepath.Join(t.TempDir(), "missing.yaml"), cfg); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	for _, text := range []string{
+		"bot:\n  tokn: x\n",
+		"bot:\n  admin_ids: abc\n",
+		"monitors:\n  drift_interval: often\n",
+		"routers: {name: main}\n",
+		"bot: [\n",
+	} {
+		if err := loadFile(writeFile(t, text), defaults()); err == nil {
+			t.Errorf("loadFile(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	for _, key := range []string{"TG_BOT_TOKEN", "BOT_TOKEN", "ADMIN_ID", "OPENWRT_HOST", "CONFIRM_WINDOW"} {
+		t.Setenv(key, "")
+	}
+	old := ConfigFile
+	t.Cleanup(func() { ConfigFile = old })
+
+	running := validConfig()
+	Set(running)
+
+	ConfigFile = writeFile(t, "bot:\n  token: \"123:abc\"\n  admin_ids: [7]\n  confirm_window: -5\n")
+	if _, err := Reload(); err == nil || !strings.Contains(err.Error(), "confirm_window") {
+		t.Errorf("Reload of an invalid file = %v, want a confirm_window error", err)
+	}
+	if Get() != running {
+		t.Error("an invalid configuration replaced the running one")
+	}
+
+	t.Setenv("CONFIRM_WINDOW", "soon")
+	ConfigFile = writeFile(t, "bot:\n  token: \"123:abc\"\n  admin_ids: [7]\n")
+	if _, err := Reload(); err == nil || !strings.Contains(err.Error(), "CONFIRM_WINDOW") {
+		t.Errorf("Reload with a bad environment value = %v, want a CONFIRM_WINDOW error", err)
+	}
+
+	t.Setenv("CONFIRM_WINDOW", "30")
+	prev, err := Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev != running {
+		t.Error("Reload did not return the previous configuration")
+	}
+	cfg := Get()
+	if cfg.ConfirmWindow != 30 || cfg.AdminID != 7 || cfg.NotifyChatID != 7 || cfg.Routers[0].Name != "main" {
+		t.Errorf("reloaded window %d, admin %d, chat %d, routers %+v", cfg.ConfirmWindow, cfg.AdminID, cfg.NotifyChatID, cfg.Routers)
+	}
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig mirrors the layout of data/config.yaml. Pointer fields tell
+// "not set" apart from zero values so that defaults survive.
+type fileConfig struct {
+	Bot           botSection           `yaml:"bot"`
+	Routers       []RouterProfile      `yaml:"routers"`
+	AdGuard       adguardSection       `yaml:"adguard"`
+	OpenClash     openclashSection     `yaml:"openclash"`
+	AI            aiSection            `yaml:"ai"`
+	Monitors      monitorsSection      `yaml:"monitors"`
+	Notifications notificationsSection `yaml:"notifications"`
+	Files         filesSection         `yaml:"files"`
+	Backups       backupsSection       `yaml:"backups"`
+	Firmware      firmwareSection      `yaml:"firmware"`
+}
+
+type botSection struct {
+	Token         string         `yaml:"token"`
+	AdminIDs      []int64        `yaml:"admin_ids"`
+	BaseURL       string         `yaml:"base_url"`
+	Proxy         string         `yaml:"proxy"`
+	ConfirmWindow *int           `yaml:"confirm_window"`
+	HealthListen  string         `yaml:"health_listen"`
+	Webhook       webhookSection `yaml:"webhook"`
+	Session       sessionSection `yaml:"session"`
+}
+
+type webhookSection struct {
+	URL        string `yaml:"url"`
+	Listen     string `yaml:"listen"`
+	Secret     string `yaml:"secret"`
+	Cert       string `yaml:"cert"`
+	Key        string `yaml:"key"`
+	PublicCert string `yaml:"public_cert"`
+}
+
+type sessionSection struct {
+	Backend string `yaml:"backend"`
+	File    string `yaml:"file"`
+}
+
+type adguardSection struct {
+	URL        string `yaml:"url"`
+	User       string `yaml:"user"`
+	Password   string `yaml:"password"`
+	Token      string `yaml:"token"`
+	LeasesMode string `yaml:"leases_mode"`
+}
+
+type openclashSection struct {
+	APIURL string `yaml:"api_url"`
+	Secret string `yaml:"secret"`
+}
+
+type aiSection struct {
+	GeminiAPIKeys []string `yaml:"gemini_api_keys"`
+}
+
+type monitorsSection struct {
+	IPCheckInterval time.Duration  `yaml:"ip_check_interval"`
+	RouterInterval  *time.Duration `yaml:"router_interval"`
+	DriftInterval   *time.Duration `yaml:"drift_interval"`
+	DriftPackages   []string       `yaml:"drift_packages"`
+	Rules           []AlertRule    `yaml:"rules"`
+}
+
+type filesSection struct {
+	Allow []string `yaml:"allow"`
+}
+
+type backupsSection struct {
+	Keep   int            `yaml:"keep"`
+	MaxAge *time.Duration `yaml:"max_age"`
+}
+
+type firmwareSection struct {
+	IndexURL      string         `yaml:"index_url"`
+	CheckInterval *time.Duration `yaml:"check_interval"`
+}
+
+type notificationsSection struct {
+	ChatID   int64           `yaml:"chat_id"`
+	Channels []NotifyChannel `yaml:"channels"`
+}
+
+// loadFile applies the YAML file at path to cfg. A missing file is not an
+// error; unknown keys and type mismatches are.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+
+	var fc fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	setStr(&cfg.BotToken, fc.Bot.Token)
+	if len(fc.Bot.AdminIDs) > 0 {
+		cfg.AdminIDs = fc.Bot.AdminIDs
+	}
+	setStr(&cfg.TGBaseURL, fc.Bot.BaseURL)
+	setStr(&cfg.TGProxy, fc.Bot.Proxy)
+	if fc.Bot.ConfirmWindow != nil {
+		cfg.ConfirmWindow = *fc.Bot.ConfirmWindow
+	}
+	setStr(&cfg.HealthListen, fc.Bot.HealthListen)
+	setStr(&cfg.WebhookURL, fc.Bot.Webhook.URL)
+	setStr(&cfg.WebhookListen, fc.Bot.Webhook.Listen)
+	setStr(&cfg.WebhookSecret, fc.Bot.Webhook.Secret)
+	setStr(&cfg.WebhookCert, fc.Bot.Webhook.Cert)
+	setStr(&cfg.WebhookKey, fc.Bot.Webhook.Key)
+	setStr(&cfg.WebhookPublicCert, fc.Bot.Webhook.PublicCert)
+	setStr(&cfg.SessionBackend, fc.Bot.Session.Backend)
+	setStr(&cfg.SessionFile, fc.Bot.Session.File)
+
+	if len(fc.Routers) > 0 {
+		cfg.Routers = fc.Routers
+	}
+
+	setStr(&cfg.AdgURL, fc.AdGuard.URL)
+	setStr(&cfg.AdgUser, fc.AdGuard.User)
+	setStr(&cfg.AdgPass, fc.AdGuard.Password)
+	setStr(&cfg.AdgToken, fc.AdGuard.Token)
+	setStr(&cfg.AdgLeasesMode, fc.AdGuard.LeasesMode)
+
+	setStr(&cfg.OpenClashAPIURL, fc.OpenClash.APIURL)
+	setStr(&cfg.OpenClashAPISecret, fc.OpenClash.Secret)
+
+	if len(fc.AI.GeminiAPIKeys) > 0 {
+		cfg.GeminiAPIKeys = fc.AI.GeminiAPIKeys
+	}
+
+	if fc.Monitors.IPCheckInterval != 0 {
+		cfg.IPCheckInterval = fc.Monitors.IPCheckInterval
+	}
+	if fc.Monitors.RouterInterval != nil {
+		cfg.RouterMonitorInterval = *fc.Monitors.RouterInterval
+	}
+	if fc.Monitors.DriftInterval != nil {
+		cfg.DriftInterval = *fc.Monitors.DriftInterval
+	}
+	if len(fc.Monitors.DriftPackages) > 0 {
+		cfg.DriftPackages = fc.Monitors.DriftPackages
+	}
+	if fc.Monitors.Rules != nil {
+		cfg.AlertRules = fc.Monitors.Rules
+		for i := range cfg.AlertRules {
+			r := &cfg.AlertRules[i]
+			if r.Name == "" {
+				r.Name = fmt.Sprintf("%s%d", r.Metric, i+1)
+			}
+		}
+	}
+	if len(fc.Files.Allow) > 0 {
+		cfg.FileAllowlist = fc.Files.Allow
+	}
+	if fc.Backups.Keep != 0 {
+		cfg.BackupKeep = fc.Backups.Keep
+	}
+	if fc.Backups.MaxAge != nil {
+		cfg.BackupMaxAge = *fc.Backups.MaxAge
+	}
+	setStr(&cfg.FirmwareIndexURL, fc.Firmware.IndexURL)
+	if fc.Firmware.CheckInterval != nil {
+		cfg.FirmwareCheckInterval = *fc.Firmware.CheckInterval
+	}
+	if fc.Notifications.ChatID != 0 {
+		cfg.NotifyChatID = fc.Notifications.ChatID
+	}
+	cfg.NotifyChannels = fc.Notifications.Channels
+	for i := range cfg.NotifyChannels {
+		ch := &cfg.NotifyChannels[i]
+		if ch.Name == "" {
+			ch.Name = fmt.Sprintf("%s%d", ch.Type, i+1)
+		}
+	}
+	return nil
+}
+
+func setStr(dst *string, v string) {
+	if v != "" {
+		*dst = v
+	}
+}
@@ -1,12 +1,24 @@
 package main
 
 import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/yingxiaomo/homeops/config"
 	"github.com/yingxiaomo/homeops/pkg/bot"
 )
 
 func main() {
-	config.LoadConfig()
+	if err := config.LoadConfig(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	b := bot.NewBot()
-	b.Start()
+	b.Start(ctx)
 }
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"sync"
 
 	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/metrics"
 
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
@@ -22,7 +24,7 @@ type GeminiClient struct {
 
 func NewGeminiClient() *GeminiClient {
 	return &GeminiClient{
-		apiKeys: config.AppConfig.GeminiAPIKeys,
+		apiKeys: config.Get().GeminiAPIKeys,
 		models: []string{
 			"gemini-3-pro-preview",
 			"gemini-2.5-pro",
@@ -34,6 +36,14 @@ func NewGeminiClient() *GeminiClient {
 	}
 }
 
+// SetKeys replaces the API keys, e.g. after the configuration was reloaded.
+func (c *GeminiClient) SetKeys(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apiKeys = keys
+	c.currentKeyIndex = 0
+}
+
 func (c *GeminiClient) rotateKey() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -67,6 +77,7 @@ func (c *GeminiClient) GenerateContent(ctx context.Context, prompt string, image
 
 		for {
 			key := c.getCurrentKey()
+			keyIndex := strconv.Itoa(c.currentKeyIndex)
 			log.Printf("Attempting model: %s with key index: %d", modelName, c.currentKeyIndex)
 
 			client, err := genai.NewClient(ctx, option.WithAPIKey(key))
@@ -92,8 +103,10 @@ func (c *GeminiClient) GenerateContent(ctx context.Context, prompt string, image
 			}()
 
 			if genErr == nil {
+				metrics.GeminiRequests.Inc(modelName, keyIndex, "ok")
 				return printResponse(resp), nil
 			}
+			metrics.GeminiRequests.Inc(modelName, keyIndex, "error")
 
 			lastErr = genErr
 			log.Printf("Error with model %s: %v", modelName, genErr)
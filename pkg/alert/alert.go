@@ -0,0 +1,145 @@
+package alert
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+)
+
+// Kind tells whether a Transition raised or cleared an alert.
+type Kind int
+
+const (
+	Fired Kind = iota
+	Recovered
+)
+
+// Transition is emitted when a rule starts or stops firing.
+type Transition struct {
+	Kind    Kind
+	Rule    config.AlertRule
+	Value   float64
+	Since   time.Time
+	Snoozed bool
+}
+
+type state struct {
+	pendingSince time.Time
+	firingSince  time.Time
+	firing       bool
+}
+
+// Engine evaluates threshold rules against samples. A rule fires once its
+// condition has held for Rule.For and recovers only once the value crosses
+// back past Rule.Recover, so values hovering at the threshold do not flap.
+type Engine struct {
+	mu      sync.Mutex
+	rules   []config.AlertRule
+	states  map[string]*state
+	snoozed map[string]time.Time
+}
+
+func NewEngine(rules []config.AlertRule) *Engine {
+	e := &Engine{states: make(map[string]*state), snoozed: make(map[string]time.Time)}
+	e.SetRules(rules)
+	return e
+}
+
+// SetRules replaces the rules, keeping the state of rules whose name did
+// not change.
+func (e *Engine) SetRules(rules []config.AlertRule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = rules
+	states := make(map[string]*state, len(rules))
+	for _, r := range rules {
+		if st, ok := e.states[r.Name]; ok {
+			states[r.Name] = st
+		} else {
+			states[r.Name] = &state{}
+		}
+	}
+	e.states = states
+}
+
+// Evaluate checks every rule whose metric is present in sample and returns
+// the resulting transitions.
+func (e *Engine) Evaluate(sample map[string]float64, now time.Time) []Transition {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var out []Transition
+	for _, r := range e.rules {
+		v, ok := sample[r.Metric]
+		if !ok {
+			continue
+		}
+		st := e.states[r.Name]
+
+		if !st.firing {
+			if !breached(r, v) {
+				st.pendingSince = time.Time{}
+				continue
+			}
+			if st.pendingSince.IsZero() {
+				st.pendingSince = now
+			}
+			if now.Sub(st.pendingSince) >= r.For {
+				st.firing = true
+				st.firingSince = st.pendingSince
+				out = append(out, Transition{Kind: Fired, Rule: r, Value: v, Since: st.pendingSince, Snoozed: e.isSnoozed(r.Name, now)})
+			}
+			continue
+		}
+
+		if recovered(r, v) {
+			st.firing = false
+			st.pendingSince = time.Time{}
+			out = append(out, Transition{Kind: Recovered, Rule: r, Value: v, Since: st.firingSince, Snoozed: e.isSnoozed(r.Name, now)})
+		}
+	}
+	return out
+}
+
+// Snooze suppresses notifications for the named rule until until.
+func (e *Engine) Snooze(name string, until time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.snoozed[name] = until
+}
+
+func (e *Engine) isSnoozed(name string, now time.Time) bool {
+	until, ok := e.snoozed[name]
+	if ok && now.After(until) {
+		delete(e.snoozed, name)
+		return false
+	}
+	return ok
+}
+
+// Rule returns the rule with the given name.
+func (e *Engine) Rule(name string) (config.AlertRule, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range e.rules {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return config.AlertRule{}, false
+}
+
+func breached(r config.AlertRule, v float64) bool {
+	if r.Op == "<" {
+		return v < r.Threshold
+	}
+	return v > r.Threshold
+}
+
+func recovered(r config.AlertRule, v float64) bool {
+	if r.Op == "<" {
+		return v >= r.RecoverAt()
+	}
+	return v <= r.RecoverAt()
+}
@@ -0,0 +1,158 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+)
+
+var t0 = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+type step struct {
+	at    time.Duration
+	value float64
+	// want is "fired", "recovered" or "" for no transition.
+	want string
+}
+
+func run(t *testing.T, e *Engine, metric string, steps []step) []Transition {
+	t.Helper()
+	var all []Transition
+	for _, s := range steps {
+		out := e.Evaluate(map[string]float64{metric: s.value}, t0.Add(s.at))
+		got := ""
+		if len(out) > 1 {
+			t.Fatalf("at %s: %d transitions, want at most one", s.at, len(out))
+		}
+		if len(out) == 1 {
+			got = map[Kind]string{Fired: "fired", Recovered: "recovered"}[out[0].Kind]
+			all = append(all, out[0])
+		}
+		if got != s.want {
+			t.Errorf("at %s value %v: got %q, want %q", s.at, s.value, got, s.want)
+		}
+	}
+	return all
+}
+
+func TestEvaluate(t *testing.T) {
+	recover150 := 150.0
+	tests := []struct {
+		name  string
+		rule  config.AlertRule
+		steps []step
+	}{
+		{
+			name: "pending then firing after For",
+			rule: config.AlertRule{Name: "cpu", Metric: "cpu", Op: ">", Threshold: 90, For: 2 * time.Minute},
+			steps: []step{
+				{0, 95, ""},
+				{time.Minute, 95, ""},
+				{2 * time.Minute, 95, "fired"},
+				{3 * time.Minute, 95, ""},
+			},
+		},
+		{
+			name: "a dip restarts the pending period",
+			rule: config.AlertRule{Name: "cpu", Metric: "cpu", Op: ">", Threshold: 90, For: 2 * time.Minute},
+			steps: []step{
+				{0, 95, ""},
+				{time.Minute, 80, ""},
+				{2 * time.Minute, 95, ""},
+				{3 * time.Minute, 95, ""},
+				{4 * time.Minute, 95, "fired"},
+			},
+		},
+		{
+			name: "hovering at the threshold does not flap",
+			rule: config.AlertRule{Name: "cpu", Metric: "cpu", Op: ">", Threshold: 90},
+			steps: []step{
+				{0, 91, "fired"},
+				{time.Minute, 89, ""},
+				{2 * time.Minute, 91, ""},
+				{3 * time.Minute, 85, ""},
+				{4 * time.Minute, 81, "recovered"},
+				{5 * time.Minute, 85, ""},
+				{6 * time.Minute, 90, ""},
+				{7 * time.Minute, 91, "fired"},
+			},
+		},
+		{
+			name: "below threshold with an explicit recover value",
+			rule: config.AlertRule{Name: "mem", Metric: "mem_free", Op: "<", Threshold: 100, Recover: &recover150},
+			steps: []step{
+				{0, 120, ""},
+				{time.Minute, 90, "fired"},
+				{2 * time.Minute, 120, ""},
+				{3 * time.Minute, 149, ""},
+				{4 * time.Minute, 150, "recovered"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run(t, NewEngine([]config.AlertRule{tt.rule}), tt.rule.Metric, tt.steps)
+		})
+	}
+}
+
+func TestEvaluateSince(t *testing.T) {
+	e := NewEngine([]config.AlertRule{{Name: "cpu", Metric: "cpu", Op: ">", Threshold: 90, For: time.Minute}})
+	out := run(t, e, "cpu", []step{
+		{0, 95, ""},
+		{time.Minute, 95, "fired"},
+		{5 * time.Minute, 10, "recovered"},
+	})
+	for _, tr := range out {
+		if !tr.Since.Equal(t0) {
+			t.Errorf("%v transition since %s, want the start of the breach %s", tr.Kind, tr.Since, t0)
+		}
+	}
+}
+
+func TestEvaluateMissingMetric(t *testing.T) {
+	e := NewEngine([]config.AlertRule{{Name: "temp", Metric: "temp", Op: ">", Threshold: 80}})
+	if out := e.Evaluate(map[string]float64{"cpu": 99}, t0); len(out) != 0 {
+		t.Errorf("transitions = %v, want none without the metric", out)
+	}
+	run(t, e, "temp", []step{{time.Minute, 85, "fired"}})
+}
+
+func TestSnooze(t *testing.T) {
+	e := NewEngine([]config.AlertRule{{Name: "cpu", Metric: "cpu", Op: ">", Threshold: 90}})
+	e.Snooze("cpu", t0.Add(10*time.Minute))
+
+	out := run(t, e, "cpu", []step{
+		{0, 95, "fired"},
+		{5 * time.Minute, 50, "recovered"},
+		{11 * time.Minute, 95, "fired"},
+	})
+	for i, want := range []bool{true, true, false} {
+		if out[i].Snoozed != want {
+			t.Errorf("transition %d snoozed = %v, want %v", i, out[i].Snoozed, want)
+		}
+	}
+	if _, ok := e.snoozed["cpu"]; ok {
+		t.Error("expired snooze was not removed")
+	}
+}
+
+func TestSetRulesKeepsState(t *testing.T) {
+	rule := config.AlertRule{Name: "cpu", Metric: "cpu", Op: ">", Threshold: 90}
+	e := NewEngine([]config.AlertRule{rule})
+	run(t, e, "cpu", []step{{0, 95, "fired"}})
+
+	// Reloading the same rule must not announce it again.
+	e.SetRules([]config.AlertRule{rule})
+	run(t, e, "cpu", []step{{time.Minute, 95, ""}})
+
+	// A renamed rule starts from scratch.
+	renamed := rule
+	renamed.Name = "cpu_high"
+	e.SetRules([]config.AlertRule{renamed})
+	run(t, e, "cpu", []step{{2 * time.Minute, 95, "fired"}})
+	if _, ok := e.Rule("cpu"); ok {
+		t.Error("Rule(cpu) found after it was removed")
+	}
+}
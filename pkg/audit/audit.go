@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const AuditFile = "data/audit.jsonl"
+
+// Entry is one line of the audit trail.
+type Entry struct {
+	Time      time.Time         `json:"time"`
+	UserID    int64             `json:"user_id"`
+	Username  string            `json:"username,omitempty"`
+	Subsystem string            `json:"subsystem"`
+	Action    string            `json:"action"`
+	Target    string            `json:"target,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	OK        bool              `json:"ok"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// Filter narrows Query results. Zero values match everything.
+type Filter struct {
+	UserID    int64
+	Subsystem string
+}
+
+func (f Filter) match(e Entry) bool {
+	if f.UserID != 0 && e.UserID != f.UserID {
+		return false
+	}
+	if f.Subsystem != "" && e.Subsystem != f.Subsystem {
+		return false
+	}
+	return true
+}
+
+var fileMu sync.Mutex
+
+// Record appends e to the audit file.
+func Record(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error marshalling audit entry: %v", err)
+		return
+	}
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(AuditFile), 0755); err != nil {
+		log.Printf("Error creating audit dir: %v", err)
+		return
+	}
+	f, err := os.OpenFile(AuditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Error opening audit file: %v", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// Log records an action performed by the sender of c. err is the outcome of
+// the action; nil means it succeeded.
+func Log(c tele.Context, subsystem, action, target string, params map[string]string, err error) {
+	e := Entry{
+		Subsystem: subsystem,
+		Action:    action,
+		Target:    target,
+		Params:    params,
+		OK:        err == nil,
+	}
+	if user := c.Sender(); user != nil {
+		e.UserID = user.ID
+		e.Username = user.Username
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	log.Printf("Audit: user %d %s.%s %s ok=%t", e.UserID, subsystem, action, target, e.OK)
+	Record(e)
+}
+
+// Query returns up to limit entries matching f, newest first, skipping the
+// first offset matches, together with the total number of matches.
+func Query(f Filter, offset, limit int) ([]Entry, int, error) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	file, err := os.Open(AuditFile)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var matched []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if f.match(e) {
+			matched = append(matched, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	total := len(matched)
+	var page []Entry
+	for i := total - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, matched[i])
+	}
+	return page, total, nil
+}
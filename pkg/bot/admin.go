@@ -2,8 +2,15 @@ package bot
 
 import (
 	"fmt"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/notify"
+	"github.com/yingxiaomo/homeops/pkg/openwrt"
 	"github.com/yingxiaomo/homeops/pkg/utils"
 	tele "gopkg.in/telebot.v3"
 )
@@ -15,14 +22,30 @@ func (b *Bot) HandleGrant(c tele.Context) error {
 
 	args := c.Args()
 	if len(args) < 2 {
-		return c.Send("用法: /grant <user_id> <feature>\n例如: /grant 12345678 ai")
+		return c.Send("用法: /grant <user_id> <feature|role>\n例如: /grant 12345678 ai\n可用功能: wrt, wrt.firewall, wrt.files, wrt.backup, wrt.uci, wrt.packages, clash, adg, ai, mail, all\n可用角色: viewer (只读), operator (切换/重启), admin (重启/防火墙/授权)")
 	}
 
 	targetID := args[0]
+	uid, err := strconv.ParseInt(targetID, 10, 64)
+	if err != nil {
+		return c.Send("❌ 无效的用户 ID。")
+	}
 	feature := strings.ToLower(args[1])
 
+	if role, ok := utils.ParseRole(feature); ok {
+		if utils.SetRole(targetID, role) {
+			utils.SavePermissions()
+			b.Routes.PublishFor(b.TeleBot, uid)
+			audit.Log(c, "admin", "grant_role", targetID, map[string]string{"role": role.String()}, nil)
+			return c.Send(fmt.Sprintf("✅ 已将用户 `%s` 的角色设为 `%s`。", targetID, role), tele.ModeMarkdown)
+		}
+		return c.Send(fmt.Sprintf("⚠️ 用户 `%s` 已是 `%s` 角色。", targetID, role), tele.ModeMarkdown)
+	}
+
 	if utils.GrantPermission(targetID, feature) {
 		utils.SavePermissions()
+		b.Routes.PublishFor(b.TeleBot, uid)
+		audit.Log(c, "admin", "grant", targetID, map[string]string{"feature": feature}, nil)
 		return c.Send(fmt.Sprintf("✅ 已授权用户 `%s` 使用 `%s` 功能。", targetID, feature), tele.ModeMarkdown)
 	}
 
@@ -35,15 +58,47 @@ func (b *Bot) HandleRevoke(c tele.Context) error {
 	}
 
 	args := c.Args()
-	if len(args) < 2 {
-		return c.Send("用法: /revoke <user_id> <feature>")
+	if len(args) < 1 {
+		return c.Send("用法: /revoke <user_id> [feature|role]\n不带第二个参数时移除该用户的全部权限。")
 	}
 
 	targetID := args[0]
+	uid, err := strconv.ParseInt(targetID, 10, 64)
+	if err != nil {
+		return c.Send("❌ 无效的用户 ID。")
+	}
+	if utils.IsConfigAdmin(uid) {
+		return c.Send("⚠️ 该用户是 ADMIN_ID 中配置的管理员，无法在此撤销。")
+	}
+
+	if len(args) == 1 {
+		if utils.RemoveUser(targetID) {
+			utils.SavePermissions()
+			b.Routes.PublishFor(b.TeleBot, uid)
+			audit.Log(c, "admin", "remove_user", targetID, nil, nil)
+			return c.Send(fmt.Sprintf("🚫 已移除用户 `%s` 的全部权限。", targetID), tele.ModeMarkdown)
+		}
+		return c.Send(fmt.Sprintf("⚠️ 用户 `%s` 不在授权列表中。", targetID), tele.ModeMarkdown)
+	}
+
 	feature := strings.ToLower(args[1])
 
+	if role, ok := utils.ParseRole(feature); ok {
+		grant, exists := utils.GetPermissions()[targetID]
+		if !exists || grant.Role != role {
+			return c.Send(fmt.Sprintf("⚠️ 用户 `%s` 不是 `%s` 角色。", targetID, role), tele.ModeMarkdown)
+		}
+		utils.SetRole(targetID, utils.RoleViewer)
+		utils.SavePermissions()
+		b.Routes.PublishFor(b.TeleBot, uid)
+		audit.Log(c, "admin", "revoke_role", targetID, map[string]string{"role": role.String()}, nil)
+		return c.Send(fmt.Sprintf("🚫 已撤销用户 `%s` 的 `%s` 角色，降为 `%s`。", targetID, role, utils.RoleViewer), tele.ModeMarkdown)
+	}
+
 	if utils.RevokePermission(targetID, feature) {
 		utils.SavePermissions()
+		b.Routes.PublishFor(b.TeleBot, uid)
+		audit.Log(c, "admin", "revoke", targetID, map[string]string{"feature": feature}, nil)
 		return c.Send(fmt.Sprintf("🚫 已撤销用户 `%s` 的 `%s` 权限。", targetID, feature), tele.ModeMarkdown)
 	}
 
@@ -55,15 +110,68 @@ func (b *Bot) HandleListUsers(c tele.Context) error {
 		return nil
 	}
 
+	msg := "👥 **已授权用户列表**\n-------------------\n"
+	for _, id := range config.Get().AdminIDs {
+		msg += fmt.Sprintf("👑 `%d`: admin (ADMIN\\_ID)\n", id)
+	}
+
 	perms := utils.GetPermissions()
 	if len(perms) == 0 {
-		return c.Send("📂 当前没有已授权用户。")
+		msg += "📂 当前没有其他已授权用户。"
+		return c.Send(msg, tele.ModeMarkdown)
 	}
 
-	msg := "👥 **已授权用户列表**\n-------------------\n"
-	for uid, features := range perms {
-		msg += fmt.Sprintf("👤 `%s`: %s\n", uid, strings.Join(features, ", "))
+	uids := make([]string, 0, len(perms))
+	for uid := range perms {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	for _, uid := range uids {
+		grant := perms[uid]
+		features := "-"
+		if len(grant.Features) > 0 {
+			features = strings.Join(grant.Features, ", ")
+		}
+		msg += fmt.Sprintf("👤 `%s`: %s | %s\n", uid, grant.Role, utils.EscapeMarkdown(features))
 	}
 
 	return c.Send(msg, tele.ModeMarkdown)
 }
+
+func (b *Bot) HandleReload(c tele.Context) error {
+	old, err := config.Reload()
+	audit.Log(c, "admin", "reload_config", config.ConfigFile, nil, err)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ 配置无效，仍使用当前配置:\n%v", err))
+	}
+	cfg := config.Get()
+
+	b.Gemini.SetKeys(cfg.GeminiAPIKeys)
+	if !slices.Equal(cfg.Routers, old.Routers) {
+		openwrt.ResetClient()
+	}
+	notify.Configure()
+	go b.publishCommands()
+
+	var restart []string
+	if cfg.BotToken != old.BotToken {
+		restart = append(restart, "bot.token")
+	}
+	if cfg.TGBaseURL != old.TGBaseURL || cfg.TGProxy != old.TGProxy {
+		restart = append(restart, "bot.base_url/proxy")
+	}
+	if cfg.WebhookURL != old.WebhookURL || cfg.WebhookListen != old.WebhookListen || cfg.WebhookSecret != old.WebhookSecret ||
+		cfg.WebhookCert != old.WebhookCert || cfg.WebhookKey != old.WebhookKey || cfg.WebhookPublicCert != old.WebhookPublicCert {
+		restart = append(restart, "bot.webhook")
+	}
+	if cfg.SessionBackend != old.SessionBackend || cfg.SessionFile != old.SessionFile {
+		restart = append(restart, "bot.session")
+	}
+
+	msg := fmt.Sprintf("✅ 已重新加载配置 (%s)。", config.ConfigFile)
+	if len(restart) > 0 {
+		msg += fmt.Sprintf("\n⚠️ 以下设置需重启后生效: %s", strings.Join(restart, ", "))
+	}
+	return c.Send(msg)
+}
@@ -1,7 +1,6 @@
 package bot
 
 import (
-	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -9,6 +8,7 @@ import (
 
 	"github.com/yingxiaomo/homeops/pkg/openclash"
 	"github.com/yingxiaomo/homeops/pkg/openwrt"
+	"github.com/yingxiaomo/homeops/pkg/session"
 	"github.com/yingxiaomo/homeops/pkg/utils"
 	tele "gopkg.in/telebot.v3"
 )
@@ -54,21 +54,25 @@ func (b *Bot) HandleAI(c tele.Context) error {
 func (b *Bot) HandleText(c tele.Context) error {
 	userID := c.Sender().ID
 
-	if state := b.Store.Get(userID, "wrt_net_state"); state != nil {
-		if s, ok := state.(string); ok {
-			return openwrt.HandleNetInput(c, s)
-		}
+	if state, ok := session.GetAs[string](b.Store, userID, "wrt_net_state"); ok {
+		return openwrt.HandleNetInput(c, state)
+	}
+
+	if edit, ok := session.GetAs[openwrt.UCIEdit](b.Store, userID, "uci_edit"); ok {
+		return openwrt.HandleUCIInput(c, edit)
+	}
+
+	if _, ok := session.GetAs[bool](b.Store, userID, openwrt.PkgSearchKey); ok {
+		return openwrt.HandlePackagesSearch(c, c.Text())
 	}
 
 	if state := b.Store.Get(userID, "fw_wizard"); state != nil {
 		return openwrt.HandleFwWizardInput(c, c.Text())
 	}
 
-	if state := b.Store.Get(userID, "adg_wizard"); state != nil {
-		if s, ok := state.(map[string]interface{}); ok {
-			if openwrt.HandleAdgWizardInput(c, s) {
-				return nil
-			}
+	if state, ok := session.GetAs[map[string]interface{}](b.Store, userID, "adg_wizard"); ok {
+		if openwrt.HandleAdgWizardInput(c, state) {
+			return nil
 		}
 	}
 
@@ -87,47 +91,43 @@ func (b *Bot) HandleText(c tele.Context) error {
 	freshLogs := ""
 	var logErr error
 	logContext := ""
-	if ctx := b.Store.Get(userID, "ai_log_context"); ctx != nil {
-		if s, ok := ctx.(string); ok {
-			logContext = s
-			b.TeleBot.Edit(msg, fmt.Sprintf("🔄 正在刷新 %s 最新日志...", logContext))
-			switch logContext {
-			case "openwrt":
-				freshLogs, logErr = openwrt.GetLogs(100)
-			case "openclash":
-				// For follow-ups, don't force debug level to avoid repeated switching.
-				freshLogs, logErr = openclash.GetDiagnosticLogs(false)
-			}
-			if logErr != nil {
-				c.Send(fmt.Sprintf("⚠️ 无法获取最新日志: %v\n将基于历史进行回答。", logErr))
-			} else {
-				// Sanitize the logs to ensure they are valid UTF-8
-				freshLogs = strings.ToValidUTF8(freshLogs, "�")
-			}
-			b.TeleBot.Edit(msg, "🤔 思考中...")
+	if s, ok := session.GetAs[string](b.Store, userID, "ai_log_context"); ok {
+		logContext = s
+		b.TeleBot.Edit(msg, fmt.Sprintf("🔄 正在刷新 %s 最新日志...", logContext))
+		switch logContext {
+		case "openwrt":
+			freshLogs, logErr = openwrt.GetLogs(utils.Ctx(c), 100)
+		case "openclash":
+			// For follow-ups, don't force debug level to avoid repeated switching.
+			freshLogs, logErr = openclash.GetDiagnosticLogs(utils.Ctx(c), false)
+		}
+		if logErr != nil {
+			c.Send(fmt.Sprintf("⚠️ 无法获取最新日志: %v\n将基于历史进行回答。", logErr))
+		} else {
+			// Sanitize the logs to ensure they are valid UTF-8
+			freshLogs = strings.ToValidUTF8(freshLogs, "�")
 		}
+		b.TeleBot.Edit(msg, "🤔 思考中...")
 	}
 	// --- End of Dynamic Log Fetching ---
 
 	// Build prompt with history if available
 	prompt := c.Text()
 	history := ""
-	if h := b.Store.Get(userID, "ai_history"); h != nil {
-		if hStr, ok := h.(string); ok {
-			history = hStr
-			// Limit history length to avoid token limits (simple char limit for now)
-			if len(history) > 20000 {
-				history = history[len(history)-20000:]
-			}
-			prompt = history + "\nUser: " + c.Text()
+	if hStr, ok := session.GetAs[string](b.Store, userID, "ai_history"); ok {
+		history = hStr
+		// Limit history length to avoid token limits (simple char limit for now)
+		if len(history) > 20000 {
+			history = history[len(history)-20000:]
 		}
+		prompt = history + "\nUser: " + c.Text()
 	}
 
 	if freshLogs != "" {
 		prompt += fmt.Sprintf("\n\n--- [最新日志参考] ---\n%s\n--- [日志结束] ---", freshLogs)
 	}
 
-	resp, err := b.Gemini.GenerateContent(context.Background(), prompt, nil)
+	resp, err := b.Gemini.GenerateContent(utils.Ctx(c), prompt, nil)
 	if err != nil {
 		_, err = b.TeleBot.Edit(msg, fmt.Sprintf("❌ Error: %v", err))
 		return err
@@ -183,7 +183,7 @@ func (b *Bot) HandlePhoto(c tele.Context) error {
 		prompt = "Describe this image"
 	}
 
-	resp, err := b.Gemini.GenerateContent(context.Background(), prompt, imgBytes)
+	resp, err := b.Gemini.GenerateContent(utils.Ctx(c), prompt, imgBytes)
 	if err != nil {
 		_, err = b.TeleBot.Edit(msg, fmt.Sprintf("❌ Error: %v", err))
 		return err
@@ -200,31 +200,22 @@ func (b *Bot) handleBatchMessage(c tele.Context) error {
 	userID := c.Sender().ID
 
 	// Get current messages
-	messages := b.Store.Get(userID, "batch_messages")
-	if messages == nil {
-		messages = []string{}
-	}
-
-	msgs, ok := messages.([]string)
-	if !ok {
-		msgs = []string{}
-	}
+	msgs, _ := session.GetAs[[]string](b.Store, userID, "batch_messages")
 
 	// Add new message
 	msgs = append(msgs, c.Text())
-	b.Store.Set(userID, "batch_messages", msgs)
+	b.Store.SetWithTTL(userID, "batch_messages", msgs, session.WizardTTL)
+	b.Store.SetWithTTL(userID, "batch_mode", true, session.WizardTTL)
 
 	// Send confirmation
 	menu := &tele.ReplyMarkup{}
 	// Set cancel button based on context
 	cancelData := "ai_toggle" // default
-	if batchCtx := b.Store.Get(userID, "batch_context"); batchCtx != nil {
-		if ctx, ok := batchCtx.(string); ok {
-			if ctx == "openwrt" {
-				cancelData = "wrt_main"
-			} else if ctx == "openclash" {
-				cancelData = "clash_main"
-			}
+	if ctx, ok := session.GetAs[string](b.Store, userID, "batch_context"); ok {
+		if ctx == "openwrt" {
+			cancelData = "wrt_main"
+		} else if ctx == "openclash" {
+			cancelData = "clash_main"
 		}
 	}
 	menu.Inline(menu.Row(menu.Data("✅ 完成输入", "batch_end"), menu.Data("❌ 取消", cancelData)))
@@ -238,16 +229,14 @@ func (b *Bot) HandleBatchStart(c tele.Context) error {
 
 	// Record the context where batch input was started
 	batchContext := "ai" // default to AI mode
-	if logCtx := b.Store.Get(userID, "ai_log_context"); logCtx != nil {
-		if ctx, ok := logCtx.(string); ok {
-			batchContext = ctx
-		}
+	if ctx, ok := session.GetAs[string](b.Store, userID, "ai_log_context"); ok {
+		batchContext = ctx
 	}
 	b.Store.Set(userID, "batch_context", batchContext)
 
 	// Set batch input mode
-	b.Store.Set(userID, "batch_mode", true)
-	b.Store.Set(userID, "batch_messages", []string{})
+	b.Store.SetWithTTL(userID, "batch_mode", true, session.WizardTTL)
+	b.Store.SetWithTTL(userID, "batch_messages", []string{}, session.WizardTTL)
 
 	menu := &tele.ReplyMarkup{}
 	// Set cancel button based on context
@@ -266,12 +255,7 @@ func (b *Bot) HandleBatchEnd(c tele.Context) error {
 	userID := c.Sender().ID
 
 	// Get collected messages
-	messages := b.Store.Get(userID, "batch_messages")
-	if messages == nil {
-		return c.Edit("❌ 没有收集到任何消息")
-	}
-
-	msgs, ok := messages.([]string)
+	msgs, ok := session.GetAs[[]string](b.Store, userID, "batch_messages")
 	if !ok || len(msgs) == 0 {
 		return c.Edit("❌ 没有收集到任何消息")
 	}
@@ -292,44 +276,40 @@ func (b *Bot) HandleBatchEnd(c tele.Context) error {
 	// Build prompt with history if available
 	prompt := combinedText
 	history := ""
-	if h := b.Store.Get(userID, "ai_history"); h != nil {
-		if hStr, ok := h.(string); ok {
-			history = hStr
-			if len(history) > 20000 {
-				history = history[len(history)-20000:]
-			}
-			prompt = history + "\nUser: " + combinedText
+	if hStr, ok := session.GetAs[string](b.Store, userID, "ai_history"); ok {
+		history = hStr
+		if len(history) > 20000 {
+			history = history[len(history)-20000:]
 		}
+		prompt = history + "\nUser: " + combinedText
 	}
 
 	// Check for log context
 	freshLogs := ""
 	var logErr error
 	logContext := ""
-	if ctx := b.Store.Get(userID, "ai_log_context"); ctx != nil {
-		if s, ok := ctx.(string); ok {
-			logContext = s
-			b.TeleBot.Edit(msg, fmt.Sprintf("🔄 正在刷新 %s 最新日志...", logContext))
-			switch logContext {
-			case "openwrt":
-				freshLogs, logErr = openwrt.GetLogs(100)
-			case "openclash":
-				freshLogs, logErr = openclash.GetDiagnosticLogs(false)
-			}
-			if logErr != nil {
-				c.Send(fmt.Sprintf("⚠️ 无法获取最新日志: %v\n将基于历史进行回答。", logErr))
-			} else {
-				freshLogs = strings.ToValidUTF8(freshLogs, "�")
-			}
-			b.TeleBot.Edit(msg, "🤔 正在处理批量输入...")
+	if s, ok := session.GetAs[string](b.Store, userID, "ai_log_context"); ok {
+		logContext = s
+		b.TeleBot.Edit(msg, fmt.Sprintf("🔄 正在刷新 %s 最新日志...", logContext))
+		switch logContext {
+		case "openwrt":
+			freshLogs, logErr = openwrt.GetLogs(utils.Ctx(c), 100)
+		case "openclash":
+			freshLogs, logErr = openclash.GetDiagnosticLogs(utils.Ctx(c), false)
+		}
+		if logErr != nil {
+			c.Send(fmt.Sprintf("⚠️ 无法获取最新日志: %v\n将基于历史进行回答。", logErr))
+		} else {
+			freshLogs = strings.ToValidUTF8(freshLogs, "�")
 		}
+		b.TeleBot.Edit(msg, "🤔 正在处理批量输入...")
 	}
 
 	if freshLogs != "" {
 		prompt += fmt.Sprintf("\n\n--- [最新日志参考] ---\n%s\n--- [日志结束] ---", freshLogs)
 	}
 
-	resp, err := b.Gemini.GenerateContent(context.Background(), prompt, nil)
+	resp, err := b.Gemini.GenerateContent(utils.Ctx(c), prompt, nil)
 	if err != nil {
 		_, err = b.TeleBot.Edit(msg, fmt.Sprintf("❌ Error: %v", err))
 		return err
@@ -349,23 +329,20 @@ func (b *Bot) HandleBatchEnd(c tele.Context) error {
 
 	// Set menu based on batch context
 	menu := &tele.ReplyMarkup{}
-	batchContext := b.Store.Get(userID, "batch_context")
-	if batchContext != nil {
-		if ctx, ok := batchContext.(string); ok {
-			switch ctx {
-			case "openwrt":
-				menu.Inline(
-					menu.Row(menu.Data("📝 批量输入", "batch_start"), menu.Data("🚪 退出 AI 模式", "ai_toggle")),
-					menu.Row(menu.Data("🔙 返回", "wrt_main")),
-				)
-			case "openclash":
-				menu.Inline(
-					menu.Row(menu.Data("📝 批量输入", "batch_start"), menu.Data("🚪 退出 AI 模式", "ai_toggle")),
-					menu.Row(menu.Data("🔙 返回", "clash_main")),
-				)
-			default: // "ai" or other cases
-				menu.Inline(menu.Row(menu.Data("📝 批量输入", "batch_start"), menu.Data("🚪 退出 AI 模式", "ai_toggle")))
-			}
+	if ctx, ok := session.GetAs[string](b.Store, userID, "batch_context"); ok {
+		switch ctx {
+		case "openwrt":
+			menu.Inline(
+				menu.Row(menu.Data("📝 批量输入", "batch_start"), menu.Data("🚪 退出 AI 模式", "ai_toggle")),
+				menu.Row(menu.Data("🔙 返回", "wrt_main")),
+			)
+		case "openclash":
+			menu.Inline(
+				menu.Row(menu.Data("📝 批量输入", "batch_start"), menu.Data("🚪 退出 AI 模式", "ai_toggle")),
+				menu.Row(menu.Data("🔙 返回", "clash_main")),
+			)
+		default: // "ai" or other cases
+			menu.Inline(menu.Row(menu.Data("📝 批量输入", "batch_start"), menu.Data("🚪 退出 AI 模式", "ai_toggle")))
 		}
 	} else {
 		menu.Inline(menu.Row(menu.Data("📝 批量输入", "batch_start"), menu.Data("🚪 退出 AI 模式", "ai_toggle")))
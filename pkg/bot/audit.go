@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+const auditPageSize = 10
+
+var auditSubsystems = map[string]bool{"wrt": true, "adg": true, "clash": true, "fw": true, "uci": true, "admin": true}
+
+func (b *Bot) HandleAudit(c tele.Context) error {
+	if !utils.IsAdmin(c.Sender().ID) {
+		return nil
+	}
+
+	var f audit.Filter
+	args := c.Args()
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(args[i])
+		switch {
+		case arg == "user" && i+1 < len(args):
+			id, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return c.Send("❌ 无效的用户 ID。")
+			}
+			f.UserID = id
+			i++
+		case auditSubsystems[arg]:
+			f.Subsystem = arg
+		default:
+			return c.Send("用法: /audit [user <user_id>] [wrt|adg|clash|fw|uci|admin]\n例如: /audit user 12345678 fw")
+		}
+	}
+
+	return b.sendAuditPage(c, f, 0)
+}
+
+// HandleAuditPage handles "audit_page|<offset>|<user_id>|<subsystem>".
+func (b *Bot) HandleAuditPage(c tele.Context, payload string) error {
+	if !utils.IsAdmin(c.Sender().ID) {
+		return c.Respond(&tele.CallbackResponse{Text: "⛔ 仅管理员可查看审计日志", ShowAlert: true})
+	}
+
+	parts := strings.Split(payload, "|")
+	if len(parts) != 3 {
+		return c.Respond()
+	}
+	offset, _ := strconv.Atoi(parts[0])
+	userID, _ := strconv.ParseInt(parts[1], 10, 64)
+	c.Respond()
+	return b.sendAuditPage(c, audit.Filter{UserID: userID, Subsystem: parts[2]}, offset)
+}
+
+func (b *Bot) sendAuditPage(c tele.Context, f audit.Filter, offset int) error {
+	entries, total, err := audit.Query(f, offset, auditPageSize)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ 读取审计日志失败: %v", err))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📜 **审计日志**")
+	if f.UserID != 0 {
+		sb.WriteString(fmt.Sprintf(" | 用户 `%d`", f.UserID))
+	}
+	if f.Subsystem != "" {
+		sb.WriteString(fmt.Sprintf(" | `%s`", f.Subsystem))
+	}
+	sb.WriteString("\n-------------------\n")
+
+	if total == 0 {
+		sb.WriteString("📂 暂无记录。")
+	}
+
+	for _, e := range entries {
+		icon := "✅"
+		if !e.OK {
+			icon = "❌"
+		}
+		who := strconv.FormatInt(e.UserID, 10)
+		if e.Username != "" {
+			who += " @" + e.Username
+		}
+		line := fmt.Sprintf("%s `%s` %s\n   %s.%s", icon, e.Time.Format("01-02 15:04:05"), utils.EscapeMarkdown(who), e.Subsystem, utils.EscapeMarkdown(e.Action))
+		if e.Target != "" {
+			line += " → " + utils.EscapeMarkdown(e.Target)
+		}
+		if len(e.Params) > 0 {
+			var ps []string
+			for k, v := range e.Params {
+				ps = append(ps, k+"="+v)
+			}
+			sort.Strings(ps)
+			line += "\n   " + utils.EscapeMarkdown(strings.Join(ps, " "))
+		}
+		if e.Error != "" {
+			line += "\n   ⚠️ " + utils.EscapeMarkdown(e.Error)
+		}
+		sb.WriteString(line + "\n")
+	}
+
+	if total > 0 {
+		sb.WriteString(fmt.Sprintf("\n第 %d-%d 条，共 %d 条", offset+1, offset+len(entries), total))
+	}
+
+	menu := &tele.ReplyMarkup{}
+	var nav []tele.Btn
+	pageData := func(off int) string {
+		return fmt.Sprintf("%d|%d|%s", off, f.UserID, f.Subsystem)
+	}
+	if offset > 0 {
+		prev := offset - auditPageSize
+		if prev < 0 {
+			prev = 0
+		}
+		nav = append(nav, menu.Data("⬅️ 较新", "audit_page", pageData(prev)))
+	}
+	if offset+len(entries) < total {
+		nav = append(nav, menu.Data("较早 ➡️", "audit_page", pageData(offset+auditPageSize)))
+	}
+	if len(nav) > 0 {
+		menu.Inline(menu.Row(nav...))
+	}
+
+	return c.EditOrSend(sb.String(), menu, tele.ModeMarkdown)
+}
@@ -1,16 +1,26 @@
 package bot
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/yingxiaomo/homeops/config"
 	"github.com/yingxiaomo/homeops/pkg/ai"
+	"github.com/yingxiaomo/homeops/pkg/health"
+	"github.com/yingxiaomo/homeops/pkg/lifecycle"
+	"github.com/yingxiaomo/homeops/pkg/metrics"
+	"github.com/yingxiaomo/homeops/pkg/notify"
 	"github.com/yingxiaomo/homeops/pkg/openclash"
 	"github.com/yingxiaomo/homeops/pkg/openwrt"
+	"github.com/yingxiaomo/homeops/pkg/router"
+	"github.com/yingxiaomo/homeops/pkg/scheduler"
 	"github.com/yingxiaomo/homeops/pkg/session"
 	"github.com/yingxiaomo/homeops/pkg/utils"
 
@@ -20,76 +30,220 @@ import (
 type Bot struct {
 	TeleBot *tele.Bot
 	Gemini  *ai.GeminiClient
-	Store   *session.SessionStore
+	Store   session.Store
+	Routes  *router.Registry
 }
 
 func NewBot() *Bot {
+	cfg := config.Get()
 	pref := tele.Settings{
-		Token:   config.AppConfig.BotToken,
-		Poller:  &tele.LongPoller{Timeout: 10 * time.Second},
+		Token:   cfg.BotToken,
+		Poller:  newPoller(),
 		Verbose: true,
 	}
 
-	if config.AppConfig.TGBaseURL != "" {
-		pref.URL = config.AppConfig.TGBaseURL
+	if cfg.TGBaseURL != "" {
+		pref.URL = cfg.TGBaseURL
 	}
 
-	log.Printf("Bot Config - Token: ...%s", config.AppConfig.BotToken[len(config.AppConfig.BotToken)-5:])
-	log.Printf("Bot Config - Proxy: %s", config.AppConfig.TGProxy)
+	log.Printf("Bot Config - Token: %s", maskToken(cfg.BotToken))
+	log.Printf("Bot Config - Proxy: %s", cfg.TGProxy)
 
-	if config.AppConfig.TGProxy != "" {
-		proxyUrl, err := url.Parse(config.AppConfig.TGProxy)
+	var transport http.RoundTripper = http.DefaultTransport
+	if cfg.TGProxy != "" {
+		proxyUrl, err := url.Parse(cfg.TGProxy)
 		if err != nil {
 			log.Printf("Invalid Proxy URL: %v", err)
 		} else {
-			pref.Client = &http.Client{
-				Transport: &http.Transport{
-					Proxy: http.ProxyURL(proxyUrl),
-				},
+			transport = &http.Transport{
+				Proxy: http.ProxyURL(proxyUrl),
 			}
 		}
 	}
+	pref.Client = &http.Client{
+		Transport: &health.Transport{Base: transport},
+		Timeout:   time.Minute,
+	}
 
 	b, err := tele.NewBot(pref)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	store, err := session.NewStore(cfg.SessionBackend, cfg.SessionFile)
+	if err != nil {
+		log.Printf("Failed to open session store, falling back to memory: %v", err)
+		store = session.NewMemoryStore()
+	}
+	session.GlobalStore = store
+	log.Printf("Session store: %s", cfg.SessionBackend)
+
 	return &Bot{
 		TeleBot: b,
 		Gemini:  ai.NewGeminiClient(),
 		Store:   session.GlobalStore,
+		Routes:  router.NewRegistry(),
+	}
+}
+
+// maskToken keeps only the last few characters of token for logging.
+func maskToken(token string) string {
+	if len(token) <= 5 {
+		return "***"
+	}
+	return "..." + token[len(token)-5:]
+}
+
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// newPoller returns a webhook poller when TG_WEBHOOK_URL is set and the
+// default long poller otherwise.
+func newPoller() tele.Poller {
+	cfg := config.Get()
+	if cfg.WebhookURL == "" {
+		log.Println("Update mode: long polling")
+		return &tele.LongPoller{Timeout: 10 * time.Second}
+	}
+
+	secret := cfg.WebhookSecret
+	if secret == "" {
+		secret = utils.RandomString(32)
+		log.Println("TG_WEBHOOK_SECRET not set, generated a random secret token for this run")
+	} else if !webhookSecretPattern.MatchString(secret) {
+		log.Fatal("TG_WEBHOOK_SECRET may only contain A-Z, a-z, 0-9, _ and - (1-256 chars)")
+	}
+
+	wh := &tele.Webhook{
+		Listen:      cfg.WebhookListen,
+		SecretToken: secret,
+		Endpoint: &tele.WebhookEndpoint{
+			PublicURL: cfg.WebhookURL,
+			Cert:      cfg.WebhookPublicCert,
+		},
 	}
+	if cfg.WebhookCert != "" || cfg.WebhookKey != "" {
+		if cfg.WebhookCert == "" || cfg.WebhookKey == "" {
+			log.Fatal("TG_WEBHOOK_CERT and TG_WEBHOOK_KEY must be set together")
+		}
+		wh.TLS = &tele.WebhookTLS{Cert: cfg.WebhookCert, Key: cfg.WebhookKey}
+	}
+
+	scheme := "http"
+	if wh.TLS != nil {
+		scheme = "https"
+	}
+	log.Printf("Update mode: webhook (%s listener on %s, public URL %s)", scheme, wh.Listen, cfg.WebhookURL)
+	return wh
 }
 
-func (b *Bot) Start() {
+// Start runs the bot until ctx is cancelled and then shuts it down.
+func (b *Bot) Start(ctx context.Context) {
 	b.TeleBot.Use(b.LogMiddleware)
 	b.TeleBot.Use(b.AuthMiddleware)
-	b.TeleBot.Handle("/start", b.HandleStart)
-	b.TeleBot.Handle("/ai", b.HandleAI)
-	b.TeleBot.Handle("/wrt", openwrt.HandleWrtMain)
-	b.TeleBot.Handle("/sticker", b.HandleStickerMenu)
-	b.TeleBot.Handle("/mail", b.HandleMailMenu)
-	b.TeleBot.Handle("/grant", b.HandleGrant)
-	b.TeleBot.Handle("/revoke", b.HandleRevoke)
-	b.TeleBot.Handle("/users", b.HandleListUsers)
-	b.TeleBot.Handle("/info", b.HandleInfo)
-	b.TeleBot.Handle("/id", b.HandleInfo)
+	b.TeleBot.Use(openwrt.RouterMiddleware)
+	b.registerRoutes()
 	b.TeleBot.Handle(tele.OnCallback, b.HandleCallback)
 	b.TeleBot.Handle(tele.OnText, b.HandleText)
 	b.TeleBot.Handle(tele.OnPhoto, b.HandlePhoto)
 	b.TeleBot.Handle(tele.OnSticker, b.HandleSticker)
+	b.TeleBot.Handle(tele.OnDocument, b.HandleDocument)
 
-	openwrt.StartIPMonitor(b.TeleBot)
+	// A webhook left over from an earlier run would make getUpdates fail.
+	if _, ok := b.TeleBot.Poller.(*tele.LongPoller); ok {
+		if err := b.TeleBot.RemoveWebhook(); err != nil {
+			log.Printf("Failed to remove webhook: %v", err)
+		}
+	}
+
+	if config.Get().HealthListen != "" {
+		b.startHealth()
+	}
+
+	go b.publishCommands()
+	notify.Setup(b.TeleBot)
+	openwrt.StartIPMonitor()
+	openwrt.StartRouterMonitor()
+	openwrt.StartDriftMonitor()
+	openwrt.StartFirmwareMonitor()
+	registerScheduleActions()
+	scheduler.Start(b.TeleBot)
+	session.StartJanitor(lifecycle.Context(), b.Store, 30*time.Second, b.notifyExpired)
 
 	log.Printf("Go Bot started on %s", b.TeleBot.Me.Username)
-	b.TeleBot.Start()
+	go b.TeleBot.Start()
+
+	<-ctx.Done()
+	b.Stop()
+}
+
+// Stop stops receiving updates, cancels in-flight jobs and flushes state.
+func (b *Bot) Stop() {
+	log.Println("Shutting down...")
+	b.TeleBot.Stop()
+	lifecycle.Shutdown(10 * time.Second)
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	health.Stop(stopCtx)
+
+	openwrt.ResetClient()
+	if err := b.Store.Flush(); err != nil {
+		log.Printf("Error flushing session store: %v", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+// startHealth serves /healthz, /readyz and /metrics on HEALTH_LISTEN.
+func (b *Bot) startHealth() {
+	cfg := config.Get()
+	if lp, ok := b.TeleBot.Poller.(*tele.LongPoller); ok {
+		health.ExpectPolls(3*lp.Timeout + 30*time.Second)
+	}
+
+	for _, r := range cfg.Routers {
+		name := r.Name
+		health.AddCheck("ssh:"+name, func(ctx context.Context) error {
+			_, err := openwrt.Exec(openwrt.WithRouter(ctx, name), "true")
+			return err
+		})
+	}
+	if cfg.AdgURL != "" {
+		health.AddCheck("adguard", func(ctx context.Context) error {
+			_, err := openwrt.NewAdGuardClient().GetFilteringStatus(ctx)
+			return err
+		})
+	}
+	if cfg.OpenClashAPIURL != "" {
+		health.AddCheck("openclash", func(ctx context.Context) error {
+			_, err := openclash.NewClient().GetConfig(ctx)
+			return err
+		})
+	}
+
+	health.Serve(cfg.HealthListen)
+}
+
+// publishCommands registers the command menu of every known user with
+// Telegram, so that each of them only sees what they may use.
+func (b *Bot) publishCommands() {
+	ids := append([]int64{}, config.Get().AdminIDs...)
+	for uid := range utils.GetPermissions() {
+		if id, err := strconv.ParseInt(uid, 10, 64); err == nil && !utils.IsConfigAdmin(id) {
+			ids = append(ids, id)
+		}
+	}
+	b.Routes.Publish(b.TeleBot, ids)
 }
 
 func (b *Bot) LogMiddleware(next tele.HandlerFunc) tele.HandlerFunc {
 	return func(c tele.Context) error {
 		user := c.Sender()
 		updateID := c.Update().ID
+		kind := "message"
+		if c.Callback() != nil {
+			kind = "callback"
+		}
+		metrics.UpdatesHandled.Inc(kind)
 		if user != nil {
 			log.Printf("[%d] Update from %s (ID: %d): %s", updateID, user.Username, user.ID, c.Text())
 			if c.Callback() != nil {
@@ -130,36 +284,77 @@ func (b *Bot) HandleStart(c tele.Context) error {
 	return c.Send(text, b.getMainMenu(), tele.ModeMarkdown)
 }
 
+func (b *Bot) registerRoutes() {
+	r := b.Routes
+
+	r.Command(b.TeleBot, "/start", "", "打开主菜单", b.HandleStart)
+	r.Command(b.TeleBot, "/ai", "ai", "AI 对话开关", b.HandleAI)
+	r.Command(b.TeleBot, "/sticker", "", "贴纸/图片转换", b.HandleStickerMenu)
+	r.Command(b.TeleBot, "/mail", "mail", "临时邮箱", b.HandleMailMenu)
+	r.Command(b.TeleBot, "/info", "", "查看我的 ID 与权限", b.HandleInfo)
+	r.Command(b.TeleBot, "/id", "", "", b.HandleInfo)
+	r.AdminCommand(b.TeleBot, "/grant", "授权: /grant <id> <功能|角色>", b.HandleGrant)
+	r.AdminCommand(b.TeleBot, "/revoke", "撤销: /revoke <id> [功能|角色]", b.HandleRevoke)
+	r.AdminCommand(b.TeleBot, "/users", "已授权用户列表", b.HandleListUsers)
+	r.AdminCommand(b.TeleBot, "/audit", "审计日志", b.HandleAudit)
+	r.AdminCommand(b.TeleBot, "/reload", "重新加载配置文件", b.HandleReload)
+	r.AdminCommand(b.TeleBot, "/schedule", "定时任务", b.HandleSchedule)
+	openwrt.RegisterCommands(r, b.TeleBot)
+	openclash.RegisterCommands(r, b.TeleBot)
+
+	r.Handle("start_main", "", b.HandleStart)
+	r.Handle("ai_toggle", "ai", b.HandleAI)
+	r.Handle("batch_start", "ai", b.HandleBatchStart)
+	r.Handle("batch_end", "ai", b.HandleBatchEnd)
+	r.Handle("sticker_main", "", b.HandleStickerMenu)
+	r.Handle("mail_main", "mail", b.HandleMailMenu)
+	r.Handle("mail_new", "mail", b.HandleMailNew)
+	r.Handle("mail_refresh", "mail", b.HandleMailRefresh)
+	r.HandlePrefix("mail_read_", "mail", b.HandleMailRead)
+	r.HandlePrefix("audit_page|", "", b.HandleAuditPage)
+	r.Handle("sched_main", "", b.HandleScheduleMenu)
+	r.HandlePrefix("sched_pause|", "", b.HandleScheduleOp("pause"))
+	r.HandlePrefix("sched_resume|", "", b.HandleScheduleOp("resume"))
+	r.HandlePrefix("sched_run|", "", b.HandleScheduleOp("run"))
+	r.HandlePrefix("sched_del|", "", b.HandleScheduleOp("delete"))
+
+	openwrt.RegisterRoutes(r)
+	openclash.RegisterRoutes(r)
+}
+
 func (b *Bot) HandleCallback(c tele.Context) error {
 	data := strings.TrimSpace(c.Callback().Data)
 	data = strings.TrimPrefix(data, "\f")
 
-	switch {
-	case data == "start_main":
-		return b.HandleStart(c)
-	case data == "ai_toggle":
-		return b.HandleAI(c)
-	case data == "batch_start":
-		return b.HandleBatchStart(c)
-	case data == "batch_end":
-		return b.HandleBatchEnd(c)
-	case strings.HasPrefix(data, "wrt_"):
-		if err := openwrt.HandleCallback(c, data); err != nil {
-			log.Printf("Error handling OpenWrt callback: %v", err)
-			return c.Respond(&tele.CallbackResponse{Text: "操作失败", ShowAlert: true})
-		}
-		return nil
-	case strings.HasPrefix(data, "clash_"):
-		return openclash.HandleCallback(c, data)
-	case strings.HasPrefix(data, "G_") || strings.HasPrefix(data, "S_"):
-		return openclash.HandleCallback(c, data)
-	case strings.HasPrefix(data, "sticker_"):
-		return b.HandleStickerCallback(c, data)
-	case strings.HasPrefix(data, "mail_"):
-		return b.HandleMailCallback(c, data)
-	default:
-		log.Printf("Unknown callback data: %s", data)
-		return c.Respond()
-	}
-	return c.Respond()
+	if err := b.Routes.Dispatch(c, data); err != nil {
+		log.Printf("Error handling callback %s: %v", data, err)
+		return c.Respond(&tele.CallbackResponse{Text: "操作失败", ShowAlert: true})
+	}
+	return nil
+}
+
+// expiredInputs names the session keys whose expiry the user is told about.
+var expiredInputs = map[string]string{
+	"fw_wizard":     "防火墙规则向导",
+	"adg_wizard":    "AdGuard 设置输入",
+	"wrt_net_state": "网络测试输入",
+	"batch_mode":    "批量输入",
+}
+
+func (b *Bot) notifyExpired(e session.Expired) {
+	name, ok := expiredInputs[e.Key]
+	if !ok {
+		return
+	}
+	log.Printf("Session %s for %d expired", e.Key, e.UserID)
+	if e.Key == "batch_mode" {
+		b.Store.Delete(e.UserID, "batch_messages")
+	}
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("🔙 返回主菜单", "start_main")))
+	msg := fmt.Sprintf("⌛ %s 已超时 (%d 分钟未操作)，已自动取消。", name, int(session.WizardTTL.Minutes()))
+	if _, err := b.TeleBot.Send(&tele.User{ID: e.UserID}, msg, menu); err != nil {
+		log.Printf("Failed to send expiry notice: %v", err)
+	}
 }
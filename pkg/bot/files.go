@@ -0,0 +1,26 @@
+package bot
+
+import (
+	"strings"
+
+	"github.com/yingxiaomo/homeops/pkg/openwrt"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+// HandleDocument uploads a received document to the router, either to the
+// target picked in the file browser or to the path given as caption.
+func (b *Bot) HandleDocument(c tele.Context) error {
+	target, ok := openwrt.UploadTarget(c.Sender().ID)
+	if !ok {
+		caption := strings.TrimSpace(c.Message().Caption)
+		if !strings.HasPrefix(caption, "/") {
+			return nil
+		}
+		target = caption
+	}
+	if !utils.HasPermission(c.Sender().ID, "wrt.files") {
+		return c.Send("⛔ 你没有使用此功能的权限。")
+	}
+	return openwrt.HandleFileUpload(c, target)
+}
@@ -41,10 +41,6 @@ func fetchJSON(url string, target interface{}) error {
 }
 
 func (b *Bot) HandleMailMenu(c tele.Context) error {
-	if !utils.IsAdmin(c.Sender().ID) {
-		return c.Send("⛔ 此功能仅限管理员使用。")
-	}
-
 	userID := c.Sender().ID
 	currentMail := userMailboxes[userID]
 
@@ -70,27 +66,15 @@ func (b *Bot) HandleMailMenu(c tele.Context) error {
 	return c.EditOrSend(txt, menu, tele.ModeMarkdown)
 }
 
-func (b *Bot) HandleMailCallback(c tele.Context, data string) error {
-	if strings.HasPrefix(data, "mail_read_") {
-		return b.HandleMailRead(c, data)
-	}
-
-	switch data {
-	case "mail_main":
+func (b *Bot) HandleMailNew(c tele.Context) error {
+	c.Respond(&tele.CallbackResponse{Text: "正在生成..."})
+	var mails []string
+	err := fetchJSON(MailAPIBase+"?action=genRandomMailbox&count=1", &mails)
+	if err == nil && len(mails) > 0 {
+		userMailboxes[c.Sender().ID] = mails[0]
 		return b.HandleMailMenu(c)
-	case "mail_new":
-		c.Respond(&tele.CallbackResponse{Text: "正在生成..."})
-		var mails []string
-		err := fetchJSON(MailAPIBase+"?action=genRandomMailbox&count=1", &mails)
-		if err == nil && len(mails) > 0 {
-			userMailboxes[c.Sender().ID] = mails[0]
-			return b.HandleMailMenu(c)
-		}
-		return c.Respond(&tele.CallbackResponse{Text: "❌ 生成失败，请稍后再试。"})
-	case "mail_refresh":
-		return b.HandleMailRefresh(c)
 	}
-	return c.Respond()
+	return c.Respond(&tele.CallbackResponse{Text: "❌ 生成失败，请稍后再试。"})
 }
 
 func (b *Bot) HandleMailRefresh(c tele.Context) error {
@@ -133,14 +117,13 @@ func (b *Bot) HandleMailRefresh(c tele.Context) error {
 	return c.Edit(txt, menu, tele.ModeMarkdown)
 }
 
-func (b *Bot) HandleMailRead(c tele.Context, data string) error {
+func (b *Bot) HandleMailRead(c tele.Context, idStr string) error {
 	userID := c.Sender().ID
 	currentMail := userMailboxes[userID]
 	if currentMail == "" {
 		return b.HandleMailMenu(c)
 	}
 
-	idStr := strings.TrimPrefix(data, "mail_read_")
 	parts := strings.Split(currentMail, "@")
 	login, domain := parts[0], parts[1]
 
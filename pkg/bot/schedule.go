@@ -0,0 +1,255 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/openclash"
+	"github.com/yingxiaomo/homeops/pkg/openwrt"
+	"github.com/yingxiaomo/homeops/pkg/scheduler"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+func registerScheduleActions() {
+	openwrt.RegisterActions()
+	openclash.RegisterActions()
+	scheduler.RegisterAction(scheduler.Action{
+		Name:      "digest",
+		Label:     "状态摘要",
+		Subsystem: "wrt",
+		Run: func(ctx context.Context, _ string) (string, error) {
+			return statusDigest(ctx), nil
+		},
+	})
+}
+
+// statusDigest summarises the router, public IPs, OpenClash and AdGuard in
+// plain text. Parts that cannot be reached are reported inline.
+func statusDigest(ctx context.Context) string {
+	cfg := config.Get()
+	var sb strings.Builder
+	sb.WriteString("📋 状态摘要 " + time.Now().Format("2006-01-02 15:04") + "\n-------------------\n")
+
+	if len(cfg.Routers) > 1 {
+		sb.WriteString(openwrt.StatusSummary(ctx) + "\n")
+	} else if st, err := openwrt.GetSystemStats(ctx); err != nil {
+		sb.WriteString(fmt.Sprintf("📟 路由器: 无法连接 (%v)\n", err))
+	} else {
+		sb.WriteString(fmt.Sprintf("⏱ 运行时间: %s\n📈 负载: %s\n🧠 内存: %dMB / %dMB\n🌡 温度: %s\n",
+			st.Uptime, st.Load, st.MemUsed, st.MemTotal, st.TempString()))
+	}
+
+	v4, v6 := openwrt.GetRouterIPs(ctx)
+	if v4 != "" {
+		sb.WriteString("🔴 IPv4: " + v4 + "\n")
+	}
+	if v6 != "" {
+		sb.WriteString("🔵 IPv6: " + v6 + "\n")
+	}
+
+	if cfg.OpenClashAPIURL != "" {
+		if conf, err := openclash.NewClient().GetConfig(ctx); err != nil {
+			sb.WriteString(fmt.Sprintf("🚀 OpenClash: 无法连接 (%v)\n", err))
+		} else {
+			sb.WriteString(fmt.Sprintf("🚀 OpenClash 模式: %v\n", conf["mode"]))
+		}
+	}
+
+	if cfg.AdgURL != "" {
+		if on, err := openwrt.NewAdGuardClient().GetFilteringStatus(ctx); err != nil {
+			sb.WriteString(fmt.Sprintf("🛡 AdGuard: 无法连接 (%v)\n", err))
+		} else if on {
+			sb.WriteString("🛡 AdGuard 过滤: 开启\n")
+		} else {
+			sb.WriteString("🛡 AdGuard 过滤: 关闭\n")
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+const scheduleUsage = "用法:\n" +
+	"/schedule add <分 时 日 月 周> <动作> [参数]\n" +
+	"/schedule add <@daily|@hourly|@weekly> <动作> [参数]\n" +
+	"/schedule pause|resume|del|run <id>\n\n" +
+	"例如: /schedule add 30 4 * * 1 reboot\n" +
+	"      /schedule add 0 23 * * * adg_pause 8h"
+
+// HandleSchedule handles /schedule [add|pause|resume|del|run ...].
+func (b *Bot) HandleSchedule(c tele.Context) error {
+	if !utils.IsAdmin(c.Sender().ID) {
+		return nil
+	}
+
+	args := c.Args()
+	if len(args) == 0 {
+		return b.sendScheduleList(c)
+	}
+
+	op := strings.ToLower(args[0])
+	if op == "add" {
+		return b.handleScheduleAdd(c, args[1:])
+	}
+	if len(args) != 2 {
+		return c.Send(scheduleUsage + "\n\n" + actionHelp())
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+	if err != nil {
+		return c.Send("❌ 无效的任务 ID。")
+	}
+
+	switch op {
+	case "pause", "resume":
+		err = scheduler.SetPaused(id, op == "pause")
+		audit.Log(c, "admin", "schedule_"+op, strconv.Itoa(id), nil, err)
+	case "del", "delete":
+		err = scheduler.Delete(id)
+		audit.Log(c, "admin", "schedule_delete", strconv.Itoa(id), nil, err)
+	case "run":
+		err = scheduler.RunNow(id)
+		audit.Log(c, "admin", "schedule_run", strconv.Itoa(id), nil, err)
+	default:
+		return c.Send(scheduleUsage)
+	}
+	if err != nil {
+		return c.Send("❌ " + err.Error())
+	}
+	return b.sendScheduleList(c)
+}
+
+func (b *Bot) handleScheduleAdd(c tele.Context, args []string) error {
+	var spec string
+	switch {
+	case len(args) >= 2 && strings.HasPrefix(args[0], "@"):
+		spec, args = args[0], args[1:]
+	case len(args) >= 6:
+		spec, args = strings.Join(args[:5], " "), args[5:]
+	default:
+		return c.Send(scheduleUsage + "\n\n" + actionHelp())
+	}
+
+	action := strings.ToLower(args[0])
+	arg := strings.Join(args[1:], " ")
+	job, err := scheduler.Add(spec, action, arg, c.Chat().ID, c.Sender().ID)
+	audit.Log(c, "admin", "schedule_add", action, map[string]string{"spec": spec, "arg": arg}, err)
+	if err != nil {
+		return c.Send("❌ 创建失败: " + err.Error() + "\n\n" + actionHelp())
+	}
+
+	next := job.Next(time.Now())
+	msg := fmt.Sprintf("✅ 已创建定时任务 #%d\n⏰ %s → %s %s", job.ID, job.Spec, job.Action, job.Arg)
+	if !next.IsZero() {
+		msg += "\n下次运行: " + next.Format("2006-01-02 15:04")
+	}
+	return c.Send(msg)
+}
+
+func actionHelp() string {
+	var sb strings.Builder
+	sb.WriteString("可用动作:")
+	for _, a := range scheduler.Actions() {
+		sb.WriteString("\n• " + a.Name)
+		if a.ArgHint != "" {
+			sb.WriteString(" " + a.ArgHint)
+		}
+		sb.WriteString(" — " + a.Label)
+	}
+	return sb.String()
+}
+
+func (b *Bot) HandleScheduleMenu(c tele.Context) error {
+	if !utils.IsAdmin(c.Sender().ID) {
+		return c.Respond(&tele.CallbackResponse{Text: "⛔ 仅管理员可管理定时任务", ShowAlert: true})
+	}
+	c.Respond()
+	return b.sendScheduleList(c)
+}
+
+func (b *Bot) sendScheduleList(c tele.Context) error {
+	jobs := scheduler.List()
+	now := time.Now()
+
+	var sb strings.Builder
+	sb.WriteString("🕒 定时任务\n-------------------\n")
+	if len(jobs) == 0 {
+		sb.WriteString("📂 暂无任务。\n")
+	}
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for _, j := range jobs {
+		state := "▶️"
+		if j.Paused {
+			state = "⏸"
+		}
+		sb.WriteString(fmt.Sprintf("%s #%d %s → %s %s\n", state, j.ID, j.Spec, j.Action, j.Arg))
+		if next := j.Next(now); !next.IsZero() {
+			sb.WriteString("   下次: " + next.Format("01-02 15:04"))
+		}
+		if !j.LastRun.IsZero() {
+			icon := "✅"
+			if !j.LastOK {
+				icon = "❌"
+			}
+			sb.WriteString(fmt.Sprintf("  上次: %s %s", icon, j.LastRun.Format("01-02 15:04")))
+		}
+		sb.WriteString("\n")
+
+		id := strconv.Itoa(j.ID)
+		toggle := menu.Data(fmt.Sprintf("⏸ #%d", j.ID), "sched_pause", id)
+		if j.Paused {
+			toggle = menu.Data(fmt.Sprintf("▶️ #%d", j.ID), "sched_resume", id)
+		}
+		rows = append(rows, menu.Row(
+			toggle,
+			menu.Data(fmt.Sprintf("⚡ #%d", j.ID), "sched_run", id),
+			menu.Data(fmt.Sprintf("🗑 #%d", j.ID), "sched_del", id),
+		))
+	}
+	sb.WriteString("\n" + scheduleUsage + "\n\n" + actionHelp())
+
+	rows = append(rows, menu.Row(menu.Data("🔄 刷新", "sched_main")))
+	menu.Inline(rows...)
+	return c.EditOrSend(sb.String(), menu)
+}
+
+// HandleScheduleOp handles the sched_pause|, sched_resume|, sched_run| and
+// sched_del| buttons.
+func (b *Bot) HandleScheduleOp(op string) func(c tele.Context, payload string) error {
+	return func(c tele.Context, payload string) error {
+		if !utils.IsAdmin(c.Sender().ID) {
+			return c.Respond(&tele.CallbackResponse{Text: "⛔ 仅管理员可管理定时任务", ShowAlert: true})
+		}
+		id, err := strconv.Atoi(payload)
+		if err != nil {
+			return c.Respond()
+		}
+
+		var text string
+		switch op {
+		case "pause", "resume":
+			err = scheduler.SetPaused(id, op == "pause")
+			text = "已暂停"
+			if op == "resume" {
+				text = "已恢复"
+			}
+		case "run":
+			err = scheduler.RunNow(id)
+			text = "已开始运行，结果将发送到创建任务的聊天"
+		case "delete":
+			err = scheduler.Delete(id)
+			text = "已删除"
+		}
+		audit.Log(c, "admin", "schedule_"+op, payload, nil, err)
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: err.Error(), ShowAlert: true})
+		}
+		c.Respond(&tele.CallbackResponse{Text: text})
+		return b.sendScheduleList(c)
+	}
+}
@@ -22,13 +22,6 @@ func (b *Bot) HandleStickerMenu(c tele.Context) error {
 	return c.EditOrSend(txt, menu, tele.ModeMarkdown)
 }
 
-func (b *Bot) HandleStickerCallback(c tele.Context, data string) error {
-	if data == "sticker_main" {
-		return b.HandleStickerMenu(c)
-	}
-	return c.Respond()
-}
-
 func (b *Bot) HandleSticker(c tele.Context) error {
 	if c.Message().Sticker.Animated || c.Message().Sticker.Video {
 		menu := &tele.ReplyMarkup{}
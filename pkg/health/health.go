@@ -0,0 +1,161 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yingxiaomo/homeops/pkg/lifecycle"
+	"github.com/yingxiaomo/homeops/pkg/metrics"
+)
+
+// Check reports whether a dependency is reachable.
+type Check func(ctx context.Context) error
+
+var (
+	lastPoll   atomic.Int64
+	pollWindow atomic.Int64
+
+	checks   = make(map[string]Check)
+	checkMu  sync.Mutex
+	checkOrd []string
+
+	server *http.Server
+)
+
+const checkTimeout = 10 * time.Second
+
+// MarkPoll records that Telegram answered a getUpdates call.
+func MarkPoll() {
+	lastPoll.Store(time.Now().UnixNano())
+}
+
+// ExpectPolls enables the polling liveness check: /healthz fails when no
+// getUpdates call succeeded within window. Without it (webhook mode) the
+// bot counts as alive as long as the process serves HTTP.
+func ExpectPolls(window time.Duration) {
+	pollWindow.Store(int64(window))
+}
+
+// AddCheck registers a readiness check served by /readyz.
+func AddCheck(name string, c Check) {
+	checkMu.Lock()
+	defer checkMu.Unlock()
+	if _, ok := checks[name]; !ok {
+		checkOrd = append(checkOrd, name)
+	}
+	checks[name] = c
+}
+
+// Transport wraps an http.RoundTripper and marks successful getUpdates calls.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusOK && strings.HasSuffix(req.URL.Path, "/getUpdates") {
+		MarkPoll()
+	}
+	return resp, err
+}
+
+// Serve starts the health and metrics server on addr in the background.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.WriteText(w)
+	})
+
+	server = &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		log.Printf("Health server listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Health server stopped: %v", err)
+		}
+	}()
+}
+
+// Stop shuts the health server down if it is running.
+func Stop(ctx context.Context) {
+	if server != nil {
+		server.Shutdown(ctx)
+	}
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	window := time.Duration(pollWindow.Load())
+	if window > 0 {
+		last := lastPoll.Load()
+		if last == 0 || time.Since(time.Unix(0, last)) > window {
+			http.Error(w, "telegram polling stalled", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.Write([]byte("ok\n"))
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checkMu.Lock()
+	names := append([]string(nil), checkOrd...)
+	fns := make([]Check, len(names))
+	for i, name := range names {
+		fns[i] = checks[name]
+	}
+	checkMu.Unlock()
+
+	results := make(map[string]string, len(names))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ready := true
+	for i, name := range names {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			status := "ok"
+			if err := runCheck(check); err != nil {
+				status = err.Error()
+			}
+			mu.Lock()
+			results[name] = status
+			if status != "ok" {
+				ready = false
+			}
+			mu.Unlock()
+		}(name, fns[i])
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+func runCheck(check Check) error {
+	ctx, cancel := context.WithTimeout(lifecycle.Context(), checkTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errors.New("timeout")
+	}
+}
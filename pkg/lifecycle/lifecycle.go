@@ -0,0 +1,46 @@
+package lifecycle
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	root, cancel = context.WithCancel(context.Background())
+	wg           sync.WaitGroup
+)
+
+// Context is cancelled when the process starts shutting down. Long-running
+// jobs and remote calls should derive their contexts from it.
+func Context() context.Context {
+	return root
+}
+
+// Go runs f in a goroutine that Shutdown waits for.
+func Go(f func(ctx context.Context)) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		f(root)
+	}()
+}
+
+// Shutdown cancels Context and waits up to timeout for goroutines started
+// with Go to return.
+func Shutdown(timeout time.Duration) {
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("Shutdown: background jobs still running after %s", timeout)
+	}
+}
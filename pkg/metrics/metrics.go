@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric is anything that can write itself in the Prometheus text format.
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registry   []metric
+	registryMu sync.Mutex
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+}
+
+// WriteText writes all registered metrics in the Prometheus text exposition
+// format.
+func WriteText(w io.Writer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, m := range registry {
+		m.write(w)
+	}
+}
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the series identified by labelValues, which must match the
+// labels the counter was created with.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.values) == 0 && len(c.labels) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, key, c.values[key])
+	}
+}
+
+// SummaryVec tracks the count and sum of observed durations in seconds.
+type SummaryVec struct {
+	name, help string
+	labels     []string
+
+	mu    sync.Mutex
+	sum   map[string]float64
+	count map[string]uint64
+}
+
+func NewSummary(name, help string, labels ...string) *SummaryVec {
+	s := &SummaryVec{name: name, help: help, labels: labels, sum: make(map[string]float64), count: make(map[string]uint64)}
+	register(s)
+	return s
+}
+
+func (s *SummaryVec) Observe(d time.Duration, labelValues ...string) {
+	key := formatLabels(s.labels, labelValues)
+	s.mu.Lock()
+	s.sum[key] += d.Seconds()
+	s.count[key]++
+	s.mu.Unlock()
+}
+
+func (s *SummaryVec) write(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", s.name, s.help, s.name)
+	for _, key := range sortedKeys(s.sum) {
+		fmt.Fprintf(w, "%s_sum%s %g\n", s.name, key, s.sum[key])
+		fmt.Fprintf(w, "%s_count%s %d\n", s.name, key, s.count[key])
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+		parts[i] = fmt.Sprintf(`%s="%s"`, name, v)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	UpdatesHandled = NewCounter("homeops_updates_total", "Telegram updates handled.", "type")
+	SSHDuration    = NewSummary("homeops_ssh_command_duration_seconds", "Latency of commands and ubus calls run on the routers.", "router")
+	SSHFailures    = NewCounter("homeops_ssh_command_failures_total", "Router commands and ubus calls that failed.", "router")
+	GeminiRequests = NewCounter("homeops_gemini_requests_total", "Gemini API calls by model, key index and result.", "model", "key", "result")
+	IPChanges      = NewCounter("homeops_ip_changes_total", "Public IP changes detected by the IP monitor.", "family")
+	Notifications  = NewCounter("homeops_notifications_total", "Notifications sent by channel and result.", "channel", "result")
+)
@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+var httpClient = &http.Client{Timeout: sendTimeout}
+
+// Telegram sends events to a chat, group or forum topic.
+type Telegram struct {
+	ChatID   int64
+	ThreadID int
+}
+
+func (t *Telegram) Notify(ctx context.Context, e Event) error {
+	if bot == nil {
+		return errors.New("bot not started")
+	}
+	opts := &tele.SendOptions{ThreadID: t.ThreadID, ReplyMarkup: e.Markup}
+	_, err := bot.Send(&tele.Chat{ID: t.ChatID}, e.Title+"\n-------------------\n"+e.Text, opts)
+	return err
+}
+
+// Webhook POSTs the event as JSON.
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+}
+
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"category": e.Category,
+		"severity": e.Severity.String(),
+		"title":    e.Title,
+		"text":     e.Text,
+		"time":     e.Time.Format(time.RFC3339),
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	return do(req)
+}
+
+// Ntfy publishes to an ntfy topic.
+type Ntfy struct {
+	URL   string
+	Topic string
+	Token string
+}
+
+var ntfyPriority = map[Severity]string{Info: "3", Warning: "4", Critical: "5"}
+
+func (n *Ntfy) Notify(ctx context.Context, e Event) error {
+	url := strings.TrimRight(n.URL, "/") + "/" + n.Topic
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(e.Text))
+	if err != nil {
+		return err
+	}
+	// Header values must be ASCII unless encoded.
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", e.Title))
+	req.Header.Set("Priority", ntfyPriority[e.Severity])
+	req.Header.Set("Tags", e.Category)
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return do(req)
+}
+
+// Gotify posts a message to a Gotify server using an application token.
+type Gotify struct {
+	URL   string
+	Token string
+}
+
+var gotifyPriority = map[Severity]int{Info: 2, Warning: 5, Critical: 8}
+
+func (g *Gotify) Notify(ctx context.Context, e Event) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"title":    e.Title,
+		"message":  e.Text,
+		"priority": gotifyPriority[e.Severity],
+	})
+	url := strings.TrimRight(g.URL, "/") + "/message"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.Token)
+	return do(req)
+}
+
+func do(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// SMTP sends events as email. Port 465 uses implicit TLS; other ports
+// upgrade with STARTTLS when the server offers it.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTP) Notify(ctx context.Context, e Event) error {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConf := &tls.Config{ServerName: s.Host}
+	if s.Port == 465 {
+		conn = tls.Client(conn, tlsConf)
+	}
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.Port != 465 {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConf); err != nil {
+				return err
+			}
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[HomeOps] "+e.Title))
+	fmt.Fprintf(&msg, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(e.Text, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
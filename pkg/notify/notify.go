@@ -0,0 +1,187 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/metrics"
+	tele "gopkg.in/telebot.v3"
+)
+
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	}
+	return "info"
+}
+
+func parseSeverity(s string) Severity {
+	switch s {
+	case "warning":
+		return Warning
+	case "critical":
+		return Critical
+	}
+	return Info
+}
+
+// Event is an alert published by a monitor. Text is plain text; Markup is
+// only shown by Telegram channels.
+type Event struct {
+	Category string
+	Severity Severity
+	Title    string
+	Text     string
+	Time     time.Time
+	Markup   *tele.ReplyMarkup
+}
+
+// Notifier delivers events to one destination.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+type channel struct {
+	name       string
+	min        Severity
+	categories map[string]bool
+	notifier   Notifier
+}
+
+func (ch *channel) accepts(e Event) bool {
+	if e.Severity < ch.min {
+		return false
+	}
+	return len(ch.categories) == 0 || ch.categories[e.Category]
+}
+
+const sendTimeout = 30 * time.Second
+
+var (
+	bot      *tele.Bot
+	channels []*channel
+	mu       sync.RWMutex
+)
+
+// Setup stores the bot used by Telegram channels and builds the channels
+// from config.Get().
+func Setup(b *tele.Bot) {
+	bot = b
+	Configure()
+}
+
+// Configure rebuilds the channels from config.Get(). Without any
+// configured channel every event goes to NotifyChatID on Telegram.
+func Configure() {
+	cfg := config.Get()
+	chans := cfg.NotifyChannels
+	if len(chans) == 0 {
+		chans = []config.NotifyChannel{{Name: "telegram", Type: "telegram"}}
+	}
+
+	var built []*channel
+	for _, c := range chans {
+		n, err := newNotifier(c)
+		if err != nil {
+			log.Printf("Notification channel %s disabled: %v", c.Name, err)
+			continue
+		}
+		ch := &channel{name: c.Name, min: parseSeverity(c.MinSeverity), notifier: n}
+		if len(c.Categories) > 0 {
+			ch.categories = make(map[string]bool)
+			for _, cat := range c.Categories {
+				ch.categories[cat] = true
+			}
+		}
+		built = append(built, ch)
+	}
+
+	mu.Lock()
+	channels = built
+	mu.Unlock()
+	log.Printf("Notifications: %d channel(s) configured", len(built))
+}
+
+func newNotifier(c config.NotifyChannel) (Notifier, error) {
+	switch c.Type {
+	case "telegram":
+		chatID := c.ChatID
+		if chatID == 0 {
+			chatID = config.Get().NotifyChatID
+		}
+		if chatID == 0 {
+			return nil, errors.New("no chat_id")
+		}
+		return &Telegram{ChatID: chatID, ThreadID: c.ThreadID}, nil
+	case "webhook":
+		return &Webhook{URL: c.URL, Headers: c.Headers}, nil
+	case "smtp":
+		port := c.Port
+		if port == 0 {
+			port = 587
+		}
+		return &SMTP{Host: c.Host, Port: port, Username: c.Username, Password: c.Password, From: c.From, To: c.To}, nil
+	case "ntfy":
+		return &Ntfy{URL: c.URL, Topic: c.Topic, Token: c.Token}, nil
+	case "gotify":
+		return &Gotify{URL: c.URL, Token: c.Token}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", c.Type)
+}
+
+// Publish sends e to every channel whose severity and category filters
+// accept it. Failures are logged and returned joined.
+func Publish(ctx context.Context, e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	mu.RLock()
+	chans := channels
+	mu.RUnlock()
+
+	var (
+		wg    sync.WaitGroup
+		errMu sync.Mutex
+		errs  []error
+	)
+	for _, ch := range chans {
+		if !ch.accepts(e) {
+			continue
+		}
+		wg.Add(1)
+		go func(ch *channel) {
+			defer wg.Done()
+			sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+			defer cancel()
+			err := ch.notifier.Notify(sendCtx, e)
+			if err != nil {
+				log.Printf("Failed to send %s notification via %s: %v", e.Category, ch.name, err)
+				metrics.Notifications.Inc(ch.name, "error")
+				errMu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", ch.name, err))
+				errMu.Unlock()
+				return
+			}
+			metrics.Notifications.Inc(ch.name, "ok")
+		}(ch)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,47 @@
+package openclash
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/yingxiaomo/homeops/pkg/scheduler"
+)
+
+var validModes = map[string]bool{"rule": true, "global": true, "direct": true, "script": true}
+
+// SetMode switches the Clash proxy mode (Rule, Global, Direct or Script).
+func SetMode(ctx context.Context, mode string) error {
+	return NewClient().PatchConfig(ctx, map[string]interface{}{
+		"mode": mode,
+	})
+}
+
+// RegisterActions makes the OpenClash actions available to scheduled jobs.
+func RegisterActions() {
+	scheduler.RegisterAction(scheduler.Action{
+		Name:      "clash_mode",
+		Label:     "切换 Clash 模式",
+		Subsystem: "clash",
+		ArgHint:   "<rule|global|direct|script>",
+		Check:     checkMode,
+		Run: func(ctx context.Context, arg string) (string, error) {
+			if err := checkMode(arg); err != nil {
+				return "", err
+			}
+			mode := strings.ToLower(arg)
+			mode = strings.ToUpper(mode[:1]) + mode[1:]
+			if err := SetMode(ctx, mode); err != nil {
+				return "", err
+			}
+			return "已切换为 " + mode, nil
+		},
+	})
+}
+
+func checkMode(mode string) error {
+	if !validModes[strings.ToLower(mode)] {
+		return fmt.Errorf("未知模式 %q，可选: rule、global、direct、script", mode)
+	}
+	return nil
+}
@@ -18,8 +18,8 @@ var (
 )
 
 func HandleAIAnalyze(c tele.Context) error {
-	if !utils.IsAdmin(c.Sender().ID) {
-		return c.Respond(&tele.CallbackResponse{Text: "⛔ 仅限管理员使用", ShowAlert: true})
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
 	}
 
 	analyzeLock.Lock()
@@ -51,12 +51,12 @@ func HandleAIAnalyze(c tele.Context) error {
 			analyzeLock.Unlock()
 		}()
 
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		ctx, cancel := context.WithTimeout(utils.Ctx(c), 2*time.Minute)
 		defer cancel()
 
 		// For the first time, collect logs with debug level enabled.
 		// For follow-ups, we can just grab the standard logs.
-		logs, err := GetDiagnosticLogs(true)
+		logs, err := GetDiagnosticLogs(ctx, true)
 		if err != nil {
 			c.Bot().Edit(msg, fmt.Sprintf("❌ 采集失败: %v", err), &tele.ReplyMarkup{
 				InlineKeyboard: [][]tele.InlineButton{{
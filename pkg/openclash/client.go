@@ -2,6 +2,7 @@ package openclash
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -18,14 +19,15 @@ type Client struct {
 }
 
 func NewClient() *Client {
+	cfg := config.Get()
 	return &Client{
-		BaseURL: config.AppConfig.OpenClashAPIURL,
-		Secret:  config.AppConfig.OpenClashAPISecret,
+		BaseURL: cfg.OpenClashAPIURL,
+		Secret:  cfg.OpenClashAPISecret,
 		Client:  &http.Client{Timeout: 5 * time.Second},
 	}
 }
 
-func (c *Client) request(method, endpoint string, body interface{}) (*http.Response, error) {
+func (c *Client) request(ctx context.Context, method, endpoint string, body interface{}) (*http.Response, error) {
 	var bodyReader *bytes.Buffer
 	if body != nil {
 		jsonBody, _ := json.Marshal(body)
@@ -34,7 +36,7 @@ func (c *Client) request(method, endpoint string, body interface{}) (*http.Respo
 		bodyReader = bytes.NewBuffer(nil)
 	}
 
-	req, err := http.NewRequest(method, c.BaseURL+endpoint, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+endpoint, bodyReader)
 	if err != nil {
 		return nil, err
 	}
@@ -49,8 +51,8 @@ func (c *Client) request(method, endpoint string, body interface{}) (*http.Respo
 	return c.Client.Do(req)
 }
 
-func (c *Client) GetConfig() (map[string]interface{}, error) {
-	resp, err := c.request("GET", "/configs", nil)
+func (c *Client) GetConfig(ctx context.Context) (map[string]interface{}, error) {
+	resp, err := c.request(ctx, "GET", "/configs", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -67,8 +69,8 @@ func (c *Client) GetConfig() (map[string]interface{}, error) {
 	return res, nil
 }
 
-func (c *Client) PatchConfig(conf map[string]interface{}) error {
-	resp, err := c.request("PATCH", "/configs", conf)
+func (c *Client) PatchConfig(ctx context.Context, conf map[string]interface{}) error {
+	resp, err := c.request(ctx, "PATCH", "/configs", conf)
 	if err != nil {
 		return err
 	}
@@ -80,8 +82,8 @@ func (c *Client) PatchConfig(conf map[string]interface{}) error {
 	return nil
 }
 
-func (c *Client) GetProxies() (map[string]interface{}, error) {
-	resp, err := c.request("GET", "/proxies", nil)
+func (c *Client) GetProxies(ctx context.Context) (map[string]interface{}, error) {
+	resp, err := c.request(ctx, "GET", "/proxies", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -98,8 +100,8 @@ func (c *Client) GetProxies() (map[string]interface{}, error) {
 	return res, nil
 }
 
-func (c *Client) GetVersion() (map[string]interface{}, error) {
-	resp, err := c.request("GET", "/version", nil)
+func (c *Client) GetVersion(ctx context.Context) (map[string]interface{}, error) {
+	resp, err := c.request(ctx, "GET", "/version", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -116,10 +118,10 @@ func (c *Client) GetVersion() (map[string]interface{}, error) {
 	return res, nil
 }
 
-func (c *Client) ReloadConfig() error {
+func (c *Client) ReloadConfig(ctx context.Context) error {
 
 	body := map[string]string{"path": "", "payload": ""}
-	resp, err := c.request("PUT", "/configs?force=true", body)
+	resp, err := c.request(ctx, "PUT", "/configs?force=true", body)
 	if err != nil {
 		return err
 	}
@@ -131,8 +133,8 @@ func (c *Client) ReloadConfig() error {
 	return nil
 }
 
-func (c *Client) FlushFakeIP() error {
-	resp, err := c.request("POST", "/cache/fakeip/flush", nil)
+func (c *Client) FlushFakeIP(ctx context.Context) error {
+	resp, err := c.request(ctx, "POST", "/cache/fakeip/flush", nil)
 	if err != nil {
 		return err
 	}
@@ -144,8 +146,8 @@ func (c *Client) FlushFakeIP() error {
 	return nil
 }
 
-func (c *Client) FlushConnections() error {
-	resp, err := c.request("DELETE", "/connections", nil)
+func (c *Client) FlushConnections(ctx context.Context) error {
+	resp, err := c.request(ctx, "DELETE", "/connections", nil)
 	if err != nil {
 		return err
 	}
@@ -157,8 +159,8 @@ func (c *Client) FlushConnections() error {
 	return nil
 }
 
-func (c *Client) GetConnections() (map[string]interface{}, error) {
-	resp, err := c.request("GET", "/connections", nil)
+func (c *Client) GetConnections(ctx context.Context) (map[string]interface{}, error) {
+	resp, err := c.request(ctx, "GET", "/connections", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -175,10 +177,10 @@ func (c *Client) GetConnections() (map[string]interface{}, error) {
 	return res, nil
 }
 
-func (c *Client) PutProxy(group, node string) error {
+func (c *Client) PutProxy(ctx context.Context, group, node string) error {
 	body := map[string]string{"name": node}
 	endpoint := fmt.Sprintf("/proxies/%s", url.PathEscape(group))
-	resp, err := c.request("PUT", endpoint, body)
+	resp, err := c.request(ctx, "PUT", endpoint, body)
 	if err != nil {
 		return err
 	}
@@ -190,9 +192,9 @@ func (c *Client) PutProxy(group, node string) error {
 	return nil
 }
 
-func (c *Client) GetProxyDelay(node string) (int, error) {
+func (c *Client) GetProxyDelay(ctx context.Context, node string) (int, error) {
 	endpoint := fmt.Sprintf("/proxies/%s/delay?timeout=3000&url=http://www.gstatic.com/generate_204", url.PathEscape(node))
-	resp, err := c.request("GET", endpoint, nil)
+	resp, err := c.request(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return 0, err
 	}
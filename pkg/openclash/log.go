@@ -1,6 +1,7 @@
 package openclash
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -10,13 +11,13 @@ import (
 // GetDiagnosticLogs fetches a multi-source diagnostic log from the system.
 // If setDebugLevel is true, it will temporarily switch OpenClash to debug log level
 // to gather more detailed information, and then switch it back.
-func GetDiagnosticLogs(setDebugLevel bool) (string, error) {
+func GetDiagnosticLogs(ctx context.Context, setDebugLevel bool) (string, error) {
 	client := NewClient()
 
 	originalLevel := "info"
 	// Only perform level switching if requested
 	if setDebugLevel {
-		config, err := client.GetConfig()
+		config, err := client.GetConfig(ctx)
 		if err == nil && config != nil {
 			if l, ok := config["log-level"].(string); ok {
 				originalLevel = l
@@ -24,7 +25,7 @@ func GetDiagnosticLogs(setDebugLevel bool) (string, error) {
 		}
 
 		if originalLevel != "debug" {
-			client.PatchConfig(map[string]interface{}{"log-level": "debug"})
+			client.PatchConfig(ctx, map[string]interface{}{"log-level": "debug"})
 			// Wait for a moment to allow new logs to be generated
 			time.Sleep(5 * time.Second)
 		}
@@ -33,7 +34,7 @@ func GetDiagnosticLogs(setDebugLevel bool) (string, error) {
 	// Ensure log level is restored if it was changed
 	defer func() {
 		if setDebugLevel && originalLevel != "debug" {
-			client.PatchConfig(map[string]interface{}{"log-level": originalLevel})
+			client.PatchConfig(context.WithoutCancel(ctx), map[string]interface{}{"log-level": originalLevel})
 		}
 	}()
 
@@ -42,7 +43,7 @@ func GetDiagnosticLogs(setDebugLevel bool) (string, error) {
 		"echo '--- [SYSTEM SYSLOG] ---'; logread | grep -E -i 'clash|openclash' | tail -n 100; " +
 		"echo '--- [NETWORK STATUS] ---'; ubus call network.interface.wan status | grep -E 'up|address|pending'"
 
-	logs, err := openwrt.SSHExec(diagCmd)
+	logs, err := openwrt.Exec(ctx, diagCmd)
 	if err != nil {
 		return "", err
 	}
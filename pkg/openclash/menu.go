@@ -7,18 +7,18 @@ import (
 	"sync"
 	"time"
 
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/router"
+	"github.com/yingxiaomo/homeops/pkg/session"
 	"github.com/yingxiaomo/homeops/pkg/utils"
 	tele "gopkg.in/telebot.v3"
 )
 
 func HandleMenu(c tele.Context) error {
-	if !utils.IsAdmin(c.Sender().ID) {
-		return c.Send("⛔ 此功能仅限管理员使用。")
-	}
-
+	ctx := utils.Ctx(c)
 	client := NewClient()
 
-	cfg, err := client.GetConfig()
+	cfg, err := client.GetConfig(ctx)
 	statusTxt := "✅ 运行中"
 	if err != nil {
 		statusTxt = fmt.Sprintf("❌ 错误: %v", err)
@@ -44,44 +44,62 @@ func HandleMenu(c tele.Context) error {
 	return utils.SendLongMessage(c, nil, txt, menu)
 }
 
-func HandleCallback(c tele.Context, data string) error {
-	switch {
-	case data == "clash_main":
-		return HandleMenu(c)
-	case data == "clash_mode":
-		return handleModeMenu(c)
-	case data == "clash_status":
-		return handleStatus(c)
-	case data == "clash_groups":
-		return handleGroups(c)
-	case data == "clash_tools":
-		return handleTools(c)
-	case data == "clash_reload":
-		return handleToolAction(c, "reload")
-	case data == "clash_flush_fakeip":
-		return handleToolAction(c, "fakeip")
-	case data == "clash_flush_conns":
-		return handleToolAction(c, "conns")
-	case data == "clash_ai_analyze":
-		return HandleAIAnalyze(c)
-	case data == "clash_speedtest_all":
-		return handleSpeedtestAll(c, "")
-	case data == "clash_toggle_debug":
-		return handleToggleDebug(c)
-	case strings.HasPrefix(data, "clash_setm_"):
-		return handleSetMode(c, data[11:])
-	case strings.HasPrefix(data, "G_"):
-		return handleListNodes(c, data[2:])
-	case strings.HasPrefix(data, "S_"):
-		parts := strings.Split(data[2:], "|")
-		if len(parts) == 2 {
-			return handleSetNode(c, parts[0], parts[1])
+// RegisterRoutes registers the OpenClash callbacks.
+func RegisterRoutes(r *router.Registry) {
+	r.Handle("clash_main", "clash", HandleMenu)
+	r.Handle("clash_mode", "clash", handleModeMenu)
+	r.Handle("clash_status", "clash", handleStatus)
+	r.Handle("clash_groups", "clash", handleGroups)
+	r.Handle("clash_tools", "clash", handleTools)
+	r.Handle("clash_reload", "clash", func(c tele.Context) error { return handleToolAction(c, "reload") })
+	r.Handle("clash_flush_fakeip", "clash", func(c tele.Context) error { return handleToolAction(c, "fakeip") })
+	r.Handle("clash_flush_conns", "clash", func(c tele.Context) error { return handleToolAction(c, "conns") })
+	r.Handle("clash_ai_analyze", "clash", HandleAIAnalyze)
+	r.Handle("clash_speedtest_all", "clash", func(c tele.Context) error { return handleSpeedtestAll(c, "") })
+	r.Handle("clash_toggle_debug", "clash", handleToggleDebug)
+	r.HandlePrefix("clash_setm_", "clash", handleSetMode)
+	r.HandlePrefix("clash_testall_", "clash", router.Token(handleSpeedtestAll))
+	r.HandlePrefix("G_", "clash", router.Token(handleListNodes))
+	r.HandlePrefix("S_", "clash", router.Token(func(c tele.Context, payload string) error {
+		group, node, ok := strings.Cut(payload, "|")
+		if !ok {
+			return c.Respond()
 		}
-	case strings.HasPrefix(data, "clash_testall_"):
-		return handleSpeedtestAll(c, data[14:])
+		return handleSetNode(c, group, node)
+	}))
+}
+
+// RegisterCommands registers the OpenClash slash commands.
+func RegisterCommands(r *router.Registry, b *tele.Bot) {
+	r.Command(b, "/clash", "clash", "OpenClash: /clash mode rule | node <组> <节点>", HandleClashCommand)
+}
+
+// HandleClashCommand handles /clash [mode <mode>|node <group> <node>]. Group
+// names containing spaces may be quoted or shortened to a unique prefix.
+func HandleClashCommand(c tele.Context) error {
+	args := c.Args()
+	if len(args) == 0 {
+		return HandleMenu(c)
 	}
 
-	return c.Respond()
+	switch strings.ToLower(args[0]) {
+	case "mode":
+		if len(args) < 2 {
+			return handleModeMenu(c)
+		}
+		mode := strings.ToLower(args[1])
+		if !validModes[mode] {
+			return c.Send("❌ 未知模式，可选: rule、global、direct、script")
+		}
+		return handleSetMode(c, mode)
+	case "node":
+		if len(args) < 3 {
+			return c.Send("用法: /clash node <组> <节点>")
+		}
+		_, rest, _ := strings.Cut(strings.TrimSpace(c.Message().Payload), args[0])
+		return handleNodeCommand(c, strings.TrimSpace(rest))
+	}
+	return c.Send("用法: /clash [mode <模式>|node <组> <节点>]")
 }
 
 func handleModeMenu(c tele.Context) error {
@@ -91,19 +109,28 @@ func handleModeMenu(c tele.Context) error {
 		menu.Row(menu.Data("Direct (直连)", "clash_setm_direct"), menu.Data("Script (脚本)", "clash_setm_script")),
 		menu.Row(menu.Data("🔙 返回", "clash_main")),
 	)
-	return c.Edit("🔄 **请选择运行模式**", menu, tele.ModeMarkdown)
+	return c.EditOrSend("🔄 **请选择运行模式**", menu, tele.ModeMarkdown)
 }
 
 func handleSetMode(c tele.Context, mode string) error {
-	client := NewClient()
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	if len(mode) > 0 {
 		mode = strings.ToUpper(mode[:1]) + mode[1:]
 	}
 
-	err := client.PatchConfig(map[string]interface{}{
-		"mode": mode,
-	})
+	err := SetMode(ctx, mode)
+	audit.Log(c, "clash", "set_mode", mode, nil, err)
 
+	if c.Callback() == nil {
+		if err != nil {
+			return c.Send("❌ 切换失败: " + err.Error())
+		}
+		return c.Send("✅ 已切换为 " + mode)
+	}
 	if err != nil {
 		return c.Respond(&tele.CallbackResponse{Text: "切换失败: " + err.Error()})
 	}
@@ -127,10 +154,11 @@ func fmtBytes(size float64) string {
 }
 
 func handleStatus(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "获取状态中..."})
 	client := NewClient()
-	ver, err := client.GetVersion()
-	conns, err2 := client.GetConnections()
+	ver, err := client.GetVersion(ctx)
+	conns, err2 := client.GetConnections(ctx)
 
 	vStr := "Unknown"
 	pStr := "Unknown"
@@ -178,9 +206,10 @@ func handleStatus(c tele.Context) error {
 }
 
 func handleGroups(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "获取节点中..."})
 	client := NewClient()
-	proxies, err := client.GetProxies()
+	proxies, err := client.GetProxies(ctx)
 	if err != nil {
 		return c.Edit("❌ 获取节点失败: " + err.Error())
 	}
@@ -212,7 +241,7 @@ func handleGroups(c tele.Context) error {
 						}
 					}
 					if !skip {
-						currentRow = append(currentRow, menu.Data(name, "G_"+name))
+						currentRow = append(currentRow, menu.Data(name, "G_"+session.PutToken(name)))
 						if len(currentRow) == 4 {
 							rows = append(rows, menu.Row(currentRow...))
 							currentRow = []tele.Btn{}
@@ -233,9 +262,10 @@ func handleGroups(c tele.Context) error {
 }
 
 func handleListNodes(c tele.Context, groupName string) error {
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "获取组信息..."})
 	client := NewClient()
-	proxies, err := client.GetProxies()
+	proxies, err := client.GetProxies(ctx)
 	if err != nil {
 		return c.Edit("❌ API Error")
 	}
@@ -285,10 +315,7 @@ func handleListNodes(c tele.Context, groupName string) error {
 			label = "✅ " + label
 		}
 
-		data := fmt.Sprintf("S_%s|%s", groupName, nodeName)
-		if len(data) > 64 {
-		}
-
+		data := "S_" + session.PutToken(groupName+"|"+nodeName)
 		currentRow = append(currentRow, menu.Data(label, data))
 		if len(currentRow) == 2 {
 			rows = append(rows, menu.Row(currentRow...))
@@ -300,16 +327,107 @@ func handleListNodes(c tele.Context, groupName string) error {
 		rows = append(rows, menu.Row(currentRow...))
 	}
 
-	rows = append(rows, menu.Row(menu.Data("⚡ 一键测速所有节点", "clash_testall_"+groupName)))
+	rows = append(rows, menu.Row(menu.Data("⚡ 一键测速所有节点", "clash_testall_"+session.PutToken(groupName))))
 	rows = append(rows, menu.Row(menu.Data("🔙 返回组列表", "clash_groups")))
 	menu.Inline(rows...)
 
 	return c.Edit(fmt.Sprintf("当前组: %s\n当前节点: %s\n请点击选择新节点:", utils.EscapeMarkdown(groupName), utils.EscapeMarkdown(nowSelected)), menu, tele.ModeMarkdown)
 }
 
+func handleNodeCommand(c tele.Context, rest string) error {
+	proxies, err := NewClient().GetProxies(utils.Ctx(c))
+	if err != nil {
+		return c.Send("❌ 获取节点失败: " + err.Error())
+	}
+
+	var groups []string
+	if pMap, ok := proxies["proxies"].(map[string]interface{}); ok {
+		for name, v := range pMap {
+			if info, ok := v.(map[string]interface{}); ok && info["type"] == "Selector" {
+				groups = append(groups, name)
+			}
+		}
+	}
+	sort.Strings(groups)
+
+	group, node, err := splitGroupNode(groups, rest)
+	if err != nil {
+		return c.Send("❌ " + err.Error())
+	}
+	return handleSetNode(c, group, node)
+}
+
+var groupQuotes = map[rune]rune{'"': '"', '“': '”', '\'': '\''}
+
+// splitGroupNode splits "<group> <node>" into its parts. The group may be
+// quoted, written out in full, or given as a prefix matching one group.
+func splitGroupNode(groups []string, rest string) (string, string, error) {
+	for open, closing := range groupQuotes {
+		if !strings.HasPrefix(rest, string(open)) {
+			continue
+		}
+		group, node, ok := strings.Cut(rest[len(string(open)):], string(closing))
+		if !ok {
+			return "", "", fmt.Errorf("组名缺少结束引号")
+		}
+		node = strings.TrimSpace(node)
+		if node == "" {
+			return "", "", fmt.Errorf("用法: /clash node <组> <节点>")
+		}
+		for _, g := range groups {
+			if g == group {
+				return group, node, nil
+			}
+		}
+		return "", "", fmt.Errorf("未找到代理组 %q", group)
+	}
+
+	// A full group name wins over a prefix; prefer the longest so that
+	// "A B" is not read as group "A" with node "B ...".
+	best := ""
+	for _, g := range groups {
+		if strings.HasPrefix(rest, g+" ") && len(g) > len(best) {
+			best = g
+		}
+	}
+	if best != "" {
+		return best, strings.TrimSpace(rest[len(best):]), nil
+	}
+
+	word, node, _ := strings.Cut(rest, " ")
+	node = strings.TrimSpace(node)
+	var matches []string
+	for _, g := range groups {
+		if strings.HasPrefix(g, word) {
+			matches = append(matches, g)
+		}
+	}
+	switch {
+	case node == "":
+		return "", "", fmt.Errorf("用法: /clash node <组> <节点>")
+	case len(matches) == 0:
+		return "", "", fmt.Errorf("未找到代理组 %q", word)
+	case len(matches) > 1:
+		return "", "", fmt.Errorf("代理组 %q 不唯一: %s，请使用引号写出完整组名", word, strings.Join(matches, "、"))
+	}
+	return matches[0], node, nil
+}
+
 func handleSetNode(c tele.Context, group, node string) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	client := NewClient()
-	err := client.PutProxy(group, node)
+	err := client.PutProxy(ctx, group, node)
+	audit.Log(c, "clash", "set_node", group, map[string]string{"node": node}, err)
+	if c.Callback() == nil {
+		if err != nil {
+			return c.Send("❌ 切换失败: " + err.Error())
+		}
+		return c.Send(fmt.Sprintf("✅ %s 已切换到 %s", group, node))
+	}
 	if err != nil {
 		return c.Respond(&tele.CallbackResponse{Text: "切换失败: " + err.Error()})
 	}
@@ -318,8 +436,9 @@ func handleSetNode(c tele.Context, group, node string) error {
 }
 
 func handleTools(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	client := NewClient()
-	cfg, _ := client.GetConfig()
+	cfg, _ := client.GetConfig(ctx)
 	logLevel := "unknown"
 	if cfg != nil {
 		if l, ok := cfg["log-level"].(string); ok {
@@ -343,6 +462,11 @@ func handleTools(c tele.Context) error {
 }
 
 func handleToolAction(c tele.Context, action string) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	client := NewClient()
 	var err error
 	var msg string
@@ -350,18 +474,20 @@ func handleToolAction(c tele.Context, action string) error {
 	switch action {
 	case "reload":
 		c.Respond(&tele.CallbackResponse{Text: "正在重载配置..."})
-		err = client.ReloadConfig()
+		err = client.ReloadConfig(ctx)
 		msg = "配置已重载"
 	case "fakeip":
 		c.Respond(&tele.CallbackResponse{Text: "正在清除 FakeIP..."})
-		err = client.FlushFakeIP()
+		err = client.FlushFakeIP(ctx)
 		msg = "FakeIP 缓存已清除"
 	case "conns":
 		c.Respond(&tele.CallbackResponse{Text: "正在断开连接..."})
-		err = client.FlushConnections()
+		err = client.FlushConnections(ctx)
 		msg = "所有连接已断开"
 	}
 
+	audit.Log(c, "clash", action, "", nil, err)
+
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
 		menu.Row(menu.Data("🔙 返回工具箱", "clash_tools")),
@@ -375,8 +501,13 @@ func handleToolAction(c tele.Context, action string) error {
 }
 
 func handleToggleDebug(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	client := NewClient()
-	cfg, err := client.GetConfig()
+	cfg, err := client.GetConfig(ctx)
 	if err != nil {
 		return c.Respond(&tele.CallbackResponse{Text: "无法获取配置"})
 	}
@@ -391,7 +522,8 @@ func handleToggleDebug(c tele.Context) error {
 		newLevel = "info"
 	}
 
-	err = client.PatchConfig(map[string]interface{}{"log-level": newLevel})
+	err = client.PatchConfig(ctx, map[string]interface{}{"log-level": newLevel})
+	audit.Log(c, "clash", "set_log_level", newLevel, nil, err)
 	if err != nil {
 		return c.Respond(&tele.CallbackResponse{Text: "切换失败"})
 	}
@@ -401,9 +533,10 @@ func handleToggleDebug(c tele.Context) error {
 }
 
 func handleSpeedtestAll(c tele.Context, groupName string) error {
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "正在测速，请稍候...", ShowAlert: true})
 	client := NewClient()
-	proxies, err := client.GetProxies()
+	proxies, err := client.GetProxies(ctx)
 	if err != nil {
 		return c.Respond(&tele.CallbackResponse{Text: "获取节点失败"})
 	}
@@ -448,7 +581,7 @@ func handleSpeedtestAll(c tele.Context, groupName string) error {
 			wg.Add(1)
 			go func(n string) {
 				defer wg.Done()
-				client.GetProxyDelay(n)
+				client.GetProxyDelay(ctx, n)
 			}(node)
 		}
 		wg.Wait()
@@ -0,0 +1,41 @@
+package openclash
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitGroupNode(t *testing.T) {
+	groups := []string{"GLOBAL", "🚀 节点选择", "🚀 节点选择 备用", "🎯 全球直连", "Auto"}
+	tests := []struct {
+		rest      string
+		group     string
+		node      string
+		wantError string
+	}{
+		{rest: "Auto HK 01", group: "Auto", node: "HK 01"},
+		{rest: `"🚀 节点选择" 香港 01`, group: "🚀 节点选择", node: "香港 01"},
+		{rest: "“🎯 全球直连” DIRECT", group: "🎯 全球直连", node: "DIRECT"},
+		{rest: "🚀 节点选择 香港 01", group: "🚀 节点选择", node: "香港 01"},
+		{rest: "🚀 节点选择 备用 香港 01", group: "🚀 节点选择 备用", node: "香港 01"},
+		{rest: "🎯 DIRECT", group: "🎯 全球直连", node: "DIRECT"},
+		{rest: "Au HK", group: "Auto", node: "HK"},
+		{rest: "🚀 香港", wantError: "不唯一"},
+		{rest: "Nope HK", wantError: "未找到代理组"},
+		{rest: `"Nope" HK`, wantError: "未找到代理组"},
+		{rest: `"🚀 节点选择 香港`, wantError: "结束引号"},
+		{rest: `"Auto"`, wantError: "用法"},
+	}
+	for _, tt := range tests {
+		group, node, err := splitGroupNode(groups, tt.rest)
+		if tt.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("splitGroupNode(%q) error = %v, want %q", tt.rest, err, tt.wantError)
+			}
+			continue
+		}
+		if err != nil || group != tt.group || node != tt.node {
+			t.Errorf("splitGroupNode(%q) = %q, %q, %v; want %q, %q", tt.rest, group, node, err, tt.group, tt.node)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package openwrt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/scheduler"
+)
+
+// RegisterActions makes the router actions available to scheduled jobs.
+func RegisterActions() {
+	scheduler.RegisterAction(scheduler.Action{
+		Name:      "reboot",
+		Label:     "重启路由器",
+		Subsystem: "wrt",
+		ArgHint:   "[路由器]",
+		Check: func(arg string) error {
+			_, err := routerOnlyArg(context.Background(), arg)
+			return err
+		},
+		Run: func(ctx context.Context, arg string) (string, error) {
+			ctx, err := routerOnlyArg(ctx, arg)
+			if err != nil {
+				return "", err
+			}
+			// Detach so the SSH session ends cleanly before the router goes down.
+			if _, err := Exec(ctx, "(sleep 2; reboot) >/dev/null 2>&1 &"); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("已向 %s 发送重启指令。", RouterName(ctx)), nil
+		},
+	})
+
+	scheduler.RegisterAction(scheduler.Action{
+		Name:      "script",
+		Label:     "运行脚本",
+		Subsystem: "wrt",
+		ArgHint:   "<脚本名> [路由器]",
+		Check: func(arg string) error {
+			_, rest, err := routerArg(context.Background(), arg)
+			if err == nil {
+				_, err = scriptPath(rest)
+			}
+			return err
+		},
+		Run: func(ctx context.Context, arg string) (string, error) {
+			ctx, rest, err := routerArg(ctx, arg)
+			if err != nil {
+				return "", err
+			}
+			path, err := scriptPath(rest)
+			if err != nil {
+				return "", err
+			}
+			return Exec(ctx, path)
+		},
+	})
+
+	scheduler.RegisterAction(scheduler.Action{
+		Name:      "backup",
+		Label:     "备份路由器配置",
+		Subsystem: "wrt",
+		ArgHint:   "[路由器]",
+		Check: func(arg string) error {
+			_, err := routerOnlyArg(context.Background(), arg)
+			return err
+		},
+		Run: func(ctx context.Context, arg string) (string, error) {
+			ctx, err := routerOnlyArg(ctx, arg)
+			if err != nil {
+				return "", err
+			}
+			b, err := CreateBackup(ctx)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("已备份 %s (%s)。", b.Name, formatSize(b.Size)), nil
+		},
+	})
+
+	scheduler.RegisterAction(scheduler.Action{
+		Name:      "adg_pause",
+		Label:     "暂停 AdGuard 防护",
+		Subsystem: "adg",
+		ArgHint:   "<时长，如 8h>",
+		Check: func(arg string) error {
+			_, err := parsePauseDuration(arg)
+			return err
+		},
+		Run: func(ctx context.Context, arg string) (string, error) {
+			d, err := parsePauseDuration(arg)
+			if err != nil {
+				return "", err
+			}
+			if err := NewAdGuardClient().SetProtection(ctx, false, d); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("防护已暂停 %s，将于 %s 自动恢复。", d, time.Now().Add(d).Format("15:04")), nil
+		},
+	})
+}
+
+// routerArg splits an optional trailing router name off arg and scopes ctx
+// to it. The rest of arg is returned.
+func routerArg(ctx context.Context, arg string) (context.Context, string, error) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return ctx, "", nil
+	}
+	last := fields[len(fields)-1]
+	if _, ok := config.Get().Router(last); ok {
+		return WithRouter(ctx, last), strings.Join(fields[:len(fields)-1], " "), nil
+	}
+	if len(fields) > 1 {
+		return ctx, "", fmt.Errorf("未知路由器 %q", last)
+	}
+	return ctx, arg, nil
+}
+
+// routerOnlyArg is routerArg for actions that take nothing but the router,
+// so that a mistyped name is not taken as the main router.
+func routerOnlyArg(ctx context.Context, arg string) (context.Context, error) {
+	ctx, rest, err := routerArg(ctx, arg)
+	if err == nil && rest != "" {
+		err = fmt.Errorf("未知路由器 %q", rest)
+	}
+	return ctx, err
+}
+
+// scriptPath turns a script name from scriptDir into its full path.
+func scriptPath(name string) (string, error) {
+	if !scriptNameRe.MatchString(name) {
+		return "", fmt.Errorf("无效的脚本名称: %q", name)
+	}
+	if !strings.HasSuffix(name, ".sh") {
+		name += ".sh"
+	}
+	return fmt.Sprintf("%s/%s", scriptDir, name), nil
+}
+
+func parsePauseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("无效的时长: %q，例如 30m、8h", s)
+	}
+	return d, nil
+}
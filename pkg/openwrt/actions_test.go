@@ -0,0 +1,62 @@
+package openwrt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yingxiaomo/homeops/config"
+)
+
+func TestRouterArg(t *testing.T) {
+	config.Set(&config.Config{Routers: []config.RouterProfile{{Name: "main"}, {Name: "edge"}}})
+
+	tests := []struct {
+		arg, router, rest string
+		err, onlyErr      bool
+	}{
+		{"", "main", "", false, false},
+		{"edge", "edge", "", false, false},
+		{"  edge  ", "edge", "", false, false},
+		{"edeg", "main", "edeg", false, true},
+		{"wan.sh", "main", "wan.sh", false, true},
+		{"wan.sh edge", "edge", "wan.sh", false, true},
+		{"wan.sh edeg", "main", "", true, true},
+	}
+	for _, tt := range tests {
+		ctx, rest, err := routerArg(context.Background(), tt.arg)
+		if (err != nil) != tt.err {
+			t.Errorf("routerArg(%q) error = %v, want error %v", tt.arg, err, tt.err)
+		}
+		if err == nil && (RouterName(ctx) != tt.router || rest != tt.rest) {
+			t.Errorf("routerArg(%q) = %s, %q; want %s, %q", tt.arg, RouterName(ctx), rest, tt.router, tt.rest)
+		}
+
+		ctx, err = routerOnlyArg(context.Background(), tt.arg)
+		if (err != nil) != tt.onlyErr {
+			t.Errorf("routerOnlyArg(%q) error = %v, want error %v", tt.arg, err, tt.onlyErr)
+		}
+		if err == nil && RouterName(ctx) != tt.router {
+			t.Errorf("routerOnlyArg(%q) = %s, want %s", tt.arg, RouterName(ctx), tt.router)
+		}
+	}
+}
+
+func TestScriptPath(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"wan", "/root/smart/wan.sh"},
+		{"wan.sh", "/root/smart/wan.sh"},
+		{"ddns_update-v2", "/root/smart/ddns_update-v2.sh"},
+		{"a;reboot", ""},
+		{"../etc/passwd", ""},
+		{"a b", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := scriptPath(tt.name)
+		if (err != nil) != (tt.want == "") || got != tt.want {
+			t.Errorf("scriptPath(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package openwrt
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -21,16 +22,17 @@ type AdGuardClient struct {
 }
 
 func NewAdGuardClient() *AdGuardClient {
+	cfg := config.Get()
 	return &AdGuardClient{
-		BaseURL:  config.AppConfig.AdgURL,
-		Username: config.AppConfig.AdgUser,
-		Password: config.AppConfig.AdgPass,
-		Token:    config.AppConfig.AdgToken,
+		BaseURL:  cfg.AdgURL,
+		Username: cfg.AdgUser,
+		Password: cfg.AdgPass,
+		Token:    cfg.AdgToken,
 		Client:   &http.Client{Timeout: 5 * time.Second},
 	}
 }
 
-func (c *AdGuardClient) Request(method, endpoint string, body interface{}) ([]byte, error) {
+func (c *AdGuardClient) Request(ctx context.Context, method, endpoint string, body interface{}) ([]byte, error) {
 	var bodyReader io.Reader
 	if body != nil {
 		jsonBody, err := json.Marshal(body)
@@ -41,7 +43,7 @@ func (c *AdGuardClient) Request(method, endpoint string, body interface{}) ([]by
 	}
 
 	url := fmt.Sprintf("%s%s", c.BaseURL, endpoint)
-	req, err := http.NewRequest(method, url, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
 	if err != nil {
 		return nil, err
 	}
@@ -69,8 +71,8 @@ func (c *AdGuardClient) Request(method, endpoint string, body interface{}) ([]by
 	return io.ReadAll(resp.Body)
 }
 
-func (c *AdGuardClient) GetDHCPLeases() ([]map[string]interface{}, error) {
-	res, err := c.Request("GET", "/control/dhcp/status", nil)
+func (c *AdGuardClient) GetDHCPLeases(ctx context.Context) ([]map[string]interface{}, error) {
+	res, err := c.Request(ctx, "GET", "/control/dhcp/status", nil)
 	if err == nil {
 		var status struct {
 			Leases []map[string]interface{} `json:"leases"`
@@ -80,7 +82,7 @@ func (c *AdGuardClient) GetDHCPLeases() ([]map[string]interface{}, error) {
 		}
 	}
 
-	if config.AppConfig.AdgLeasesMode == "api" {
+	if config.Get().AdgLeasesMode == "api" {
 		return nil, fmt.Errorf("API returned no leases and SSH fallback disabled")
 	}
 
@@ -91,7 +93,7 @@ func (c *AdGuardClient) GetDHCPLeases() ([]map[string]interface{}, error) {
 	}
 
 	for _, p := range paths {
-		content, _ := SSHExec(fmt.Sprintf("cat %s 2>/dev/null", p))
+		content, _ := ReadFile(ctx, p)
 		if content != "" {
 			leases := []map[string]interface{}{}
 			lines := strings.Split(content, "\n")
@@ -120,8 +122,8 @@ func (c *AdGuardClient) GetDHCPLeases() ([]map[string]interface{}, error) {
 	return nil, fmt.Errorf("failed to get leases from API and SSH")
 }
 
-func (c *AdGuardClient) GetFilteringStatus() (bool, error) {
-	res, err := c.Request("GET", "/control/filtering/status", nil)
+func (c *AdGuardClient) GetFilteringStatus(ctx context.Context) (bool, error) {
+	res, err := c.Request(ctx, "GET", "/control/filtering/status", nil)
 	if err != nil {
 		return false, err
 	}
@@ -135,14 +137,25 @@ func (c *AdGuardClient) GetFilteringStatus() (bool, error) {
 	return status.Enabled, nil
 }
 
-func (c *AdGuardClient) SetFiltering(enabled bool) error {
+func (c *AdGuardClient) SetFiltering(ctx context.Context, enabled bool) error {
 	body := map[string]bool{"enabled": enabled}
-	_, err := c.Request("POST", "/control/filtering/config", body)
+	_, err := c.Request(ctx, "POST", "/control/filtering/config", body)
 	return err
 }
 
-func (c *AdGuardClient) GetStats() (map[string]interface{}, error) {
-	res, err := c.Request("GET", "/control/stats", nil)
+// SetProtection enables or disables DNS protection. A positive duration
+// disables it only for that long.
+func (c *AdGuardClient) SetProtection(ctx context.Context, enabled bool, duration time.Duration) error {
+	body := map[string]interface{}{"enabled": enabled}
+	if !enabled && duration > 0 {
+		body["duration"] = duration.Milliseconds()
+	}
+	_, err := c.Request(ctx, "POST", "/control/protection", body)
+	return err
+}
+
+func (c *AdGuardClient) GetStats(ctx context.Context) (map[string]interface{}, error) {
+	res, err := c.Request(ctx, "GET", "/control/stats", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -153,8 +166,8 @@ func (c *AdGuardClient) GetStats() (map[string]interface{}, error) {
 	return stats, nil
 }
 
-func (c *AdGuardClient) GetFeatureStatus(endpoint string) (bool, error) {
-	res, err := c.Request("GET", endpoint, nil)
+func (c *AdGuardClient) GetFeatureStatus(ctx context.Context, endpoint string) (bool, error) {
+	res, err := c.Request(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return false, err
 	}
@@ -167,17 +180,17 @@ func (c *AdGuardClient) GetFeatureStatus(endpoint string) (bool, error) {
 	return status.Enabled, nil
 }
 
-func (c *AdGuardClient) SetFeatureStatus(endpoint string, enabled bool) error {
+func (c *AdGuardClient) SetFeatureStatus(ctx context.Context, endpoint string, enabled bool) error {
 	action := "disable"
 	if enabled {
 		action = "enable"
 	}
-	_, err := c.Request("POST", fmt.Sprintf("%s/%s", endpoint, action), map[string]interface{}{})
+	_, err := c.Request(ctx, "POST", fmt.Sprintf("%s/%s", endpoint, action), map[string]interface{}{})
 	return err
 }
 
-func (c *AdGuardClient) GetConfig(endpoint string) (map[string]interface{}, error) {
-	res, err := c.Request("GET", endpoint, nil)
+func (c *AdGuardClient) GetConfig(ctx context.Context, endpoint string) (map[string]interface{}, error) {
+	res, err := c.Request(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -188,13 +201,13 @@ func (c *AdGuardClient) GetConfig(endpoint string) (map[string]interface{}, erro
 	return cfg, nil
 }
 
-func (c *AdGuardClient) SetConfig(endpoint string, cfg map[string]interface{}) error {
-	_, err := c.Request("POST", endpoint, cfg)
+func (c *AdGuardClient) SetConfig(ctx context.Context, endpoint string, cfg map[string]interface{}) error {
+	_, err := c.Request(ctx, "POST", endpoint, cfg)
 	return err
 }
 
-func (c *AdGuardClient) GetDNSInfo() (map[string]interface{}, error) {
-	res, err := c.Request("GET", "/control/dns_info", nil)
+func (c *AdGuardClient) GetDNSInfo(ctx context.Context) (map[string]interface{}, error) {
+	res, err := c.Request(ctx, "GET", "/control/dns_info", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -205,12 +218,12 @@ func (c *AdGuardClient) GetDNSInfo() (map[string]interface{}, error) {
 	return info, nil
 }
 
-func (c *AdGuardClient) SetDNSConfig(cfg map[string]interface{}) error {
-	return c.SetConfig("/control/dns_config", cfg)
+func (c *AdGuardClient) SetDNSConfig(ctx context.Context, cfg map[string]interface{}) error {
+	return c.SetConfig(ctx, "/control/dns_config", cfg)
 }
 
-func (c *AdGuardClient) GetDHCPStatus() (map[string]interface{}, error) {
-	res, err := c.Request("GET", "/control/dhcp/status", nil)
+func (c *AdGuardClient) GetDHCPStatus(ctx context.Context) (map[string]interface{}, error) {
+	res, err := c.Request(ctx, "GET", "/control/dhcp/status", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -221,13 +234,13 @@ func (c *AdGuardClient) GetDHCPStatus() (map[string]interface{}, error) {
 	return status, nil
 }
 
-func (c *AdGuardClient) SetDHCPConfig(cfg map[string]interface{}) error {
-	_, err := c.Request("POST", "/control/dhcp/set_config", cfg)
+func (c *AdGuardClient) SetDHCPConfig(ctx context.Context, cfg map[string]interface{}) error {
+	_, err := c.Request(ctx, "POST", "/control/dhcp/set_config", cfg)
 	return err
 }
 
-func (c *AdGuardClient) GetFiltering() (map[string]interface{}, error) {
-	res, err := c.Request("GET", "/control/filtering/status", nil)
+func (c *AdGuardClient) GetFiltering(ctx context.Context) (map[string]interface{}, error) {
+	res, err := c.Request(ctx, "GET", "/control/filtering/status", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -238,29 +251,29 @@ func (c *AdGuardClient) GetFiltering() (map[string]interface{}, error) {
 	return status, nil
 }
 
-func (c *AdGuardClient) AddFilter(name, url string, whitelist bool) error {
+func (c *AdGuardClient) AddFilter(ctx context.Context, name, url string, whitelist bool) error {
 	body := map[string]interface{}{
 		"name":      name,
 		"url":       url,
 		"whitelist": whitelist,
 	}
-	_, err := c.Request("POST", "/control/filtering/add_url", body)
+	_, err := c.Request(ctx, "POST", "/control/filtering/add_url", body)
 	return err
 }
 
-func (c *AdGuardClient) RemoveFilter(url string, whitelist bool) error {
+func (c *AdGuardClient) RemoveFilter(ctx context.Context, url string, whitelist bool) error {
 	body := map[string]interface{}{
 		"url":       url,
 		"whitelist": whitelist,
 	}
-	_, err := c.Request("POST", "/control/filtering/remove_url", body)
+	_, err := c.Request(ctx, "POST", "/control/filtering/remove_url", body)
 	return err
 }
 
-func (c *AdGuardClient) SetRules(rules []string) error {
+func (c *AdGuardClient) SetRules(ctx context.Context, rules []string) error {
 	body := map[string]interface{}{
 		"rules": rules,
 	}
-	_, err := c.Request("POST", "/control/filtering/set_rules", body)
+	_, err := c.Request(ctx, "POST", "/control/filtering/set_rules", body)
 	return err
 }
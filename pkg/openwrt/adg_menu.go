@@ -2,27 +2,31 @@ package openwrt
 
 import (
 	"fmt"
+	"maps"
 	"strconv"
 	"strings"
 
+	"github.com/yingxiaomo/homeops/pkg/audit"
 	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/utils"
 	tele "gopkg.in/telebot.v3"
 )
 
 func HandleAdgMenu(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	client := NewAdGuardClient()
 
 	if client.BaseURL == "" {
 		c.Respond()
 		menu := &tele.ReplyMarkup{}
 		menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_main")))
-		return c.Edit("❌ AdGuard 未配置，请检查 .env 文件。", menu)
+		return c.EditOrSend("❌ AdGuard 未配置，请检查 .env 文件。", menu)
 	}
 
 	c.Respond(&tele.CallbackResponse{Text: "正在获取 AdGuard 数据..."})
 
-	filtering, err1 := client.GetFilteringStatus()
-	stats, err2 := client.GetStats()
+	filtering, err1 := client.GetFilteringStatus(ctx)
+	stats, err2 := client.GetStats(ctx)
 
 	statusIcon := "🔴"
 	statusText := "已禁用"
@@ -59,15 +63,21 @@ func HandleAdgMenu(c tele.Context) error {
 		menu.Row(menu.Data("🚫 过滤器", "wrt_adg_filters"), menu.Data("♻️ 重启服务", "wrt_adg_restart")),
 		menu.Row(menu.Data("🔙 返回", "wrt_main")),
 	)
-	return c.Edit(txt, menu, tele.ModeMarkdown)
+	return c.EditOrSend(txt, menu, tele.ModeMarkdown)
 }
 
 func HandleAdgToggle(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	client := NewAdGuardClient()
-	filtering, _ := client.GetFilteringStatus()
+	filtering, _ := client.GetFilteringStatus(ctx)
 
 	newState := !filtering
-	err := client.SetFiltering(newState)
+	err := client.SetFiltering(ctx, newState)
+	audit.Log(c, "adg", "set_filtering", "", map[string]string{"enabled": strconv.FormatBool(newState)}, err)
 
 	if err != nil {
 		c.Respond(&tele.CallbackResponse{Text: fmt.Sprintf("Error: %v", err), ShowAlert: true})
@@ -83,15 +93,16 @@ func HandleAdgToggle(c tele.Context) error {
 }
 
 func HandleAdgGeneral(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	client := NewAdGuardClient()
 	c.Respond(&tele.CallbackResponse{Text: "获取设置..."})
 
-	ss, _ := client.GetFeatureStatus("/control/safesearch/status")
-	pc, _ := client.GetFeatureStatus("/control/parental/status")
-	sb, _ := client.GetFeatureStatus("/control/safebrowsing/status")
+	ss, _ := client.GetFeatureStatus(ctx, "/control/safesearch/status")
+	pc, _ := client.GetFeatureStatus(ctx, "/control/parental/status")
+	sb, _ := client.GetFeatureStatus(ctx, "/control/safebrowsing/status")
 
-	qlCfg, _ := client.GetConfig("/control/querylog/config")
-	stCfg, _ := client.GetConfig("/control/stats/config")
+	qlCfg, _ := client.GetConfig(ctx, "/control/querylog/config")
+	stCfg, _ := client.GetConfig(ctx, "/control/stats/config")
 
 	qlOn := false
 	qlInt := 0.0
@@ -163,6 +174,11 @@ func HandleAdgGeneral(c tele.Context) error {
 }
 
 func HandleAdgGenToggle(c tele.Context, data string) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	parts := strings.Split(data, "|")
 	if len(parts) < 2 {
 		return c.Respond()
@@ -184,7 +200,8 @@ func HandleAdgGenToggle(c tele.Context, data string) error {
 	}
 
 	if endpoint != "" {
-		client.SetFeatureStatus(endpoint, val)
+		err := client.SetFeatureStatus(ctx, endpoint, val)
+		audit.Log(c, "adg", "set_feature", endpoint, map[string]string{"enabled": valStr}, err)
 	}
 
 	return HandleAdgGeneral(c)
@@ -199,12 +216,17 @@ func HandleAdgGenCycleStats(c tele.Context) error {
 }
 
 func handleAdgCycle(c tele.Context, endpoint string) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	client := NewAdGuardClient()
 	c.Respond(&tele.CallbackResponse{Text: "切换时长..."})
 
 	steps := []float64{86400000, 604800000, 2592000000, 7776000000, 0}
 
-	cfg, _ := client.GetConfig(endpoint)
+	cfg, _ := client.GetConfig(ctx, endpoint)
 	if cfg == nil {
 		return c.Respond()
 	}
@@ -232,15 +254,17 @@ func handleAdgCycle(c tele.Context, endpoint string) error {
 	cfg["enabled"] = (nextInt > 0)
 	cfg["interval"] = nextInt
 
-	client.SetConfig(endpoint, cfg)
+	err := client.SetConfig(ctx, endpoint, cfg)
+	audit.Log(c, "adg", "set_interval", endpoint, map[string]string{"interval_ms": fmt.Sprint(nextInt)}, err)
 	return HandleAdgGeneral(c)
 }
 
 func HandleAdgDns(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	client := NewAdGuardClient()
 	c.Respond(&tele.CallbackResponse{Text: "获取 DNS 信息..."})
 
-	info, err := client.GetDNSInfo()
+	info, err := client.GetDNSInfo(ctx)
 	if err != nil {
 		return c.Edit(fmt.Sprintf("❌ 获取失败: %v", err), &tele.ReplyMarkup{
 			InlineKeyboard: [][]tele.InlineButton{{{Text: "🔙 返回", Data: "wrt_adg"}}},
@@ -276,10 +300,11 @@ func HandleAdgDns(c tele.Context) error {
 
 // DNS Advanced
 func HandleAdgDNSAdvanced(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	client := NewAdGuardClient()
 	c.Respond(&tele.CallbackResponse{Text: "获取高级设置..."})
 
-	info, err := client.GetDNSInfo()
+	info, err := client.GetDNSInfo(ctx)
 	if err != nil {
 		return c.Edit(fmt.Sprintf("❌ 获取失败: %v", err))
 	}
@@ -333,6 +358,11 @@ func HandleAdgDNSAdvanced(c tele.Context) error {
 }
 
 func HandleAdgDNSToggle(c tele.Context, data string) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	parts := strings.Split(data, "|")
 	if len(parts) < 2 {
 		return c.Respond()
@@ -342,10 +372,11 @@ func HandleAdgDNSToggle(c tele.Context, data string) error {
 	val := (parts[1] == "true")
 
 	client := NewAdGuardClient()
-	info, _ := client.GetDNSInfo()
+	info, _ := client.GetDNSInfo(ctx)
 	if info == nil {
 		return c.Respond()
 	}
+	old := maps.Clone(info)
 
 	if target == "dnssec" {
 		info["dnssec_enabled"] = val
@@ -354,13 +385,19 @@ func HandleAdgDNSToggle(c tele.Context, data string) error {
 		info["disable_ipv6"] = val
 	}
 
-	client.SetDNSConfig(info)
+	err := setDNSConfigConfirmed(c, client, fmt.Sprintf("AdGuard %s = %t", target, val), info, old)
+	audit.Log(c, "adg", "dns_toggle", target, map[string]string{"value": parts[1]}, err)
 	return HandleAdgDNSAdvanced(c)
 }
 
 func HandleAdgDnsCycleBM(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	client := NewAdGuardClient()
-	info, _ := client.GetDNSInfo()
+	info, _ := client.GetDNSInfo(ctx)
 	if info == nil {
 		return c.Respond()
 	}
@@ -379,16 +416,19 @@ func HandleAdgDnsCycleBM(c tele.Context) error {
 		}
 	}
 
+	old := maps.Clone(info)
 	info["blocking_mode"] = next
-	client.SetDNSConfig(info)
+	err := setDNSConfigConfirmed(c, client, "AdGuard 拦截模式 → "+next, info, old)
+	audit.Log(c, "adg", "blocking_mode", next, nil, err)
 	return HandleAdgDNSAdvanced(c)
 }
 
 func HandleAdgDhcp(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	client := NewAdGuardClient()
 	c.Respond(&tele.CallbackResponse{Text: "读取 DHCP 租约..."})
 
-	leases, err := client.GetDHCPLeases()
+	leases, err := client.GetDHCPLeases(ctx)
 	if err != nil || len(leases) == 0 {
 		menu := &tele.ReplyMarkup{}
 		menu.Inline(
@@ -429,10 +469,11 @@ func HandleAdgDhcp(c tele.Context) error {
 }
 
 func HandleAdgDhcpConfig(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	client := NewAdGuardClient()
 	c.Respond(&tele.CallbackResponse{Text: "获取 DHCP 配置..."})
 
-	st, err := client.GetDHCPStatus()
+	st, err := client.GetDHCPStatus(ctx)
 	if err != nil {
 		return c.Edit(fmt.Sprintf("❌ 获取失败: %v", err))
 	}
@@ -456,23 +497,30 @@ func HandleAdgDhcpConfig(c tele.Context) error {
 }
 
 func HandleAdgDhcpToggle(c tele.Context, data string) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	val := (data == "true")
 	client := NewAdGuardClient()
-	st, _ := client.GetDHCPStatus()
+	st, _ := client.GetDHCPStatus(ctx)
 	if st == nil {
 		return c.Respond()
 	}
 
 	st["enabled"] = val
-	client.SetDHCPConfig(st)
+	err := client.SetDHCPConfig(ctx, st)
+	audit.Log(c, "adg", "dhcp_toggle", "", map[string]string{"enabled": data}, err)
 	return HandleAdgDhcpConfig(c)
 }
 
 func HandleAdgRules(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	client := NewAdGuardClient()
 	c.Respond(&tele.CallbackResponse{Text: "获取规则..."})
 
-	status, err := client.GetFiltering()
+	status, err := client.GetFiltering(ctx)
 	if err != nil {
 		return c.Edit(fmt.Sprintf("❌ 获取失败: %v", err))
 	}
@@ -502,10 +550,11 @@ func HandleAdgRules(c tele.Context) error {
 }
 
 func HandleAdgFilters(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	client := NewAdGuardClient()
 	c.Respond(&tele.CallbackResponse{Text: "获取过滤器..."})
 
-	status, err := client.GetFiltering()
+	status, err := client.GetFiltering(ctx)
 	if err != nil {
 		return c.Edit(fmt.Sprintf("❌ 获取失败: %v", err))
 	}
@@ -538,8 +587,14 @@ func HandleAdgFilters(c tele.Context) error {
 }
 
 func HandleAdgRestart(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "正在重启 AdGuard..."})
-	SSHExec("/etc/init.d/AdGuardHome restart || /etc/init.d/adguardhome restart")
+	_, err := Exec(ctx, "/etc/init.d/AdGuardHome restart || /etc/init.d/adguardhome restart")
+	audit.Log(c, "adg", "restart", "", nil, err)
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_adg")))
@@ -548,6 +603,7 @@ func HandleAdgRestart(c tele.Context) error {
 }
 
 func HandleAdgWizardInput(c tele.Context, state map[string]interface{}) bool {
+	ctx := utils.Ctx(c)
 	mode, ok := state["mode"].(string)
 	if !ok {
 		return false
@@ -576,27 +632,31 @@ func HandleAdgWizardInput(c tele.Context, state map[string]interface{}) bool {
 	switch mode {
 	case "set_upstreams":
 		lines := strings.Split(text, "\n")
-		cfg, _ := client.GetDNSInfo()
+		cfg, _ := client.GetDNSInfo(ctx)
 		if cfg != nil {
+			old := maps.Clone(cfg)
 			cfg["upstream_dns"] = lines
-			client.SetDNSConfig(cfg)
+			err := setDNSConfigConfirmed(c, client, "AdGuard 上游 DNS", cfg, old)
+			audit.Log(c, "adg", "set_upstreams", "", map[string]string{"upstreams": strings.Join(lines, ",")}, err)
 			c.Send("✅ 已更新上游 DNS。", menu)
 		} else {
 			c.Send("❌ 更新失败。", menu)
 		}
 	case "set_bootstrap":
 		lines := strings.Split(text, "\n")
-		cfg, _ := client.GetDNSInfo()
+		cfg, _ := client.GetDNSInfo(ctx)
 		if cfg != nil {
+			old := maps.Clone(cfg)
 			cfg["bootstrap_dns"] = lines
-			client.SetDNSConfig(cfg)
+			err := setDNSConfigConfirmed(c, client, "AdGuard Bootstrap DNS", cfg, old)
+			audit.Log(c, "adg", "set_bootstrap", "", map[string]string{"bootstrap": strings.Join(lines, ",")}, err)
 			c.Send("✅ 已更新 Bootstrap DNS。", menu)
 		} else {
 			c.Send("❌ 更新失败。", menu)
 		}
 	case "edit_rules":
 		rule := strings.TrimSpace(text)
-		status, _ := client.GetFiltering()
+		status, _ := client.GetFiltering(ctx)
 		if status != nil {
 			rules := []string{}
 			if v, ok := status["user_rules"].([]interface{}); ok {
@@ -615,11 +675,13 @@ func HandleAdgWizardInput(c tele.Context, state map[string]interface{}) bool {
 			}
 			msg := ""
 			if deleted {
-				client.SetRules(newRules)
+				err := client.SetRules(ctx, newRules)
+				audit.Log(c, "adg", "delete_rule", rule, nil, err)
 				msg = fmt.Sprintf("✅ 已删除规则: `%s`", rule)
 			} else {
 				newRules = append(newRules, rule)
-				client.SetRules(newRules)
+				err := client.SetRules(ctx, newRules)
+				audit.Log(c, "adg", "add_rule", rule, nil, err)
 				msg = fmt.Sprintf("✅ 已添加规则: `%s`", rule)
 			}
 			c.Send(msg, tele.ModeMarkdown, menu)
@@ -629,10 +691,12 @@ func HandleAdgWizardInput(c tele.Context, state map[string]interface{}) bool {
 	case "set_ratelimit":
 		val, err := strconv.Atoi(text)
 		if err == nil {
-			cfg, _ := client.GetDNSInfo()
+			cfg, _ := client.GetDNSInfo(ctx)
 			if cfg != nil {
+				old := maps.Clone(cfg)
 				cfg["ratelimit"] = val
-				client.SetDNSConfig(cfg)
+				err := setDNSConfigConfirmed(c, client, fmt.Sprintf("AdGuard 速率限制 %d/s", val), cfg, old)
+				audit.Log(c, "adg", "set_ratelimit", "", map[string]string{"ratelimit": text}, err)
 				c.Send(fmt.Sprintf("✅ 速率限制已设置为 %d/s。", val), menu)
 			} else {
 				c.Send("❌ 获取配置失败。", menu)
@@ -643,10 +707,12 @@ func HandleAdgWizardInput(c tele.Context, state map[string]interface{}) bool {
 	case "set_cache":
 		val, err := strconv.Atoi(text)
 		if err == nil {
-			cfg, _ := client.GetDNSInfo()
+			cfg, _ := client.GetDNSInfo(ctx)
 			if cfg != nil {
+				old := maps.Clone(cfg)
 				cfg["cache_size"] = val * 1024 * 1024
-				client.SetDNSConfig(cfg)
+				err := setDNSConfigConfirmed(c, client, fmt.Sprintf("AdGuard 缓存 %d MB", val), cfg, old)
+				audit.Log(c, "adg", "set_cache", "", map[string]string{"cache_mb": text}, err)
 				c.Send(fmt.Sprintf("✅ 缓存大小已设置为 %d MB。", val), menu)
 			} else {
 				c.Send("❌ 获取配置失败。", menu)
@@ -657,7 +723,8 @@ func HandleAdgWizardInput(c tele.Context, state map[string]interface{}) bool {
 	case "add_filter":
 		parts := strings.SplitN(text, " ", 2)
 		if len(parts) == 2 {
-			err := client.AddFilter(parts[0], parts[1], false)
+			err := client.AddFilter(ctx, parts[0], parts[1], false)
+			audit.Log(c, "adg", "add_filter", parts[1], map[string]string{"name": parts[0]}, err)
 			if err == nil {
 				c.Send(fmt.Sprintf("✅ 已添加过滤器: %s", parts[0]), menu)
 			} else {
@@ -668,7 +735,8 @@ func HandleAdgWizardInput(c tele.Context, state map[string]interface{}) bool {
 		}
 	case "del_filter":
 		url := strings.TrimSpace(text)
-		err := client.RemoveFilter(url, false)
+		err := client.RemoveFilter(ctx, url, false)
+		audit.Log(c, "adg", "remove_filter", url, nil, err)
 		if err == nil {
 			c.Send("✅ 已删除过滤器。", menu)
 		} else {
@@ -682,9 +750,13 @@ func HandleAdgWizardInput(c tele.Context, state map[string]interface{}) bool {
 }
 
 func HandleAdgStartWizard(c tele.Context, mode string, msg string) error {
-	session.GlobalStore.Set(c.Sender().ID, "adg_wizard", map[string]interface{}{
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	session.GlobalStore.SetWithTTL(c.Sender().ID, "adg_wizard", map[string]interface{}{
 		"mode": mode,
-	})
+	}, session.WizardTTL)
 
 	cancelBtn := "wrt_adg"
 	switch mode {
@@ -0,0 +1,423 @@
+package openwrt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+// BackupDir holds the configuration archives, one directory per router.
+const BackupDir = "data/backups"
+
+const (
+	remoteBackup  = "/tmp/homeops-backup.tar.gz"
+	remoteRestore = "/tmp/homeops-restore.tar.gz"
+	backupListMax = 15
+)
+
+var (
+	backupNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+-(\d{8}-\d{6})(?:-(\d+))?\.tar\.gz$`)
+	// backupMu serialises backups and restores, which share the remote
+	// temporary files.
+	backupMu sync.Mutex
+)
+
+// Backup is a configuration archive stored under BackupDir.
+type Backup struct {
+	Router string
+	Name   string
+	Size   int64
+	Time   time.Time
+	// seq orders backups taken within the same second.
+	seq int
+}
+
+func (b Backup) Path() string {
+	return filepath.Join(BackupDir, b.Router, b.Name)
+}
+
+// CreateBackup archives the configuration of the router selected by ctx
+// with sysupgrade -b, falling back to a tarball of /etc/config, and applies
+// the retention rules.
+func CreateBackup(ctx context.Context) (Backup, error) {
+	b, err := createBackup(ctx)
+	if err == nil {
+		if n := pruneBackups(b.Router); n > 0 {
+			log.Printf("Pruned %d old backups of %s", n, b.Router)
+		}
+	}
+	return b, err
+}
+
+// createBackup is CreateBackup without the retention rules.
+func createBackup(ctx context.Context) (Backup, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	router := RouterName(ctx)
+	now := time.Now()
+	stamp := now.Format("20060102-150405")
+	b := Backup{Router: router, Name: fmt.Sprintf("%s-%s.tar.gz", router, stamp), Time: now}
+	for n := 2; ; n++ {
+		if _, err := os.Stat(b.Path()); os.IsNotExist(err) {
+			break
+		}
+		b.Name, b.seq = fmt.Sprintf("%s-%s-%d.tar.gz", router, stamp, n), n
+	}
+
+	cmd := fmt.Sprintf("sysupgrade -b %[1]s >/dev/null 2>&1 || tar -czf %[1]s -C / etc/config", remoteBackup)
+	if out, err := Exec(ctx, cmd); err != nil {
+		return b, fmt.Errorf("backup failed: %v %s", err, strings.TrimSpace(out))
+	}
+	defer Exec(context.WithoutCancel(ctx), "rm -f "+remoteBackup)
+
+	var data bytes.Buffer
+	err := withSFTP(ctx, func(sc *sftp.Client) error {
+		f, err := sc.Open(remoteBackup)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(&data, f)
+		return err
+	})
+	if err != nil {
+		return b, fmt.Errorf("failed to download backup: %v", err)
+	}
+	if !bytes.HasPrefix(data.Bytes(), []byte{0x1f, 0x8b}) {
+		return b, fmt.Errorf("backup is not a gzip archive")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.Path()), 0700); err != nil {
+		return b, err
+	}
+	tmp := b.Path() + ".tmp"
+	if err := os.WriteFile(tmp, data.Bytes(), 0600); err != nil {
+		return b, err
+	}
+	if err := os.Rename(tmp, b.Path()); err != nil {
+		return b, err
+	}
+	b.Size = int64(data.Len())
+	return b, nil
+}
+
+// ListBackups returns the stored backups of router, newest first.
+func ListBackups(router string) ([]Backup, error) {
+	entries, err := os.ReadDir(filepath.Join(BackupDir, router))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Backup
+	for _, e := range entries {
+		if b, ok := parseBackup(router, e); ok {
+			list = append(list, b)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Time.Equal(list[j].Time) {
+			return list[i].Time.After(list[j].Time)
+		}
+		return list[i].seq > list[j].seq
+	})
+	return list, nil
+}
+
+func parseBackup(router string, e os.DirEntry) (Backup, bool) {
+	m := backupNameRe.FindStringSubmatch(e.Name())
+	if m == nil || e.IsDir() {
+		return Backup{}, false
+	}
+	t, err := time.ParseInLocation("20060102-150405", m[1], time.Local)
+	if err != nil {
+		return Backup{}, false
+	}
+	info, err := e.Info()
+	if err != nil {
+		return Backup{}, false
+	}
+	seq, _ := strconv.Atoi(m[2])
+	return Backup{Router: router, Name: e.Name(), Size: info.Size(), Time: t, seq: seq}, true
+}
+
+func getBackup(router, name string) (Backup, error) {
+	list, err := ListBackups(router)
+	if err != nil {
+		return Backup{}, err
+	}
+	for _, b := range list {
+		if b.Name == name {
+			return b, nil
+		}
+	}
+	return Backup{}, fmt.Errorf("备份 %s 不存在", name)
+}
+
+// pruneBackups keeps the newest BackupKeep backups of router and drops
+// those older than BackupMaxAge. The newest backup is always kept.
+func pruneBackups(router string) int {
+	list, err := ListBackups(router)
+	if err != nil {
+		log.Printf("Failed to list backups of %s: %v", router, err)
+		return 0
+	}
+	cfg := config.Get()
+	removed := 0
+	for i, b := range list {
+		if i == 0 {
+			continue
+		}
+		if i < cfg.BackupKeep && (cfg.BackupMaxAge == 0 || time.Since(b.Time) <= cfg.BackupMaxAge) {
+			continue
+		}
+		if err := os.Remove(b.Path()); err != nil {
+			log.Printf("Failed to remove backup %s: %v", b.Path(), err)
+			continue
+		}
+		removed++
+	}
+	return removed
+}
+
+// RestoreBackup uploads b to the router selected by ctx, restores it and
+// reboots the router.
+func RestoreBackup(ctx context.Context, b Backup) error {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	f, err := os.Open(b.Path())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = withSFTP(ctx, func(sc *sftp.Client) error {
+		dst, err := sc.Create(remoteRestore)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, f); err != nil {
+			dst.Close()
+			return err
+		}
+		return dst.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload backup: %v", err)
+	}
+
+	cmd := fmt.Sprintf("if command -v sysupgrade >/dev/null; then sysupgrade -r %[1]s; else tar -xzf %[1]s -C /; fi", remoteRestore)
+	if out, err := Exec(ctx, cmd); err != nil {
+		Exec(context.WithoutCancel(ctx), "rm -f "+remoteRestore)
+		return fmt.Errorf("restore failed: %v %s", err, strings.TrimSpace(out))
+	}
+	// The restored files are in place before the reboot; take them as the
+	// new drift baseline.
+	for _, pkg := range config.Get().DriftPackages {
+		refreshSnapshot(ctx, pkg)
+	}
+	// Detach so the SSH session ends cleanly before the router goes down.
+	_, err = Exec(ctx, fmt.Sprintf("(rm -f %s; sleep 2; reboot) >/dev/null 2>&1 &", remoteRestore))
+	return err
+}
+
+// HandleBackupMenu lists the backups of the current router.
+func HandleBackupMenu(c tele.Context) error {
+	c.Respond()
+	router := RouterName(utils.Ctx(c))
+	list, err := ListBackups(router)
+
+	var sb strings.Builder
+	sb.WriteString("💾 **配置备份**")
+	if multiRouter() {
+		sb.WriteString(fmt.Sprintf(" · `%s`", router))
+	}
+	sb.WriteString("\n")
+	switch {
+	case err != nil:
+		sb.WriteString(fmt.Sprintf("❌ 读取备份失败: %s\n", utils.EscapeMarkdown(err.Error())))
+	case len(list) == 0:
+		sb.WriteString("📂 暂无备份。\n")
+	default:
+		cfg := config.Get()
+		sb.WriteString(fmt.Sprintf("共 %d 个备份，保留最近 %d 个", len(list), cfg.BackupKeep))
+		if cfg.BackupMaxAge > 0 {
+			sb.WriteString(fmt.Sprintf("，最长 %d 天", int(cfg.BackupMaxAge.Hours()/24)))
+		}
+		sb.WriteString("\n")
+	}
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for i, b := range list {
+		if i == backupListMax {
+			break
+		}
+		label := fmt.Sprintf("📦 %s (%s)", b.Time.Format("2006-01-02 15:04"), formatSize(b.Size))
+		rows = append(rows, menu.Row(menu.Data(label, "wrt_backup_open", session.PutToken(b.Name))))
+	}
+	rows = append(rows,
+		menu.Row(menu.Data("➕ 立即备份", "wrt_backup_new")),
+		menu.Row(menu.Data("🔙 返回", "wrt_main")),
+	)
+	menu.Inline(rows...)
+	return c.EditOrSend(sb.String(), menu, tele.ModeMarkdown)
+}
+
+// HandleBackupCreate takes a backup now.
+func HandleBackupCreate(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+	ctx := utils.Ctx(c)
+	c.Respond(&tele.CallbackResponse{Text: "正在备份..."})
+	c.Edit("⏳ 正在备份路由器配置...")
+
+	b, err := CreateBackup(ctx)
+	audit.Log(c, "wrt", "backup", b.Name, map[string]string{"router": b.Router}, err)
+
+	menu := &tele.ReplyMarkup{}
+	if err != nil {
+		menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_backup")))
+		return c.Edit(fmt.Sprintf("❌ 备份失败: %v", err), menu)
+	}
+	menu.Inline(
+		menu.Row(menu.Data("📤 发送文件", "wrt_backup_send", session.PutToken(b.Name))),
+		menu.Row(menu.Data("🔙 返回", "wrt_backup")),
+	)
+	return c.Edit(fmt.Sprintf("✅ 已备份 %s (%s)", b.Name, formatSize(b.Size)), menu)
+}
+
+// HandleBackupOpen shows one backup.
+func HandleBackupOpen(c tele.Context, name string) error {
+	b, err := getBackup(RouterName(utils.Ctx(c)), name)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: err.Error(), ShowAlert: true})
+	}
+	c.Respond()
+
+	token := session.PutToken(b.Name)
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("📤 发送文件", "wrt_backup_send", token), menu.Data("♻️ 恢复", "wrt_backup_restore", token)),
+		menu.Row(menu.Data("🗑 删除", "wrt_backup_del", token), menu.Data("🔙 返回", "wrt_backup")),
+	)
+	return c.Edit(fmt.Sprintf("📦 %s\n路由器: %s\n时间: %s\n大小: %s",
+		b.Name, b.Router, b.Time.Format("2006-01-02 15:04:05"), formatSize(b.Size)), menu)
+}
+
+// HandleBackupSend sends a backup as a document. Backups contain
+// credentials, so this is limited to admins.
+func HandleBackupSend(c tele.Context, name string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	b, err := getBackup(RouterName(utils.Ctx(c)), name)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: err.Error(), ShowAlert: true})
+	}
+	c.Respond()
+
+	doc := &tele.Document{File: tele.FromDisk(b.Path()), FileName: b.Name, Caption: "💾 " + b.Name}
+	_, err = c.Bot().Send(c.Recipient(), doc)
+	audit.Log(c, "wrt", "backup_download", b.Name, map[string]string{"router": b.Router}, err)
+	if err != nil {
+		return c.Send("❌ 发送失败: " + err.Error())
+	}
+	return nil
+}
+
+// HandleBackupDelete removes a backup.
+func HandleBackupDelete(c tele.Context, name string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	b, err := getBackup(RouterName(utils.Ctx(c)), name)
+	if err == nil {
+		err = os.Remove(b.Path())
+	}
+	audit.Log(c, "wrt", "backup_delete", name, nil, err)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: err.Error(), ShowAlert: true})
+	}
+	c.Respond(&tele.CallbackResponse{Text: "已删除"})
+	return HandleBackupMenu(c)
+}
+
+// HandleBackupRestoreConfirm asks before restoring a backup.
+func HandleBackupRestoreConfirm(c tele.Context, name string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	ctx := utils.Ctx(c)
+	b, err := getBackup(RouterName(ctx), name)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: err.Error(), ShowAlert: true})
+	}
+	c.Respond()
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("✅ 确认恢复并重启", "wrt_backup_restore_do", session.PutToken(b.Name))),
+		menu.Row(menu.Data("❌ 取消", "wrt_backup")),
+	)
+	return c.Edit(fmt.Sprintf("⚠️ 确认将 %s 恢复为 %s 时的配置吗？\n当前配置会先自动备份，恢复后路由器将重启，期间网络会中断。",
+		RouterName(ctx), b.Time.Format("2006-01-02 15:04")), menu)
+}
+
+// HandleBackupRestoreDo backs up the current configuration, then restores
+// the chosen backup.
+func HandleBackupRestoreDo(c tele.Context, name string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	ctx := utils.Ctx(c)
+	b, err := getBackup(RouterName(ctx), name)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: err.Error(), ShowAlert: true})
+	}
+	c.Respond(&tele.CallbackResponse{Text: "正在恢复..."})
+	c.Edit("⏳ 正在备份当前配置...")
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_backup")))
+
+	// Pruning could drop the backup being restored, so it waits until the
+	// restore is done.
+	pre, err := createBackup(ctx)
+	if err != nil {
+		audit.Log(c, "wrt", "backup_restore", b.Name, map[string]string{"router": b.Router}, err)
+		return c.Edit(fmt.Sprintf("❌ 备份当前配置失败，已取消恢复: %v", err), menu)
+	}
+
+	c.Edit("⏳ 正在恢复配置...")
+	err = RestoreBackup(ctx, b)
+	if n := pruneBackups(b.Router); n > 0 {
+		log.Printf("Pruned %d old backups of %s", n, b.Router)
+	}
+	audit.Log(c, "wrt", "backup_restore", b.Name, map[string]string{"router": b.Router, "previous": pre.Name}, err)
+	if err != nil {
+		return c.Edit(fmt.Sprintf("❌ 恢复失败: %v\n恢复前的配置已保存为 %s", err, pre.Name), menu)
+	}
+	return c.Edit(fmt.Sprintf("✅ 已恢复 %s，路由器正在重启...\n恢复前的配置已保存为 %s", b.Name, pre.Name), menu)
+}
@@ -0,0 +1,60 @@
+package openwrt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yingxiaomo/homeops/config"
+)
+
+func TestListAndPruneBackups(t *testing.T) {
+	t.Chdir(t.TempDir())
+	config.Set(&config.Config{BackupKeep: 3})
+
+	names := []string{
+		"main-20260101-120000.tar.gz",
+		"main-20260102-120000.tar.gz",
+		"main-20260102-120000-2.tar.gz",
+		"main-20260102-120000-10.tar.gz",
+		"main-20260103-080000.tar.gz",
+		"notes.txt",
+	}
+	dir := filepath.Join(BackupDir, "main")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range names {
+		if err := os.WriteFile(filepath.Join(dir, n), []byte{0x1f, 0x8b}, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := ListBackups("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"main-20260103-080000.tar.gz",
+		"main-20260102-120000-10.tar.gz",
+		"main-20260102-120000-2.tar.gz",
+		"main-20260102-120000.tar.gz",
+		"main-20260101-120000.tar.gz",
+	}
+	if len(list) != len(want) {
+		t.Fatalf("ListBackups = %v, want %v", list, want)
+	}
+	for i, b := range list {
+		if b.Name != want[i] {
+			t.Errorf("ListBackups[%d] = %s, want %s", i, b.Name, want[i])
+		}
+	}
+
+	if n := pruneBackups("main"); n != 2 {
+		t.Errorf("pruneBackups removed %d backups, want 2", n)
+	}
+	list, _ = ListBackups("main")
+	if len(list) != 3 || list[2].Name != want[2] {
+		t.Errorf("after pruning = %v, want the newest 3", list)
+	}
+}
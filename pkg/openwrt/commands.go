@@ -0,0 +1,114 @@
+package openwrt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/router"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+// RegisterCommands registers the OpenWrt, firewall and AdGuard slash commands.
+func RegisterCommands(r *router.Registry, b *tele.Bot) {
+	r.Command(b, "/wrt", "wrt", "OpenWrt 管理面板: /wrt [路由器]", HandleWrtCommand)
+	r.Command(b, "/status", "wrt", "路由器系统状态: /status [all]", withAll(HandleStatus, HandleStatusAll))
+	r.Command(b, "/ip", "wrt", "当前公网 IP", HandleShowCurrentIPs)
+	r.Command(b, "/devices", "wrt", "联网设备列表: /devices [all]", withAll(HandleDevices, HandleDevicesAll))
+	r.Command(b, "/ping", "wrt", "Ping 测试: /ping <host>", HandleNetCommand("ping"))
+	r.Command(b, "/trace", "wrt", "路由追踪: /trace <host>", HandleNetCommand("trace"))
+	r.Command(b, "/script", "wrt", "运行脚本: /script <name>", HandleScriptCommand)
+	r.Command(b, "/fw", "wrt.firewall", "防火墙: /fw list", HandleFwCommand)
+	r.Command(b, "/uci", "wrt.uci", "UCI 配置浏览与编辑: /uci [配置包]", HandleUCICommand)
+	r.Command(b, "/adg", "adg", "AdGuard: /adg pause 10m", HandleAdgCommand)
+}
+
+// HandleWrtCommand handles /wrt [router]; with a name it switches the
+// sender to that router profile first.
+func HandleWrtCommand(c tele.Context) error {
+	if name := strings.TrimSpace(c.Message().Payload); name != "" {
+		if !selectRouter(c, name) {
+			var names []string
+			for _, r := range config.Get().Routers {
+				names = append(names, r.Name)
+			}
+			return c.Send("❌ 未知路由器，可选: " + strings.Join(names, "、"))
+		}
+	}
+	return HandleWrtMain(c)
+}
+
+// withAll runs all instead of one when the command is given "all". Any
+// other argument is refused rather than ignored.
+func withAll(one, all tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		switch arg := strings.TrimSpace(c.Message().Payload); {
+		case strings.EqualFold(arg, "all"):
+			return all(c)
+		case arg != "":
+			return c.Send(fmt.Sprintf("❌ 未知路由器 %q，切换路由器请使用 /wrt <路由器>，查看全部请使用 all", arg))
+		}
+		return one(c)
+	}
+}
+
+// HandleFwCommand handles /fw [list|redirects|rules].
+func HandleFwCommand(c tele.Context) error {
+	args := c.Args()
+	if len(args) == 0 {
+		return HandleFwMenu(c)
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list", "all":
+		return HandleFwListAll(c)
+	case "redirects":
+		return HandleFwListRedirects(c)
+	case "rules":
+		return HandleFwListRules(c)
+	}
+	return c.Send("用法: /fw [list|redirects|rules]")
+}
+
+// HandleAdgCommand handles /adg [pause <duration>|resume].
+func HandleAdgCommand(c tele.Context) error {
+	ctx := utils.Ctx(c)
+	args := c.Args()
+	if len(args) == 0 {
+		return HandleAdgMenu(c)
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "pause":
+		if len(args) < 2 {
+			return c.Send("用法: /adg pause <时长>\n例如: /adg pause 10m")
+		}
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d <= 0 {
+			return c.Send("❌ 无效的时长，例如: 30s、10m、1h")
+		}
+		if !utils.RequireRole(c, utils.RoleOperator) {
+			return nil
+		}
+		err = NewAdGuardClient().SetProtection(ctx, false, d)
+		audit.Log(c, "adg", "pause_protection", "", map[string]string{"duration": d.String()}, err)
+		if err != nil {
+			return c.Send(fmt.Sprintf("❌ 暂停失败: %v", err))
+		}
+		return c.Send(fmt.Sprintf("⏸ AdGuard 防护已暂停 %s，将于 %s 自动恢复。", d, time.Now().Add(d).Format("15:04:05")))
+	case "resume":
+		if !utils.RequireRole(c, utils.RoleOperator) {
+			return nil
+		}
+		err := NewAdGuardClient().SetProtection(ctx, true, 0)
+		audit.Log(c, "adg", "resume_protection", "", nil, err)
+		if err != nil {
+			return c.Send(fmt.Sprintf("❌ 恢复失败: %v", err))
+		}
+		return c.Send("▶️ AdGuard 防护已恢复。")
+	}
+	return c.Send("用法: /adg [pause <时长>|resume]")
+}
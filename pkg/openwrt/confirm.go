@@ -0,0 +1,215 @@
+package openwrt
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+// A pendingChange has been applied but not yet confirmed. Unless kept within
+// the confirm window it is reverted.
+type pendingChange struct {
+	desc      string
+	subsystem string
+	userID    int64
+	chat      tele.Recipient
+	bot       *tele.Bot
+	timer     *time.Timer
+	keep      func() error
+	revert    func() error
+}
+
+var (
+	pendingChanges = make(map[string]*pendingChange)
+	pendingMu      sync.Mutex
+)
+
+// beginConfirm registers an applied change and asks the user to keep it
+// within window seconds.
+func beginConfirm(c tele.Context, window int, subsystem, desc string, keep, revert func() error) {
+	id := utils.RandomString(8)
+	pc := &pendingChange{
+		desc:      desc,
+		subsystem: subsystem,
+		userID:    c.Sender().ID,
+		chat:      c.Chat(),
+		bot:       c.Bot(),
+		keep:      keep,
+		revert:    revert,
+	}
+
+	pendingMu.Lock()
+	pendingChanges[id] = pc
+	pc.timer = time.AfterFunc(time.Duration(window)*time.Second, func() { expireChange(id) })
+	pendingMu.Unlock()
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(
+		menu.Data("✅ 保留更改", "wrt_cc_keep", id),
+		menu.Data("↩️ 立即回滚", "wrt_cc_revert", id),
+	))
+	msg := fmt.Sprintf("⚠️ **已应用: %s**\n请在 %d 秒内确认保留，否则将自动回滚。", utils.EscapeMarkdown(desc), window)
+	if _, err := c.Bot().Send(c.Chat(), msg, menu, tele.ModeMarkdown); err != nil {
+		log.Printf("Error sending confirm prompt: %v", err)
+	}
+}
+
+func takeChange(id string) *pendingChange {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	pc, ok := pendingChanges[id]
+	if !ok {
+		return nil
+	}
+	delete(pendingChanges, id)
+	pc.timer.Stop()
+	return pc
+}
+
+func expireChange(id string) {
+	pc := takeChange(id)
+	if pc == nil {
+		return
+	}
+
+	err := pc.revert()
+	audit.Record(audit.Entry{UserID: pc.userID, Subsystem: pc.subsystem, Action: "auto_rollback", Target: pc.desc, OK: err == nil, Error: errString(err)})
+
+	msg := fmt.Sprintf("↩️ 未在时限内确认，已回滚: %s", pc.desc)
+	if err != nil {
+		msg = fmt.Sprintf("❌ 自动回滚失败: %s\n%v", pc.desc, err)
+	}
+	if _, err := pc.bot.Send(pc.chat, msg); err != nil {
+		log.Printf("Error sending rollback notice: %v", err)
+	}
+}
+
+func HandleConfirmKeep(c tele.Context, id string) error {
+	return resolveChange(c, id, true)
+}
+
+func HandleConfirmRevert(c tele.Context, id string) error {
+	return resolveChange(c, id, false)
+}
+
+func resolveChange(c tele.Context, id string, keep bool) error {
+	pendingMu.Lock()
+	pc, ok := pendingChanges[id]
+	pendingMu.Unlock()
+	if !ok {
+		c.Respond(&tele.CallbackResponse{Text: "该更改已确认或已回滚"})
+		return c.Edit("⌛ 该更改已确认或已回滚。")
+	}
+	if pc.userID != c.Sender().ID && !utils.IsAdmin(c.Sender().ID) {
+		return c.Respond(&tele.CallbackResponse{Text: "⛔ 只有发起者或管理员可以确认", ShowAlert: true})
+	}
+	if pc = takeChange(id); pc == nil {
+		return c.Respond()
+	}
+
+	if keep {
+		err := pc.keep()
+		audit.Log(c, pc.subsystem, "confirm", pc.desc, nil, err)
+		if err != nil {
+			c.Respond(&tele.CallbackResponse{Text: "确认失败", ShowAlert: true})
+			return c.Edit(fmt.Sprintf("❌ 确认失败，路由器将自动回滚: %s\n%v", pc.desc, err))
+		}
+		c.Respond(&tele.CallbackResponse{Text: "已保留"})
+		return c.Edit(fmt.Sprintf("✅ 已保留: %s", pc.desc))
+	}
+
+	err := pc.revert()
+	audit.Log(c, pc.subsystem, "rollback", pc.desc, nil, err)
+	if err != nil {
+		c.Respond(&tele.CallbackResponse{Text: "回滚失败", ShowAlert: true})
+		return c.Edit(fmt.Sprintf("❌ 回滚失败: %s\n%v", pc.desc, err))
+	}
+	c.Respond(&tele.CallbackResponse{Text: "已回滚"})
+	return c.Edit(fmt.Sprintf("↩️ 已回滚: %s", pc.desc))
+}
+
+// applyUCIConfirmed snapshots /etc/config/<pkg>, runs cmd (which is expected
+// to commit and reload service, or run reload_config when service is empty)
+// and starts the confirm window. A watchdog on the router restores the
+// snapshot on its own if the bot loses connectivity before the change is
+// confirmed.
+func applyUCIConfirmed(c tele.Context, subsystem, pkg, service, desc, cmd string) error {
+	ctx := utils.Ctx(c)
+	window := config.Get().ConfirmWindow
+	if window <= 0 {
+		_, err := Exec(ctx, cmd)
+		refreshSnapshot(ctx, pkg)
+		return err
+	}
+
+	reload := "reload_config"
+	if service != "" {
+		reload = fmt.Sprintf("/etc/init.d/%s reload", service)
+	}
+	snap := fmt.Sprintf("/tmp/homeops_cc_%s_%d", pkg, time.Now().UnixNano())
+	restore := fmt.Sprintf("if [ -f %[1]s ]; then cp %[1]s /etc/config/%[2]s && rm -f %[1]s && %[3]s; fi", snap, pkg, reload)
+	watchdog := fmt.Sprintf("cp /etc/config/%s %s && (nohup sh -c 'sleep %d; %s' >/dev/null 2>&1 &)", pkg, snap, window+30, restore)
+
+	if out, err := Exec(ctx, watchdog); err != nil {
+		return fmt.Errorf("snapshot failed: %v %s", err, out)
+	}
+
+	// The drift monitor must not report the change while it is pending;
+	// whatever the outcome, the result becomes the new baseline.
+	resume := pauseDrift(ctx, pkg)
+	revert := func() error {
+		defer resume()
+		defer refreshSnapshot(ctx, pkg)
+		if out, err := Exec(ctx, restore); err != nil {
+			return fmt.Errorf("%v %s", err, out)
+		}
+		return nil
+	}
+
+	if out, err := Exec(ctx, cmd); err != nil {
+		if rerr := revert(); rerr != nil {
+			log.Printf("Error restoring %s after failed change: %v", pkg, rerr)
+		}
+		return fmt.Errorf("%v %s", err, out)
+	}
+
+	beginConfirm(c, window, subsystem, desc, func() error {
+		defer resume()
+		defer refreshSnapshot(ctx, pkg)
+		_, err := Exec(ctx, "rm -f "+snap)
+		return err
+	}, revert)
+	return nil
+}
+
+// setDNSConfigConfirmed applies cfg to AdGuard and restores old unless the
+// change is confirmed.
+func setDNSConfigConfirmed(c tele.Context, client *AdGuardClient, desc string, cfg, old map[string]interface{}) error {
+	ctx := utils.Ctx(c)
+	if err := client.SetDNSConfig(ctx, cfg); err != nil {
+		return err
+	}
+	window := config.Get().ConfirmWindow
+	if window <= 0 {
+		return nil
+	}
+
+	beginConfirm(c, window, "adg", desc, func() error { return nil }, func() error {
+		return client.SetDNSConfig(ctx, old)
+	})
+	return nil
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
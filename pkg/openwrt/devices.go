@@ -9,10 +9,11 @@ import (
 )
 
 func HandleDevices(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "获取设备列表中..."})
 
 	adg := NewAdGuardClient()
-	leases, err := adg.GetDHCPLeases()
+	leases, err := adg.GetDHCPLeases(ctx)
 	if err == nil && len(leases) > 0 {
 		txt := "📱 **当前联网设备 (ADG DHCP)**\n-------------------\n"
 		count := 0
@@ -40,14 +41,10 @@ func HandleDevices(c tele.Context) error {
 			count++
 		}
 
-		menu := &tele.ReplyMarkup{}
-		menu.Inline(
-			menu.Row(menu.Data("🔙 返回", "wrt_main")),
-		)
-		return c.Edit(txt, menu, tele.ModeMarkdown)
+		return c.EditOrSend(txt, devicesMenu(), tele.ModeMarkdown)
 	}
 
-	res, err := SSHExec("cat /tmp/dhcp.leases")
+	res, err := ReadFile(ctx, "/tmp/dhcp.leases")
 	if err == nil && strings.TrimSpace(res) != "" {
 		txt := "📱 **当前联网设备 (DHCP)**\n-------------------\n"
 		lines := strings.Split(res, "\n")
@@ -65,14 +62,10 @@ func HandleDevices(c tele.Context) error {
 			txt += "没有活跃设备。"
 		}
 
-		menu := &tele.ReplyMarkup{}
-		menu.Inline(
-			menu.Row(menu.Data("🔙 返回", "wrt_main")),
-		)
-		return c.Edit(txt, menu, tele.ModeMarkdown)
+		return c.EditOrSend(txt, devicesMenu(), tele.ModeMarkdown)
 	}
 
-	arp, _ := SSHExec("cat /proc/net/arp")
+	arp, _ := Exec(ctx, "cat /proc/net/arp")
 	if strings.TrimSpace(arp) != "" {
 		lines := strings.Split(arp, "\n")
 		if len(lines) > 1 {
@@ -95,16 +88,12 @@ func HandleDevices(c tele.Context) error {
 				}
 			}
 			if count > 0 {
-				menu := &tele.ReplyMarkup{}
-				menu.Inline(
-					menu.Row(menu.Data("🔙 返回", "wrt_main")),
-				)
-				return c.Edit(txt, menu, tele.ModeMarkdown)
+				return c.EditOrSend(txt, devicesMenu(), tele.ModeMarkdown)
 			}
 		}
 	}
 
-	neigh, _ := SSHExec("ip neigh show")
+	neigh, _ := Exec(ctx, "ip neigh show")
 	if strings.TrimSpace(neigh) != "" {
 		txt := "📱 **当前邻居列表 (IP Neigh)**\n-------------------\n"
 		lines := strings.Split(neigh, "\n")
@@ -134,17 +123,22 @@ func HandleDevices(c tele.Context) error {
 		}
 
 		if count > 0 {
-			menu := &tele.ReplyMarkup{}
-			menu.Inline(
-				menu.Row(menu.Data("🔙 返回", "wrt_main")),
-			)
-			return c.Edit(txt, menu, tele.ModeMarkdown)
+			return c.EditOrSend(txt, devicesMenu(), tele.ModeMarkdown)
 		}
 	}
 
-	return c.Edit("获取失败或没有活跃设备。", &tele.ReplyMarkup{
-		InlineKeyboard: [][]tele.InlineButton{
-			{{Text: "🔙 返回", Data: "wrt_main"}},
-		},
-	})
+	return c.EditOrSend("获取失败或没有活跃设备。", devicesMenu())
+}
+
+func devicesMenu() *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{}
+	if multiRouter() {
+		menu.Inline(
+			menu.Row(menu.Data("🗂 全部路由器", "wrt_devices_all")),
+			menu.Row(menu.Data("🔙 返回", "wrt_main")),
+		)
+	} else {
+		menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_main")))
+	}
+	return menu
 }
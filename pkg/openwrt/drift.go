@@ -0,0 +1,382 @@
+package openwrt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/lifecycle"
+	"github.com/yingxiaomo/homeops/pkg/notify"
+	"github.com/yingxiaomo/homeops/pkg/uci"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+// UCIHistoryDir holds the "uci export" snapshots, one directory per router
+// and package.
+const UCIHistoryDir = "data/uci_history"
+
+const (
+	uciHistoryKeep = 30
+	driftShownMax  = 30
+	// driftEventTTL is how long the buttons of a notice stay usable.
+	driftEventTTL = 7 * 24 * time.Hour
+	// snapshotLayout names snapshots so that they sort by time.
+	snapshotLayout = "20060102-150405.000"
+)
+
+// driftEvent is a detected change awaiting accept or revert.
+type driftEvent struct {
+	router string
+	pkg    string
+	// prev and cur name the snapshots before and after the change.
+	prev, cur string
+	at        time.Time
+}
+
+var (
+	driftEvents = make(map[string]driftEvent)
+	driftMu     sync.Mutex
+	// driftSkipped remembers packages that could not be exported so the
+	// failure is logged only once.
+	driftSkipped = make(map[string]bool)
+	// driftPaused counts the changes made through the bot that are still
+	// waiting for confirmation, by router/package. Such packages are not
+	// checked until the change is kept or reverted.
+	driftPaused = make(map[string]int)
+)
+
+// StartDriftMonitor snapshots the watched UCI packages every
+// monitors.drift_interval and reports changes made outside the bot.
+func StartDriftMonitor() {
+	lifecycle.Go(func(ctx context.Context) {
+		// Check once a minute for a changed interval while disabled.
+		interval := config.Get().DriftInterval
+		tick := interval
+		if tick == 0 {
+			tick = time.Minute
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			cfg := config.Get()
+			if cfg.DriftInterval != 0 {
+				for _, r := range cfg.Routers {
+					checkDrift(WithRouter(ctx, r.Name), cfg.DriftPackages)
+				}
+			}
+			if d := cfg.DriftInterval; d != interval {
+				interval = d
+				if d == 0 {
+					d = time.Minute
+				}
+				ticker.Reset(d)
+			}
+		}
+	})
+	log.Println("UCI drift monitor registered.")
+}
+
+func checkDrift(ctx context.Context, pkgs []string) {
+	router := RouterName(ctx)
+	for _, name := range pkgs {
+		key := router + "/" + name
+		driftMu.Lock()
+		paused := driftPaused[key] > 0
+		driftMu.Unlock()
+		if paused {
+			continue
+		}
+		text, err := Exec(ctx, "uci export "+name)
+		if err != nil {
+			driftMu.Lock()
+			if !driftSkipped[key] {
+				log.Printf("Drift check: cannot export %s on %s: %v %s", name, router, err, strings.TrimSpace(text))
+				driftSkipped[key] = true
+			}
+			driftMu.Unlock()
+			continue
+		}
+		driftMu.Lock()
+		delete(driftSkipped, key)
+		driftMu.Unlock()
+
+		cur, err := parsePackage(text, name)
+		if err != nil {
+			log.Printf("Drift check: %v", err)
+			continue
+		}
+
+		prevName, prevText, ok := latestSnapshot(router, name)
+		if !ok {
+			if _, err := saveSnapshot(router, name, text); err != nil {
+				log.Printf("Drift check: failed to save snapshot of %s: %v", key, err)
+			}
+			continue
+		}
+		prev, err := parsePackage(prevText, name)
+		if err != nil {
+			log.Printf("Drift check: snapshot %s/%s: %v", key, prevName, err)
+			continue
+		}
+
+		changes := uci.Diff(prev, cur)
+		if len(changes) == 0 {
+			continue
+		}
+		curName, err := saveSnapshot(router, name, text)
+		if err != nil {
+			log.Printf("Drift check: failed to save snapshot of %s: %v", key, err)
+			continue
+		}
+		log.Printf("Drift check: %d changes in %s", len(changes), key)
+
+		id := utils.RandomString(8)
+		now := time.Now()
+		driftMu.Lock()
+		for k, ev := range driftEvents {
+			if now.Sub(ev.at) > driftEventTTL {
+				delete(driftEvents, k)
+			}
+		}
+		driftEvents[id] = driftEvent{router: router, pkg: name, prev: prevName, cur: curName, at: now}
+		driftMu.Unlock()
+		notify.Publish(ctx, driftNotice(id, router, name, changes))
+	}
+}
+
+// pauseDrift stops drift checks of pkg on the router selected by ctx until
+// the returned function is called.
+func pauseDrift(ctx context.Context, pkg string) func() {
+	key := RouterName(ctx) + "/" + pkg
+	driftMu.Lock()
+	driftPaused[key]++
+	driftMu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			driftMu.Lock()
+			if driftPaused[key]--; driftPaused[key] <= 0 {
+				delete(driftPaused, key)
+			}
+			driftMu.Unlock()
+		})
+	}
+}
+
+// refreshSnapshot records the current version of pkg as the drift baseline
+// after a change made through the bot, so that it is not reported as drift.
+func refreshSnapshot(ctx context.Context, pkg string) {
+	if !slices.Contains(config.Get().DriftPackages, pkg) {
+		return
+	}
+	router := RouterName(ctx)
+	text, err := Exec(ctx, "uci export "+pkg)
+	if err != nil {
+		log.Printf("Drift check: cannot export %s on %s: %v %s", pkg, router, err, strings.TrimSpace(text))
+		return
+	}
+	if _, prev, ok := latestSnapshot(router, pkg); ok && prev == text {
+		return
+	}
+	if _, err := saveSnapshot(router, pkg, text); err != nil {
+		log.Printf("Drift check: failed to save snapshot of %s/%s: %v", router, pkg, err)
+	}
+}
+
+func parsePackage(text, name string) (*uci.Package, error) {
+	pkgs, err := uci.ParseExport(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+	for _, p := range pkgs {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return &uci.Package{Name: name}, nil
+}
+
+func driftNotice(id, router, pkg string, changes []uci.Change) notify.Event {
+	var sb strings.Builder
+	for i, ch := range changes {
+		if i == driftShownMax {
+			sb.WriteString(fmt.Sprintf("... 另有 %d 项变更\n", len(changes)-i))
+			break
+		}
+		sb.WriteString(ch.String() + "\n")
+	}
+
+	where := ""
+	if multiRouter() {
+		where = " · " + router
+	}
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(
+		menu.Data("↩️ 恢复上一版", "wrt_drift_revert", id),
+		menu.Data("✅ 接受", "wrt_drift_accept", id),
+	))
+	return notify.Event{
+		Category: "drift",
+		Severity: notify.Warning,
+		Title:    "📝 配置变更: " + pkg + where,
+		Text:     strings.TrimRight(sb.String(), "\n"),
+		Markup:   menu,
+	}
+}
+
+func snapshotDir(router, pkg string) string {
+	return filepath.Join(UCIHistoryDir, router, pkg)
+}
+
+// listSnapshots returns the snapshot names of a package, oldest first.
+func listSnapshots(router, pkg string) []string {
+	entries, err := os.ReadDir(snapshotDir(router, pkg))
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".uci") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func latestSnapshot(router, pkg string) (name, text string, ok bool) {
+	names := listSnapshots(router, pkg)
+	if len(names) == 0 {
+		return "", "", false
+	}
+	name = names[len(names)-1]
+	text, err := readSnapshot(router, pkg, name)
+	return name, text, err == nil
+}
+
+func readSnapshot(router, pkg, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotDir(router, pkg), name))
+	return string(data), err
+}
+
+// saveSnapshot stores text as the newest version of pkg and drops the
+// oldest versions beyond uciHistoryKeep.
+func saveSnapshot(router, pkg, text string) (string, error) {
+	dir := snapshotDir(router, pkg)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	name := time.Now().Format(snapshotLayout) + ".uci"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0600); err != nil {
+		return "", err
+	}
+
+	names := listSnapshots(router, pkg)
+	for len(names) > uciHistoryKeep {
+		os.Remove(filepath.Join(dir, names[0]))
+		names = names[1:]
+	}
+	return name, nil
+}
+
+func peekDriftEvent(id string) (driftEvent, bool) {
+	driftMu.Lock()
+	defer driftMu.Unlock()
+	ev, ok := driftEvents[id]
+	if ok && time.Since(ev.at) > driftEventTTL {
+		delete(driftEvents, id)
+		return ev, false
+	}
+	return ev, ok
+}
+
+func takeDriftEvent(id string) (driftEvent, bool) {
+	ev, ok := peekDriftEvent(id)
+	driftMu.Lock()
+	delete(driftEvents, id)
+	driftMu.Unlock()
+	return ev, ok
+}
+
+// HandleDriftAccept handles "wrt_drift_accept|<id>". The new version is
+// already the baseline, so this only records the decision.
+func HandleDriftAccept(c tele.Context, id string) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+	ev, ok := takeDriftEvent(id)
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "此变更已处理或已过期"})
+	}
+	audit.Log(c, "wrt", "drift_accept", ev.router+"/"+ev.pkg, map[string]string{"snapshot": ev.cur}, nil)
+	c.Respond(&tele.CallbackResponse{Text: "已接受"})
+	return c.Edit(fmt.Sprintf("%s\n\n✅ 已接受 (%s)", c.Message().Text, c.Sender().FirstName))
+}
+
+// HandleDriftRevert handles "wrt_drift_revert|<id>" by importing the
+// snapshot taken before the change and reloading the affected services.
+func HandleDriftRevert(c tele.Context, id string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	ev, ok := peekDriftEvent(id)
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "此变更已处理或已过期"})
+	}
+	// Importing prev would also discard any change detected after this one.
+	if latest, _, _ := latestSnapshot(ev.router, ev.pkg); latest != ev.cur {
+		return c.Respond(&tele.CallbackResponse{Text: "已有更新的变更，无法恢复此版本", ShowAlert: true})
+	}
+	if _, ok := takeDriftEvent(id); !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "此变更已处理或已过期"})
+	}
+	if _, ok := config.Get().Router(ev.router); !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "路由器已不存在"})
+	}
+	c.Respond(&tele.CallbackResponse{Text: "正在恢复..."})
+
+	// The revert goes through the confirm window like any other change, and
+	// the restored version becomes the baseline once it is kept.
+	utils.WithCtx(c, WithRouter(utils.Ctx(c), ev.router))
+	text, err := readSnapshot(ev.router, ev.pkg, ev.prev)
+	if err == nil {
+		err = applyUCIConfirmed(c, "uci", ev.pkg, "", fmt.Sprintf("恢复 %s/%s 到 %s 的版本", ev.router, ev.pkg, snapshotTime(ev.prev)), importCommand(ev.pkg, text))
+	}
+	audit.Log(c, "wrt", "drift_revert", ev.router+"/"+ev.pkg, map[string]string{"snapshot": ev.prev}, err)
+	if err != nil {
+		return c.Edit(fmt.Sprintf("%s\n\n❌ 恢复失败: %v", c.Message().Text, err))
+	}
+	return c.Edit(fmt.Sprintf("%s\n\n↩️ 已恢复到 %s 的版本 (%s)", c.Message().Text, snapshotTime(ev.prev), c.Sender().FirstName))
+}
+
+// importCommand replaces pkg with the exported text, commits it and reloads
+// the services whose configuration changed. Nothing is committed if the
+// import fails.
+func importCommand(pkg, text string) string {
+	return fmt.Sprintf("uci import %[1]s <<'HOMEOPS_EOF' && uci commit %[1]s && reload_config\n%[2]s\nHOMEOPS_EOF",
+		uci.Quote(pkg), strings.TrimRight(text, "\n"))
+}
+
+func snapshotTime(name string) string {
+	t, err := time.ParseInLocation(snapshotLayout, strings.TrimSuffix(name, ".uci"), time.Local)
+	if err != nil {
+		return name
+	}
+	return t.Format("01-02 15:04")
+}
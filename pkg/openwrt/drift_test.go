@@ -0,0 +1,97 @@
+package openwrt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+)
+
+const (
+	networkBefore = "package network\n\nconfig interface 'lan'\n\toption proto 'static'\n"
+	networkAfter  = "package network\n\nconfig interface 'lan'\n\toption proto 'dhcp'\n"
+)
+
+// setupDriftRevert records a change of network on the router "edge" and
+// returns the id of its event.
+func setupDriftRevert(t *testing.T, edge *FakeExecutor) string {
+	t.Helper()
+	config.Set(&config.Config{
+		AdminIDs:      []int64{1},
+		Routers:       []config.RouterProfile{{Name: "main"}, {Name: "edge"}},
+		DriftPackages: []string{"network"},
+	})
+	SetExecutor("edge", edge)
+
+	prev, cur := "20260101-120000.000.uci", "20260101-130000.000.uci"
+	dir := snapshotDir("edge", "network")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, text := range map[string]string{prev: networkBefore, cur: networkAfter} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	driftMu.Lock()
+	driftEvents["ev1"] = driftEvent{router: "edge", pkg: "network", prev: prev, cur: cur, at: time.Now()}
+	driftMu.Unlock()
+	t.Cleanup(func() {
+		driftMu.Lock()
+		delete(driftEvents, "ev1")
+		driftMu.Unlock()
+	})
+	return "ev1"
+}
+
+func TestHandleDriftRevert(t *testing.T) {
+	t.Chdir(t.TempDir())
+	c, calls := newTestContext(t, &FakeExecutor{})
+	edge := &FakeExecutor{Outputs: map[string]string{
+		importCommand("network", networkBefore): "",
+		"uci export network":                    networkBefore,
+	}}
+	id := setupDriftRevert(t, edge)
+
+	if err := HandleDriftRevert(c, id); err != nil {
+		t.Fatal(err)
+	}
+	if got := edge.Calls(); len(got) != 2 || got[0] != importCommand("network", networkBefore) {
+		t.Errorf("calls on edge = %q, want the import and an export", got)
+	}
+	if text := lastText(t, calls()); !strings.Contains(text, "已恢复") {
+		t.Errorf("message = %q, want it to report the revert", text)
+	}
+	if _, text, _ := latestSnapshot("edge", "network"); text != networkBefore {
+		t.Errorf("baseline = %q, want the restored version", text)
+	}
+}
+
+func TestHandleDriftRevertImportFails(t *testing.T) {
+	t.Chdir(t.TempDir())
+	c, calls := newTestContext(t, &FakeExecutor{})
+	edge := &FakeExecutor{Outputs: map[string]string{"uci export network": networkAfter}}
+	id := setupDriftRevert(t, edge)
+
+	if err := HandleDriftRevert(c, id); err != nil {
+		t.Fatal(err)
+	}
+	if text := lastText(t, calls()); !strings.Contains(text, "恢复失败") {
+		t.Errorf("message = %q, want a failure", text)
+	}
+	if _, text, _ := latestSnapshot("edge", "network"); text != networkAfter {
+		t.Errorf("baseline = %q, want the unchanged version", text)
+	}
+}
+
+func TestImportCommand(t *testing.T) {
+	got := importCommand("network", networkBefore)
+	want := "uci import 'network' <<'HOMEOPS_EOF' && uci commit 'network' && reload_config\n" +
+		strings.TrimRight(networkBefore, "\n") + "\nHOMEOPS_EOF"
+	if got != want {
+		t.Errorf("importCommand = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,137 @@
+package openwrt
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/metrics"
+)
+
+// Executor runs commands and ubus calls on one router.
+type Executor interface {
+	// Exec runs a shell command and returns its combined output.
+	Exec(ctx context.Context, cmd string) (string, error)
+	// Call invokes a ubus method and decodes its reply into out, which may
+	// be nil. args may be nil for methods without arguments.
+	Call(ctx context.Context, object, method string, args, out any) error
+	// ReadFile returns the contents of a file on the router.
+	ReadFile(ctx context.Context, path string) (string, error)
+}
+
+// Streamer is implemented by executors that can deliver a command's output
+// while it runs.
+type Streamer interface {
+	Stream(ctx context.Context, cmd string, w io.Writer) error
+}
+
+var (
+	executors  = make(map[string]Executor)
+	executorMu sync.Mutex
+)
+
+// executorFor returns the executor for r, creating one for its transport
+// on first use.
+func executorFor(r config.RouterProfile) Executor {
+	executorMu.Lock()
+	defer executorMu.Unlock()
+	if e, ok := executors[r.Name]; ok {
+		return e
+	}
+	var e Executor
+	if r.Transport == "ubus" {
+		e = NewUbusExecutor(r)
+	} else {
+		e = &SSHExecutor{Router: r}
+	}
+	executors[r.Name] = e
+	return e
+}
+
+// SetExecutor replaces the executor of the named router, e.g. with a
+// FakeExecutor in tests.
+func SetExecutor(router string, e Executor) {
+	executorMu.Lock()
+	defer executorMu.Unlock()
+	executors[router] = e
+}
+
+// ResetClient closes all router connections so the next call connects with
+// the current configuration.
+func ResetClient() {
+	executorMu.Lock()
+	defer executorMu.Unlock()
+	for name, e := range executors {
+		if c, ok := e.(io.Closer); ok {
+			c.Close()
+		}
+		delete(executors, name)
+	}
+}
+
+func observe(router string, start time.Time, err error) {
+	metrics.SSHDuration.Observe(time.Since(start), router)
+	if err != nil {
+		metrics.SSHFailures.Inc(router)
+	}
+}
+
+// Exec runs cmd on the router selected by ctx (see WithRouter), or on the
+// main router. Cancelling ctx kills the remote command.
+func Exec(ctx context.Context, cmd string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	r := routerFrom(ctx)
+	start := time.Now()
+	out, err := executorFor(r).Exec(ctx, cmd)
+	observe(r.Name, start, err)
+	return out, err
+}
+
+// Stream runs cmd on the router selected by ctx, writing its output to w as
+// it arrives. Executors that cannot stream write it once cmd has finished.
+func Stream(ctx context.Context, cmd string, w io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := routerFrom(ctx)
+	start := time.Now()
+	var err error
+	if s, ok := executorFor(r).(Streamer); ok {
+		err = s.Stream(ctx, cmd, w)
+	} else {
+		var out string
+		out, err = executorFor(r).Exec(ctx, cmd)
+		io.WriteString(w, out)
+	}
+	observe(r.Name, start, err)
+	return err
+}
+
+// Ubus calls object.method on the router selected by ctx and decodes the
+// reply into out.
+func Ubus(ctx context.Context, object, method string, args, out any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := routerFrom(ctx)
+	start := time.Now()
+	err := executorFor(r).Call(ctx, object, method, args, out)
+	observe(r.Name, start, err)
+	return err
+}
+
+// ReadFile returns the contents of path on the router selected by ctx.
+func ReadFile(ctx context.Context, path string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	r := routerFrom(ctx)
+	start := time.Now()
+	out, err := executorFor(r).ReadFile(ctx, path)
+	observe(r.Name, start, err)
+	return out, err
+}
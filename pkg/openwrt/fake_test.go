@@ -0,0 +1,83 @@
+package openwrt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// FakeExecutor is an Executor for tests. Commands, ubus calls and files are
+// answered from the maps; anything missing fails. Install it with
+// SetExecutor.
+type FakeExecutor struct {
+	// Outputs maps a command to its output.
+	Outputs map[string]string
+	// Replies maps "object method" to a value returned as JSON.
+	Replies map[string]any
+	// Files maps a path to its contents.
+	Files map[string]string
+	// Err, when set, is returned by every call.
+	Err error
+
+	mu    sync.Mutex
+	calls []string
+}
+
+// Calls returns the commands, ubus calls ("ubus object method") and file
+// reads ("read path") made so far.
+func (f *FakeExecutor) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *FakeExecutor) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *FakeExecutor) Exec(ctx context.Context, cmd string) (string, error) {
+	f.record(cmd)
+	if f.Err != nil {
+		return "", f.Err
+	}
+	out, ok := f.Outputs[cmd]
+	if !ok {
+		return "", fmt.Errorf("fake: unexpected command %q", cmd)
+	}
+	return out, nil
+}
+
+func (f *FakeExecutor) Call(ctx context.Context, object, method string, args, out any) error {
+	key := object + " " + method
+	f.record("ubus " + key)
+	if f.Err != nil {
+		return f.Err
+	}
+	reply, ok := f.Replies[key]
+	if !ok {
+		return fmt.Errorf("ubus %s: not found", key)
+	}
+	if out == nil {
+		return nil
+	}
+	b, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func (f *FakeExecutor) ReadFile(ctx context.Context, path string) (string, error) {
+	f.record("read " + path)
+	if f.Err != nil {
+		return "", f.Err
+	}
+	data, ok := f.Files[path]
+	if !ok {
+		return "", fmt.Errorf("fake: no such file %q", path)
+	}
+	return data, nil
+}
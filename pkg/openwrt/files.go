@@ -0,0 +1,478 @@
+package openwrt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/sftp"
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// fileInlineMax is the largest text file shown inside the message.
+	fileInlineMax = 3000
+	// fileTransferMax is the Bot API limit for downloading user uploads.
+	fileTransferMax = 20 << 20
+	// dirListMax caps the entries shown for one directory.
+	dirListMax = 40
+
+	uploadStateKey   = "wrt_upload"
+	uploadPendingKey = "wrt_upload_pending"
+)
+
+// pendingUpload is a received document waiting for the admin to confirm.
+type pendingUpload struct {
+	// Router is the router the target was checked on.
+	Router string `json:"router"`
+	FileID string `json:"file_id"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Target string `json:"target"`
+	Exists bool   `json:"exists"`
+}
+
+// sftpClient opens an SFTP session on the cached connection, reconnecting
+// once if the connection is dead.
+func (e *SSHExecutor) sftpClient() (*sftp.Client, error) {
+	client, err := e.getClient(false)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		if client, err = e.getClient(true); err != nil {
+			return nil, fmt.Errorf("failed to reconnect: %v", err)
+		}
+		if sc, err = sftp.NewClient(client); err != nil {
+			return nil, fmt.Errorf("sftp unavailable (is openssh-sftp-server installed?): %v", err)
+		}
+	}
+	return sc, nil
+}
+
+// withSFTP runs f with an SFTP session on the router selected by ctx.
+func withSFTP(ctx context.Context, f func(sc *sftp.Client) error) error {
+	r := routerFrom(ctx)
+	e, ok := executorFor(r).(*SSHExecutor)
+	if !ok {
+		return fmt.Errorf("file transfer needs the ssh transport, %s uses %s", r.Name, r.Transport)
+	}
+	sc, err := e.sftpClient()
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sc.Close()
+		case <-done:
+		}
+	}()
+	return f(sc)
+}
+
+// allowedPath reports whether p lies under one of the configured roots.
+func allowedPath(p string) bool {
+	if !path.IsAbs(p) || path.Clean(p) != p {
+		return false
+	}
+	for _, root := range config.Get().FileAllowlist {
+		if p == root || root == "/" || strings.HasPrefix(p, root+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPath rejects p unless both p and its resolved location, following
+// symlinks, are allowed. p itself need not exist.
+func checkPath(sc *sftp.Client, p string) error {
+	if !allowedPath(p) {
+		return fmt.Errorf("%s is outside the allowed paths", p)
+	}
+	real, err := resolvePath(sc, p)
+	if err != nil {
+		return err
+	}
+	if !allowedPath(real) {
+		return fmt.Errorf("%s resolves to %s outside the allowed paths", p, real)
+	}
+	return nil
+}
+
+// resolvePath follows the symlinks in every component of p. Components that
+// do not exist are kept as they are.
+func resolvePath(sc *sftp.Client, p string) (string, error) {
+	resolved := "/"
+	rest := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	for links := 0; len(rest) > 0; {
+		name := rest[0]
+		rest = rest[1:]
+		if name == "" {
+			continue
+		}
+		next := path.Join(resolved, name)
+		info, err := sc.Lstat(next)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > 40 {
+			return "", fmt.Errorf("too many symlinks in %s", p)
+		}
+		target, err := sc.ReadLink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return resolved, nil
+}
+
+// HandleFilesMenu lists the allowed roots.
+func HandleFilesMenu(c tele.Context) error {
+	session.GlobalStore.Delete(c.Sender().ID, uploadStateKey)
+	c.Respond()
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for _, root := range config.Get().FileAllowlist {
+		rows = append(rows, menu.Row(menu.Data("📁 "+root, "wrt_files_open", session.PutToken(root))))
+	}
+	rows = append(rows, menu.Row(menu.Data("🔙 返回", "wrt_main")))
+	menu.Inline(rows...)
+
+	txt := "📁 **文件管理**\n浏览、下载路由器上的文件，或上传文件替换 (自动备份为 `.bak`)。"
+	if multiRouter() {
+		txt += fmt.Sprintf("\n当前路由器: `%s`", RouterName(utils.Ctx(c)))
+	}
+	return c.EditOrSend(txt, menu, tele.ModeMarkdown)
+}
+
+// HandleFilesOpen lists a directory or shows a file.
+func HandleFilesOpen(c tele.Context, p string) error {
+	ctx := utils.Ctx(c)
+	c.Respond()
+
+	var info os.FileInfo
+	var entries []os.FileInfo
+	var content []byte
+	err := withSFTP(ctx, func(sc *sftp.Client) error {
+		if err := checkPath(sc, p); err != nil {
+			return err
+		}
+		var err error
+		if info, err = sc.Stat(p); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			entries, err = sc.ReadDir(p)
+			return err
+		}
+		if info.Size() <= fileInlineMax {
+			f, err := sc.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			content, err = io.ReadAll(f)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		menu := &tele.ReplyMarkup{}
+		menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_files")))
+		return c.EditOrSend(fmt.Sprintf("❌ 无法打开 %s\n%v", p, err), menu)
+	}
+
+	if info.IsDir() {
+		return showDir(c, p, entries)
+	}
+	return showFile(c, p, info, content)
+}
+
+func parentButton(menu *tele.ReplyMarkup, p string) tele.Btn {
+	if parent := path.Dir(p); parent != p && allowedPath(parent) {
+		return menu.Data("⬆️ 上级目录", "wrt_files_open", session.PutToken(parent))
+	}
+	return menu.Data("🔙 返回", "wrt_files")
+}
+
+func showDir(c tele.Context, dir string, entries []os.FileInfo) error {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir() != entries[j].IsDir() {
+			return entries[i].IsDir()
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for i, e := range entries {
+		if i == dirListMax {
+			break
+		}
+		label := "📄 " + e.Name() + " (" + formatSize(e.Size()) + ")"
+		if e.IsDir() {
+			label = "📁 " + e.Name() + "/"
+		}
+		rows = append(rows, menu.Row(menu.Data(label, "wrt_files_open", session.PutToken(path.Join(dir, e.Name())))))
+	}
+	rows = append(rows,
+		menu.Row(menu.Data("📤 上传到此目录", "wrt_files_up", session.PutToken(dir))),
+		menu.Row(parentButton(menu, dir)),
+	)
+	menu.Inline(rows...)
+
+	txt := fmt.Sprintf("📁 %s\n共 %d 项", dir, len(entries))
+	if len(entries) > dirListMax {
+		txt += fmt.Sprintf("，仅显示前 %d 项", dirListMax)
+	}
+	return c.EditOrSend(txt, menu)
+}
+
+func showFile(c tele.Context, p string, info os.FileInfo, content []byte) error {
+	token := session.PutToken(p)
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("⬇️ 下载", "wrt_files_get", token), menu.Data("📤 上传替换", "wrt_files_up", token)),
+		menu.Row(parentButton(menu, p)),
+	)
+
+	txt := fmt.Sprintf("📄 **%s**\n大小: %s | 权限: %s | 修改: %s",
+		utils.EscapeMarkdown(p), formatSize(info.Size()), info.Mode().Perm(), info.ModTime().Format("2006-01-02 15:04"))
+	if content != nil && utf8.Valid(content) && !bytes.ContainsRune(content, 0) {
+		shown := strings.ReplaceAll(strings.TrimRight(string(content), "\n"), "`", "'")
+		if shown == "" {
+			shown = "(空文件)"
+		}
+		txt += "\n```\n" + shown + "\n```"
+	}
+	return c.EditOrSend(txt, menu, tele.ModeMarkdown)
+}
+
+// HandleFilesGet sends a file as a document.
+func HandleFilesGet(c tele.Context, p string) error {
+	ctx := utils.Ctx(c)
+	c.Respond(&tele.CallbackResponse{Text: "正在下载..."})
+
+	err := withSFTP(ctx, func(sc *sftp.Client) error {
+		if err := checkPath(sc, p); err != nil {
+			return err
+		}
+		f, err := sc.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = c.Bot().Send(c.Recipient(), &tele.Document{
+			File:     tele.FromReader(f),
+			FileName: path.Base(p),
+			Caption:  p,
+		})
+		return err
+	})
+	audit.Log(c, "wrt", "file_download", p, nil, err)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ 下载 %s 失败: %v", p, err))
+	}
+	return nil
+}
+
+// HandleFilesUploadStart asks for the document to upload to target, a
+// directory or a file to replace.
+func HandleFilesUploadStart(c tele.Context, target string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	c.Respond()
+	session.GlobalStore.SetWithTTL(c.Sender().ID, uploadStateKey, target, session.WizardTTL)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("❌ 取消", "wrt_files")))
+	return c.Send(fmt.Sprintf("📤 请以文件形式发送要上传的内容。\n目标: %s\n(上传到目录时使用原文件名)", target), menu)
+}
+
+// UploadTarget returns the path userID is uploading to, if any.
+func UploadTarget(userID int64) (string, bool) {
+	return session.GetAs[string](session.GlobalStore, userID, uploadStateKey)
+}
+
+// HandleFileUpload receives a document for target and asks to confirm.
+func HandleFileUpload(c tele.Context, target string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	doc := c.Message().Document
+	session.GlobalStore.Delete(c.Sender().ID, uploadStateKey)
+	if doc.FileSize > fileTransferMax {
+		return c.Send(fmt.Sprintf("❌ 文件过大 (%s)，最大 %s", formatSize(doc.FileSize), formatSize(fileTransferMax)))
+	}
+
+	ctx := utils.Ctx(c)
+	up := pendingUpload{Router: RouterName(ctx), FileID: doc.FileID, Name: doc.FileName, Size: doc.FileSize}
+	err := withSFTP(ctx, func(sc *sftp.Client) error {
+		if info, err := sc.Stat(target); err == nil && info.IsDir() {
+			if doc.FileName == "" || strings.ContainsAny(doc.FileName, "/\x00") {
+				return fmt.Errorf("invalid file name %q", doc.FileName)
+			}
+			target = path.Join(target, doc.FileName)
+		}
+		if err := checkPath(sc, target); err != nil {
+			return err
+		}
+		info, err := sc.Stat(target)
+		if err == nil && info.IsDir() {
+			return fmt.Errorf("%s is a directory", target)
+		}
+		up.Exists = err == nil
+		return nil
+	})
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ 无法上传到 %s: %v", target, err))
+	}
+	up.Target = target
+	session.GlobalStore.SetWithTTL(c.Sender().ID, uploadPendingKey, up, session.WizardTTL)
+
+	dest := target
+	if multiRouter() {
+		dest = up.Router + ":" + target
+	}
+	txt := fmt.Sprintf("⚠️ 确认将 %s (%s) 上传到 %s？", up.Name, formatSize(up.Size), dest)
+	if up.Exists {
+		txt += fmt.Sprintf("\n目标文件已存在，原文件将备份为 %s.bak", target)
+	}
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("✅ 确认上传", "wrt_files_put"), menu.Data("❌ 取消", "wrt_files_put_cancel")))
+	return c.Send(txt, menu)
+}
+
+// HandleFilesPut writes the confirmed upload, backing up the old file.
+func HandleFilesPut(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	up, ok := session.GetAs[pendingUpload](session.GlobalStore, c.Sender().ID, uploadPendingKey)
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "上传已过期，请重新发送文件", ShowAlert: true})
+	}
+	session.GlobalStore.Delete(c.Sender().ID, uploadPendingKey)
+	if _, ok := config.Get().Router(up.Router); !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "路由器已不存在", ShowAlert: true})
+	}
+	c.Respond(&tele.CallbackResponse{Text: "正在上传..."})
+
+	// Write to the router the target was checked on, even if the sender
+	// switched routers since.
+	ctx := WithRouter(utils.Ctx(c), up.Router)
+	src, err := c.Bot().File(&tele.File{FileID: up.FileID})
+	if err == nil {
+		defer src.Close()
+		err = withSFTP(ctx, func(sc *sftp.Client) error {
+			return putFile(sc, up.Target, src)
+		})
+	}
+	if err == nil && path.Dir(up.Target) == "/etc/config" {
+		refreshSnapshot(ctx, path.Base(up.Target))
+	}
+	backup := ""
+	if up.Exists {
+		backup = up.Target + ".bak"
+	}
+	audit.Log(c, "wrt", "file_upload", up.Target, map[string]string{"router": up.Router, "size": fmt.Sprint(up.Size), "backup": backup}, err)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("📁 打开目录", "wrt_files_open", session.PutToken(path.Dir(up.Target)))))
+	if err != nil {
+		return c.Edit(fmt.Sprintf("❌ 上传 %s 失败: %v", up.Target, err), menu)
+	}
+	txt := fmt.Sprintf("✅ 已上传 %s (%s)", up.Target, formatSize(up.Size))
+	if backup != "" {
+		txt += "\n原文件已备份为 " + backup
+	}
+	return c.Edit(txt, menu)
+}
+
+// HandleFilesPutCancel discards a pending upload.
+func HandleFilesPutCancel(c tele.Context) error {
+	session.GlobalStore.Delete(c.Sender().ID, uploadPendingKey)
+	c.Respond()
+	return c.Edit("已取消上传。")
+}
+
+// putFile copies an existing target to target.bak and then writes src to
+// it, keeping the original permissions.
+func putFile(sc *sftp.Client, target string, src io.Reader) error {
+	if err := checkPath(sc, target); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := sc.Stat(target); err == nil {
+		mode = info.Mode().Perm()
+		if err := copyRemote(sc, target, target+".bak", mode); err != nil {
+			return fmt.Errorf("backup failed: %v", err)
+		}
+	}
+
+	dst, err := sc.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return sc.Chmod(target, mode)
+}
+
+func copyRemote(sc *sftp.Client, from, to string, mode os.FileMode) error {
+	src, err := sc.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := sc.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return sc.Chmod(to, mode)
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -6,44 +6,13 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/yingxiaomo/homeops/pkg/audit"
 	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/uci"
 	"github.com/yingxiaomo/homeops/pkg/utils"
 	tele "gopkg.in/telebot.v3"
 )
 
-func parseUCIFirewall(output string, prefix string) map[string]map[string]string {
-	rules := make(map[string]map[string]string)
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		if !strings.Contains(line, "=") {
-			continue
-		}
-		parts := strings.SplitN(line, "=", 2)
-		key := strings.TrimSpace(parts[0])
-		value := strings.Trim(strings.TrimSpace(parts[1]), "'")
-
-		keyParts := strings.Split(key, ".")
-		if len(keyParts) < 2 {
-			continue
-		}
-		section := keyParts[1]
-		if prefix != "" && !strings.HasPrefix(section, prefix) {
-			continue
-		}
-
-		if _, ok := rules[section]; !ok {
-			rules[section] = make(map[string]string)
-		}
-
-		if len(keyParts) == 3 {
-			rules[section][keyParts[2]] = value
-		} else {
-			rules[section]["_type"] = value
-		}
-	}
-	return rules
-}
-
 func HandleFwMenu(c tele.Context) error {
 	session.GlobalStore.Delete(c.Sender().ID, "fw_wizard")
 	c.Respond()
@@ -54,21 +23,25 @@ func HandleFwMenu(c tele.Context) error {
 		menu.Row(menu.Data("📋 显示全部", "wrt_fw_list_all")),
 		menu.Row(menu.Data("🔙 返回", "wrt_main")),
 	)
-	return c.Edit("🔥 防火墙管理\n仅显示前缀为 `homeops_` 的规则。", menu, tele.ModeMarkdown)
+	return c.EditOrSend("🔥 防火墙管理\n仅显示前缀为 `homeops_` 的规则。", menu, tele.ModeMarkdown)
 }
 
 func HandleFwListRedirects(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "读取配置中..."})
-	res, _ := SSHExec("uci show firewall")
-	rules := parseUCIFirewall(res, "")
+	fw, err := loadPackage(ctx, "firewall")
+	if err != nil {
+		return fwLoadError(c, err)
+	}
 
 	txt := "🔀 **端口转发 (Redirects)**\n-------------------\n"
 	menu := &tele.ReplyMarkup{}
 	var rows []tele.Row
 
 	count := 0
-	for sec, data := range rules {
-		if data["_type"] != "redirect" {
+	for i, data := range fw.Sections {
+		sec := fw.ID(i)
+		if data.Type != "redirect" {
 			continue
 		}
 		if !strings.HasPrefix(sec, "homeops_") {
@@ -76,25 +49,25 @@ func HandleFwListRedirects(c tele.Context) error {
 		}
 		count++
 		name := strings.TrimPrefix(sec, "homeops_")
-		srcDport := data["src_dport"]
+		srcDport := data.Get("src_dport")
 		if srcDport == "" {
 			srcDport = "?"
 		}
-		destIp := data["dest_ip"]
+		destIp := data.Get("dest_ip")
 		if destIp == "" {
 			destIp = "?"
 		}
-		destPort := data["dest_port"]
+		destPort := data.Get("dest_port")
 		if destPort == "" {
 			destPort = srcDport
 		}
-		proto := data["proto"]
+		proto := data.Get("proto")
 		if proto == "" {
 			proto = "tcp"
 		}
 
 		txt += fmt.Sprintf("🔹 `%s`: %s :%s ➝ %s:%s\n", name, utils.EscapeMarkdown(strings.ToUpper(proto)), utils.EscapeMarkdown(srcDport), utils.EscapeMarkdown(destIp), utils.EscapeMarkdown(destPort))
-		rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("🗑️ 删除 %s", name), "wrt_fw_del", sec)))
+		rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("🗑️ 删除 %s", name), "wrt_fw_del", session.PutToken(sec))))
 	}
 	if count == 0 {
 		txt += "无记录。"
@@ -102,21 +75,25 @@ func HandleFwListRedirects(c tele.Context) error {
 
 	rows = append(rows, menu.Row(menu.Data("🔙 返回", "wrt_fw_menu")))
 	menu.Inline(rows...)
-	return c.Edit(txt, menu, tele.ModeMarkdown)
+	return c.EditOrSend(txt, menu, tele.ModeMarkdown)
 }
 
 func HandleFwListRules(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "读取配置中..."})
-	res, _ := SSHExec("uci show firewall")
-	rules := parseUCIFirewall(res, "")
+	fw, err := loadPackage(ctx, "firewall")
+	if err != nil {
+		return fwLoadError(c, err)
+	}
 
 	txt := "🛡️ **通信规则 (Rules)**\n-------------------\n"
 	menu := &tele.ReplyMarkup{}
 	var rows []tele.Row
 
 	count := 0
-	for sec, data := range rules {
-		if data["_type"] != "rule" {
+	for i, data := range fw.Sections {
+		sec := fw.ID(i)
+		if data.Type != "rule" {
 			continue
 		}
 		if !strings.HasPrefix(sec, "homeops_") {
@@ -124,25 +101,25 @@ func HandleFwListRules(c tele.Context) error {
 		}
 		count++
 		name := strings.TrimPrefix(sec, "homeops_")
-		src := data["src"]
+		src := data.Get("src")
 		if src == "" {
 			src = "*"
 		}
-		dest := data["dest"]
+		dest := data.Get("dest")
 		if dest == "" {
 			dest = "*"
 		}
-		destPort := data["dest_port"]
+		destPort := data.Get("dest_port")
 		if destPort == "" {
 			destPort = "All"
 		}
-		target := data["target"]
+		target := data.Get("target")
 		if target == "" {
 			target = "?"
 		}
 
 		txt += fmt.Sprintf("🔸 `%s`: %s➝%s :%s (%s)\n", name, utils.EscapeMarkdown(src), utils.EscapeMarkdown(dest), utils.EscapeMarkdown(destPort), utils.EscapeMarkdown(target))
-		rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("🗑️ 删除 %s", name), "wrt_fw_del", sec)))
+		rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("🗑️ 删除 %s", name), "wrt_fw_del", session.PutToken(sec))))
 	}
 	if count == 0 {
 		txt += "无记录。"
@@ -150,13 +127,16 @@ func HandleFwListRules(c tele.Context) error {
 
 	rows = append(rows, menu.Row(menu.Data("🔙 返回", "wrt_fw_menu")))
 	menu.Inline(rows...)
-	return c.Edit(txt, menu, tele.ModeMarkdown)
+	return c.EditOrSend(txt, menu, tele.ModeMarkdown)
 }
 
 func HandleFwListAll(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "读取全部规则..."})
-	res, _ := SSHExec("uci show firewall")
-	rules := parseUCIFirewall(res, "")
+	fw, err := loadPackage(ctx, "firewall")
+	if err != nil {
+		return fwLoadError(c, err)
+	}
 
 	txt := "📋 **全部防火墙配置**\n-------------------\n"
 	menu := &tele.ReplyMarkup{}
@@ -164,61 +144,62 @@ func HandleFwListAll(c tele.Context) error {
 	var redirects []string
 	var ruleLines []string
 
-	for sec, data := range rules {
-		t := data["_type"]
+	for i, data := range fw.Sections {
+		sec := fw.ID(i)
+		t := data.Type
 		tag := "系统"
 		if strings.HasPrefix(sec, "homeops_") {
 			tag = "HomeOps"
 		}
 
-		name := data["name"]
+		name := data.Get("name")
 		if name == "" {
 			name = sec
 		}
 
 		if t == "redirect" {
-			srcDport := data["src_dport"]
+			srcDport := data.Get("src_dport")
 			if srcDport == "" {
 				srcDport = "?"
 			}
-			destIp := data["dest_ip"]
+			destIp := data.Get("dest_ip")
 			if destIp == "" {
 				destIp = "?"
 			}
-			destPort := data["dest_port"]
+			destPort := data.Get("dest_port")
 			if destPort == "" {
 				destPort = srcDport
 			}
-			proto := data["proto"]
+			proto := data.Get("proto")
 			if proto == "" {
 				proto = "tcp"
 			}
 
 			redirects = append(redirects, fmt.Sprintf("🔀 [%s] `%s`: %s :%s ➝ %s:%s (`%s`)", tag, name, utils.EscapeMarkdown(strings.ToUpper(proto)), utils.EscapeMarkdown(srcDport), utils.EscapeMarkdown(destIp), utils.EscapeMarkdown(destPort), sec))
 			if tag == "系统" {
-				rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("迁移为可管理: %s", name), fmt.Sprintf("wrt_fw_rename_%s", sec))))
+				rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("迁移为可管理: %s", name), "wrt_fw_rename_"+session.PutToken(sec))))
 			}
 		} else if t == "rule" {
-			src := data["src"]
+			src := data.Get("src")
 			if src == "" {
 				src = "*"
 			}
-			dest := data["dest"]
+			dest := data.Get("dest")
 			if dest == "" {
 				dest = "*"
 			}
-			destPort := data["dest_port"]
+			destPort := data.Get("dest_port")
 			if destPort == "" {
 				destPort = "All"
 			}
-			target := data["target"]
+			target := data.Get("target")
 			if target == "" {
 				target = "?"
 			}
 
 			ruleLines = append(ruleLines, fmt.Sprintf("🛡️ [%s] `%s`: %s➝%s :%s (%s) (`%s`)", tag, name, utils.EscapeMarkdown(src), utils.EscapeMarkdown(dest), utils.EscapeMarkdown(destPort), utils.EscapeMarkdown(target), sec))
 			if tag == "系统" {
-				rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("迁移为可管理: %s", name), fmt.Sprintf("wrt_fw_rename_%s", sec))))
+				rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("迁移为可管理: %s", name), "wrt_fw_rename_"+session.PutToken(sec))))
 			}
 		}
 	}
@@ -239,32 +220,36 @@ func HandleFwListAll(c tele.Context) error {
 	if len(txt) > 4000 {
 		txt = txt[:4000] + "\n...(truncated)"
 	}
-	return c.Edit(txt, menu, tele.ModeMarkdown)
+	return c.EditOrSend(txt, menu, tele.ModeMarkdown)
 }
 
-func HandleFwDel(c tele.Context) error {
-	parts := strings.Split(c.Callback().Data, "|")
-	if len(parts) < 2 {
-		return c.Respond(&tele.CallbackResponse{Text: "Error: Missing section"})
+func HandleFwDel(c tele.Context, sec string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
 	}
-	sec := parts[1]
 
 	c.Respond(&tele.CallbackResponse{Text: "正在删除..."})
-	cmd := fmt.Sprintf("uci delete firewall.%s && uci commit firewall && /etc/init.d/firewall reload", sec)
-	SSHExec(cmd)
+	cmd := uci.Delete(uci.Path("firewall", sec)) + " && uci commit firewall && /etc/init.d/firewall reload"
+	err := applyUCIConfirmed(c, "fw", "firewall", "firewall", fmt.Sprintf("删除防火墙规则 %s", sec), cmd)
+	audit.Log(c, "fw", "delete", sec, nil, err)
 
 	return HandleFwMenu(c)
 }
 
-func HandleFwRename(c tele.Context) error {
-	sec := strings.TrimPrefix(c.Callback().Data, "wrt_fw_rename_")
+func HandleFwRename(c tele.Context, sec string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "正在迁移为可管理..."})
 
-	res, _ := SSHExec("uci show firewall")
-	rules := parseUCIFirewall(res, "")
-	if data, ok := rules[sec]; ok {
-		t := data["_type"]
-		rawName := data["name"]
+	fw, err := loadPackage(ctx, "firewall")
+	if err != nil {
+		return fwLoadError(c, err)
+	}
+	if data := fw.Section(sec); data != nil {
+		rawName := data.Get("name")
 		if rawName == "" {
 			rawName = sec
 		}
@@ -272,26 +257,27 @@ func HandleFwRename(c tele.Context) error {
 		base := strings.ToLower(rawName)
 		base = regexp.MustCompile(`[^a-z0-9_]`).ReplaceAllString(base, "_")
 		if base == "" {
-			if t == "redirect" {
+			if data.Type == "redirect" {
 				base = "redirect"
 			} else {
 				base = "rule"
 			}
 		}
 
-		idx := "0"
+		idx := sec
 		matches := regexp.MustCompile(`\[(\d+)\]`).FindStringSubmatch(sec)
 		if len(matches) > 1 {
 			idx = matches[1]
 		}
 
 		newSec := fmt.Sprintf("homeops_%s", base)
-		if _, exists := rules[newSec]; exists {
+		if fw.Section(newSec) != nil {
 			newSec = fmt.Sprintf("homeops_%s_%s", base, idx)
 		}
 
-		cmd := fmt.Sprintf("uci rename firewall.%s=%s && uci commit firewall && /etc/init.d/firewall reload", sec, newSec)
-		SSHExec(cmd)
+		cmd := uci.Rename(uci.Path("firewall", sec), newSec) + " && uci commit firewall && /etc/init.d/firewall reload"
+		err := applyUCIConfirmed(c, "fw", "firewall", "firewall", fmt.Sprintf("迁移防火墙规则 %s → %s", sec, newSec), cmd)
+		audit.Log(c, "fw", "rename", sec, map[string]string{"new": newSec}, err)
 
 		menu := &tele.ReplyMarkup{}
 		menu.Inline(menu.Row(menu.Data("📋 返回全部", "wrt_fw_list_all")))
@@ -303,6 +289,12 @@ func HandleFwRename(c tele.Context) error {
 	return c.Edit(fmt.Sprintf("未找到段: %s", sec), menu)
 }
 
+func fwLoadError(c tele.Context, err error) error {
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_fw_menu")))
+	return c.EditOrSend(fmt.Sprintf("❌ 读取防火墙配置失败: %v", err), menu)
+}
+
 type FwWizardState struct {
 	Type string            `json:"type"`
 	Step string            `json:"step"`
@@ -310,13 +302,17 @@ type FwWizardState struct {
 }
 
 func HandleFwAddRedirectStart(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+
 	c.Respond()
 	state := FwWizardState{
 		Type: "redirect",
 		Step: "name",
 		Data: make(map[string]string),
 	}
-	session.GlobalStore.Set(c.Sender().ID, "fw_wizard", state)
+	session.GlobalStore.SetWithTTL(c.Sender().ID, "fw_wizard", state, session.WizardTTL)
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("取消", "wrt_fw_menu")))
@@ -324,13 +320,17 @@ func HandleFwAddRedirectStart(c tele.Context) error {
 }
 
 func HandleFwAddRuleStart(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+
 	c.Respond()
 	state := FwWizardState{
 		Type: "rule",
 		Step: "name",
 		Data: make(map[string]string),
 	}
-	session.GlobalStore.Set(c.Sender().ID, "fw_wizard", state)
+	session.GlobalStore.SetWithTTL(c.Sender().ID, "fw_wizard", state, session.WizardTTL)
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("取消", "wrt_fw_menu")))
@@ -339,11 +339,7 @@ func HandleFwAddRuleStart(c tele.Context) error {
 
 func HandleFwWizardInput(c tele.Context, text string) error {
 	userID := c.Sender().ID
-	val := session.GlobalStore.Get(userID, "fw_wizard")
-	if val == nil {
-		return nil
-	}
-	state, ok := val.(FwWizardState)
+	state, ok := session.GetAs[FwWizardState](session.GlobalStore, userID, "fw_wizard")
 	if !ok {
 		return nil
 	}
@@ -364,7 +360,7 @@ func HandleFwWizardInput(c tele.Context, text string) error {
 		case "name":
 			state.Data["name"] = text
 			state.Step = "ext_port"
-			session.GlobalStore.Set(userID, "fw_wizard", state)
+			session.GlobalStore.SetWithTTL(userID, "fw_wizard", state, session.WizardTTL)
 			return c.Send("➕ **第 2/5 步**\n请输入外部端口 (Src Dport):", menu, tele.ModeMarkdown, tele.ForceReply)
 		case "ext_port":
 			if _, err := strconv.Atoi(text); err != nil {
@@ -372,12 +368,12 @@ func HandleFwWizardInput(c tele.Context, text string) error {
 			}
 			state.Data["ext_port"] = text
 			state.Step = "int_ip"
-			session.GlobalStore.Set(userID, "fw_wizard", state)
+			session.GlobalStore.SetWithTTL(userID, "fw_wizard", state, session.WizardTTL)
 			return c.Send("➕ **第 3/5 步**\n请输入内部 IP (Dest IP):", menu, tele.ModeMarkdown, tele.ForceReply)
 		case "int_ip":
 			state.Data["int_ip"] = text
 			state.Step = "int_port"
-			session.GlobalStore.Set(userID, "fw_wizard", state)
+			session.GlobalStore.SetWithTTL(userID, "fw_wizard", state, session.WizardTTL)
 			return c.Send("➕ **第 4/5 步**\n请输入内部端口 (Dest Port):", menu, tele.ModeMarkdown, tele.ForceReply)
 		case "int_port":
 			if _, err := strconv.Atoi(text); err != nil {
@@ -385,7 +381,7 @@ func HandleFwWizardInput(c tele.Context, text string) error {
 			}
 			state.Data["int_port"] = text
 			state.Step = "proto"
-			session.GlobalStore.Set(userID, "fw_wizard", state)
+			session.GlobalStore.SetWithTTL(userID, "fw_wizard", state, session.WizardTTL)
 
 			protoMenu := &tele.ReplyMarkup{}
 			protoMenu.Inline(
@@ -400,22 +396,22 @@ func HandleFwWizardInput(c tele.Context, text string) error {
 		case "name":
 			state.Data["name"] = text
 			state.Step = "src"
-			session.GlobalStore.Set(userID, "fw_wizard", state)
+			session.GlobalStore.SetWithTTL(userID, "fw_wizard", state, session.WizardTTL)
 			return c.Send("➕ **第 2/5 步**\n请输入源区域 (Src, 如: wan):", menu, tele.ModeMarkdown, tele.ForceReply)
 		case "src":
 			state.Data["src"] = text
 			state.Step = "dest"
-			session.GlobalStore.Set(userID, "fw_wizard", state)
+			session.GlobalStore.SetWithTTL(userID, "fw_wizard", state, session.WizardTTL)
 			return c.Send("➕ **第 3/5 步**\n请输入目标区域 (Dest, 如: lan):", menu, tele.ModeMarkdown, tele.ForceReply)
 		case "dest":
 			state.Data["dest"] = text
 			state.Step = "dest_port"
-			session.GlobalStore.Set(userID, "fw_wizard", state)
+			session.GlobalStore.SetWithTTL(userID, "fw_wizard", state, session.WizardTTL)
 			return c.Send("➕ **第 4/5 步**\n请输入目标端口 (Dest Port, 留空表示全部):", menu, tele.ModeMarkdown, tele.ForceReply)
 		case "dest_port":
 			state.Data["dest_port"] = text
 			state.Step = "target"
-			session.GlobalStore.Set(userID, "fw_wizard", state)
+			session.GlobalStore.SetWithTTL(userID, "fw_wizard", state, session.WizardTTL)
 
 			targetMenu := &tele.ReplyMarkup{}
 			targetMenu.Inline(
@@ -430,13 +426,16 @@ func HandleFwWizardInput(c tele.Context, text string) error {
 }
 
 func HandleFwWizardProto(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+
 	userID := c.Sender().ID
-	val := session.GlobalStore.Get(userID, "fw_wizard")
-	if val == nil {
+	state, ok := session.GetAs[FwWizardState](session.GlobalStore, userID, "fw_wizard")
+	if !ok {
 		return c.Respond(&tele.CallbackResponse{Text: "Session expired"})
 	}
-	state, ok := val.(FwWizardState)
-	if !ok || state.Type != "redirect" || state.Step != "proto" {
+	if state.Type != "redirect" || state.Step != "proto" {
 		return c.Respond()
 	}
 
@@ -453,33 +452,37 @@ func HandleFwWizardProto(c tele.Context) error {
 	name := state.Data["name"]
 	sec := fmt.Sprintf("homeops_%s", name)
 	cmds := []string{
-		fmt.Sprintf("uci set firewall.%s=redirect", sec),
-		fmt.Sprintf("uci set firewall.%s.name='%s'", sec, name),
-		fmt.Sprintf("uci set firewall.%s.src='wan'", sec),
-		fmt.Sprintf("uci set firewall.%s.src_dport='%s'", sec, state.Data["ext_port"]),
-		fmt.Sprintf("uci set firewall.%s.dest='lan'", sec),
-		fmt.Sprintf("uci set firewall.%s.dest_ip='%s'", sec, state.Data["int_ip"]),
-		fmt.Sprintf("uci set firewall.%s.dest_port='%s'", sec, state.Data["int_port"]),
-		fmt.Sprintf("uci set firewall.%s.proto='%s'", sec, proto),
-		fmt.Sprintf("uci set firewall.%s.target='DNAT'", sec),
+		uci.Set(uci.Path("firewall", sec), "redirect"),
+		uci.Set(uci.Path("firewall", sec, "name"), name),
+		uci.Set(uci.Path("firewall", sec, "src"), "wan"),
+		uci.Set(uci.Path("firewall", sec, "src_dport"), state.Data["ext_port"]),
+		uci.Set(uci.Path("firewall", sec, "dest"), "lan"),
+		uci.Set(uci.Path("firewall", sec, "dest_ip"), state.Data["int_ip"]),
+		uci.Set(uci.Path("firewall", sec, "dest_port"), state.Data["int_port"]),
+		uci.Set(uci.Path("firewall", sec, "proto"), proto),
+		uci.Set(uci.Path("firewall", sec, "target"), "DNAT"),
 		"uci commit firewall",
 		"/etc/init.d/firewall reload",
 	}
 
-	SSHExec(strings.Join(cmds, " && "))
+	err := applyUCIConfirmed(c, "fw", "firewall", "firewall", fmt.Sprintf("添加端口转发 %s", name), strings.Join(cmds, " && "))
+	audit.Log(c, "fw", "add_redirect", sec, state.Data, err)
 	session.GlobalStore.Delete(userID, "fw_wizard")
 
 	return HandleFwMenu(c)
 }
 
 func HandleFwWizardTarget(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+
 	userID := c.Sender().ID
-	val := session.GlobalStore.Get(userID, "fw_wizard")
-	if val == nil {
+	state, ok := session.GetAs[FwWizardState](session.GlobalStore, userID, "fw_wizard")
+	if !ok {
 		return c.Respond(&tele.CallbackResponse{Text: "Session expired"})
 	}
-	state, ok := val.(FwWizardState)
-	if !ok || state.Type != "rule" || state.Step != "target" {
+	if state.Type != "rule" || state.Step != "target" {
 		return c.Respond()
 	}
 
@@ -496,17 +499,18 @@ func HandleFwWizardTarget(c tele.Context) error {
 	name := state.Data["name"]
 	sec := fmt.Sprintf("homeops_%s", name)
 	cmds := []string{
-		fmt.Sprintf("uci set firewall.%s=rule", sec),
-		fmt.Sprintf("uci set firewall.%s.name='%s'", sec, name),
-		fmt.Sprintf("uci set firewall.%s.src='%s'", sec, state.Data["src"]),
-		fmt.Sprintf("uci set firewall.%s.dest='%s'", sec, state.Data["dest"]),
-		fmt.Sprintf("uci set firewall.%s.dest_port='%s'", sec, state.Data["dest_port"]),
-		fmt.Sprintf("uci set firewall.%s.target='%s'", sec, target),
+		uci.Set(uci.Path("firewall", sec), "rule"),
+		uci.Set(uci.Path("firewall", sec, "name"), name),
+		uci.Set(uci.Path("firewall", sec, "src"), state.Data["src"]),
+		uci.Set(uci.Path("firewall", sec, "dest"), state.Data["dest"]),
+		uci.Set(uci.Path("firewall", sec, "dest_port"), state.Data["dest_port"]),
+		uci.Set(uci.Path("firewall", sec, "target"), target),
 		"uci commit firewall",
 		"/etc/init.d/firewall reload",
 	}
 
-	SSHExec(strings.Join(cmds, " && "))
+	err := applyUCIConfirmed(c, "fw", "firewall", "firewall", fmt.Sprintf("添加通信规则 %s", name), strings.Join(cmds, " && "))
+	audit.Log(c, "fw", "add_rule", sec, state.Data, err)
 	session.GlobalStore.Delete(userID, "fw_wizard")
 
 	return HandleFwMenu(c)
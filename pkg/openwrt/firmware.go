@@ -0,0 +1,592 @@
+package openwrt
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/lifecycle"
+	"github.com/yingxiaomo/homeops/pkg/notify"
+	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/uci"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	remoteFirmware = "/tmp/homeops-sysupgrade.bin"
+	sysupPlanKey   = "sysup_plan"
+	// upgradeTimeout bounds the wait for the router to come back after
+	// sysupgrade.
+	upgradeTimeout = 15 * time.Minute
+	upgradePoll    = 20 * time.Second
+)
+
+var (
+	releaseVersionRe = regexp.MustCompile(`^[0-9][0-9A-Za-z.-]*$`)
+	releaseTargetRe  = regexp.MustCompile(`^[a-z0-9_-]+/[a-z0-9_-]+$`)
+	imageNameRe      = regexp.MustCompile(`^[A-Za-z0-9._+-]+$`)
+	sha256Re         = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+	firmwareClient = &http.Client{Timeout: 30 * time.Second}
+
+	firmwareMu sync.Mutex
+	// latestFirmware is the newest release seen by the monitor.
+	latestFirmware string
+	// announcedFirmware maps a router to the release it was last told about.
+	announcedFirmware = make(map[string]string)
+	// upgrading holds the routers with a sysupgrade in progress.
+	upgrading = make(map[string]bool)
+)
+
+// BoardInfo is the reply of ubus "system board".
+type BoardInfo struct {
+	Kernel    string `json:"kernel"`
+	Hostname  string `json:"hostname"`
+	Model     string `json:"model"`
+	BoardName string `json:"board_name"`
+	Release   struct {
+		Distribution string `json:"distribution"`
+		Version      string `json:"version"`
+		Revision     string `json:"revision"`
+		Target       string `json:"target"`
+		Description  string `json:"description"`
+	} `json:"release"`
+}
+
+// GetBoardInfo returns the model and firmware release of the router
+// selected by ctx.
+func GetBoardInfo(ctx context.Context) (*BoardInfo, error) {
+	var b BoardInfo
+	if err := Ubus(ctx, "system", "board", nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// sysupPlan is a firmware upgrade awaiting confirmation.
+type sysupPlan struct {
+	Router  string `json:"router"`
+	From    string `json:"from"`
+	Version string `json:"version"`
+	Image   string `json:"image"`
+	URL     string `json:"url"`
+	SHA256  string `json:"sha256"`
+}
+
+// splitVersion splits "24.10.0-rc2" into its numbers and the release
+// candidate, which is math.MaxInt for final releases.
+func splitVersion(v string) ([]int, int) {
+	base, suffix, _ := strings.Cut(v, "-")
+	var nums []int
+	for _, p := range strings.Split(base, ".") {
+		n, _ := strconv.Atoi(p)
+		nums = append(nums, n)
+	}
+	rc := math.MaxInt
+	if n, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(suffix), "rc")); err == nil && suffix != "" {
+		rc = n
+	}
+	return nums, rc
+}
+
+// compareVersions compares OpenWrt release versions; release candidates
+// sort before the final release.
+func compareVersions(a, b string) int {
+	an, arc := splitVersion(a)
+	bn, brc := splitVersion(b)
+	if c := slices.Compare(an, bn); c != 0 {
+		return c
+	}
+	return cmp.Compare(arc, brc)
+}
+
+// newerRelease reports whether latest should be offered to a router
+// running current. Snapshot builds are never offered a release.
+func newerRelease(current, latest string) bool {
+	if current == "" || latest == "" || strings.Contains(strings.ToUpper(current), "SNAPSHOT") {
+		return false
+	}
+	return compareVersions(latest, current) > 0
+}
+
+// indexRelative resolves elem against the directory of the release index,
+// which is either an http(s) URL or a local file.
+func indexRelative(index string, elem ...string) string {
+	if u, err := url.Parse(index); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		u.Path = path.Join(append([]string{path.Dir(u.Path)}, elem...)...)
+		return u.String()
+	}
+	return filepath.Join(append([]string{filepath.Dir(index)}, elem...)...)
+}
+
+func isRemote(loc string) bool {
+	return strings.HasPrefix(loc, "http://") || strings.HasPrefix(loc, "https://")
+}
+
+// fetchJSON decodes the JSON document at loc, a URL or a local file.
+func fetchJSON(ctx context.Context, loc string, out any) error {
+	if !isRemote(loc) {
+		data, err := os.ReadFile(loc)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, out)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := firmwareClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", loc, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(out)
+}
+
+// latestRelease returns the stable version listed by the release index.
+func latestRelease(ctx context.Context) (string, error) {
+	var index struct {
+		StableVersion string `json:"stable_version"`
+	}
+	if err := fetchJSON(ctx, config.Get().FirmwareIndexURL, &index); err != nil {
+		return "", fmt.Errorf("failed to read release index: %v", err)
+	}
+	if !releaseVersionRe.MatchString(index.StableVersion) {
+		return "", fmt.Errorf("release index has no valid stable_version: %q", index.StableVersion)
+	}
+	firmwareMu.Lock()
+	latestFirmware = index.StableVersion
+	firmwareMu.Unlock()
+	return index.StableVersion, nil
+}
+
+// findImage looks up the sysupgrade image of board in the profiles.json of
+// version.
+func findImage(ctx context.Context, board *BoardInfo, version string) (name, loc, sum string, err error) {
+	target := board.Release.Target
+	if !releaseTargetRe.MatchString(target) {
+		return "", "", "", fmt.Errorf("unknown target %q", target)
+	}
+	index := config.Get().FirmwareIndexURL
+	dir := []string{"releases", version, "targets", target}
+	var profiles struct {
+		Profiles map[string]struct {
+			Images []struct {
+				Name       string `json:"name"`
+				SHA256     string `json:"sha256"`
+				Type       string `json:"type"`
+				Filesystem string `json:"filesystem"`
+			} `json:"images"`
+			SupportedDevices []string `json:"supported_devices"`
+		} `json:"profiles"`
+	}
+	if err := fetchJSON(ctx, indexRelative(index, append(dir, "profiles.json")...), &profiles); err != nil {
+		return "", "", "", fmt.Errorf("failed to read profiles of %s %s: %v", version, target, err)
+	}
+
+	id := strings.ReplaceAll(board.BoardName, ",", "_")
+	profile, ok := profiles.Profiles[id]
+	if !ok {
+		for _, p := range profiles.Profiles {
+			if slices.Contains(p.SupportedDevices, board.BoardName) {
+				profile, ok = p, true
+				break
+			}
+		}
+	}
+	if !ok && len(profiles.Profiles) == 1 {
+		for _, p := range profiles.Profiles {
+			profile, ok = p, true
+		}
+	}
+	if !ok {
+		return "", "", "", fmt.Errorf("no profile for board %q in %s %s", board.BoardName, version, target)
+	}
+
+	// Prefer the sysupgrade image; x86 and similar targets only ship
+	// combined images. squashfs wins over ext4.
+	best, bestScore := -1, 0
+	for i, img := range profile.Images {
+		score := 0
+		switch img.Type {
+		case "sysupgrade":
+			score = 4
+		case "combined":
+			score = 2
+		default:
+			continue
+		}
+		if img.Filesystem == "squashfs" || img.Filesystem == "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return "", "", "", fmt.Errorf("no sysupgrade image for board %q in %s", board.BoardName, version)
+	}
+	img := profile.Images[best]
+	if !imageNameRe.MatchString(img.Name) || !sha256Re.MatchString(img.SHA256) {
+		return "", "", "", fmt.Errorf("invalid image entry %q", img.Name)
+	}
+	return img.Name, indexRelative(index, append(dir, img.Name)...), img.SHA256, nil
+}
+
+// StartFirmwareMonitor compares the firmware of every router with the
+// release index every firmware.check_interval and announces new releases.
+func StartFirmwareMonitor() {
+	lifecycle.Go(func(ctx context.Context) {
+		// Check once an hour for a changed interval while disabled.
+		interval := config.Get().FirmwareCheckInterval
+		tick := interval
+		if tick == 0 {
+			tick = time.Hour
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			cfg := config.Get()
+			if cfg.FirmwareCheckInterval != 0 {
+				checkFirmware(ctx, cfg.Routers)
+			}
+			if d := cfg.FirmwareCheckInterval; d != interval {
+				interval = d
+				if d == 0 {
+					d = time.Hour
+				}
+				ticker.Reset(d)
+			}
+		}
+	})
+	log.Println("Firmware release monitor registered.")
+}
+
+func checkFirmware(ctx context.Context, routers []config.RouterProfile) {
+	latest, err := latestRelease(ctx)
+	if err != nil {
+		log.Printf("Firmware check: %v", err)
+		return
+	}
+	for _, r := range routers {
+		rctx := WithRouter(ctx, r.Name)
+		board, err := GetBoardInfo(rctx)
+		if err != nil {
+			log.Printf("Firmware check: cannot read board of %s: %v", r.Name, err)
+			continue
+		}
+		if !newerRelease(board.Release.Version, latest) {
+			continue
+		}
+		firmwareMu.Lock()
+		seen := announcedFirmware[r.Name] == latest
+		announcedFirmware[r.Name] = latest
+		firmwareMu.Unlock()
+		if seen {
+			continue
+		}
+		log.Printf("Firmware check: %s runs %s, %s is available", r.Name, board.Release.Version, latest)
+		notify.Publish(rctx, firmwareNotice(r.Name, board, latest))
+	}
+}
+
+func firmwareNotice(router string, board *BoardInfo, latest string) notify.Event {
+	where := ""
+	if multiRouter() {
+		where = " · " + router
+	}
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("⬆️ 查看升级", "wrt_sysup", router)))
+	return notify.Event{
+		Category: "firmware",
+		Severity: notify.Info,
+		Title:    fmt.Sprintf("🆕 新固件: %s %s%s", board.Release.Distribution, latest, where),
+		Text:     fmt.Sprintf("型号: %s\n当前版本: %s\n最新版本: %s", board.Model, board.Release.Version, latest),
+		Markup:   menu,
+	}
+}
+
+// firmwareLines formats board for the status page.
+func firmwareLines(board *BoardInfo) string {
+	rel := board.Release
+	txt := fmt.Sprintf("\n🖥 型号: %s\n📦 固件: %s (%s)\n🐧 内核: %s",
+		utils.EscapeMarkdown(board.Model),
+		utils.EscapeMarkdown(strings.TrimSpace(rel.Distribution+" "+rel.Version+" "+rel.Revision)),
+		utils.EscapeMarkdown(rel.Target), utils.EscapeMarkdown(board.Kernel))
+	firmwareMu.Lock()
+	latest := latestFirmware
+	firmwareMu.Unlock()
+	if newerRelease(rel.Version, latest) {
+		txt += fmt.Sprintf("\n🆕 可升级到 %s", utils.EscapeMarkdown(latest))
+	}
+	return txt
+}
+
+// HandleSysupgradeCheck handles "wrt_sysup|<router>" by looking up the
+// newest release and its image for the router.
+func HandleSysupgradeCheck(c tele.Context, router string) error {
+	if _, ok := config.Get().Router(router); !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "路由器已不存在"})
+	}
+	ctx := WithRouter(utils.Ctx(c), router)
+	c.Respond(&tele.CallbackResponse{Text: "正在检查固件更新..."})
+
+	menu := &tele.ReplyMarkup{}
+	back := menu.Row(menu.Data("🔙 返回", "wrt_main"))
+	fail := func(format string, args ...any) error {
+		menu.Inline(back)
+		return c.Edit(fmt.Sprintf(format, args...), menu)
+	}
+
+	board, err := GetBoardInfo(ctx)
+	if err != nil {
+		return fail("❌ 无法读取路由器版本: %v", err)
+	}
+	latest, err := latestRelease(ctx)
+	if err != nil {
+		return fail("❌ %v", err)
+	}
+	if !newerRelease(board.Release.Version, latest) {
+		return fail("✅ %s 已是最新版本 (%s %s)", router, board.Release.Distribution, board.Release.Version)
+	}
+	name, loc, sum, err := findImage(ctx, board, latest)
+	if err != nil {
+		return fail("❌ 找不到适用于 %s 的 %s 固件: %v", board.Model, latest, err)
+	}
+
+	plan := sysupPlan{Router: router, From: board.Release.Version, Version: latest, Image: name, URL: loc, SHA256: sum}
+	session.GlobalStore.SetWithTTL(c.Sender().ID, sysupPlanKey, plan, session.WizardTTL)
+
+	menu.Inline(menu.Row(menu.Data("⬆️ 升级到 "+latest, "wrt_sysup_go")), back)
+	return c.Edit(fmt.Sprintf("🆕 **固件升级** · `%s`\n-------------------\n型号: %s\n当前版本: %s\n最新版本: %s\n镜像: `%s`\nSHA256: `%s`",
+		router, utils.EscapeMarkdown(board.Model), plan.From, plan.Version, name, sum), menu, tele.ModeMarkdown)
+}
+
+// HandleSysupgradeConfirm asks for confirmation before upgrading.
+func HandleSysupgradeConfirm(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	plan, ok := session.GetAs[sysupPlan](session.GlobalStore, c.Sender().ID, sysupPlanKey)
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "升级信息已过期，请重新检查"})
+	}
+	c.Respond()
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("✅ 确认升级", "wrt_sysup_do")),
+		menu.Row(menu.Data("❌ 取消", "wrt_main")),
+	)
+	return c.Edit(fmt.Sprintf("⚠️ 确认将 %s 从 %s 升级到 %s 吗？\n将依次下载固件并校验 SHA256、备份当前配置、执行 sysupgrade (保留配置)。\n升级期间路由器会重启，网络中断数分钟；断电可能导致设备损坏。",
+		plan.Router, plan.From, plan.Version), menu)
+}
+
+// HandleSysupgradeDo downloads and verifies the image, backs up the
+// configuration and starts sysupgrade, then waits for the router to come
+// back in the background.
+func HandleSysupgradeDo(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	plan, ok := session.GetAs[sysupPlan](session.GlobalStore, c.Sender().ID, sysupPlanKey)
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "升级信息已过期，请重新检查"})
+	}
+	firmwareMu.Lock()
+	busy := upgrading[plan.Router]
+	upgrading[plan.Router] = true
+	firmwareMu.Unlock()
+	if busy {
+		return c.Respond(&tele.CallbackResponse{Text: "该路由器正在升级中"})
+	}
+	session.GlobalStore.Delete(c.Sender().ID, sysupPlanKey)
+	c.Respond(&tele.CallbackResponse{Text: "开始升级..."})
+
+	ctx := WithRouter(utils.Ctx(c), plan.Router)
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_main")))
+
+	var steps []string
+	step := func(text string) {
+		steps = append(steps, "⏳ "+text)
+		c.Edit(fmt.Sprintf("⬆️ %s: %s → %s\n%s", plan.Router, plan.From, plan.Version, strings.Join(steps, "\n")))
+	}
+	finish := func(err error, backup string) error {
+		params := map[string]string{"from": plan.From, "to": plan.Version, "image": plan.Image}
+		if backup != "" {
+			params["backup"] = backup
+		}
+		audit.Log(c, "wrt", "sysupgrade", plan.Router, params, err)
+		mark := "✅"
+		if err != nil {
+			mark = "❌"
+			Exec(context.WithoutCancel(ctx), "rm -f "+remoteFirmware)
+			firmwareMu.Lock()
+			delete(upgrading, plan.Router)
+			firmwareMu.Unlock()
+		}
+		steps[len(steps)-1] = mark + strings.TrimPrefix(steps[len(steps)-1], "⏳")
+		text := fmt.Sprintf("⬆️ %s: %s → %s\n%s", plan.Router, plan.From, plan.Version, strings.Join(steps, "\n"))
+		if err != nil {
+			return c.Edit(fmt.Sprintf("%s\n\n❌ 升级已中止: %v", text, err), menu)
+		}
+		return c.Edit(text)
+	}
+	done := func() {
+		steps[len(steps)-1] = "✅" + strings.TrimPrefix(steps[len(steps)-1], "⏳")
+	}
+
+	step("下载固件 " + plan.Image)
+	if err := downloadFirmware(ctx, plan.URL); err != nil {
+		return finish(err, "")
+	}
+	done()
+
+	step("校验 SHA256")
+	if err := verifyFirmware(ctx, plan.SHA256); err != nil {
+		return finish(err, "")
+	}
+	done()
+
+	step("备份当前配置")
+	b, err := CreateBackup(ctx)
+	if err != nil {
+		return finish(err, "")
+	}
+	done()
+
+	step("执行 sysupgrade，已保存备份 " + b.Name)
+	// Detach so the SSH session ends cleanly before the router goes down.
+	if _, err := Exec(ctx, fmt.Sprintf("(sleep 2; sysupgrade %s) >/dev/null 2>&1 &", remoteFirmware)); err != nil {
+		return finish(fmt.Errorf("failed to start sysupgrade: %v", err), b.Name)
+	}
+	if err := finish(nil, b.Name); err != nil {
+		return err
+	}
+
+	msg, err := c.Bot().Send(c.Recipient(), fmt.Sprintf("⏳ %s 正在刷写固件并重启，最多等待 %d 分钟...", plan.Router, int(upgradeTimeout.Minutes())))
+	if err != nil {
+		log.Printf("Sysupgrade of %s: failed to send progress: %v", plan.Router, err)
+	}
+	bot, to := c.Bot(), c.Recipient()
+	lifecycle.Go(func(ctx context.Context) {
+		text := waitUpgrade(WithRouter(ctx, plan.Router), plan)
+		firmwareMu.Lock()
+		delete(upgrading, plan.Router)
+		firmwareMu.Unlock()
+		if msg != nil {
+			bot.Edit(msg, text, menu)
+		} else {
+			bot.Send(to, text, menu)
+		}
+	})
+	return nil
+}
+
+func downloadFirmware(ctx context.Context, loc string) error {
+	if isRemote(loc) {
+		out, err := Exec(ctx, fmt.Sprintf("rm -f %[1]s; wget -q -O %[1]s %[2]s 2>&1", remoteFirmware, uci.Quote(loc)))
+		if err != nil {
+			return fmt.Errorf("download failed: %v %s", err, strings.TrimSpace(out))
+		}
+		return nil
+	}
+	// A local release mirror: upload the image from the bot's host.
+	f, err := os.Open(loc)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = withSFTP(ctx, func(sc *sftp.Client) error {
+		dst, err := sc.Create(remoteFirmware)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, f); err != nil {
+			dst.Close()
+			return err
+		}
+		return dst.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("upload failed: %v", err)
+	}
+	return nil
+}
+
+// verifyFirmware checks the downloaded image against sum and lets
+// sysupgrade test that it fits the board.
+func verifyFirmware(ctx context.Context, sum string) error {
+	out, err := Exec(ctx, "sha256sum "+remoteFirmware)
+	if err != nil {
+		return fmt.Errorf("sha256sum failed: %v %s", err, strings.TrimSpace(out))
+	}
+	if got, _, _ := strings.Cut(strings.TrimSpace(out), " "); got != sum {
+		return fmt.Errorf("SHA256 mismatch: got %s, want %s", got, sum)
+	}
+	if out, err := Exec(ctx, "sysupgrade -T "+remoteFirmware+" 2>&1"); err != nil {
+		return fmt.Errorf("image rejected by sysupgrade: %v %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// waitUpgrade polls the router until it reports plan.Version or
+// upgradeTimeout passes, and describes the outcome.
+func waitUpgrade(ctx context.Context, plan sysupPlan) string {
+	deadline := time.Now().Add(upgradeTimeout)
+	start := time.Now()
+	lastSeen := ""
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return fmt.Sprintf("⚠️ Bot 已停止，未能确认 %s 的升级结果。", plan.Router)
+		case <-time.After(upgradePoll):
+		}
+		pctx, cancel := context.WithTimeout(ctx, upgradePoll)
+		board, err := GetBoardInfo(pctx)
+		cancel()
+		if err != nil {
+			continue
+		}
+		lastSeen = board.Release.Version
+		if board.Release.Version == plan.Version {
+			log.Printf("Sysupgrade of %s to %s completed", plan.Router, plan.Version)
+			return fmt.Sprintf("✅ %s 已恢复在线 (用时 %s)\n当前版本: %s %s\n内核: %s",
+				plan.Router, time.Since(start).Round(time.Second), board.Release.Distribution, board.Release.Version, board.Kernel)
+		}
+	}
+	log.Printf("Sysupgrade of %s to %s not confirmed (last seen %q)", plan.Router, plan.Version, lastSeen)
+	if lastSeen != "" {
+		return fmt.Sprintf("❌ %s 仍在运行 %s，升级可能未成功，请检查路由器。", plan.Router, lastSeen)
+	}
+	return fmt.Sprintf("❌ %s 在 %d 分钟内未恢复连接，请检查路由器。", plan.Router, int(upgradeTimeout.Minutes()))
+}
@@ -0,0 +1,173 @@
+package openwrt
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/yingxiaomo/homeops/config"
+)
+
+func TestSplitVersion(t *testing.T) {
+	tests := []struct {
+		v    string
+		nums []int
+		rc   int
+	}{
+		{"24.10.1", []int{24, 10, 1}, math.MaxInt},
+		{"24.10.0-rc2", []int{24, 10, 0}, 2},
+		{"24.10.0-RC10", []int{24, 10, 0}, 10},
+		{"24.10-SNAPSHOT", []int{24, 10}, math.MaxInt},
+	}
+	for _, tt := range tests {
+		nums, rc := splitVersion(tt.v)
+		if !slices.Equal(nums, tt.nums) || rc != tt.rc {
+			t.Errorf("splitVersion(%q) = %v, %d; want %v, %d", tt.v, nums, rc, tt.nums, tt.rc)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"24.10.1", "24.10.1", 0},
+		{"24.10.1", "24.10.0", 1},
+		{"23.05.5", "24.10.0", -1},
+		{"24.10.0", "24.10.0-rc7", 1},
+		{"24.10.0-rc2", "24.10.0-rc10", -1},
+		{"24.10.0-rc1", "23.05.5", 1},
+		{"24.10.0-rc1", "24.10.0-rc1", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestNewerRelease(t *testing.T) {
+	tests := []struct {
+		current, latest string
+		want            bool
+	}{
+		{"23.05.5", "24.10.1", true},
+		{"24.10.0-rc7", "24.10.0", true},
+		{"24.10.1", "24.10.1", false},
+		{"24.10.0", "24.10.0-rc7", false},
+		{"24.10.1", "23.05.5", false},
+		{"SNAPSHOT", "24.10.1", false},
+		{"24.10-snapshot", "24.10.1", false},
+		{"", "24.10.1", false},
+		{"24.10.1", "", false},
+	}
+	for _, tt := range tests {
+		if got := newerRelease(tt.current, tt.latest); got != tt.want {
+			t.Errorf("newerRelease(%q, %q) = %v, want %v", tt.current, tt.latest, got, tt.want)
+		}
+	}
+}
+
+func testBoard(target, name string) *BoardInfo {
+	b := &BoardInfo{BoardName: name}
+	b.Release.Target = target
+	return b
+}
+
+func TestFindImage(t *testing.T) {
+	index, err := filepath.Abs("testdata/firmware/.versions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata/firmware")))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		board  *BoardInfo
+		image  string
+		sum    string
+		errMsg string
+	}{
+		{
+			name:  "sysupgrade by profile id",
+			board: testBoard("ramips/mt7621", "xiaomi,mi-router-4a-gigabit"),
+			image: "openwrt-24.10.1-ramips-mt7621-xiaomi_mi-router-4a-gigabit-squashfs-sysupgrade.bin",
+			sum:   strings.Repeat("2", 64),
+		},
+		{
+			name:  "supported_devices fallback",
+			board: testBoard("ramips/mt7621", "glinet,gl-mt1300-legacy"),
+			image: "openwrt-24.10.1-ramips-mt7621-glinet_gl-mt1300-squashfs-sysupgrade.bin",
+			sum:   strings.Repeat("4", 64),
+		},
+		{
+			name:  "single profile, squashfs combined over ext4",
+			board: testBoard("x86/64", "qemu-standard-pc-q35-ich9-2009"),
+			image: "openwrt-24.10.1-x86-64-generic-squashfs-combined.img.gz",
+			sum:   strings.Repeat("8", 64),
+		},
+		{
+			name:   "unknown board",
+			board:  testBoard("ramips/mt7621", "unknown,board"),
+			errMsg: "no profile",
+		},
+		{
+			name:   "no sysupgrade image",
+			board:  testBoard("ramips/mt7621", "factory,only"),
+			errMsg: "no sysupgrade image",
+		},
+		{
+			name:   "invalid image name",
+			board:  testBoard("ramips/mt7621", "broken,device"),
+			errMsg: "invalid image entry",
+		},
+		{
+			name:   "invalid target",
+			board:  testBoard("../x86", "generic"),
+			errMsg: "unknown target",
+		},
+		{
+			name:   "missing release",
+			board:  testBoard("mediatek/filogic", "generic"),
+			errMsg: "failed to read profiles",
+		},
+	}
+
+	for _, idx := range []string{index, srv.URL + "/.versions.json"} {
+		config.Set(&config.Config{FirmwareIndexURL: idx})
+		dir := strings.TrimSuffix(idx, ".versions.json")
+
+		latest, err := latestRelease(context.Background())
+		if err != nil || latest != "24.10.1" {
+			t.Fatalf("latestRelease(%s) = %q, %v; want 24.10.1", idx, latest, err)
+		}
+
+		for _, tt := range tests {
+			name, loc, sum, err := findImage(context.Background(), tt.board, latest)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("%s (%s): err = %v, want %q", tt.name, idx, err, tt.errMsg)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s (%s): %v", tt.name, idx, err)
+				continue
+			}
+			want := dir + "releases/24.10.1/targets/" + tt.board.Release.Target + "/" + tt.image
+			if !isRemote(idx) {
+				want = filepath.FromSlash(want)
+			}
+			if name != tt.image || loc != want || sum != tt.sum {
+				t.Errorf("%s (%s) = %q, %q, %q; want %q, %q, %q", tt.name, idx, name, loc, sum, tt.image, want, tt.sum)
+			}
+		}
+	}
+}
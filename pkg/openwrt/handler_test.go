@@ -0,0 +1,147 @@
+package openwrt
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/session"
+	tele "gopkg.in/telebot.v3"
+)
+
+// apiCall is one request the bot made to the Telegram API.
+type apiCall struct {
+	Method string
+	Params map[string]any
+}
+
+// newTestContext returns the context of a callback query from user 1 and
+// the Telegram API calls made through it. The router "main" is served by f.
+func newTestContext(t *testing.T, f *FakeExecutor) (tele.Context, func() []apiCall) {
+	t.Helper()
+	var (
+		mu    sync.Mutex
+		calls []apiCall
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var params map[string]any
+		json.Unmarshal(body, &params)
+		mu.Lock()
+		calls = append(calls, apiCall{Method: r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], Params: params})
+		mu.Unlock()
+		io.WriteString(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":1}}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	b, err := tele.NewBot(tele.Settings{Token: "test", URL: srv.URL, Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Set(&config.Config{Routers: []config.RouterProfile{{Name: "main"}}})
+	session.GlobalStore = session.NewMemoryStore()
+	SetExecutor("main", f)
+	t.Cleanup(ResetClient)
+
+	chat := &tele.Chat{ID: 1}
+	c := b.NewContext(tele.Update{Callback: &tele.Callback{
+		ID:      "cb",
+		Sender:  &tele.User{ID: 1},
+		Message: &tele.Message{ID: 1, Chat: chat},
+	}})
+	return c, func() []apiCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]apiCall(nil), calls...)
+	}
+}
+
+// lastText returns the text of the last message sent or edited.
+func lastText(t *testing.T, calls []apiCall) string {
+	t.Helper()
+	for i := len(calls) - 1; i >= 0; i-- {
+		if text, ok := calls[i].Params["text"].(string); ok {
+			return text
+		}
+	}
+	t.Fatalf("no message in %v", calls)
+	return ""
+}
+
+func TestHandleFwListRedirects(t *testing.T) {
+	f := &FakeExecutor{Replies: map[string]any{
+		"uci get": map[string]any{"values": map[string]any{
+			"cfg01": map[string]any{".type": "defaults", ".anonymous": true, ".index": 0, "input": "ACCEPT"},
+			"homeops_nas": map[string]any{".type": "redirect", ".anonymous": false, ".index": 1,
+				"src_dport": "8443", "dest_ip": "192.168.1.10", "dest_port": "443"},
+			"cfg03": map[string]any{".type": "redirect", ".anonymous": true, ".index": 2,
+				"src_dport": "22", "dest_ip": "192.168.1.2"},
+			"homeops_web": map[string]any{".type": "rule", ".anonymous": false, ".index": 3, "dest_port": "80"},
+		}},
+	}}
+	c, calls := newTestContext(t, f)
+
+	if err := HandleFwListRedirects(c); err != nil {
+		t.Fatal(err)
+	}
+	text := lastText(t, calls())
+	if want := "`nas`: TCP :8443 ➝ 192.168.1.10:443"; !strings.Contains(text, want) {
+		t.Errorf("text = %q, want it to contain %q", text, want)
+	}
+	for _, unwanted := range []string{"192.168.1.2:", "web"} {
+		if strings.Contains(text, unwanted) {
+			t.Errorf("text = %q, should not list %q", text, unwanted)
+		}
+	}
+}
+
+func TestHandleFwListRedirectsEmpty(t *testing.T) {
+	// Without ubus the firewall is read with "uci export".
+	f := &FakeExecutor{Outputs: map[string]string{
+		"uci export 'firewall'": "package firewall\n\nconfig defaults\n\toption input 'ACCEPT'\n",
+	}}
+	c, calls := newTestContext(t, f)
+
+	if err := HandleFwListRedirects(c); err != nil {
+		t.Fatal(err)
+	}
+	if text := lastText(t, calls()); !strings.Contains(text, "无记录") {
+		t.Errorf("text = %q, want an empty list", text)
+	}
+	if got := f.Calls(); len(got) != 2 || got[0] != "ubus uci get" {
+		t.Errorf("calls = %q, want ubus then uci export", got)
+	}
+}
+
+func TestHandleUCIInputKeepsOneElementList(t *testing.T) {
+	f := &FakeExecutor{Outputs: map[string]string{
+		"uci export 'network'": "package network\n\nconfig interface 'lan'\n\tlist dns '1.1.1.1'\n",
+		"uci delete 'network.lan.dns' && uci add_list 'network.lan.dns=8.8.8.8'": "",
+	}}
+	c, _ := newTestContext(t, f)
+	config.Set(&config.Config{AdminIDs: []int64{1}, Routers: []config.RouterProfile{{Name: "main"}}})
+
+	if err := HandleUCIOption(c, "network.lan.dns"); err != nil {
+		t.Fatal(err)
+	}
+	edit, ok := session.GetAs[UCIEdit](session.GlobalStore, 1, uciEditKey)
+	if !ok || !edit.List {
+		t.Fatalf("edit = %+v, want a list option", edit)
+	}
+
+	c.Message().Text = "8.8.8.8"
+	if err := HandleUCIInput(c, edit); err != nil {
+		t.Fatal(err)
+	}
+	want := "uci delete 'network.lan.dns' && uci add_list 'network.lan.dns=8.8.8.8'"
+	if !slices.Contains(f.Calls(), want) {
+		t.Errorf("calls = %q, want %q", f.Calls(), want)
+	}
+}
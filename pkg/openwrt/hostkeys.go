@@ -0,0 +1,200 @@
+package openwrt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/lifecycle"
+	"github.com/yingxiaomo/homeops/pkg/notify"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	tele "gopkg.in/telebot.v3"
+)
+
+// KnownHostsFile pins the SSH host key of every router on first connect.
+const KnownHostsFile = "data/known_hosts"
+
+type rejectedKey struct {
+	hostname string
+	key      ssh.PublicKey
+}
+
+var (
+	knownHostsMu sync.Mutex
+	// rejectedKeys holds the last mismatching key per host label until an
+	// admin accepts it.
+	rejectedKeys = make(map[string]rejectedKey)
+)
+
+// hostKeyCallback verifies host keys against KnownHostsFile. Unknown hosts
+// are pinned; a changed key is refused and reported under label, the router
+// name or "<router>:jump" for its bastion.
+func hostKeyCallback(label string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		check, err := loadKnownHosts()
+		if err != nil {
+			return err
+		}
+		err = check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+
+		if len(keyErr.Want) == 0 {
+			if err := appendKnownHost(hostname, key); err != nil {
+				return fmt.Errorf("failed to pin host key: %v", err)
+			}
+			log.Printf("Pinned SSH host key of %s (%s): %s", label, hostname, ssh.FingerprintSHA256(key))
+			return nil
+		}
+
+		want := ssh.FingerprintSHA256(keyErr.Want[0].Key)
+		got := ssh.FingerprintSHA256(key)
+		if old, ok := rejectedKeys[label]; !ok || ssh.FingerprintSHA256(old.key) != got {
+			rejectedKeys[label] = rejectedKey{hostname: hostname, key: key}
+			log.Printf("SSH host key mismatch for %s (%s): got %s, pinned %s", label, hostname, got, want)
+			go notify.Publish(lifecycle.Context(), hostKeyEvent(label, hostname, got, want))
+		}
+		return fmt.Errorf("host key mismatch for %s: got %s, pinned %s", hostname, got, want)
+	}
+}
+
+// probeKey matches no pinned key, so checking it yields every key pinned
+// for a host.
+type probeKey struct{}
+
+func (probeKey) Type() string                        { return "" }
+func (probeKey) Marshal() []byte                     { return nil }
+func (probeKey) Verify([]byte, *ssh.Signature) error { return errors.New("probe key") }
+
+// knownHostAlgorithms returns the host key algorithms matching the keys
+// pinned for addr, so the server presents a key we can verify instead of
+// its preferred one. It returns nil for unknown hosts.
+func knownHostAlgorithms(addr string) []string {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	check, err := loadKnownHosts()
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(check(addr, &net.TCPAddr{}, probeKey{}), &keyErr) {
+		return nil
+	}
+
+	var algos []string
+	for _, k := range keyErr.Want {
+		if t := k.Key.Type(); t == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		} else {
+			algos = append(algos, t)
+		}
+	}
+	slices.Sort(algos)
+	return algos
+}
+
+func loadKnownHosts() (ssh.HostKeyCallback, error) {
+	if _, err := os.Stat(KnownHostsFile); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(KnownHostsFile), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(KnownHostsFile, nil, 0600); err != nil {
+			return nil, err
+		}
+	}
+	return knownhosts.New(KnownHostsFile)
+}
+
+func appendKnownHost(hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(KnownHostsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	return err
+}
+
+// replaceKnownHost drops every pinned key of hostname and pins key.
+func replaceKnownHost(hostname string, key ssh.PublicKey) error {
+	data, err := os.ReadFile(KnownHostsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	host := knownhosts.Normalize(hostname)
+
+	var kept []string
+	sc := bufio.NewScanner(strings.NewReader(string(data)))
+	for sc.Scan() {
+		line := sc.Text()
+		if fields := strings.Fields(line); len(fields) > 0 && slices.Contains(strings.Split(fields[0], ","), host) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	kept = append(kept, knownhosts.Line([]string{host}, key))
+
+	tmp := KnownHostsFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(kept, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, KnownHostsFile)
+}
+
+func hostKeyEvent(label, hostname, got, want string) notify.Event {
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("✅ 信任新密钥", "hostkey_accept", label)))
+	return notify.Event{
+		Category: "security",
+		Severity: notify.Critical,
+		Title:    "🚨 SSH 主机密钥不匹配: " + label,
+		Text: fmt.Sprintf("%s 返回的主机密钥与已记录的不一致，已拒绝连接。\n新指纹: %s\n已记录: %s\n\n如果路由器刚刚重刷固件，确认指纹无误后可信任新密钥；否则可能有人在冒充路由器。",
+			hostname, got, want),
+		Markup: menu,
+	}
+}
+
+// HandleHostKeyAccept handles "hostkey_accept|<label>".
+func HandleHostKeyAccept(c tele.Context, label string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+
+	knownHostsMu.Lock()
+	rk, ok := rejectedKeys[label]
+	var err error
+	if ok {
+		if err = replaceKnownHost(rk.hostname, rk.key); err == nil {
+			delete(rejectedKeys, label)
+		}
+	}
+	knownHostsMu.Unlock()
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "没有待确认的新密钥"})
+	}
+
+	fp := ssh.FingerprintSHA256(rk.key)
+	audit.Log(c, "wrt", "hostkey_accept", label, map[string]string{"host": rk.hostname, "fingerprint": fp}, err)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "保存失败: " + err.Error(), ShowAlert: true})
+	}
+
+	c.Respond(&tele.CallbackResponse{Text: "已信任新密钥"})
+	return c.Edit(fmt.Sprintf("%s\n\n✅ 已信任新密钥 %s", c.Message().Text, fp))
+}
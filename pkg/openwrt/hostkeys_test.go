@@ -0,0 +1,103 @@
+package openwrt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// serveSSH accepts handshakes with the given host keys until the test ends.
+func serveSSH(t *testing.T, keys ...ssh.Signer) string {
+	t.Helper()
+	conf := &ssh.ServerConfig{NoClientAuth: true}
+	for _, k := range keys {
+		conf.AddHostKey(k)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if sc, chans, reqs, err := ssh.NewServerConn(conn, conf); err == nil {
+					go ssh.DiscardRequests(reqs)
+					for ch := range chans {
+						ch.Reject(ssh.Prohibited, "")
+					}
+					sc.Close()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestKnownHostAlgorithms(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := ssh.NewSignerFromKey(edPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := ssh.NewSignerFromKey(rsaPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := serveSSH(t, edKey, rsaKey)
+	if algos := knownHostAlgorithms(addr); algos != nil {
+		t.Fatalf("knownHostAlgorithms of an unknown host = %v, want nil", algos)
+	}
+
+	// The ed25519 key was pinned, e.g. from ssh-keyscan, but the client
+	// would negotiate RSA by default.
+	if err := appendKnownHost(addr, edKey.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	algos := knownHostAlgorithms(addr)
+	if want := []string{ssh.KeyAlgoED25519}; !slices.Equal(algos, want) {
+		t.Fatalf("knownHostAlgorithms = %v, want %v", algos, want)
+	}
+	if other := knownHostAlgorithms("127.0.0.2:22"); other != nil {
+		t.Errorf("knownHostAlgorithms of another host = %v, want nil", other)
+	}
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:              "root",
+		HostKeyCallback:   hostKeyCallback("test"),
+		HostKeyAlgorithms: algos,
+	})
+	if err != nil {
+		t.Fatalf("dial with pinned ed25519 key: %v", err)
+	}
+	client.Close()
+
+	// RSA keys may be verified with any of its signature algorithms.
+	if err := replaceKnownHost(addr, rsaKey.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSA}
+	if algos := knownHostAlgorithms(addr); !slices.Equal(algos, want) {
+		t.Errorf("knownHostAlgorithms = %v, want %v", algos, want)
+	}
+}
@@ -1,14 +1,18 @@
 package openwrt
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/yingxiaomo/homeops/pkg/lifecycle"
 	"log"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/metrics"
+	"github.com/yingxiaomo/homeops/pkg/notify"
 	tele "gopkg.in/telebot.v3"
 )
 
@@ -19,20 +23,14 @@ type IPHistory struct {
 	V6 string `json:"v6"`
 }
 
-func GetRouterIPs() (string, string) {
+func GetRouterIPs(ctx context.Context) (string, string) {
 	v4 := ""
 	v6 := ""
 	ifaces := []string{"wan", "wan_6", "wan6"}
 
 	for _, iface := range ifaces {
-		cmd := fmt.Sprintf("ubus call network.interface.%s status", iface)
-		res, _ := SSHExec(cmd)
-		if res == "" {
-			continue
-		}
-
 		var data map[string]interface{}
-		if err := json.Unmarshal([]byte(res), &data); err == nil {
+		if err := Ubus(ctx, "network.interface."+iface, "status", nil, &data); err == nil {
 			if v4 == "" {
 				if ipv4Arr, ok := data["ipv4-address"].([]interface{}); ok && len(ipv4Arr) > 0 {
 					if addrMap, ok := ipv4Arr[0].(map[string]interface{}); ok {
@@ -72,13 +70,13 @@ func GetRouterIPs() (string, string) {
 	}
 
 	if v4 == "" {
-		res, _ := SSHExec("/usr/bin/curl -4 -s --max-time 5 icanhazip.com || /usr/bin/curl -4 -s --max-time 5 ifconfig.me")
+		res, _ := Exec(ctx, "/usr/bin/curl -4 -s --max-time 5 icanhazip.com || /usr/bin/curl -4 -s --max-time 5 ifconfig.me")
 		if res != "" && strings.Contains(res, ".") {
 			v4 = strings.TrimSpace(res)
 		}
 	}
 	if v6 == "" {
-		res, _ := SSHExec("/usr/bin/curl -6 -s --max-time 5 icanhazip.com || /usr/bin/curl -6 -s --max-time 5 ifconfig.co")
+		res, _ := Exec(ctx, "/usr/bin/curl -6 -s --max-time 5 icanhazip.com || /usr/bin/curl -6 -s --max-time 5 ifconfig.co")
 		if res != "" && strings.Contains(res, ":") {
 			v6 = strings.TrimSpace(res)
 		}
@@ -115,34 +113,47 @@ func saveStoredIPs(history *IPHistory) {
 	os.WriteFile(IPHistoryFile, data, 0644)
 }
 
-func StartIPMonitor(b *tele.Bot) {
-	ticker := time.NewTicker(60 * time.Second)
-	go func() {
-		for range ticker.C {
-			checkIPJob(b)
+func StartIPMonitor() {
+	interval := config.Get().IPCheckInterval
+	lifecycle.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			checkIPJob(ctx)
+			// Pick up a changed interval after /reload.
+			if d := config.Get().IPCheckInterval; d != interval {
+				interval = d
+				ticker.Reset(d)
+			}
 		}
-	}()
+	})
 	log.Println("IP Monitor Job registered.")
 }
 
-func checkIPJob(b *tele.Bot) {
-	currentV4, currentV6 := GetRouterIPs()
+func checkIPJob(ctx context.Context) {
+	currentV4, currentV6 := GetRouterIPs(ctx)
 	if currentV4 == "" && currentV6 == "" {
 		return
 	}
 
 	stored := getStoredIPs()
 	changed := false
-	msg := "🚨 **公网 IP 变动通知**\n-------------------\n"
+	msg := ""
 
 	if currentV4 != "" && currentV4 != stored.V4 {
 		old := stored.V4
 		if old == "" {
 			old = "未知"
 		}
-		msg += fmt.Sprintf("🔴 IPv4: `%s`\n(旧: %s)\n", currentV4, old)
+		msg += fmt.Sprintf("🔴 IPv4: %s\n(旧: %s)\n", currentV4, old)
 		stored.V4 = currentV4
 		changed = true
+		metrics.IPChanges.Inc("v4")
 	}
 
 	if currentV6 != "" && currentV6 != stored.V6 {
@@ -150,22 +161,22 @@ func checkIPJob(b *tele.Bot) {
 		if old == "" {
 			old = "未知"
 		}
-		msg += fmt.Sprintf("🔵 IPv6: `%s`\n(旧: %s)\n", currentV6, old)
+		msg += fmt.Sprintf("🔵 IPv6: %s\n(旧: %s)\n", currentV6, old)
 		stored.V6 = currentV6
 		changed = true
+		metrics.IPChanges.Inc("v6")
 	}
 
 	if changed {
 		saveStoredIPs(stored)
-		adminID := config.AppConfig.AdminID
-		if adminID != 0 {
-			menu := &tele.ReplyMarkup{}
-			menu.Inline(menu.Row(menu.Data("🔙 返回主菜单", "start_main")))
-
-			_, err := b.Send(&tele.User{ID: adminID}, msg, menu, tele.ModeMarkdown)
-			if err != nil {
-				log.Printf("Failed to send IP notification: %v", err)
-			}
-		}
+		menu := &tele.ReplyMarkup{}
+		menu.Inline(menu.Row(menu.Data("🔙 返回主菜单", "start_main")))
+		notify.Publish(ctx, notify.Event{
+			Category: "ip",
+			Severity: notify.Warning,
+			Title:    "🚨 公网 IP 变动通知",
+			Text:     strings.TrimRight(msg, "\n"),
+			Markup:   menu,
+		})
 	}
 }
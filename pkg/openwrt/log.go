@@ -1,14 +1,15 @@
 package openwrt
 
 import (
+	"context"
 	"fmt"
 	"strings"
 )
 
 // GetLogs fetches the last N lines from OpenWrt's system log.
-func GetLogs(count int) (string, error) {
+func GetLogs(ctx context.Context, count int) (string, error) {
 	cmd := fmt.Sprintf("logread | tail -n %d", count)
-	logs, err := SSHExec(cmd)
+	logs, err := Exec(ctx, cmd)
 	if err != nil {
 		return "", err
 	}
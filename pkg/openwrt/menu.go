@@ -1,8 +1,9 @@
 package openwrt
 
 import (
-	"strings"
+	"fmt"
 
+	"github.com/yingxiaomo/homeops/pkg/router"
 	"github.com/yingxiaomo/homeops/pkg/utils"
 	tele "gopkg.in/telebot.v3"
 )
@@ -10,146 +11,134 @@ import (
 func HandleWrtMain(c tele.Context) error {
 	c.Respond()
 	menu := &tele.ReplyMarkup{}
-	menu.Inline(
+	rows := []tele.Row{
 		menu.Row(menu.Data("📈 系统状态", "wrt_status"), menu.Data("🏠 当前 IP", "wrt_show_current_ips")),
 		menu.Row(menu.Data("📱 联网设备", "wrt_devices"), menu.Data("🌐 网络工具", "wrt_net")),
 		menu.Row(menu.Data("📜 运行脚本", "wrt_scripts_list"), menu.Data("🔥 防火墙", "wrt_fw_menu")),
-		menu.Row(menu.Data("🛡️ AdGuard", "wrt_adg"), menu.Data("🔄 重启系统", "wrt_reboot_confirm")),
-		menu.Row(menu.Data("🤖 AI 分析日志", "wrt_ai_analyze"), menu.Data("🔙 返回", "start_main")),
-	)
-	return utils.SendLongMessage(c, nil, "📡 **OpenWrt 管理面板**\n请选择功能：", menu)
-}
-
-func HandleCallback(c tele.Context, data string) error {
-	if strings.HasPrefix(data, "wrt_net_run_") {
-		return HandleNetRunQuick(c, data)
+		menu.Row(menu.Data("🛡️ AdGuard", "wrt_adg"), menu.Data("⚙️ UCI 配置", "uci_pkgs", "0")),
+		menu.Row(menu.Data("📁 文件", "wrt_files"), menu.Data("💾 配置备份", "wrt_backup")),
+		menu.Row(menu.Data("📦 软件包", "wrt_pkg"), menu.Data("🔄 重启系统", "wrt_reboot_confirm")),
 	}
-	if strings.HasPrefix(data, "wrt_run_") {
-		if strings.HasPrefix(data, "wrt_run_script") {
-			return HandleRunScript(c)
-		}
+	title := "📡 **OpenWrt 管理面板**\n请选择功能："
+	if multiRouter() {
+		name := RouterName(utils.Ctx(c))
+		title = fmt.Sprintf("📡 **OpenWrt 管理面板** · `%s`\n请选择功能：", name)
+		rows = append(rows, menu.Row(menu.Data("🔀 切换路由器 ("+name+")", "wrt_pick_menu")))
 	}
+	rows = append(rows, menu.Row(menu.Data("🤖 AI 分析日志", "wrt_ai_analyze"), menu.Data("🔙 返回", "start_main")))
+	menu.Inline(rows...)
+	return utils.SendLongMessage(c, nil, title, menu)
+}
 
-	if strings.HasPrefix(data, "wrt_fw_del") {
-		return HandleFwDel(c)
-	}
-	if strings.HasPrefix(data, "wrt_fw_rename_") {
-		return HandleFwRename(c)
-	}
-	if strings.HasPrefix(data, "wrt_svc_restart") {
-		return HandleServiceRestart(c)
-	}
+// RegisterRoutes registers the OpenWrt, firewall and AdGuard callbacks.
+func RegisterRoutes(r *router.Registry) {
+	r.Handle("wrt_main", "wrt", HandleWrtMain)
+	r.Handle("wrt_exit", "wrt", HandleWrtMain)
+	r.Handle("wrt_status", "wrt", HandleStatus)
+	r.Handle("wrt_show_current_ips", "wrt", HandleShowCurrentIPs)
+	r.Handle("wrt_devices", "wrt", HandleDevices)
+	r.Handle("wrt_net", "wrt", HandleNetMenu)
+	r.Handle("wrt_net_quick", "wrt", HandleNetQuick)
+	r.Handle("wrt_net_manual", "wrt", HandleNetManual)
+	r.Handle("wrt_net_ping_ask", "wrt", HandleNetPingAsk)
+	r.Handle("wrt_net_trace_ask", "wrt", HandleNetTraceAsk)
+	r.Handle("wrt_net_nslookup_ask", "wrt", HandleNetNslookupAsk)
+	r.Handle("wrt_net_curl_ask", "wrt", HandleNetCurlAsk)
+	r.HandlePrefix("wrt_net_run_", "wrt", HandleNetRunQuick)
+	r.Handle("wrt_scripts_list", "wrt", HandleScriptsList)
+	r.HandlePrefix("wrt_run_script|", "wrt", router.Token(HandleRunScript))
+	r.HandlePrefix("wrt_stream_cancel|", "wrt", HandleStreamCancel)
+	r.Handle("wrt_ai_analyze", "wrt", HandleAIAnalyze)
+	r.Handle("wrt_reboot_confirm", "wrt", HandleRebootConfirm)
+	r.Handle("wrt_reboot_do", "wrt", HandleRebootDo)
+	r.Handle("wrt_services_menu", "wrt", HandleServicesMenu)
+	r.Handle("wrt_svc_restart", "wrt", HandleServiceRestart)
+	r.Handle("wrt_drop_caches", "wrt", HandleDropCaches)
+	r.HandlePrefix("alert_snooze|", "wrt", router.Token(HandleAlertSnooze))
+	r.Handle("wrt_pick_menu", "wrt", HandleRouterPick)
+	r.HandlePrefix("wrt_pick|", "wrt", HandleRouterSelect)
+	r.Handle("wrt_status_all", "wrt", HandleStatusAll)
+	r.Handle("wrt_devices_all", "wrt", HandleDevicesAll)
 
-	if strings.HasPrefix(data, "wrt_fw_wiz_proto") {
-		return HandleFwWizardProto(c)
-	}
-	if strings.HasPrefix(data, "wrt_fw_wiz_target") {
-		return HandleFwWizardTarget(c)
-	}
+	r.Handle("wrt_fw_menu", "wrt.firewall", HandleFwMenu)
+	r.Handle("wrt_fw_list_redirects", "wrt.firewall", HandleFwListRedirects)
+	r.Handle("wrt_fw_list_rules", "wrt.firewall", HandleFwListRules)
+	r.Handle("wrt_fw_list_all", "wrt.firewall", HandleFwListAll)
+	r.Handle("wrt_fw_add_redirect_start", "wrt.firewall", HandleFwAddRedirectStart)
+	r.Handle("wrt_fw_add_rule_start", "wrt.firewall", HandleFwAddRuleStart)
+	r.HandlePrefix("wrt_fw_del|", "wrt.firewall", router.Token(HandleFwDel))
+	r.HandlePrefix("wrt_fw_rename_", "wrt.firewall", router.Token(HandleFwRename))
+	r.Handle("wrt_fw_wiz_proto", "wrt.firewall", HandleFwWizardProto)
+	r.Handle("wrt_fw_wiz_target", "wrt.firewall", HandleFwWizardTarget)
+	r.Handle("wrt_files", "wrt.files", HandleFilesMenu)
+	r.HandlePrefix("wrt_files_open|", "wrt.files", router.Token(HandleFilesOpen))
+	r.HandlePrefix("wrt_files_get|", "wrt.files", router.Token(HandleFilesGet))
+	r.HandlePrefix("wrt_files_up|", "wrt.files", router.Token(HandleFilesUploadStart))
+	r.Handle("wrt_files_put", "wrt.files", HandleFilesPut)
+	r.Handle("wrt_files_put_cancel", "wrt.files", HandleFilesPutCancel)
+	r.HandlePrefix("uci_pkgs|", "wrt.uci", HandleUCIPackages)
+	r.HandlePrefix("uci_pkg|", "wrt.uci", HandleUCIPackage)
+	r.HandlePrefix("uci_sec|", "wrt.uci", router.Token(HandleUCISection))
+	r.HandlePrefix("uci_opt|", "wrt.uci", router.Token(HandleUCIOption))
+	r.HandlePrefix("uci_optnew|", "wrt.uci", router.Token(HandleUCIOptionNew))
+	r.HandlePrefix("uci_optdel|", "wrt.uci", router.Token(HandleUCIOptionDelete))
+	r.HandlePrefix("uci_secdel|", "wrt.uci", router.Token(HandleUCISectionDelete))
+	r.HandlePrefix("uci_changes|", "wrt.uci", HandleUCIChanges)
+	r.HandlePrefix("uci_commit|", "wrt.uci", HandleUCICommit)
+	r.HandlePrefix("uci_revert|", "wrt.uci", HandleUCIRevert)
+	r.Handle("wrt_backup", "wrt.backup", HandleBackupMenu)
+	r.Handle("wrt_backup_new", "wrt.backup", HandleBackupCreate)
+	r.HandlePrefix("wrt_backup_open|", "wrt.backup", router.Token(HandleBackupOpen))
+	r.HandlePrefix("wrt_backup_send|", "wrt.backup", router.Token(HandleBackupSend))
+	r.HandlePrefix("wrt_backup_del|", "wrt.backup", router.Token(HandleBackupDelete))
+	r.HandlePrefix("wrt_backup_restore|", "wrt.backup", router.Token(HandleBackupRestoreConfirm))
+	r.HandlePrefix("wrt_backup_restore_do|", "wrt.backup", router.Token(HandleBackupRestoreDo))
+	r.Handle("wrt_pkg", "wrt.packages", HandlePackagesMenu)
+	r.Handle("wrt_pkg_check", "wrt.packages", HandlePackagesCheck)
+	r.Handle("wrt_pkg_search", "wrt.packages", HandlePackagesSearchStart)
+	r.HandlePrefix("wrt_pkg_toggle|", "wrt.packages", HandlePackagesToggle)
+	r.HandlePrefix("wrt_pkg_upgrade|", "wrt.packages", HandlePackagesUpgrade)
+	r.HandlePrefix("wrt_pkg_info|", "wrt.packages", router.Token(HandlePackagesInfo))
+	r.HandlePrefix("wrt_pkg_install|", "wrt.packages", router.Token(HandlePackagesInstall))
+	r.HandlePrefix("wrt_pkg_remove|", "wrt.packages", router.Token(HandlePackagesRemove))
+	r.HandlePrefix("wrt_pkg_run|", "wrt.packages", HandlePackagesRun)
+	r.HandlePrefix("wrt_sysup|", "wrt", HandleSysupgradeCheck)
+	r.Handle("wrt_sysup_go", "", HandleSysupgradeConfirm)
+	r.Handle("wrt_sysup_do", "", HandleSysupgradeDo)
+	r.HandlePrefix("wrt_drift_accept|", "", HandleDriftAccept)
+	r.HandlePrefix("wrt_drift_revert|", "", HandleDriftRevert)
+	r.HandlePrefix("wrt_cc_keep|", "", HandleConfirmKeep)
+	r.HandlePrefix("hostkey_accept|", "", HandleHostKeyAccept)
+	r.HandlePrefix("wrt_cc_revert|", "", HandleConfirmRevert)
 
-	if strings.HasPrefix(data, "wrt_adg_gen_toggle_") {
-		return HandleAdgGenToggle(c, strings.TrimPrefix(data, "wrt_adg_gen_toggle_"))
-	}
-	if strings.HasPrefix(data, "wrt_adg_dns_toggle_") {
-		return HandleAdgDNSToggle(c, strings.TrimPrefix(data, "wrt_adg_dns_toggle_"))
-	}
-	if strings.HasPrefix(data, "wrt_adg_dhcp_toggle") {
-		parts := strings.Split(data, "|")
-		if len(parts) == 2 {
-			return HandleAdgDhcpToggle(c, parts[1])
-		}
-		return c.Respond()
-	}
+	r.Handle("wrt_adg", "adg", HandleAdgMenu)
+	r.Handle("wrt_adg_toggle", "adg", HandleAdgToggle)
+	r.Handle("wrt_adg_general", "adg", HandleAdgGeneral)
+	r.HandlePrefix("wrt_adg_gen_toggle_", "adg", HandleAdgGenToggle)
+	r.Handle("wrt_adg_gen_cycle_log", "adg", HandleAdgGenCycleLog)
+	r.Handle("wrt_adg_gen_cycle_stats", "adg", HandleAdgGenCycleStats)
+	r.Handle("wrt_adg_dns", "adg", HandleAdgDns)
+	r.Handle("wrt_adg_dns_advanced", "adg", HandleAdgDNSAdvanced)
+	r.HandlePrefix("wrt_adg_dns_toggle_", "adg", HandleAdgDNSToggle)
+	r.Handle("wrt_adg_dns_cycle_bm", "adg", HandleAdgDnsCycleBM)
+	r.Handle("wrt_adg_dns_edit_upstream", "adg", adgWizard("set_upstreams", "请输入新的上游 DNS (每行一个):"))
+	r.Handle("wrt_adg_dns_edit_bootstrap", "adg", adgWizard("set_bootstrap", "请输入新的 Bootstrap DNS (每行一个):"))
+	r.Handle("wrt_adg_dns_edit_rl", "adg", adgWizard("set_ratelimit", "请输入速率限制 (次/秒):"))
+	r.Handle("wrt_adg_dns_edit_cache", "adg", adgWizard("set_cache", "请输入缓存大小 (MB):"))
+	r.Handle("wrt_adg_dhcp", "adg", HandleAdgDhcp)
+	r.Handle("wrt_adg_dhcp_config", "adg", HandleAdgDhcpConfig)
+	r.HandlePrefix("wrt_adg_dhcp_toggle|", "adg", HandleAdgDhcpToggle)
+	r.Handle("wrt_adg_rules", "adg", HandleAdgRules)
+	r.Handle("wrt_adg_rules_edit", "adg", adgWizard("edit_rules", "请输入要添加或删除的规则 (精确匹配删除):"))
+	r.Handle("wrt_adg_filters", "adg", HandleAdgFilters)
+	r.Handle("wrt_adg_filter_add", "adg", adgWizard("add_filter", "请输入过滤器 名称 和 URL (空格分隔):"))
+	r.Handle("wrt_adg_filter_del", "adg", adgWizard("del_filter", "请输入要删除的过滤器 URL:"))
+	r.Handle("wrt_adg_filter_refresh", "adg", HandleAdgFilters)
+	r.Handle("wrt_adg_restart", "adg", HandleAdgRestart)
+}
 
-	switch data {
-	case "wrt_main", "wrt_exit":
-		return HandleWrtMain(c)
-	case "wrt_status":
-		return HandleStatus(c)
-	case "wrt_show_current_ips":
-		return HandleShowCurrentIPs(c)
-	case "wrt_devices":
-		return HandleDevices(c)
-	case "wrt_net":
-		return HandleNetMenu(c)
-	case "wrt_net_quick":
-		return HandleNetQuick(c)
-	case "wrt_net_manual":
-		return HandleNetManual(c)
-	case "wrt_net_ping_ask":
-		return HandleNetPingAsk(c)
-	case "wrt_net_trace_ask":
-		return HandleNetTraceAsk(c)
-	case "wrt_net_nslookup_ask":
-		return HandleNetNslookupAsk(c)
-	case "wrt_net_curl_ask":
-		return HandleNetCurlAsk(c)
-	case "wrt_scripts_list":
-		return HandleScriptsList(c)
-	case "wrt_fw_menu":
-		return HandleFwMenu(c)
-	case "wrt_fw_list_redirects":
-		return HandleFwListRedirects(c)
-	case "wrt_fw_list_rules":
-		return HandleFwListRules(c)
-	case "wrt_fw_list_all":
-		return HandleFwListAll(c)
-	case "wrt_fw_add_redirect_start":
-		return HandleFwAddRedirectStart(c)
-	case "wrt_fw_add_rule_start":
-		return HandleFwAddRuleStart(c)
-	case "wrt_adg":
-		return HandleAdgMenu(c)
-	case "wrt_adg_toggle":
-		return HandleAdgToggle(c)
-	case "wrt_adg_general":
-		return HandleAdgGeneral(c)
-	case "wrt_adg_gen_cycle_log":
-		return HandleAdgGenCycleLog(c)
-	case "wrt_adg_gen_cycle_stats":
-		return HandleAdgGenCycleStats(c)
-	case "wrt_adg_dns":
-		return HandleAdgDns(c)
-	case "wrt_adg_dns_advanced":
-		return HandleAdgDNSAdvanced(c)
-	case "wrt_adg_dns_edit_upstream":
-		return HandleAdgStartWizard(c, "set_upstreams", "请输入新的上游 DNS (每行一个):")
-	case "wrt_adg_dns_edit_bootstrap":
-		return HandleAdgStartWizard(c, "set_bootstrap", "请输入新的 Bootstrap DNS (每行一个):")
-	case "wrt_adg_dns_edit_rl":
-		return HandleAdgStartWizard(c, "set_ratelimit", "请输入速率限制 (次/秒):")
-	case "wrt_adg_dns_edit_cache":
-		return HandleAdgStartWizard(c, "set_cache", "请输入缓存大小 (MB):")
-	case "wrt_adg_dns_cycle_bm":
-		return HandleAdgDnsCycleBM(c)
-	case "wrt_adg_dhcp":
-		return HandleAdgDhcp(c)
-	case "wrt_adg_dhcp_config":
-		return HandleAdgDhcpConfig(c)
-	case "wrt_adg_rules":
-		return HandleAdgRules(c)
-	case "wrt_adg_rules_edit":
-		return HandleAdgStartWizard(c, "edit_rules", "请输入要添加或删除的规则 (精确匹配删除):")
-	case "wrt_adg_filters":
-		return HandleAdgFilters(c)
-	case "wrt_adg_filter_add":
-		return HandleAdgStartWizard(c, "add_filter", "请输入过滤器 名称 和 URL (空格分隔):")
-	case "wrt_adg_filter_del":
-		return HandleAdgStartWizard(c, "del_filter", "请输入要删除的过滤器 URL:")
-	case "wrt_adg_filter_refresh":
-		return HandleAdgFilters(c)
-	case "wrt_adg_restart":
-		return HandleAdgRestart(c)
-	case "wrt_ai_analyze":
-		return HandleAIAnalyze(c)
-	case "wrt_reboot_confirm":
-		return HandleRebootConfirm(c)
-	case "wrt_reboot_do":
-		return HandleRebootDo(c)
-	case "wrt_services_menu":
-		return HandleServicesMenu(c)
-	case "wrt_drop_caches":
-		return HandleDropCaches(c)
+func adgWizard(mode, prompt string) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		return HandleAdgStartWizard(c, mode, prompt)
 	}
-	return c.Respond()
 }
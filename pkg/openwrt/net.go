@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/utils"
 	tele "gopkg.in/telebot.v3"
 )
 
@@ -40,92 +41,107 @@ func HandleNetManual(c tele.Context) error {
 	return c.Edit("✍️ **手动测试**\n请选择工具并输入目标：", menu, tele.ModeMarkdown)
 }
 
-func HandleNetRunQuick(c tele.Context, data string) error {
+func HandleNetRunQuick(c tele.Context, test string) error {
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "正在执行测试..."})
 
 	var cmd, title string
-	switch data {
-	case "wrt_net_run_ping_gateway":
-		gw, _ := SSHExec("ip route | grep default | awk '{print $3}' | head -n 1")
+	switch test {
+	case "ping_gateway":
+		gw, _ := Exec(ctx, "ip route | grep default | awk '{print $3}' | head -n 1")
 		gw = strings.TrimSpace(gw)
 		if gw == "" {
 			gw = "192.168.1.1"
 		}
 		cmd = fmt.Sprintf("ping -c 4 -w 5 %s", gw)
 		title = fmt.Sprintf("Ping Gateway (%s)", gw)
-	case "wrt_net_run_ping_baidu":
+	case "ping_baidu":
 		cmd = "ping -c 4 -w 5 www.baidu.com"
 		title = "Ping Baidu"
-	case "wrt_net_run_ping_google":
+	case "ping_google":
 		cmd = "ping -c 4 -w 5 www.google.com"
 		title = "Ping Google"
-	case "wrt_net_run_ping_dns":
+	case "ping_dns":
 		cmd = "ping -c 4 -w 5 8.8.8.8"
 		title = "Ping 8.8.8.8"
-	case "wrt_net_run_trace_google":
+	case "trace_google":
 		cmd = "traceroute -I -m 15 -w 2 -q 1 -n www.google.com 2>/dev/null || traceroute -m 15 -w 2 -q 1 -n www.google.com"
 		title = "Trace Google"
-	case "wrt_net_run_ns_google":
+	case "ns_google":
 		cmd = "nslookup www.google.com"
 		title = "Nslookup Google"
 	}
 
-	c.Edit(fmt.Sprintf("⏳ 正在执行 %s...", title))
-	res, _ := SSHExec(cmd)
-	if res == "" {
-		res = "❌ 执行失败或无输出"
-	}
-	if len(res) > 3000 {
-		res = res[:3000] + "\n...(truncated)"
-	}
-	res = strings.ReplaceAll(res, "`", "'")
-
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("🔙 返回快速诊断", "wrt_net_quick")))
-	return c.Edit(fmt.Sprintf("📝 **%s 结果**:\n```\n%s\n```", title, res), menu, tele.ModeMarkdown)
+	liveOutput(c, c.Message(), title, cmd, menu)
+	return nil
 }
 
 func HandleNetPingAsk(c tele.Context) error {
-	session.GlobalStore.Set(c.Sender().ID, "wrt_net_state", "ping")
+	session.GlobalStore.SetWithTTL(c.Sender().ID, "wrt_net_state", "ping", session.WizardTTL)
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("❌ 取消", "wrt_net_manual")))
 	return c.Send("📡 请输入要 Ping 的地址/域名：\n(例如: 8.8.8.8 或 google.com)", menu, tele.ForceReply)
 }
 
 func HandleNetTraceAsk(c tele.Context) error {
-	session.GlobalStore.Set(c.Sender().ID, "wrt_net_state", "trace")
+	session.GlobalStore.SetWithTTL(c.Sender().ID, "wrt_net_state", "trace", session.WizardTTL)
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("❌ 取消", "wrt_net_manual")))
 	return c.Send("📍 请输入要追踪的目标地址：\n(例如: 1.1.1.1)", menu, tele.ForceReply)
 }
 
 func HandleNetNslookupAsk(c tele.Context) error {
-	session.GlobalStore.Set(c.Sender().ID, "wrt_net_state", "nslookup")
+	session.GlobalStore.SetWithTTL(c.Sender().ID, "wrt_net_state", "nslookup", session.WizardTTL)
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("❌ 取消", "wrt_net_manual")))
 	return c.Send("🔎 请输入要查询的域名：", menu, tele.ForceReply)
 }
 
 func HandleNetCurlAsk(c tele.Context) error {
-	session.GlobalStore.Set(c.Sender().ID, "wrt_net_state", "curl")
+	session.GlobalStore.SetWithTTL(c.Sender().ID, "wrt_net_state", "curl", session.WizardTTL)
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("❌ 取消", "wrt_net_manual")))
 	return c.Send("🌐 请输入要检测的 URL：", menu, tele.ForceReply)
 }
 
+var netTargetRe = regexp.MustCompile(`^[a-zA-Z0-9\.\-\_:/]+$`)
+
+// validNetTarget rejects characters the shell would interpret and targets
+// starting with "-", which the tools would parse as options.
+func validNetTarget(target string) bool {
+	return netTargetRe.MatchString(target) && !strings.HasPrefix(target, "-")
+}
+
 func HandleNetInput(c tele.Context, state string) error {
 	target := c.Text()
 
-	if !regexp.MustCompile(`^[a-zA-Z0-9\.\-\_:/]+$`).MatchString(target) {
+	if !validNetTarget(target) {
 		menu := &tele.ReplyMarkup{}
 		menu.Inline(menu.Row(menu.Data("❌ 取消", "wrt_net_manual")))
 		return c.Send("❌ 检测到非法字符，请重新输入", menu)
 	}
 
 	session.GlobalStore.Delete(c.Sender().ID, "wrt_net_state")
+	return runNetTool(c, state, target)
+}
 
-	c.Send(fmt.Sprintf("⏳ 正在执行 %s %s...", state, target))
+// HandleNetCommand handles /ping, /trace, /nslookup and /curl <target>.
+func HandleNetCommand(tool string) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		target := strings.TrimSpace(c.Message().Payload)
+		if target == "" {
+			return c.Send(fmt.Sprintf("用法: /%s <地址>", tool))
+		}
+		if !validNetTarget(target) {
+			return c.Send("❌ 检测到非法字符")
+		}
+		return runNetTool(c, tool, target)
+	}
+}
 
+func runNetTool(c tele.Context, state, target string) error {
 	var cmd string
 	switch state {
 	case "ping":
@@ -138,16 +154,8 @@ func HandleNetInput(c tele.Context, state string) error {
 		cmd = fmt.Sprintf("curl -I -s -w 'Response Code: %%{http_code}\\nTime: %%{time_total}s\\n' -o /dev/null %s", target)
 	}
 
-	res, _ := SSHExec(cmd)
-	if res == "" {
-		res = "❌ 执行失败或无输出"
-	}
-	if len(res) > 3000 {
-		res = res[:3000] + "\n...(truncated)"
-	}
-	res = strings.ReplaceAll(res, "`", "'")
-
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("🔙 返回手动测试", "wrt_net_manual")))
-	return c.Send(fmt.Sprintf("📝 **测试结果**:\n```\n%s\n```", res), menu, tele.ModeMarkdown)
+	liveOutput(c, nil, state+" "+target, cmd, menu)
+	return nil
 }
@@ -0,0 +1,21 @@
+package openwrt
+
+import "testing"
+
+func TestValidNetTarget(t *testing.T) {
+	for target, want := range map[string]bool{
+		"1.1.1.1":                 true,
+		"openwrt.org":             true,
+		"2606:4700::1111":         true,
+		"https://example.com/a-b": true,
+		"-K/etc/shadow":           false,
+		"--output=/etc/passwd":    false,
+		"-c1000":                  false,
+		"a;reboot":                false,
+		"":                        false,
+	} {
+		if got := validNetTarget(target); got != want {
+			t.Errorf("validNetTarget(%q) = %v, want %v", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/uci"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	pkgUpgradableKey = "opkg_upgradable"
+	pkgSelectedKey   = "opkg_selected"
+	pkgOpKey         = "opkg_op"
+	// PkgSearchKey marks a user who is typing a package search.
+	PkgSearchKey = "opkg_search"
+
+	pkgListMax   = 30
+	pkgSearchMax = 20
+)
+
+var (
+	pkgNameRe   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+	pkgSearchRe = regexp.MustCompile(`^[A-Za-z0-9._+*-]{2,40}$`)
+	// riskyPackages can leave the router unbootable when upgraded or
+	// removed, so they need a second confirmation.
+	riskyPackages = []string{"kernel", "base-files", "libc", "busybox", "procd"}
+)
+
+// pkgInfo is one line of "opkg list" or "opkg list-upgradable".
+type pkgInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	New     string `json:"new,omitempty"`
+}
+
+// pkgOp is an install, upgrade or remove waiting for confirmation.
+type pkgOp struct {
+	Action   string   `json:"action"`
+	Packages []string `json:"packages"`
+}
+
+var pkgActionLabels = map[string]string{
+	"upgrade": "升级",
+	"install": "安装",
+	"remove":  "卸载",
+}
+
+func isRiskyPackage(name string) bool {
+	return slices.Contains(riskyPackages, name) || strings.HasPrefix(name, "kmod-")
+}
+
+// parsePkgList parses "name - version[ - description|new version]" lines.
+func parsePkgList(out string, upgradable bool) []pkgInfo {
+	var list []pkgInfo
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), " - ", 3)
+		if len(parts) < 2 || !pkgNameRe.MatchString(parts[0]) {
+			continue
+		}
+		p := pkgInfo{Name: parts[0], Version: parts[1]}
+		if upgradable {
+			if len(parts) < 3 {
+				continue
+			}
+			p.New = parts[2]
+		}
+		list = append(list, p)
+	}
+	return list
+}
+
+func listUpgradable(ctx context.Context) ([]pkgInfo, error) {
+	out, err := Exec(ctx, "opkg list-upgradable")
+	if err != nil {
+		return nil, fmt.Errorf("%v %s", err, strings.TrimSpace(out))
+	}
+	return parsePkgList(out, true), nil
+}
+
+func pkgCommand(op pkgOp) string {
+	quoted := make([]string, len(op.Packages))
+	for i, p := range op.Packages {
+		quoted[i] = uci.Quote(p)
+	}
+	return fmt.Sprintf("opkg %s %s 2>&1", op.Action, strings.Join(quoted, " "))
+}
+
+// HandlePackagesMenu shows the package management menu.
+func HandlePackagesMenu(c tele.Context) error {
+	session.GlobalStore.Delete(c.Sender().ID, PkgSearchKey)
+	c.Respond()
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("🔄 检查更新", "wrt_pkg_check"), menu.Data("🔍 搜索软件包", "wrt_pkg_search")),
+		menu.Row(menu.Data("🔙 返回", "wrt_main")),
+	)
+	return c.EditOrSend("📦 **软件包管理**\n检查更新会先执行 `opkg update`。", menu, tele.ModeMarkdown)
+}
+
+// HandlePackagesCheck runs opkg update and lists the upgradable packages.
+func HandlePackagesCheck(c tele.Context) error {
+	ctx := utils.Ctx(c)
+	c.Respond(&tele.CallbackResponse{Text: "正在更新软件源..."})
+
+	back := &tele.ReplyMarkup{}
+	back.Inline(back.Row(back.Data("🔙 返回", "wrt_pkg")))
+	// A single unreachable feed fails "opkg update" but the others are
+	// still usable, so only stop when the user cancelled.
+	if _, err := liveOutput(c, c.Message(), "opkg update", "opkg update 2>&1", back); errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	list, err := listUpgradable(ctx)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ 读取可升级列表失败: %v", err), back)
+	}
+	session.GlobalStore.SetWithTTL(c.Sender().ID, pkgUpgradableKey, list, session.WizardTTL)
+	session.GlobalStore.SetWithTTL(c.Sender().ID, pkgSelectedKey, []string{}, session.WizardTTL)
+	return sendUpgradable(c, list, nil, false)
+}
+
+func sendUpgradable(c tele.Context, list []pkgInfo, selected []string, edit bool) error {
+	menu := &tele.ReplyMarkup{}
+	var sb strings.Builder
+	var rows []tele.Row
+
+	if len(list) == 0 {
+		sb.WriteString("✅ 所有软件包都是最新的。")
+	} else {
+		sb.WriteString(fmt.Sprintf("⬆️ %d 个软件包可升级", len(list)))
+		if len(list) > pkgListMax {
+			sb.WriteString(fmt.Sprintf("，仅显示前 %d 个", pkgListMax))
+		}
+		sb.WriteString("\n-------------------\n")
+	}
+
+	var row []tele.Btn
+	for i, p := range list {
+		if i == pkgListMax {
+			break
+		}
+		mark := ""
+		if isRiskyPackage(p.Name) {
+			mark = " ⚠️"
+		}
+		sb.WriteString(fmt.Sprintf("• %s%s: %s → %s\n", p.Name, mark, p.Version, p.New))
+
+		box := "⬜ "
+		if slices.Contains(selected, p.Name) {
+			box = "☑️ "
+		}
+		row = append(row, menu.Data(box+p.Name, "wrt_pkg_toggle", strconv.Itoa(i)))
+		if len(row) == 2 {
+			rows = append(rows, menu.Row(row...))
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, menu.Row(row...))
+	}
+	if len(list) > 0 {
+		rows = append(rows, menu.Row(
+			menu.Data(fmt.Sprintf("⬆️ 升级所选 (%d)", len(selected)), "wrt_pkg_upgrade", "selected"),
+			menu.Data("⬆️ 全部升级", "wrt_pkg_upgrade", "all"),
+		))
+		sb.WriteString("\n⚠️ 标记的为核心软件包，升级有风险。")
+	}
+	rows = append(rows, menu.Row(menu.Data("🔙 返回", "wrt_pkg")))
+	menu.Inline(rows...)
+
+	if edit {
+		return c.Edit(sb.String(), menu)
+	}
+	return c.Send(sb.String(), menu)
+}
+
+// HandlePackagesToggle handles "wrt_pkg_toggle|<index>".
+func HandlePackagesToggle(c tele.Context, payload string) error {
+	userID := c.Sender().ID
+	list, ok := session.GetAs[[]pkgInfo](session.GlobalStore, userID, pkgUpgradableKey)
+	i, err := strconv.Atoi(payload)
+	if !ok || err != nil || i < 0 || i >= len(list) {
+		return c.Respond(&tele.CallbackResponse{Text: "列表已过期，请重新检查更新"})
+	}
+	c.Respond()
+
+	selected, _ := session.GetAs[[]string](session.GlobalStore, userID, pkgSelectedKey)
+	name := list[i].Name
+	if j := slices.Index(selected, name); j >= 0 {
+		selected = slices.Delete(selected, j, j+1)
+	} else {
+		selected = append(selected, name)
+	}
+	session.GlobalStore.SetWithTTL(userID, pkgSelectedKey, selected, session.WizardTTL)
+	return sendUpgradable(c, list, selected, true)
+}
+
+// HandlePackagesUpgrade handles "wrt_pkg_upgrade|selected|all".
+func HandlePackagesUpgrade(c tele.Context, which string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	userID := c.Sender().ID
+	list, ok := session.GetAs[[]pkgInfo](session.GlobalStore, userID, pkgUpgradableKey)
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "列表已过期，请重新检查更新"})
+	}
+
+	var names []string
+	if which == "all" {
+		for _, p := range list {
+			names = append(names, p.Name)
+		}
+	} else {
+		names, _ = session.GetAs[[]string](session.GlobalStore, userID, pkgSelectedKey)
+	}
+	if len(names) == 0 {
+		return c.Respond(&tele.CallbackResponse{Text: "请先选择要升级的软件包"})
+	}
+	c.Respond()
+	return confirmPkgOp(c, pkgOp{Action: "upgrade", Packages: names})
+}
+
+// HandlePackagesSearchStart asks for a search term.
+func HandlePackagesSearchStart(c tele.Context) error {
+	c.Respond()
+	session.GlobalStore.SetWithTTL(c.Sender().ID, PkgSearchKey, true, session.WizardTTL)
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("❌ 取消", "wrt_pkg")))
+	return c.Send("🔍 请输入软件包名称或关键字 (支持 * 通配符):", menu)
+}
+
+// HandlePackagesSearch lists the packages matching term, available and
+// installed.
+func HandlePackagesSearch(c tele.Context, term string) error {
+	session.GlobalStore.Delete(c.Sender().ID, PkgSearchKey)
+	menu := &tele.ReplyMarkup{}
+	term = strings.TrimSpace(term)
+	if !pkgSearchRe.MatchString(term) {
+		menu.Inline(menu.Row(menu.Data("🔍 重新搜索", "wrt_pkg_search"), menu.Data("🔙 返回", "wrt_pkg")))
+		return c.Send("❌ 关键字只能包含字母、数字和 . _ + - *，长度 2-40。", menu)
+	}
+	pattern := term
+	if !strings.Contains(term, "*") {
+		pattern = "*" + term + "*"
+	}
+
+	ctx := utils.Ctx(c)
+	out, err := Exec(ctx, "opkg list "+uci.Quote(pattern))
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ 搜索失败: %v %s", err, strings.TrimSpace(out)))
+	}
+	found := parsePkgList(out, false)
+	installedOut, _ := Exec(ctx, "opkg list-installed "+uci.Quote(pattern))
+	installed := make(map[string]string)
+	for _, p := range parsePkgList(installedOut, false) {
+		installed[p.Name] = p.Version
+		if !slices.ContainsFunc(found, func(f pkgInfo) bool { return f.Name == p.Name }) {
+			found = append(found, p)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔍 %s: 找到 %d 个软件包", term, len(found)))
+	if len(found) > pkgSearchMax {
+		sb.WriteString(fmt.Sprintf("，仅显示前 %d 个", pkgSearchMax))
+	}
+	if len(found) == 0 {
+		sb.WriteString("\n如果刚刷机，请先「检查更新」以下载软件源列表。")
+	}
+
+	var rows []tele.Row
+	for i, p := range found {
+		if i == pkgSearchMax {
+			break
+		}
+		label := "📦 " + p.Name
+		if _, ok := installed[p.Name]; ok {
+			label = "✅ " + p.Name
+		}
+		rows = append(rows, menu.Row(menu.Data(label, "wrt_pkg_info", session.PutToken(p.Name))))
+	}
+	rows = append(rows, menu.Row(menu.Data("🔍 重新搜索", "wrt_pkg_search"), menu.Data("🔙 返回", "wrt_pkg")))
+	menu.Inline(rows...)
+	return c.Send(sb.String(), menu)
+}
+
+// pkgStatus returns the installed version of name, or "" if it is not
+// installed.
+func pkgStatus(ctx context.Context, name string) string {
+	out, _ := Exec(ctx, "opkg list-installed "+uci.Quote(name))
+	for _, p := range parsePkgList(out, false) {
+		if p.Name == name {
+			return p.Version
+		}
+	}
+	return ""
+}
+
+// HandlePackagesInfo shows one package with install or remove buttons.
+func HandlePackagesInfo(c tele.Context, name string) error {
+	if !pkgNameRe.MatchString(name) {
+		return c.Respond()
+	}
+	ctx := utils.Ctx(c)
+	c.Respond()
+
+	out, _ := Exec(ctx, "opkg info "+uci.Quote(name))
+	fields := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if k, v, ok := strings.Cut(line, ": "); ok {
+			if _, seen := fields[k]; !seen {
+				fields[k] = strings.TrimSpace(v)
+			}
+		}
+	}
+	version := pkgStatus(ctx, name)
+
+	var sb strings.Builder
+	sb.WriteString("📦 " + name)
+	if isRiskyPackage(name) {
+		sb.WriteString(" ⚠️ 核心软件包")
+	}
+	sb.WriteString("\n")
+	if version != "" {
+		sb.WriteString("已安装: " + version + "\n")
+	} else {
+		sb.WriteString("未安装\n")
+	}
+	if v := fields["Version"]; v != "" && v != version {
+		sb.WriteString("可用版本: " + v + "\n")
+	}
+	if v := fields["Size"]; v != "" {
+		sb.WriteString("大小: " + v + "\n")
+	}
+	if v := fields["Description"]; v != "" {
+		sb.WriteString("\n" + v)
+	}
+
+	token := session.PutToken(name)
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	if version == "" {
+		rows = append(rows, menu.Row(menu.Data("⬇️ 安装", "wrt_pkg_install", token)))
+	} else {
+		rows = append(rows, menu.Row(menu.Data("🗑 卸载", "wrt_pkg_remove", token)))
+	}
+	rows = append(rows, menu.Row(menu.Data("🔙 返回", "wrt_pkg")))
+	menu.Inline(rows...)
+	return c.Edit(strings.TrimRight(sb.String(), "\n"), menu)
+}
+
+// HandlePackagesInstall handles "wrt_pkg_install|<token>".
+func HandlePackagesInstall(c tele.Context, name string) error {
+	return startPkgOp(c, "install", name)
+}
+
+// HandlePackagesRemove handles "wrt_pkg_remove|<token>".
+func HandlePackagesRemove(c tele.Context, name string) error {
+	return startPkgOp(c, "remove", name)
+}
+
+func startPkgOp(c tele.Context, action, name string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	if !pkgNameRe.MatchString(name) {
+		return c.Respond()
+	}
+	c.Respond()
+	return confirmPkgOp(c, pkgOp{Action: action, Packages: []string{name}})
+}
+
+// confirmPkgOp stores op and asks for the first confirmation.
+func confirmPkgOp(c tele.Context, op pkgOp) error {
+	session.GlobalStore.SetWithTTL(c.Sender().ID, pkgOpKey, op, session.WizardTTL)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("✅ 确认"+pkgActionLabels[op.Action], "wrt_pkg_run", "1")),
+		menu.Row(menu.Data("❌ 取消", "wrt_pkg")),
+	)
+	return c.Edit(fmt.Sprintf("⚠️ 确认%s以下 %d 个软件包吗？\n%s",
+		pkgActionLabels[op.Action], len(op.Packages), strings.Join(op.Packages, "\n")), menu)
+}
+
+// HandlePackagesRun handles "wrt_pkg_run|<step>". Operations touching core
+// packages need a second confirmation (step 2) before they run.
+func HandlePackagesRun(c tele.Context, step string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	op, ok := session.GetAs[pkgOp](session.GlobalStore, c.Sender().ID, pkgOpKey)
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "操作已过期，请重新选择"})
+	}
+
+	var risky []string
+	for _, p := range op.Packages {
+		if isRiskyPackage(p) {
+			risky = append(risky, p)
+		}
+	}
+	if len(risky) > 0 && step != "2" {
+		c.Respond()
+		menu := &tele.ReplyMarkup{}
+		menu.Inline(
+			menu.Row(menu.Data("⚠️ 我了解风险，继续", "wrt_pkg_run", "2")),
+			menu.Row(menu.Data("❌ 取消", "wrt_pkg")),
+		)
+		return c.Edit(fmt.Sprintf("🚨 以下为核心软件包，%s失败可能导致路由器无法启动或断网：\n%s\n\n请再次确认。",
+			pkgActionLabels[op.Action], strings.Join(risky, "\n")), menu)
+	}
+
+	session.GlobalStore.Delete(c.Sender().ID, pkgOpKey)
+	c.Respond(&tele.CallbackResponse{Text: "开始执行..."})
+
+	ctx := utils.Ctx(c)
+	back := &tele.ReplyMarkup{}
+	back.Inline(back.Row(back.Data("🔙 软件包管理", "wrt_pkg")))
+	title := fmt.Sprintf("opkg %s", op.Action)
+	_, err := liveOutput(c, c.Message(), title, pkgCommand(op), back)
+	audit.Log(c, "wrt", "opkg_"+op.Action, strings.Join(op.Packages, " "), nil, err)
+
+	return c.Send(pkgSummary(ctx, op, err), back)
+}
+
+// pkgSummary checks the outcome of op package by package.
+func pkgSummary(ctx context.Context, op pkgOp, err error) string {
+	var remaining map[string]string
+	if op.Action == "upgrade" {
+		remaining = make(map[string]string)
+		list, lerr := listUpgradable(ctx)
+		if lerr != nil {
+			return fmt.Sprintf("📋 %s完成，但无法读取结果: %v", pkgActionLabels[op.Action], lerr)
+		}
+		for _, p := range list {
+			remaining[p.Name] = p.New
+		}
+	}
+
+	ok := 0
+	var lines []string
+	for _, name := range op.Packages {
+		var line string
+		done := false
+		switch op.Action {
+		case "upgrade":
+			if _, left := remaining[name]; left {
+				line = "❌ " + name + " 仍可升级"
+			} else {
+				line, done = "✅ "+name+" "+pkgStatus(ctx, name), true
+			}
+		case "install":
+			if v := pkgStatus(ctx, name); v != "" {
+				line, done = "✅ "+name+" "+v, true
+			} else {
+				line = "❌ " + name + " 未安装"
+			}
+		case "remove":
+			if pkgStatus(ctx, name) == "" {
+				line, done = "✅ "+name+" 已卸载", true
+			} else {
+				line = "❌ " + name + " 仍已安装"
+			}
+		}
+		if done {
+			ok++
+		}
+		lines = append(lines, line)
+	}
+
+	head := fmt.Sprintf("📋 %s结果: %d/%d 成功", pkgActionLabels[op.Action], ok, len(op.Packages))
+	if err != nil {
+		head += fmt.Sprintf("\nopkg 返回错误: %v", err)
+	}
+	return head + "\n" + strings.Join(lines, "\n")
+}
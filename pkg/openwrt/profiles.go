@@ -0,0 +1,202 @@
+package openwrt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+// selectedRouterKey is the session key holding the router a user picked.
+const selectedRouterKey = "wrt_router"
+
+type routerCtxKey struct{}
+
+// WithRouter scopes router commands run with the returned context to the
+// named router profile.
+func WithRouter(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, routerCtxKey{}, name)
+}
+
+// routerFrom returns the profile ctx is scoped to, falling back to the main
+// router when none or an unknown one is set.
+func routerFrom(ctx context.Context) config.RouterProfile {
+	cfg := config.Get()
+	if name, ok := ctx.Value(routerCtxKey{}).(string); ok {
+		if r, ok := cfg.Router(name); ok {
+			return r
+		}
+	}
+	return cfg.MainRouter()
+}
+
+// RouterName returns the name of the router ctx is scoped to.
+func RouterName(ctx context.Context) string {
+	return routerFrom(ctx).Name
+}
+
+func multiRouter() bool {
+	return len(config.Get().Routers) > 1
+}
+
+// selectedRouter returns the router userID picked in the /wrt menu.
+func selectedRouter(userID int64) string {
+	if session.GlobalStore == nil {
+		return ""
+	}
+	name, _ := session.GetAs[string](session.GlobalStore, userID, selectedRouterKey)
+	return name
+}
+
+// RouterMiddleware scopes every handler's context to the router the sender
+// selected.
+func RouterMiddleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if user := c.Sender(); user != nil && multiRouter() {
+			if name := selectedRouter(user.ID); name != "" {
+				utils.WithCtx(c, WithRouter(utils.Ctx(c), name))
+			}
+		}
+		return next(c)
+	}
+}
+
+func selectRouter(c tele.Context, name string) bool {
+	if _, ok := config.Get().Router(name); !ok {
+		return false
+	}
+	session.GlobalStore.Set(c.Sender().ID, selectedRouterKey, name)
+	utils.WithCtx(c, WithRouter(utils.Ctx(c), name))
+	return true
+}
+
+// HandleRouterPick shows the configured router profiles.
+func HandleRouterPick(c tele.Context) error {
+	c.Respond()
+	current := RouterName(utils.Ctx(c))
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for i, r := range config.Get().Routers {
+		label := fmt.Sprintf("%s (%s)", r.Name, r.Host)
+		if i == 0 {
+			label += " · 主路由"
+		}
+		if r.Name == current {
+			label = "✅ " + label
+		}
+		rows = append(rows, menu.Row(menu.Data(label, "wrt_pick", r.Name)))
+	}
+	rows = append(rows, menu.Row(menu.Data("🔙 返回", "wrt_main")))
+	menu.Inline(rows...)
+	return c.EditOrSend("🔀 **选择要管理的路由器**", menu, tele.ModeMarkdown)
+}
+
+// HandleRouterSelect handles "wrt_pick|<name>".
+func HandleRouterSelect(c tele.Context, name string) error {
+	if !selectRouter(c, name) {
+		return c.Respond(&tele.CallbackResponse{Text: "路由器不存在", ShowAlert: true})
+	}
+	c.Respond(&tele.CallbackResponse{Text: "已切换到 " + name})
+	return HandleWrtMain(c)
+}
+
+// eachRouter runs f for every router profile concurrently and returns the
+// results in profile order.
+func eachRouter(ctx context.Context, f func(ctx context.Context, r config.RouterProfile) string) []string {
+	routers := config.Get().Routers
+	out := make([]string, len(routers))
+	var wg sync.WaitGroup
+	for i, r := range routers {
+		wg.Add(1)
+		go func(i int, r config.RouterProfile) {
+			defer wg.Done()
+			out[i] = f(WithRouter(ctx, r.Name), r)
+		}(i, r)
+	}
+	wg.Wait()
+	return out
+}
+
+// StatusSummary returns one status line per router profile.
+func StatusSummary(ctx context.Context) string {
+	lines := eachRouter(ctx, func(ctx context.Context, r config.RouterProfile) string {
+		st, err := GetSystemStats(ctx)
+		if err != nil {
+			return fmt.Sprintf("❌ %s: 无法连接 (%v)", r.Name, err)
+		}
+		return fmt.Sprintf("🖥 %s: 负载 %s | 内存 %d/%dMB | 温度 %s | 运行 %s",
+			r.Name, st.Load, st.MemUsed, st.MemTotal, st.TempString(), st.Uptime)
+	})
+	return strings.Join(lines, "\n")
+}
+
+// HandleStatusAll shows the status of every router.
+func HandleStatusAll(c tele.Context) error {
+	ctx := utils.Ctx(c)
+	c.Respond(&tele.CallbackResponse{Text: "正在查询全部路由器..."})
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_main")))
+	return c.EditOrSend("📟 全部路由器状态\n-------------------\n"+StatusSummary(ctx), menu)
+}
+
+// wifiStationsCmd lists the MAC addresses associated with any wireless
+// interface, one per line.
+const wifiStationsCmd = "for i in $(iw dev 2>/dev/null | awk '/Interface/{print $2}'); do iw dev $i station dump | awk '/^Station/{print $2}'; done"
+
+// HandleDevicesAll lists the Wi-Fi clients of every router, named after
+// the main router's DHCP leases.
+func HandleDevicesAll(c tele.Context) error {
+	ctx := utils.Ctx(c)
+	c.Respond(&tele.CallbackResponse{Text: "正在查询全部路由器..."})
+
+	type lease struct{ ip, name string }
+	leases := make(map[string]lease)
+	mainCtx := WithRouter(ctx, config.Get().MainRouter().Name)
+	if res, err := ReadFile(mainCtx, "/tmp/dhcp.leases"); err == nil {
+		for _, line := range strings.Split(res, "\n") {
+			parts := strings.Fields(line)
+			if len(parts) >= 4 {
+				leases[strings.ToLower(parts[1])] = lease{ip: parts[2], name: parts[3]}
+			}
+		}
+	}
+
+	sections := eachRouter(ctx, func(ctx context.Context, r config.RouterProfile) string {
+		res, err := Exec(ctx, wifiStationsCmd)
+		if err != nil {
+			return fmt.Sprintf("📡 %s: 无法连接 (%v)", r.Name, err)
+		}
+		var macs []string
+		for _, m := range strings.Fields(res) {
+			macs = append(macs, strings.ToLower(m))
+		}
+		sort.Strings(macs)
+
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("📡 %s: %d 台无线设备", r.Name, len(macs)))
+		for _, m := range macs {
+			if l, ok := leases[m]; ok {
+				sb.WriteString(fmt.Sprintf("\n• %s (%s) [%s]", l.name, l.ip, m))
+			} else {
+				sb.WriteString(fmt.Sprintf("\n• [%s]", m))
+			}
+		}
+		return sb.String()
+	})
+
+	var msg *tele.Message
+	if c.Callback() != nil {
+		msg = c.Message()
+	}
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_main")))
+	return utils.SendLongMessage(c, msg, "📱 全部路由器无线设备\n-------------------\n"+strings.Join(sections, "\n\n"), menu)
+}
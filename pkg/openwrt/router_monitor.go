@@ -0,0 +1,200 @@
+package openwrt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/alert"
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/lifecycle"
+	"github.com/yingxiaomo/homeops/pkg/notify"
+	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+var (
+	alertEngines = make(map[string]*alert.Engine)
+	alertMu      sync.Mutex
+)
+
+// alertEngine returns the engine tracking the named router, creating it
+// with the current rules on first use.
+func alertEngine(router string) *alert.Engine {
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	e, ok := alertEngines[router]
+	if !ok {
+		e = alert.NewEngine(config.Get().AlertRules)
+		alertEngines[router] = e
+	}
+	return e
+}
+
+var metricNames = map[string]struct{ label, unit string }{
+	"temp":          {"核心温度", "°C"},
+	"mem":           {"内存占用", "%"},
+	"load":          {"系统负载", ""},
+	"load_per_core": {"每核负载", ""},
+}
+
+var snoozeChoices = []time.Duration{time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+// StartRouterMonitor samples load, memory and temperature every
+// monitors.router_interval and raises alerts for the configured rules.
+func StartRouterMonitor() {
+	lifecycle.Go(func(ctx context.Context) {
+		// Check once a minute for a changed interval while disabled.
+		interval := config.Get().RouterMonitorInterval
+		tick := interval
+		if tick == 0 {
+			tick = time.Minute
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			cfg := config.Get()
+			if cfg.RouterMonitorInterval != 0 {
+				for _, r := range cfg.Routers {
+					e := alertEngine(r.Name)
+					e.SetRules(cfg.AlertRules)
+					checkRouterHealth(WithRouter(ctx, r.Name), e)
+				}
+			}
+			if d := cfg.RouterMonitorInterval; d != interval {
+				interval = d
+				if d == 0 {
+					d = time.Minute
+				}
+				ticker.Reset(d)
+			}
+		}
+	})
+	log.Println("Router health monitor registered.")
+}
+
+func checkRouterHealth(ctx context.Context, alerts *alert.Engine) {
+	router := RouterName(ctx)
+	st, err := GetSystemStats(ctx)
+	if err != nil {
+		log.Printf("Router %s health sample failed: %v", router, err)
+		return
+	}
+
+	sample := map[string]float64{"load": st.Load1}
+	if st.Cores > 0 {
+		sample["load_per_core"] = st.Load1 / float64(st.Cores)
+	}
+	if st.MemTotal > 0 {
+		sample["mem"] = float64(st.MemUsed) * 100 / float64(st.MemTotal)
+	}
+	if st.TempC > 0 {
+		sample["temp"] = st.TempC
+	}
+
+	for _, t := range alerts.Evaluate(sample, time.Now()) {
+		if t.Snoozed {
+			log.Printf("Alert %s on %s suppressed (snoozed)", t.Rule.Name, router)
+			continue
+		}
+		notify.Publish(ctx, alertEvent(router, t))
+	}
+}
+
+func alertEvent(router string, t alert.Transition) notify.Event {
+	r := t.Rule
+	m := metricNames[r.Metric]
+	op := r.Op
+	if op == "" {
+		op = ">"
+	}
+	where := ""
+	if multiRouter() {
+		where = " · " + router
+	}
+
+	if t.Kind == alert.Recovered {
+		return notify.Event{
+			Category: "health",
+			Severity: notify.Info,
+			Title:    "✅ 已恢复: " + m.label + where,
+			Text: fmt.Sprintf("当前 %s%s (恢复阈值 %s%s)\n告警持续: %s",
+				formatValue(t.Value), m.unit, formatValue(r.RecoverAt()), m.unit, time.Since(t.Since).Round(time.Second)),
+		}
+	}
+
+	sev := notify.Warning
+	switch r.Severity {
+	case "critical":
+		sev = notify.Critical
+	case "info":
+		sev = notify.Info
+	}
+
+	menu := &tele.ReplyMarkup{}
+	var btns []tele.Btn
+	for _, d := range snoozeChoices {
+		btns = append(btns, menu.Data(fmt.Sprintf("🔕 %s", formatSnooze(d)), "alert_snooze", session.PutToken(router+"|"+r.Name+"|"+d.String())))
+	}
+	menu.Inline(menu.Row(btns...))
+
+	return notify.Event{
+		Category: "health",
+		Severity: sev,
+		Title:    "⚠️ 路由器告警: " + m.label + where,
+		Text: fmt.Sprintf("当前 %s%s %s 阈值 %s%s\n已持续 %s (自 %s)",
+			formatValue(t.Value), m.unit, op, formatValue(r.Threshold), m.unit,
+			time.Since(t.Since).Round(time.Second), t.Since.Format("15:04:05")),
+		Markup: menu,
+	}
+}
+
+func formatValue(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", v), "0"), ".")
+}
+
+func formatSnooze(d time.Duration) string {
+	return fmt.Sprintf("%d 小时", int(d.Hours()))
+}
+
+// HandleAlertSnooze handles "alert_snooze|<token>", where the token holds
+// "<router>|<rule>|<duration>".
+func HandleAlertSnooze(c tele.Context, payload string) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+	parts := strings.Split(payload, "|")
+	if len(parts) != 3 {
+		return c.Respond()
+	}
+	router, name := parts[0], parts[1]
+	d, err := time.ParseDuration(parts[2])
+	if err != nil {
+		return c.Respond()
+	}
+	if _, ok := config.Get().Router(router); !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "路由器已不存在"})
+	}
+	alerts := alertEngine(router)
+	if _, ok := alerts.Rule(name); !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "规则已不存在"})
+	}
+
+	until := time.Now().Add(d)
+	alerts.Snooze(name, until)
+	audit.Log(c, "wrt", "snooze_alert", router+"/"+name, map[string]string{"duration": d.String()}, nil)
+
+	c.Respond(&tele.CallbackResponse{Text: "已静音 " + formatSnooze(d)})
+	return c.Edit(fmt.Sprintf("%s\n\n🔕 已静音至 %s", c.Message().Text, until.Format("01-02 15:04")))
+}
@@ -3,21 +3,30 @@ package openwrt
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"strings"
 
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/uci"
+	"github.com/yingxiaomo/homeops/pkg/utils"
 	tele "gopkg.in/telebot.v3"
 )
 
+const scriptDir = "/root/smart"
+
+var scriptNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
 func HandleScriptsList(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "读取脚本列表..."})
 
-	scriptDir := "/root/smart"
-	res, _ := SSHExec(fmt.Sprintf("ls %s/*.sh 2>/dev/null", scriptDir))
+	res, _ := Exec(ctx, fmt.Sprintf("ls %s/*.sh 2>/dev/null", scriptDir))
 
 	menu := &tele.ReplyMarkup{}
 	if strings.TrimSpace(res) == "" {
 		menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_main")))
-		return c.Edit(fmt.Sprintf("目录 %s 下没有找到脚本。", scriptDir), menu)
+		return c.EditOrSend(fmt.Sprintf("目录 %s 下没有找到脚本。", scriptDir), menu)
 	}
 
 	var rows []tele.Row
@@ -27,35 +36,64 @@ func HandleScriptsList(c tele.Context) error {
 			continue
 		}
 		name := filepath.Base(s)
-		rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("▶️ %s", name), "wrt_run_script", s)))
+		if !scriptNameRe.MatchString(name) {
+			continue
+		}
+		rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("▶️ %s", name), "wrt_run_script", session.PutToken(name))))
 	}
 	rows = append(rows, menu.Row(menu.Data("🔙 返回", "wrt_main")))
 	menu.Inline(rows...)
 
-	return c.Edit(fmt.Sprintf("📂 脚本列表 (%s):\n点击即可立即运行。", scriptDir), menu)
+	return c.EditOrSend(fmt.Sprintf("📂 脚本列表 (%s):\n点击即可立即运行。", scriptDir), menu)
 }
 
-func HandleRunScript(c tele.Context) error {
-	parts := strings.SplitN(c.Callback().Data, "|", 2)
-	if len(parts) < 2 {
-		return c.Respond(&tele.CallbackResponse{Text: "Error: Invalid script path"})
+// HandleRunScript handles "wrt_run_script|<token>", whose token holds the
+// name of a script in scriptDir.
+func HandleRunScript(c tele.Context, name string) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+	path, err := scriptPath(name)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: err.Error(), ShowAlert: true})
 	}
-	scriptPath := parts[1]
 
-	c.Respond(&tele.CallbackResponse{Text: "正在运行脚本...", ShowAlert: true})
-	c.Edit(fmt.Sprintf("⏳ 正在执行: %s\n请稍候...", scriptPath))
+	c.Respond(&tele.CallbackResponse{Text: "正在运行脚本..."})
+	return runScript(c, path)
+}
 
-	res, _ := SSHExec(scriptPath)
-	if len(res) > 3000 {
-		res = res[:3000] + "\n... (输出过长已截断)"
+// HandleScriptCommand handles /script [name]; without a name it lists the
+// scripts in scriptDir.
+func HandleScriptCommand(c tele.Context) error {
+	ctx := utils.Ctx(c)
+	name := strings.TrimSpace(c.Message().Payload)
+	if name == "" {
+		return HandleScriptsList(c)
 	}
-
-	resultText := fmt.Sprintf("✅ 执行完成: %s\n\n📝 输出:\n%s", scriptPath, res)
-	if res == "" {
-		resultText = fmt.Sprintf("✅ 执行完成 (无输出): %s", scriptPath)
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+	path, err := scriptPath(name)
+	if err != nil {
+		return c.Send("❌ 无效的脚本名称。")
+	}
+	if _, err := Exec(ctx, "test -f "+uci.Quote(path)); err != nil {
+		return c.Send(fmt.Sprintf("❌ 未找到脚本: %s", path))
 	}
 
+	return runScript(c, path)
+}
+
+// runScript streams the script's output into the callback's message, or
+// a new one for commands.
+func runScript(c tele.Context, scriptPath string) error {
+	var msg *tele.Message
+	if c.Callback() != nil {
+		msg = c.Message()
+	}
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("🔙 返回脚本列表", "wrt_scripts_list")))
-	return c.Edit(resultText, menu)
+	_, err := liveOutput(c, msg, scriptPath, scriptPath, menu)
+	audit.Log(c, "wrt", "run_script", scriptPath, nil, err)
+	return nil
 }
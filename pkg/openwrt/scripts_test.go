@@ -0,0 +1,53 @@
+package openwrt
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/session"
+)
+
+func TestHandleScriptsList(t *testing.T) {
+	f := &FakeExecutor{Outputs: map[string]string{
+		"ls /root/smart/*.sh 2>/dev/null": "/root/smart/wan.sh\n/root/smart/a;reboot.sh\n",
+	}}
+	c, calls := newTestContext(t, f)
+
+	if err := HandleScriptsList(c); err != nil {
+		t.Fatal(err)
+	}
+	var payloads []string
+	re := regexp.MustCompile(`wrt_run_script\|([A-Za-z0-9_-]+)`)
+	for _, call := range calls() {
+		for _, m := range re.FindAllStringSubmatch(fmt.Sprint(call.Params["reply_markup"]), -1) {
+			p, ok := session.ResolveToken(m[1])
+			if !ok {
+				t.Errorf("token %s does not resolve", m[1])
+			}
+			payloads = append(payloads, p)
+		}
+	}
+	if len(payloads) != 1 || payloads[0] != "wan.sh" {
+		t.Errorf("script buttons = %q, want only wan.sh", payloads)
+	}
+}
+
+func TestHandleRunScriptRejectsBadName(t *testing.T) {
+	f := &FakeExecutor{}
+	c, calls := newTestContext(t, f)
+	config.Set(&config.Config{AdminIDs: []int64{1}, Routers: []config.RouterProfile{{Name: "main"}}})
+
+	for _, name := range []string{"x;reboot", "/bin/sh", "$(id)", ""} {
+		if err := HandleRunScript(c, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := f.Calls(); len(got) != 0 {
+		t.Errorf("calls = %q, want none", got)
+	}
+	if n := len(calls()); n != 4 {
+		t.Errorf("%d API calls, want one alert per name", n)
+	}
+}
@@ -1,96 +1,328 @@
 package openwrt
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/uci"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
-var (
-	sshClient *ssh.Client
-	sshMutex  sync.Mutex
-)
+// SSHExecutor runs commands over one cached SSH connection and ubus calls
+// through the ubus command line tool.
+type SSHExecutor struct {
+	Router config.RouterProfile
+
+	mu     sync.Mutex
+	client *ssh.Client
+	// jump is the bastion connection client is tunnelled through, if any.
+	jump *ssh.Client
+}
 
-func getClient(forceReconnect bool) (*ssh.Client, error) {
-	sshMutex.Lock()
-	defer sshMutex.Unlock()
+// getClient returns the cached connection, dialing a new one when there is
+// none or forceReconnect is set.
+func (e *SSHExecutor) getClient(forceReconnect bool) (*ssh.Client, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-	if sshClient != nil {
+	if e.client != nil {
 		if !forceReconnect {
-			return sshClient, nil
+			return e.client, nil
 		}
-		sshClient.Close()
-		sshClient = nil
+		e.closeLocked()
 	}
 
-	authMethods := []ssh.AuthMethod{}
+	r := e.Router
+	auth, done, err := authMethods(r)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 
-	if config.AppConfig.OpenWrtKeyFile != "" {
-		key, err := os.ReadFile(config.AppConfig.OpenWrtKeyFile)
+	conf := &ssh.ClientConfig{
+		User:            r.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback(r.Name),
+		Timeout:         5 * time.Second,
+	}
+
+	addr := net.JoinHostPort(r.Host, strconv.Itoa(r.Port))
+	conf.HostKeyAlgorithms = knownHostAlgorithms(addr)
+	var client, jump *ssh.Client
+	if r.Jump == "" {
+		client, err = ssh.Dial("tcp", addr, conf)
+	} else {
+		client, jump, err = dialJump(r, addr, conf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e.client, e.jump = client, jump
+	go e.keepAlive(client, r.KeepAlive)
+	return client, nil
+}
+
+// dialJump connects to addr through the bastion named by r.Jump, which is
+// authenticated with the same credentials as the router.
+func dialJump(r config.RouterProfile, addr string, conf *ssh.ClientConfig) (*ssh.Client, *ssh.Client, error) {
+	user, jumpAddr, err := r.JumpHost()
+	if err != nil {
+		return nil, nil, err
+	}
+	jumpConf := *conf
+	jumpConf.User = user
+	jumpConf.HostKeyCallback = hostKeyCallback(r.Name + ":jump")
+	jumpConf.HostKeyAlgorithms = knownHostAlgorithms(jumpAddr)
+	jump, err := ssh.Dial("tcp", jumpAddr, &jumpConf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("jump host %s: %v", jumpAddr, err)
+	}
+
+	conn, err := jump.Dial("tcp", addr)
+	if err != nil {
+		jump.Close()
+		return nil, nil, fmt.Errorf("jump host %s: %v", jumpAddr, err)
+	}
+	cc, chans, reqs, err := ssh.NewClientConn(conn, addr, conf)
+	if err != nil {
+		conn.Close()
+		jump.Close()
+		return nil, nil, err
+	}
+	return ssh.NewClient(cc, chans, reqs), jump, nil
+}
+
+// authMethods returns the configured key, the ssh-agent keys (when
+// SSH_AUTH_SOCK is set) and the password, in that order. done releases the
+// agent connection once the handshake is over.
+func authMethods(r config.RouterProfile) (methods []ssh.AuthMethod, done func(), err error) {
+	done = func() {}
+	var signers []ssh.Signer
+
+	if r.KeyFile != "" {
+		signer, err := loadKey(r)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read SSH key: %v", err)
+			if r.Password == "" && os.Getenv("SSH_AUTH_SOCK") == "" {
+				return nil, nil, err
+			}
+			log.Printf("SSH key for %s unusable, trying other methods: %v", r.Name, err)
+		} else {
+			signers = append(signers, signer)
 		}
+	}
 
-		signer, err := ssh.ParsePrivateKey(key)
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse SSH key: %v", err)
+			log.Printf("ssh-agent unavailable: %v", err)
+		} else {
+			done = func() { conn.Close() }
+			if keys, err := agent.NewClient(conn).Signers(); err != nil {
+				log.Printf("ssh-agent unavailable: %v", err)
+			} else {
+				signers = append(signers, keys...)
+			}
 		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
-	} else {
-		authMethods = append(authMethods, ssh.Password(config.AppConfig.OpenWrtPass))
 	}
 
-	conf := &ssh.ClientConfig{
-		User:            config.AppConfig.OpenWrtUser,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * time.Second,
+	// The client tries each method type once, so all keys go in one method.
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
 	}
+	if r.Password != "" || len(methods) == 0 {
+		methods = append(methods, ssh.Password(r.Password))
+	}
+	return methods, done, nil
+}
 
-	addr := fmt.Sprintf("%s:%d", config.AppConfig.OpenWrtHost, config.AppConfig.OpenWrtPort)
-	client, err := ssh.Dial("tcp", addr, conf)
+// loadKey parses r.KeyFile, decrypting it with the configured passphrase.
+func loadKey(r config.RouterProfile) (ssh.Signer, error) {
+	key, err := os.ReadFile(r.KeyFile)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read SSH key: %v", err)
 	}
 
-	sshClient = client
-	return client, nil
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH key: %v", err)
+		}
+		return signer, nil
+	}
+
+	passphrase := r.KeyPassphrase
+	if r.KeyPassphraseFile != "" {
+		data, err := os.ReadFile(r.KeyPassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key passphrase: %v", err)
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("SSH key %s is encrypted but no passphrase is configured", r.KeyFile)
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SSH key: %v", err)
+	}
+	return signer, nil
 }
 
-func SSHExec(cmd string) (string, error) {
-	client, err := getClient(false)
+// keepAlive pings client every interval and drops it from the cache when a
+// ping fails or goes unanswered, so the next command reconnects instead of
+// hanging on a dead connection.
+func (e *SSHExecutor) keepAlive(client *ssh.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		errc := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			errc <- err
+		}()
+
+		var err error
+		select {
+		case err = <-errc:
+		case <-time.After(interval):
+			err = fmt.Errorf("no reply within %s", interval)
+		}
+		if err == nil {
+			continue
+		}
+
+		e.mu.Lock()
+		if e.client == client {
+			log.Printf("SSH connection to %s lost: %v", e.Router.Name, err)
+			e.closeLocked()
+		}
+		e.mu.Unlock()
+		return
+	}
+}
+
+// Close closes the cached connection.
+func (e *SSHExecutor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closeLocked()
+	return nil
+}
+
+func (e *SSHExecutor) closeLocked() {
+	if e.client != nil {
+		e.client.Close()
+		e.client = nil
+	}
+	if e.jump != nil {
+		e.jump.Close()
+		e.jump = nil
+	}
+}
+
+func (e *SSHExecutor) Exec(ctx context.Context, cmd string) (string, error) {
+	var out bytes.Buffer
+	err := e.Stream(ctx, cmd, &out)
+	return out.String(), err
+}
+
+// Stream runs cmd, writing stdout and stderr to w as they arrive.
+// Cancelling ctx closes the session.
+func (e *SSHExecutor) Stream(ctx context.Context, cmd string, w io.Writer) error {
+	client, err := e.getClient(false)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	session, err := client.NewSession()
 	if err != nil {
-		client, err = getClient(true)
+		client, err = e.getClient(true)
 		if err != nil {
-			return "", fmt.Errorf("failed to reconnect: %v", err)
+			return fmt.Errorf("failed to reconnect: %v", err)
 		}
 		session, err = client.NewSession()
 		if err != nil {
-			return "", fmt.Errorf("failed to create session after reconnect: %v", err)
+			return fmt.Errorf("failed to create session after reconnect: %v", err)
 		}
 	}
 	defer session.Close()
 
-	output, err := session.CombinedOutput(cmd)
+	out := &syncWriter{w: w}
+	session.Stdout = out
+	session.Stderr = out
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Signal(ssh.SIGKILL)
+			session.Close()
+		case <-done:
+		}
+	}()
+
+	err = session.Run(cmd)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// syncWriter serialises the concurrent stdout and stderr copies of a
+// session.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+func (e *SSHExecutor) Call(ctx context.Context, object, method string, args, out any) error {
+	cmd := fmt.Sprintf("ubus call %s %s", object, method)
+	if args != nil {
+		b, err := json.Marshal(args)
+		if err != nil {
+			return err
+		}
+		cmd += " " + uci.Quote(string(b))
+	}
+	res, err := e.Exec(ctx, cmd)
 	if err != nil {
-		return string(output), err
+		return fmt.Errorf("ubus %s %s: %v %s", object, method, err, strings.TrimSpace(res))
 	}
+	if out == nil || strings.TrimSpace(res) == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(res), out)
+}
 
-	return string(output), nil
+func (e *SSHExecutor) ReadFile(ctx context.Context, path string) (string, error) {
+	return e.Exec(ctx, "cat "+uci.Quote(path))
 }
 
-func GetSystemStatus() string {
+func GetSystemStatus(ctx context.Context) string {
 	cmd := "uptime && echo '---' && free -h"
-	out, err := SSHExec(cmd)
+	out, err := Exec(ctx, cmd)
 	if err != nil {
 		return fmt.Sprintf("Error: %v", err)
 	}
@@ -0,0 +1,188 @@
+package openwrt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// streamEditInterval throttles message edits to stay clear of
+	// Telegram's rate limits.
+	streamEditInterval = 2 * time.Second
+	// streamMaxShown is how much output fits in the message; longer output
+	// is attached as a document.
+	streamMaxShown = 3000
+)
+
+var (
+	runningStreams = make(map[string]context.CancelFunc)
+	streamsMu      sync.Mutex
+)
+
+// streamBuffer collects output and remembers whether it changed since the
+// last edit.
+type streamBuffer struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	changed bool
+}
+
+func (b *streamBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.changed = true
+	return b.buf.Write(p)
+}
+
+// snapshot returns the output and whether it changed since the last call.
+func (b *streamBuffer) snapshot() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	changed := b.changed
+	b.changed = false
+	return b.buf.String(), changed
+}
+
+// liveOutput runs cmd and shows its output in msg (or a new message when
+// msg is nil) while it runs, with a button to cancel it. Output that does
+// not fit is sent as a .txt document at the end. back is shown once the
+// command has finished.
+func liveOutput(c tele.Context, msg *tele.Message, title, cmd string, back *tele.ReplyMarkup) (string, error) {
+	ctx, cancel := context.WithCancel(utils.Ctx(c))
+	defer cancel()
+
+	id := utils.RandomString(8)
+	streamsMu.Lock()
+	runningStreams[id] = cancel
+	streamsMu.Unlock()
+	defer func() {
+		streamsMu.Lock()
+		delete(runningStreams, id)
+		streamsMu.Unlock()
+	}()
+
+	running := &tele.ReplyMarkup{}
+	running.Inline(running.Row(running.Data("⏹ 取消", "wrt_stream_cancel", id)))
+
+	start := time.Now()
+	render := func(head, out string) string {
+		shown := out
+		if len(shown) > streamMaxShown {
+			shown = "...\n" + tailString(shown, streamMaxShown)
+		}
+		shown = strings.ReplaceAll(strings.TrimRight(shown, "\n"), "`", "'")
+		if shown == "" {
+			shown = "(暂无输出)"
+		}
+		return fmt.Sprintf("%s\n```\n%s\n```", head, shown)
+	}
+
+	head := fmt.Sprintf("⏳ **%s** 运行中...", utils.EscapeMarkdown(title))
+	var err error
+	if msg != nil {
+		msg, err = c.Bot().Edit(msg, render(head, ""), running, tele.ModeMarkdown)
+	} else {
+		msg, err = c.Bot().Send(c.Recipient(), render(head, ""), running, tele.ModeMarkdown)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	out := &streamBuffer{}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(streamEditInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			text, changed := out.snapshot()
+			if !changed {
+				continue
+			}
+			head := fmt.Sprintf("⏳ **%s** 运行中 (%s)...", utils.EscapeMarkdown(title), time.Since(start).Round(time.Second))
+			c.Bot().Edit(msg, render(head, text), running, tele.ModeMarkdown)
+		}
+	}()
+
+	err = Stream(ctx, cmd, out)
+	close(done)
+	wg.Wait()
+
+	text, _ := out.snapshot()
+	elapsed := time.Since(start).Round(time.Second)
+	switch {
+	case errors.Is(err, context.Canceled):
+		head = fmt.Sprintf("⏹ **%s** 已取消 (%s)", utils.EscapeMarkdown(title), elapsed)
+	case err != nil:
+		head = fmt.Sprintf("❌ **%s** 失败 (%s): %s", utils.EscapeMarkdown(title), elapsed, utils.EscapeMarkdown(err.Error()))
+	default:
+		head = fmt.Sprintf("✅ **%s** 完成 (%s)", utils.EscapeMarkdown(title), elapsed)
+	}
+	if len(text) > streamMaxShown {
+		head += "\n完整输出见附件。"
+	}
+	if text == "" && err == nil {
+		text = "(无输出)"
+	}
+	c.Bot().Edit(msg, render(head, text), back, tele.ModeMarkdown)
+
+	if len(text) > streamMaxShown {
+		doc := &tele.Document{
+			File:     tele.FromReader(strings.NewReader(text)),
+			FileName: outputFileName(title, start),
+			Caption:  title,
+		}
+		if _, err := c.Bot().Send(c.Recipient(), doc); err != nil {
+			return text, err
+		}
+	}
+	return text, err
+}
+
+// HandleStreamCancel handles "wrt_stream_cancel|<id>".
+func HandleStreamCancel(c tele.Context, id string) error {
+	streamsMu.Lock()
+	cancel, ok := runningStreams[id]
+	streamsMu.Unlock()
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: "命令已结束"})
+	}
+	cancel()
+	return c.Respond(&tele.CallbackResponse{Text: "正在取消..."})
+}
+
+// tailString returns the last n bytes of s, starting at a rune boundary.
+func tailString(s string, n int) string {
+	i := len(s) - n
+	for i < len(s) && !utf8.RuneStart(s[i]) {
+		i++
+	}
+	return s[i:]
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func outputFileName(title string, t time.Time) string {
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(title, "_"), "_")
+	if name == "" {
+		name = "output"
+	}
+	return fmt.Sprintf("%s-%s.txt", name, t.Format("20060102-150405"))
+}
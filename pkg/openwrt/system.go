@@ -1,79 +1,185 @@
 package openwrt
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/utils"
 	tele "gopkg.in/telebot.v3"
 )
 
-func HandleStatus(c tele.Context) error {
-	c.Respond(&tele.CallbackResponse{Text: "正在通过 SSH 获取数据..."})
-	cmd := "uptime && free -m && [ -f /sys/class/thermal/thermal_zone0/temp ] && cat /sys/class/thermal/thermal_zone0/temp || echo 0"
-	res, _ := SSHExec(cmd)
-	if res == "" {
-		menu := &tele.ReplyMarkup{}
-		menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_main")))
-		return c.Edit("无法通过 SSH 连接到路由器，请检查配置。", menu)
+// SystemStats is a snapshot of the router's load, memory and temperature.
+type SystemStats struct {
+	Uptime   string
+	Load     string
+	Load1    float64
+	Cores    int
+	MemUsed  int
+	MemTotal int
+	// TempC is the SoC temperature; zero when the router has no sensor.
+	TempC float64
+}
+
+// sensorsCmd prints the number of CPU cores and the SoC temperature in
+// millidegrees (0 without a sensor), one per line.
+const sensorsCmd = "grep -c ^processor /proc/cpuinfo; [ -f /sys/class/thermal/thermal_zone0/temp ] && cat /sys/class/thermal/thermal_zone0/temp || echo 0"
+
+// GetSystemStats reads uptime, load and memory from ubus "system info",
+// falling back to parsing uptime and free, plus cores and temperature.
+func GetSystemStats(ctx context.Context) (*SystemStats, error) {
+	var info struct {
+		Uptime int64    `json:"uptime"`
+		Load   []uint64 `json:"load"`
+		Memory struct {
+			Total     int64 `json:"total"`
+			Free      int64 `json:"free"`
+			Buffered  int64 `json:"buffered"`
+			Cached    int64 `json:"cached"`
+			Available int64 `json:"available"`
+		} `json:"memory"`
+	}
+	if err := Ubus(ctx, "system", "info", nil, &info); err != nil || len(info.Load) < 3 {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return systemStatsFromShell(ctx)
+	}
+
+	st := &SystemStats{Uptime: formatUptime(time.Duration(info.Uptime) * time.Second)}
+	// ubus reports load averages in fixed point with 16 fractional bits.
+	var loads []string
+	for _, l := range info.Load[:3] {
+		loads = append(loads, fmt.Sprintf("%.2f", float64(l)/65536))
+	}
+	st.Load = strings.Join(loads, ", ")
+	st.Load1 = float64(info.Load[0]) / 65536
+
+	m := info.Memory
+	free := m.Available
+	if free == 0 {
+		free = m.Free + m.Buffered + m.Cached
 	}
+	st.MemTotal = int(m.Total >> 20)
+	st.MemUsed = int((m.Total - free) >> 20)
 
-	lines := strings.Split(res, "\n")
+	if res, err := Exec(ctx, sensorsCmd); err == nil {
+		lines := strings.Fields(res)
+		if len(lines) == 2 {
+			st.Cores, _ = strconv.Atoi(lines[0])
+			if val, _ := strconv.Atoi(lines[1]); val > 0 {
+				st.TempC = float64(val) / 1000.0
+			}
+		}
+	}
+	return st, nil
+}
+
+func systemStatsFromShell(ctx context.Context) (*SystemStats, error) {
+	res, err := Exec(ctx, "uptime && free -m && "+sensorsCmd)
+	if strings.TrimSpace(res) == "" {
+		if err == nil {
+			err = fmt.Errorf("empty output")
+		}
+		return nil, err
+	}
+
+	st := &SystemStats{}
+	lines := strings.Split(strings.TrimSpace(res), "\n")
 	uptimeInfo := lines[0]
-	memTotal := "0"
-	memUsed := "0"
 	for _, l := range lines {
 		if strings.Contains(l, "Mem:") {
 			parts := strings.Fields(l)
 			if len(parts) >= 3 {
-				memTotal = parts[1]
-				memUsed = parts[2]
+				st.MemTotal, _ = strconv.Atoi(parts[1])
+				st.MemUsed, _ = strconv.Atoi(parts[2])
 			}
 			break
 		}
 	}
-	tempRaw := "0"
-	if len(lines) > 0 {
-		tempRaw = lines[len(lines)-1]
+	if val, err := strconv.Atoi(strings.TrimSpace(lines[len(lines)-1])); err == nil && val > 0 {
+		st.TempC = float64(val) / 1000.0
 	}
-	temp := "N/A"
-	if t, err := fmt.Sscanf(tempRaw, "%d", new(int)); err == nil && t > 0 {
-		var val int
-		fmt.Sscanf(tempRaw, "%d", &val)
-		temp = fmt.Sprintf("%.1f°C", float64(val)/1000.0)
+	if len(lines) >= 2 {
+		st.Cores, _ = strconv.Atoi(strings.TrimSpace(lines[len(lines)-2]))
 	}
 
 	upSplit := strings.Split(uptimeInfo, "up")
-	uptime := ""
 	if len(upSplit) > 1 {
 		commaSplit := strings.Split(upSplit[1], ",")
-		uptime = strings.TrimSpace(commaSplit[0])
+		st.Uptime = strings.TrimSpace(commaSplit[0])
 	}
 
 	loadSplit := strings.Split(uptimeInfo, "load average:")
-	load := ""
 	if len(loadSplit) > 1 {
-		load = strings.TrimSpace(loadSplit[1])
+		st.Load = strings.TrimSpace(loadSplit[1])
+		first, _, _ := strings.Cut(st.Load, ",")
+		st.Load1, _ = strconv.ParseFloat(strings.TrimSpace(first), 64)
 	}
+	return st, nil
+}
 
-	txt := fmt.Sprintf("📟 **OpenWrt 状态**\n-------------------\n⏱ 运行时间: %s\n📈 系统负载: %s\n🧠 内存占用: %sMB / %sMB\n🌡 核心温度: %s",
-		uptime, load, memUsed, memTotal, temp)
+func formatUptime(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	hm := fmt.Sprintf("%d:%02d", int(d.Hours())%24, int(d.Minutes())%60)
+	if days > 0 {
+		return fmt.Sprintf("%d 天 %s", days, hm)
+	}
+	return hm
+}
+
+func (st *SystemStats) TempString() string {
+	if st.TempC == 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%.1f°C", st.TempC)
+}
+
+func HandleStatus(c tele.Context) error {
+	ctx := utils.Ctx(c)
+	c.Respond(&tele.CallbackResponse{Text: "正在获取数据..."})
+	st, err := GetSystemStats(ctx)
+	if err != nil {
+		menu := &tele.ReplyMarkup{}
+		menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_main")))
+		return c.EditOrSend("无法连接到路由器，请检查配置。", menu)
+	}
+
+	title := "📟 **OpenWrt 状态**"
+	if multiRouter() {
+		title += fmt.Sprintf(" · `%s`", RouterName(ctx))
+	}
+	txt := fmt.Sprintf("%s\n-------------------\n⏱ 运行时间: %s\n📈 系统负载: %s\n🧠 内存占用: %dMB / %dMB\n🌡 核心温度: %s",
+		title, st.Uptime, st.Load, st.MemUsed, st.MemTotal, st.TempString())
+	if board, err := GetBoardInfo(ctx); err == nil {
+		txt += firmwareLines(board)
+	}
 
 	menu := &tele.ReplyMarkup{}
-	menu.Inline(
+	rows := []tele.Row{
 		menu.Row(menu.Data("🛠 服务管理", "wrt_services_menu"), menu.Data("🧹 清理内存", "wrt_drop_caches")),
-		menu.Row(menu.Data("🔙 返回", "wrt_main")),
-	)
-	return c.Edit(txt, menu, tele.ModeMarkdown)
+		menu.Row(menu.Data("⬆️ 固件升级", "wrt_sysup", RouterName(ctx))),
+	}
+	if multiRouter() {
+		rows = append(rows, menu.Row(menu.Data("🗂 全部路由器", "wrt_status_all")))
+	}
+	rows = append(rows, menu.Row(menu.Data("🔙 返回", "wrt_main")))
+	menu.Inline(rows...)
+	return c.EditOrSend(txt, menu, tele.ModeMarkdown)
 }
 
 func HandleShowCurrentIPs(c tele.Context) error {
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "正在查询 IP..."})
-	v4, v6 := GetRouterIPs()
+	v4, v6 := GetRouterIPs(ctx)
 
 	if v4 == "" && v6 == "" {
 		menu := &tele.ReplyMarkup{}
 		menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_main")))
-		return c.Edit("❌ 无法获取 IP 地址，请检查网络或 SSH 连接。", menu)
+		return c.EditOrSend("❌ 无法获取 IP 地址，请检查网络或路由器连接。", menu)
 	}
 
 	msg := "🏠 **当前公网 IP**\n-------------------\n"
@@ -90,10 +196,14 @@ func HandleShowCurrentIPs(c tele.Context) error {
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("🔙 返回", "wrt_main")))
-	return c.Edit(msg, menu, tele.ModeMarkdown)
+	return c.EditOrSend(msg, menu, tele.ModeMarkdown)
 }
 
 func HandleRebootConfirm(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+
 	c.Respond()
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
@@ -104,14 +214,20 @@ func HandleRebootConfirm(c tele.Context) error {
 }
 
 func HandleRebootDo(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "指令已发送"})
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("🔙 返回主菜单", "start_main")))
 
 	c.Edit("🚀 正在重启路由器，请等待网络恢复...", menu)
+	audit.Log(c, "wrt", "reboot", "", nil, nil)
 	go func() {
-		SSHExec("reboot")
+		Exec(ctx, "reboot")
 	}()
 	return nil
 }
@@ -131,6 +247,11 @@ func HandleServicesMenu(c tele.Context) error {
 }
 
 func HandleServiceRestart(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	parts := strings.Split(c.Callback().Data, "|")
 	if len(parts) < 2 {
 		return c.Respond(&tele.CallbackResponse{Text: "Error: Invalid request"})
@@ -139,7 +260,8 @@ func HandleServiceRestart(c tele.Context) error {
 	c.Respond(&tele.CallbackResponse{Text: fmt.Sprintf("正在重启 %s...", svc)})
 	c.Edit(fmt.Sprintf("⏳ 正在重启 %s，请稍候...", svc))
 
-	SSHExec(fmt.Sprintf("/etc/init.d/%s restart", svc))
+	_, err := Exec(ctx, fmt.Sprintf("/etc/init.d/%s restart", svc))
+	audit.Log(c, "wrt", "service_restart", svc, nil, err)
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(menu.Row(menu.Data("🔙 返回服务列表", "wrt_services_menu")))
@@ -147,7 +269,13 @@ func HandleServiceRestart(c tele.Context) error {
 }
 
 func HandleDropCaches(c tele.Context) error {
+	if !utils.RequireRole(c, utils.RoleOperator) {
+		return nil
+	}
+
+	ctx := utils.Ctx(c)
 	c.Respond(&tele.CallbackResponse{Text: "正在清理内存..."})
-	SSHExec("sync && echo 3 > /proc/sys/vm/drop_caches")
+	_, err := Exec(ctx, "sync && echo 3 > /proc/sys/vm/drop_caches")
+	audit.Log(c, "wrt", "drop_caches", "", nil, err)
 	return HandleStatus(c)
 }
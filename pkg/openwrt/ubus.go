@@ -0,0 +1,179 @@
+package openwrt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yingxiaomo/homeops/config"
+)
+
+// ubusNullSession is the anonymous session used to log in.
+const ubusNullSession = "00000000000000000000000000000000"
+
+// errUbusSession is returned when the rpcd session expired or was revoked.
+var errUbusSession = errors.New("ubus session expired")
+
+var ubusStatus = []string{
+	"ok", "invalid command", "invalid argument", "method not found", "not found",
+	"no data", "permission denied", "timeout", "not supported", "unknown error",
+	"connection failed",
+}
+
+// UbusExecutor talks to ubus through the JSON-RPC endpoint of uhttpd,
+// logging in to rpcd with the profile's user and password. Commands and
+// file reads go through the rpcd file plugin, so the user's ACL must allow
+// file exec of /bin/sh and file read.
+type UbusExecutor struct {
+	Router config.RouterProfile
+	Client *http.Client
+
+	mu      sync.Mutex
+	session string
+	id      atomic.Int64
+}
+
+func NewUbusExecutor(r config.RouterProfile) *UbusExecutor {
+	return &UbusExecutor{
+		Router: r,
+		Client: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+func (e *UbusExecutor) Call(ctx context.Context, object, method string, args, out any) error {
+	sid, err := e.login(ctx, false)
+	if err != nil {
+		return err
+	}
+	err = e.rpc(ctx, sid, object, method, args, out)
+	if errors.Is(err, errUbusSession) {
+		if sid, err = e.login(ctx, true); err != nil {
+			return err
+		}
+		err = e.rpc(ctx, sid, object, method, args, out)
+	}
+	return err
+}
+
+func (e *UbusExecutor) Exec(ctx context.Context, cmd string) (string, error) {
+	var res struct {
+		Code   int    `json:"code"`
+		Stdout string `json:"stdout"`
+		Stderr string `json:"stderr"`
+	}
+	args := map[string]any{"command": "/bin/sh", "params": []string{"-c", cmd}}
+	if err := e.Call(ctx, "file", "exec", args, &res); err != nil {
+		return "", err
+	}
+	out := res.Stdout + res.Stderr
+	if res.Code != 0 {
+		return out, fmt.Errorf("exit status %d", res.Code)
+	}
+	return out, nil
+}
+
+func (e *UbusExecutor) ReadFile(ctx context.Context, path string) (string, error) {
+	var res struct {
+		Data string `json:"data"`
+	}
+	if err := e.Call(ctx, "file", "read", map[string]string{"path": path}, &res); err != nil {
+		return "", err
+	}
+	return res.Data, nil
+}
+
+// login returns the current rpcd session, logging in when there is none
+// or force is set.
+func (e *UbusExecutor) login(ctx context.Context, force bool) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.session != "" && !force {
+		return e.session, nil
+	}
+
+	var res struct {
+		Session string `json:"ubus_rpc_session"`
+	}
+	creds := map[string]string{"username": e.Router.User, "password": e.Router.Password}
+	if err := e.rpc(ctx, ubusNullSession, "session", "login", creds, &res); err != nil {
+		return "", fmt.Errorf("rpcd login failed: %w", err)
+	}
+	if res.Session == "" {
+		return "", fmt.Errorf("rpcd login failed: no session returned")
+	}
+	e.session = res.Session
+	return e.session, nil
+}
+
+func (e *UbusExecutor) rpc(ctx context.Context, sid, object, method string, args, out any) error {
+	if args == nil {
+		args = map[string]any{}
+	}
+	body, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      e.id.Add(1),
+		"method":  "call",
+		"params":  []any{sid, object, method, args},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.Router.UbusURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("ubus %s %s: HTTP %s", object, method, resp.Status)
+	}
+
+	var reply struct {
+		Result []json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("ubus %s %s: %v", object, method, err)
+	}
+	if reply.Error != nil {
+		// -32002 "Access denied" means the session is no longer valid.
+		if reply.Error.Code == -32002 {
+			return errUbusSession
+		}
+		return fmt.Errorf("ubus %s %s: %s", object, method, reply.Error.Message)
+	}
+	if len(reply.Result) == 0 {
+		return fmt.Errorf("ubus %s %s: empty result", object, method)
+	}
+
+	var code int
+	if err := json.Unmarshal(reply.Result[0], &code); err != nil {
+		return fmt.Errorf("ubus %s %s: %v", object, method, err)
+	}
+	if code != 0 {
+		status := "unknown error"
+		if code > 0 && code < len(ubusStatus) {
+			status = ubusStatus[code]
+		}
+		return fmt.Errorf("ubus %s %s: %s", object, method, status)
+	}
+	if out != nil && len(reply.Result) > 1 {
+		return json.Unmarshal(reply.Result[1], out)
+	}
+	return nil
+}
@@ -0,0 +1,589 @@
+package openwrt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/uci"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	uciPackagesPerPage = 24
+	uciSectionsPerPage = 15
+	uciValueMax        = 200
+	uciEditKey         = "uci_edit"
+)
+
+// UCIEdit is the option an admin is entering a value for. Option is empty
+// when adding a new option.
+type UCIEdit struct {
+	Package string `json:"package"`
+	Section string `json:"section"`
+	Option  string `json:"option"`
+	List    bool   `json:"list"`
+}
+
+// loadPackage reads a UCI package, preferring ubus "uci get" and falling
+// back to parsing "uci export". Anonymous sections get @type[n] ids either
+// way.
+func loadPackage(ctx context.Context, name string) (*uci.Package, error) {
+	var res struct {
+		Values map[string]map[string]any `json:"values"`
+	}
+	if err := Ubus(ctx, "uci", "get", map[string]string{"config": name}, &res); err != nil {
+		return exportPackage(ctx, name)
+	}
+
+	names := make([]string, 0, len(res.Values))
+	for n := range res.Values {
+		names = append(names, n)
+	}
+	index := func(n string) float64 {
+		i, _ := res.Values[n][".index"].(float64)
+		return i
+	}
+	sort.Slice(names, func(i, j int) bool { return index(names[i]) < index(names[j]) })
+
+	pkg := &uci.Package{Name: name}
+	for _, n := range names {
+		values := res.Values[n]
+		sec := &uci.Section{}
+		sec.Type, _ = values[".type"].(string)
+		if anon, _ := values[".anonymous"].(bool); !anon {
+			sec.Name = n
+		}
+		var opts []string
+		for k := range values {
+			if !strings.HasPrefix(k, ".") {
+				opts = append(opts, k)
+			}
+		}
+		sort.Strings(opts)
+		for _, k := range opts {
+			switch v := values[k].(type) {
+			case string:
+				sec.Options = append(sec.Options, &uci.Option{Name: k, Values: []string{v}})
+			case []any:
+				o := &uci.Option{Name: k, List: true}
+				for _, item := range v {
+					o.Values = append(o.Values, fmt.Sprint(item))
+				}
+				sec.Options = append(sec.Options, o)
+			}
+		}
+		pkg.Sections = append(pkg.Sections, sec)
+	}
+	return pkg, nil
+}
+
+// exportPackage parses "uci export", which includes changes staged from the
+// command line and, unlike "uci show", tells one-element lists from options.
+func exportPackage(ctx context.Context, name string) (*uci.Package, error) {
+	out, err := Exec(ctx, "uci export "+uci.Quote(name))
+	if err != nil {
+		return nil, fmt.Errorf("%v %s", err, strings.TrimSpace(out))
+	}
+	return parsePackage(out, name)
+}
+
+func listPackages(ctx context.Context) ([]string, error) {
+	var res struct {
+		Configs []string `json:"configs"`
+	}
+	if err := Ubus(ctx, "uci", "configs", nil, &res); err == nil && len(res.Configs) > 0 {
+		sort.Strings(res.Configs)
+		return res.Configs, nil
+	}
+	out, err := Exec(ctx, "ls /etc/config")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, n := range strings.Fields(out) {
+		if uci.ValidPackage(n) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// stagedChanges returns the uncommitted changes of pkg, one per line.
+func stagedChanges(ctx context.Context, pkg string) ([]string, error) {
+	out, err := Exec(ctx, uci.Changes(pkg))
+	if err != nil {
+		return nil, fmt.Errorf("%v %s", err, strings.TrimSpace(out))
+	}
+	var lines []string
+	for _, l := range strings.Split(out, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, nil
+}
+
+func maskChanges(changes []string) []string {
+	masked := make([]string, len(changes))
+	for i, l := range changes {
+		masked[i] = uci.MaskChange(l)
+	}
+	return masked
+}
+
+// splitUCIPath splits a package[.section[.option]] path from a callback
+// token, rejecting malformed names.
+func splitUCIPath(path string) (pkg, sec, opt string, ok bool) {
+	parts := strings.SplitN(path, ".", 3)
+	pkg = parts[0]
+	if !uci.ValidPackage(pkg) {
+		return "", "", "", false
+	}
+	if len(parts) > 1 {
+		if sec = parts[1]; !uci.ValidSection(sec) {
+			return "", "", "", false
+		}
+	}
+	if len(parts) > 2 {
+		if opt = parts[2]; !uci.ValidName(opt) {
+			return "", "", "", false
+		}
+	}
+	return pkg, sec, opt, true
+}
+
+func pageArg(s string) int {
+	n, _ := strconv.Atoi(s)
+	return max(n, 0)
+}
+
+func pageButtons(menu *tele.ReplyMarkup, unique, prefix string, page, pages int) []tele.Btn {
+	var nav []tele.Btn
+	if page > 0 {
+		nav = append(nav, menu.Data("⬅️ 上一页", unique, prefix+strconv.Itoa(page-1)))
+	}
+	if page < pages-1 {
+		nav = append(nav, menu.Data("➡️ 下一页", unique, prefix+strconv.Itoa(page+1)))
+	}
+	return nav
+}
+
+// HandleUCICommand handles /uci [package].
+func HandleUCICommand(c tele.Context) error {
+	if pkg := strings.TrimSpace(c.Message().Payload); pkg != "" {
+		if !uci.ValidPackage(pkg) {
+			return c.Send("❌ 无效的配置包名称。")
+		}
+		return showUCIPackage(c, pkg, 0, "")
+	}
+	return HandleUCIPackages(c, "0")
+}
+
+// HandleUCIPackages handles "uci_pkgs|<page>".
+func HandleUCIPackages(c tele.Context, payload string) error {
+	c.Respond()
+	session.GlobalStore.Delete(c.Sender().ID, uciEditKey)
+	back := &tele.ReplyMarkup{}
+	back.Inline(back.Row(back.Data("🔙 返回", "wrt_main")))
+
+	names, err := listPackages(utils.Ctx(c))
+	if err != nil {
+		return c.EditOrSend(fmt.Sprintf("❌ 读取配置列表失败: %v", err), back)
+	}
+
+	pages := max((len(names)+uciPackagesPerPage-1)/uciPackagesPerPage, 1)
+	page := min(pageArg(payload), pages-1)
+	shown := names[page*uciPackagesPerPage : min((page+1)*uciPackagesPerPage, len(names))]
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	var row []tele.Btn
+	for _, n := range shown {
+		row = append(row, menu.Data(n, "uci_pkg", n+"|0"))
+		if len(row) == 3 {
+			rows = append(rows, menu.Row(row...))
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, menu.Row(row...))
+	}
+	if nav := pageButtons(menu, "uci_pkgs", "", page, pages); len(nav) > 0 {
+		rows = append(rows, menu.Row(nav...))
+	}
+	rows = append(rows, menu.Row(menu.Data("🔙 返回", "wrt_main")))
+	menu.Inline(rows...)
+
+	txt := fmt.Sprintf("⚙️ UCI 配置 (%d/%d)\n请选择配置包：", page+1, pages)
+	if multiRouter() {
+		txt = fmt.Sprintf("⚙️ UCI 配置 · %s (%d/%d)\n请选择配置包：", RouterName(utils.Ctx(c)), page+1, pages)
+	}
+	return c.EditOrSend(txt, menu)
+}
+
+// HandleUCIPackage handles "uci_pkg|<package>|<page>".
+func HandleUCIPackage(c tele.Context, payload string) error {
+	pkg, page, _ := strings.Cut(payload, "|")
+	if !uci.ValidPackage(pkg) {
+		return c.Respond()
+	}
+	c.Respond()
+	return showUCIPackage(c, pkg, pageArg(page), "")
+}
+
+func showUCIPackage(c tele.Context, name string, page int, note string) error {
+	ctx := utils.Ctx(c)
+	session.GlobalStore.Delete(c.Sender().ID, uciEditKey)
+	back := &tele.ReplyMarkup{}
+	back.Inline(back.Row(back.Data("🔙 返回", "uci_pkgs", "0")))
+
+	pkg, err := exportPackage(ctx, name)
+	if err != nil {
+		return c.EditOrSend(fmt.Sprintf("❌ 读取 %s 失败: %v", name, err), back)
+	}
+	changes, _ := stagedChanges(ctx, name)
+
+	pages := max((len(pkg.Sections)+uciSectionsPerPage-1)/uciSectionsPerPage, 1)
+	page = min(page, pages-1)
+	first := page * uciSectionsPerPage
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for i := first; i < min(first+uciSectionsPerPage, len(pkg.Sections)); i++ {
+		s := pkg.Sections[i]
+		id := pkg.ID(i)
+		label := fmt.Sprintf("%s (%s)", id, s.Type)
+		if s.Name == "" && s.Get("name") != "" {
+			label = fmt.Sprintf("%s %s", id, s.Get("name"))
+		}
+		rows = append(rows, menu.Row(menu.Data(label, "uci_sec", session.PutToken(uci.Path(name, id)))))
+	}
+	if nav := pageButtons(menu, "uci_pkg", name+"|", page, pages); len(nav) > 0 {
+		rows = append(rows, menu.Row(nav...))
+	}
+	if len(changes) > 0 {
+		rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("📝 待提交更改 (%d)", len(changes)), "uci_changes", name)))
+	}
+	rows = append(rows, menu.Row(menu.Data("🔙 返回", "uci_pkgs", "0")))
+	menu.Inline(rows...)
+
+	txt := fmt.Sprintf("⚙️ %s: %d 个节 (%d/%d)", name, len(pkg.Sections), page+1, pages)
+	if note != "" {
+		txt = note + "\n\n" + txt
+	}
+	return c.EditOrSend(txt, menu)
+}
+
+// HandleUCISection handles "uci_sec|<token>", the token holding
+// package.section.
+func HandleUCISection(c tele.Context, path string) error {
+	pkg, sec, _, ok := splitUCIPath(path)
+	if !ok || sec == "" {
+		return c.Respond()
+	}
+	c.Respond()
+	return showUCISection(c, pkg, sec, "")
+}
+
+func showUCISection(c tele.Context, name, id, note string) error {
+	ctx := utils.Ctx(c)
+	session.GlobalStore.Delete(c.Sender().ID, uciEditKey)
+	back := &tele.ReplyMarkup{}
+	back.Inline(back.Row(back.Data("🔙 返回", "uci_pkg", name+"|0")))
+
+	pkg, err := exportPackage(ctx, name)
+	if err != nil {
+		return c.EditOrSend(fmt.Sprintf("❌ 读取 %s 失败: %v", name, err), back)
+	}
+	s := pkg.Section(id)
+	if s == nil {
+		return c.EditOrSend(fmt.Sprintf("❌ %s.%s 不存在", name, id), back)
+	}
+
+	admin := utils.HasRole(c.Sender().ID, utils.RoleAdmin)
+	var sb strings.Builder
+	if note != "" {
+		sb.WriteString(note + "\n\n")
+	}
+	sb.WriteString(fmt.Sprintf("⚙️ %s.%s (%s)\n-------------------\n", name, id, s.Type))
+	if len(s.Options) == 0 {
+		sb.WriteString("(无选项)\n")
+	}
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	var row []tele.Btn
+	for _, o := range s.Options {
+		sb.WriteString(formatOption(o, admin) + "\n")
+		row = append(row, menu.Data("✏️ "+o.Name, "uci_opt", session.PutToken(uci.Path(name, id, o.Name))))
+		if len(row) == 2 {
+			rows = append(rows, menu.Row(row...))
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, menu.Row(row...))
+	}
+	token := session.PutToken(uci.Path(name, id))
+	rows = append(rows,
+		menu.Row(menu.Data("➕ 添加选项", "uci_optnew", token), menu.Data("🗑 删除此节", "uci_secdel", token)),
+		menu.Row(menu.Data("🔙 返回", "uci_pkg", name+"|0")),
+	)
+	menu.Inline(rows...)
+	return utils.SendLongMessage(c, editable(c), strings.TrimRight(sb.String(), "\n"), menu)
+}
+
+func formatOption(o *uci.Option, admin bool) string {
+	vals := make([]string, len(o.Values))
+	for i, v := range o.Values {
+		if !admin && uci.Sensitive(o.Name) {
+			v = uci.Masked
+		} else if len(v) > uciValueMax {
+			v = v[:uciValueMax] + "..."
+		}
+		vals[i] = "'" + v + "'"
+	}
+	if o.List {
+		return fmt.Sprintf("%s = [%s]", o.Name, strings.Join(vals, ", "))
+	}
+	return fmt.Sprintf("%s = %s", o.Name, strings.Join(vals, " "))
+}
+
+// editable returns the message to edit in place: the one holding the
+// pressed button, or nil for a text reply.
+func editable(c tele.Context) *tele.Message {
+	if c.Callback() != nil {
+		return c.Message()
+	}
+	return nil
+}
+
+// HandleUCIOption handles "uci_opt|<token>" by asking for the new value of
+// package.section.option.
+func HandleUCIOption(c tele.Context, path string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	pkgName, sec, opt, ok := splitUCIPath(path)
+	if !ok || opt == "" {
+		return c.Respond()
+	}
+	c.Respond()
+
+	pkg, err := exportPackage(utils.Ctx(c), pkgName)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ 读取 %s 失败: %v", pkgName, err))
+	}
+	var o *uci.Option
+	if s := pkg.Section(sec); s != nil {
+		o = s.Option(opt)
+	}
+	if o == nil {
+		return showUCISection(c, pkgName, sec, fmt.Sprintf("❌ 选项 %s 不存在", opt))
+	}
+
+	edit := UCIEdit{Package: pkgName, Section: sec, Option: opt, List: o.List}
+	session.GlobalStore.SetWithTTL(c.Sender().ID, uciEditKey, edit, session.WizardTTL)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("🗑 删除此选项", "uci_optdel", session.PutToken(path))),
+		menu.Row(menu.Data("❌ 取消", "uci_sec", session.PutToken(uci.Path(pkgName, sec)))),
+	)
+	txt := fmt.Sprintf("✏️ %s\n当前值: %s\n\n请发送新值。", path, formatOption(o, true))
+	if o.List {
+		txt += "\n这是列表选项，每行一个值。"
+	} else {
+		txt += "\n发送多行将改为列表选项。"
+	}
+	return c.Send(txt, menu)
+}
+
+// HandleUCIOptionNew handles "uci_optnew|<token>" by asking for a new
+// option of package.section.
+func HandleUCIOptionNew(c tele.Context, path string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	pkg, sec, _, ok := splitUCIPath(path)
+	if !ok || sec == "" {
+		return c.Respond()
+	}
+	c.Respond()
+	session.GlobalStore.SetWithTTL(c.Sender().ID, uciEditKey, UCIEdit{Package: pkg, Section: sec}, session.WizardTTL)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("❌ 取消", "uci_sec", session.PutToken(path))))
+	return c.Send(fmt.Sprintf("➕ 为 %s 添加选项\n请发送 选项名=值，列表选项每行一个值，例如:\nnetwork=lan\nwan", path), menu)
+}
+
+// HandleUCIInput stages the value entered for a UCIEdit.
+func HandleUCIInput(c tele.Context, edit UCIEdit) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	session.GlobalStore.Delete(c.Sender().ID, uciEditKey)
+
+	text := strings.TrimSpace(c.Text())
+	opt, list := edit.Option, edit.List
+	if opt == "" {
+		name, value, ok := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !uci.ValidName(name) {
+			return showUCISection(c, edit.Package, edit.Section, "❌ 格式应为 选项名=值，选项名只能包含字母、数字和下划线")
+		}
+		opt, text = name, value
+	}
+
+	values := strings.Split(text, "\n")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	list = list || len(values) > 1
+
+	ctx := utils.Ctx(c)
+	exists := edit.Option != ""
+	if !exists {
+		if pkg, err := exportPackage(ctx, edit.Package); err == nil {
+			if s := pkg.Section(edit.Section); s != nil && s.Option(opt) != nil {
+				exists, list = true, list || s.Option(opt).List
+			}
+		}
+	}
+
+	path := uci.Path(edit.Package, edit.Section, opt)
+	cmd := uci.Set(path, strings.Join(values, " "))
+	if list {
+		var cmds []string
+		if exists {
+			cmds = append(cmds, uci.Delete(path))
+		}
+		for _, v := range values {
+			cmds = append(cmds, uci.AddList(path, v))
+		}
+		cmd = strings.Join(cmds, " && ")
+	}
+
+	out, err := Exec(ctx, cmd)
+	logged := strings.Join(values, "\n")
+	if uci.Sensitive(opt) {
+		logged = uci.Masked
+	}
+	audit.Log(c, "uci", "stage_set", path, map[string]string{"value": logged}, err)
+	if err != nil {
+		return showUCISection(c, edit.Package, edit.Section, fmt.Sprintf("❌ 修改 %s 失败: %v %s", path, err, strings.TrimSpace(out)))
+	}
+	return showUCISection(c, edit.Package, edit.Section, fmt.Sprintf("✅ 已暂存 %s，提交后生效", path))
+}
+
+// HandleUCIOptionDelete handles "uci_optdel|<token>".
+func HandleUCIOptionDelete(c tele.Context, path string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	pkg, sec, opt, ok := splitUCIPath(path)
+	if !ok || opt == "" {
+		return c.Respond()
+	}
+	c.Respond()
+	session.GlobalStore.Delete(c.Sender().ID, uciEditKey)
+
+	out, err := Exec(utils.Ctx(c), uci.Delete(path))
+	audit.Log(c, "uci", "stage_delete", path, nil, err)
+	if err != nil {
+		return showUCISection(c, pkg, sec, fmt.Sprintf("❌ 删除 %s 失败: %v %s", path, err, strings.TrimSpace(out)))
+	}
+	return showUCISection(c, pkg, sec, fmt.Sprintf("✅ 已暂存删除 %s，提交后生效", path))
+}
+
+// HandleUCISectionDelete handles "uci_secdel|<token>".
+func HandleUCISectionDelete(c tele.Context, path string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	pkg, sec, _, ok := splitUCIPath(path)
+	if !ok || sec == "" {
+		return c.Respond()
+	}
+	c.Respond()
+
+	out, err := Exec(utils.Ctx(c), uci.Delete(path))
+	audit.Log(c, "uci", "stage_delete", path, nil, err)
+	if err != nil {
+		return showUCISection(c, pkg, sec, fmt.Sprintf("❌ 删除 %s 失败: %v %s", path, err, strings.TrimSpace(out)))
+	}
+	return showUCIPackage(c, pkg, 0, fmt.Sprintf("✅ 已暂存删除 %s，提交后生效", path))
+}
+
+// HandleUCIChanges handles "uci_changes|<package>".
+func HandleUCIChanges(c tele.Context, pkg string) error {
+	if !uci.ValidPackage(pkg) {
+		return c.Respond()
+	}
+	c.Respond()
+
+	changes, err := stagedChanges(utils.Ctx(c), pkg)
+	menu := &tele.ReplyMarkup{}
+	if err != nil {
+		menu.Inline(menu.Row(menu.Data("🔙 返回", "uci_pkg", pkg+"|0")))
+		return c.Edit(fmt.Sprintf("❌ 读取待提交更改失败: %v", err), menu)
+	}
+	if len(changes) == 0 {
+		return showUCIPackage(c, pkg, 0, "没有待提交的更改。")
+	}
+
+	menu.Inline(
+		menu.Row(menu.Data("✅ 提交并应用", "uci_commit", pkg), menu.Data("🗑 放弃更改", "uci_revert", pkg)),
+		menu.Row(menu.Data("🔙 返回", "uci_pkg", pkg+"|0")),
+	)
+	if !utils.HasRole(c.Sender().ID, utils.RoleAdmin) {
+		changes = maskChanges(changes)
+	}
+	txt := fmt.Sprintf("📝 %s 待提交更改 (%d)\n-------------------\n%s", pkg, len(changes), strings.Join(changes, "\n"))
+	return utils.SendLongMessage(c, editable(c), txt, menu)
+}
+
+// HandleUCICommit handles "uci_commit|<package>". The commit goes through
+// the confirm window like the firewall wizards.
+func HandleUCICommit(c tele.Context, pkg string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	if !uci.ValidPackage(pkg) {
+		return c.Respond()
+	}
+	c.Respond(&tele.CallbackResponse{Text: "正在提交..."})
+
+	changes, _ := stagedChanges(utils.Ctx(c), pkg)
+	err := applyUCIConfirmed(c, "uci", pkg, "", fmt.Sprintf("提交 %s 的 %d 项更改", pkg, len(changes)), uci.Commit(pkg)+" && reload_config")
+	audit.Log(c, "uci", "commit", pkg, map[string]string{"changes": strings.Join(maskChanges(changes), "\n")}, err)
+	if err != nil {
+		return showUCIPackage(c, pkg, 0, fmt.Sprintf("❌ 提交失败: %v", err))
+	}
+	return showUCIPackage(c, pkg, 0, fmt.Sprintf("✅ 已提交 %s 并重新加载服务", pkg))
+}
+
+// HandleUCIRevert handles "uci_revert|<package>".
+func HandleUCIRevert(c tele.Context, pkg string) error {
+	if !utils.RequireRole(c, utils.RoleAdmin) {
+		return nil
+	}
+	if !uci.ValidPackage(pkg) {
+		return c.Respond()
+	}
+	c.Respond()
+
+	out, err := Exec(utils.Ctx(c), uci.Revert(pkg))
+	audit.Log(c, "uci", "revert", pkg, nil, err)
+	if err != nil {
+		return showUCIPackage(c, pkg, 0, fmt.Sprintf("❌ 放弃更改失败: %v %s", err, strings.TrimSpace(out)))
+	}
+	return showUCIPackage(c, pkg, 0, "🗑 已放弃待提交的更改")
+}
@@ -0,0 +1,181 @@
+package router
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+// HandlerFunc handles a callback matched by prefix. payload is the part of
+// the callback data that follows the registered prefix.
+type HandlerFunc func(c tele.Context, payload string) error
+
+type route struct {
+	key     string
+	feature string
+	handler HandlerFunc
+}
+
+type command struct {
+	name        string
+	description string
+	feature     string
+	adminOnly   bool
+}
+
+// Registry maps callback data and slash commands to handlers, and enforces
+// the feature each of them was registered with before calling the handler.
+type Registry struct {
+	exact    map[string]route
+	prefixes []route
+	commands []command
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		exact: make(map[string]route),
+	}
+}
+
+// Handle registers an exact callback. It also matches telebot style data of
+// the form "data|payload"; such handlers read the payload from c.Callback().
+func (r *Registry) Handle(data, feature string, h tele.HandlerFunc) {
+	r.exact[data] = route{
+		key:     data,
+		feature: feature,
+		handler: func(c tele.Context, _ string) error { return h(c) },
+	}
+}
+
+// HandlePrefix registers a callback prefix. When several prefixes match, the
+// longest one wins.
+func (r *Registry) HandlePrefix(prefix, feature string, h HandlerFunc) {
+	r.prefixes = append(r.prefixes, route{key: prefix, feature: feature, handler: h})
+	sort.SliceStable(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i].key) > len(r.prefixes[j].key)
+	})
+}
+
+// Command registers a slash command on b guarded by feature. Commands with a
+// description are published to Telegram's command menu by Publish.
+func (r *Registry) Command(b *tele.Bot, name, feature, description string, h tele.HandlerFunc) {
+	r.commands = append(r.commands, command{name: name, description: description, feature: feature})
+	b.Handle(name, func(c tele.Context) error {
+		if !allowed(c, feature) {
+			return c.Send("⛔ 你没有使用此功能的权限。")
+		}
+		return h(c)
+	})
+}
+
+// AdminCommand registers a slash command that only admins may use.
+func (r *Registry) AdminCommand(b *tele.Bot, name, description string, h tele.HandlerFunc) {
+	r.commands = append(r.commands, command{name: name, description: description, adminOnly: true})
+	b.Handle(name, func(c tele.Context) error {
+		if !utils.IsAdmin(c.Sender().ID) {
+			return nil
+		}
+		return h(c)
+	})
+}
+
+// CommandsFor returns the published commands userID may use. A userID of 0
+// yields the commands that need no permission at all.
+func (r *Registry) CommandsFor(userID int64) []tele.Command {
+	var cmds []tele.Command
+	for _, cmd := range r.commands {
+		if cmd.description == "" {
+			continue
+		}
+		switch {
+		case cmd.adminOnly:
+			if userID == 0 || !utils.IsAdmin(userID) {
+				continue
+			}
+		case cmd.feature != "":
+			if userID == 0 || !utils.HasPermission(userID, cmd.feature) {
+				continue
+			}
+		}
+		cmds = append(cmds, tele.Command{Text: strings.TrimPrefix(cmd.name, "/"), Description: cmd.description})
+	}
+	return cmds
+}
+
+// Publish registers the command list with Telegram: the permission-free
+// commands as the default, and a per-chat list for each of userIDs.
+func (r *Registry) Publish(b *tele.Bot, userIDs []int64) {
+	if err := b.SetCommands(r.CommandsFor(0)); err != nil {
+		log.Printf("Error setting default commands: %v", err)
+	}
+	for _, id := range userIDs {
+		r.PublishFor(b, id)
+	}
+}
+
+// PublishFor refreshes the command list shown in userID's private chat.
+func (r *Registry) PublishFor(b *tele.Bot, userID int64) {
+	scope := tele.CommandScope{Type: tele.CommandScopeChat, ChatID: userID}
+	if err := b.SetCommands(r.CommandsFor(userID), scope); err != nil {
+		log.Printf("Error setting commands for %d: %v", userID, err)
+	}
+}
+
+func (r *Registry) match(data string) (route, string, bool) {
+	if rt, ok := r.exact[data]; ok {
+		return rt, "", true
+	}
+	if unique, payload, found := strings.Cut(data, "|"); found {
+		if rt, ok := r.exact[unique]; ok {
+			return rt, payload, true
+		}
+	}
+	for _, rt := range r.prefixes {
+		if strings.HasPrefix(data, rt.key) {
+			return rt, strings.TrimPrefix(data, rt.key), true
+		}
+	}
+	return route{}, "", false
+}
+
+// Dispatch routes callback data to its handler after checking that the
+// sender has the feature the route requires.
+func (r *Registry) Dispatch(c tele.Context, data string) error {
+	rt, payload, ok := r.match(data)
+	if !ok {
+		log.Printf("Unknown callback data: %s", data)
+		return c.Respond()
+	}
+
+	if !allowed(c, rt.feature) {
+		log.Printf("Permission denied: user %d, feature %q, data %s", c.Sender().ID, rt.feature, data)
+		return c.Respond(&tele.CallbackResponse{Text: "⛔ 你没有使用此功能的权限", ShowAlert: true})
+	}
+
+	return rt.handler(c, payload)
+}
+
+func allowed(c tele.Context, feature string) bool {
+	user := c.Sender()
+	if user == nil {
+		return false
+	}
+	return utils.HasPermission(user.ID, feature)
+}
+
+// Token wraps a handler whose payload is a session token, passing it the
+// resolved value instead. Expired tokens are answered with a hint to reopen
+// the menu.
+func Token(h HandlerFunc) HandlerFunc {
+	return func(c tele.Context, payload string) error {
+		value, ok := session.ResolveToken(payload)
+		if !ok {
+			return c.Respond(&tele.CallbackResponse{Text: "⌛ 按钮已过期，请重新打开菜单", ShowAlert: true})
+		}
+		return h(c, value)
+	}
+}
@@ -0,0 +1,134 @@
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/yingxiaomo/homeops/config"
+	"github.com/yingxiaomo/homeops/pkg/session"
+	"github.com/yingxiaomo/homeops/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+// newTestBot returns a bot talking to a fake API and a function reporting
+// the text of the last callback answer.
+func newTestBot(t *testing.T) (*tele.Bot, func() string) {
+	t.Helper()
+	var (
+		mu     sync.Mutex
+		answer string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]any
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &params)
+		mu.Lock()
+		answer, _ = params["text"].(string)
+		mu.Unlock()
+		io.WriteString(w, `{"ok":true,"result":true}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	b, err := tele.NewBot(tele.Settings{Token: "test", URL: srv.URL, Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return answer
+	}
+}
+
+func callback(b *tele.Bot, userID int64, data string) tele.Context {
+	return b.NewContext(tele.Update{Callback: &tele.Callback{
+		ID:      "cb",
+		Sender:  &tele.User{ID: userID},
+		Message: &tele.Message{ID: 1, Chat: &tele.Chat{ID: userID}},
+		Data:    data,
+	}})
+}
+
+func TestDispatch(t *testing.T) {
+	config.Set(&config.Config{AdminIDs: []int64{1}})
+	utils.GrantPermission("2", "wrt")
+	utils.GrantPermission("3", "wrt.firewall")
+	t.Cleanup(func() {
+		utils.RemoveUser("2")
+		utils.RemoveUser("3")
+	})
+
+	var got string
+	r := NewRegistry()
+	r.Handle("wrt_menu", "wrt", func(c tele.Context) error { got = "menu"; return nil })
+	r.Handle("wrt_fw", "wrt.firewall", func(c tele.Context) error { got = "fw:" + c.Callback().Data; return nil })
+	r.Handle("help", "", func(c tele.Context) error { got = "help"; return nil })
+	r.HandlePrefix("wrt_svc_", "wrt", func(c tele.Context, payload string) error { got = "svc:" + payload; return nil })
+	r.HandlePrefix("wrt_svc_restart_", "wrt.services", func(c tele.Context, payload string) error { got = "restart:" + payload; return nil })
+
+	tests := []struct {
+		name   string
+		user   int64
+		data   string
+		want   string
+		denied bool
+	}{
+		{"admin", 1, "wrt_fw", "fw:wrt_fw", false},
+		{"exact feature", 2, "wrt_menu", "menu", false},
+		{"parent feature covers child", 2, "wrt_fw|rule1", "fw:wrt_fw|rule1", false},
+		{"child feature does not cover parent", 3, "wrt_menu", "", true},
+		{"child feature", 3, "wrt_fw", "fw:wrt_fw", false},
+		{"no grants", 4, "wrt_menu", "", true},
+		{"no feature needs a grant", 4, "help", "", true},
+		{"any grant opens featureless routes", 3, "help", "help", false},
+		{"prefix payload", 2, "wrt_svc_dnsmasq", "svc:dnsmasq", false},
+		{"longest prefix wins", 2, "wrt_svc_restart_dnsmasq", "restart:dnsmasq", false},
+		{"longest prefix checks its own feature", 3, "wrt_svc_restart_dnsmasq", "", true},
+		{"unknown data", 1, "nope", "", false},
+	}
+	for _, tt := range tests {
+		b, answer := newTestBot(t)
+		got = ""
+		if err := r.Dispatch(callback(b, tt.user, tt.data), tt.data); err != nil {
+			t.Errorf("%s: Dispatch: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: handler got %q, want %q", tt.name, got, tt.want)
+		}
+		if denied := answer() == "⛔ 你没有使用此功能的权限"; denied != tt.denied {
+			t.Errorf("%s: denied = %v, want %v", tt.name, denied, tt.denied)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	config.Set(&config.Config{AdminIDs: []int64{1}})
+	old := session.GlobalStore
+	session.GlobalStore = session.NewMemoryStore()
+	t.Cleanup(func() { session.GlobalStore = old })
+
+	var got string
+	r := NewRegistry()
+	r.HandlePrefix("run|", "wrt", Token(func(c tele.Context, value string) error { got = value; return nil }))
+
+	b, answer := newTestBot(t)
+	data := "run|" + session.PutToken("/root/backup.sh")
+	if err := r.Dispatch(callback(b, 1, data), data); err != nil {
+		t.Fatal(err)
+	}
+	if got != "/root/backup.sh" {
+		t.Errorf("handler got %q, want the token's payload", got)
+	}
+
+	got = ""
+	if err := r.Dispatch(callback(b, 1, "run|expired"), "run|expired"); err != nil {
+		t.Fatal(err)
+	}
+	if got != "" || answer() != "⌛ 按钮已过期，请重新打开菜单" {
+		t.Errorf("expired token: handler got %q, answer %q", got, answer())
+	}
+}
@@ -0,0 +1,116 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func bitsOf(vals ...int) uint64 {
+	var b uint64
+	for _, v := range vals {
+		b |= 1 << uint(v)
+	}
+	return b
+}
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		expr                          string
+		minute, hour, dom, month, dow uint64
+	}{
+		{"0-5 3 * * *", bitsOf(0, 1, 2, 3, 4, 5), bitsOf(3), 0xfffffffe, 0x1ffe, 0xff},
+		{"*/15 0 1 1 0", bitsOf(0, 15, 30, 45), bitsOf(0), bitsOf(1), bitsOf(1), bitsOf(0)},
+		{"5/20 10-20/5 1,15 */6 1-5", bitsOf(5, 25, 45), bitsOf(10, 15, 20), bitsOf(1, 15), bitsOf(1, 7), bitsOf(1, 2, 3, 4, 5)},
+		{"0 0 * * 7", bitsOf(0), bitsOf(0), 0xfffffffe, 0x1ffe, bitsOf(0, 7)},
+		{"@daily", bitsOf(0), bitsOf(0), 0xfffffffe, 0x1ffe, 0xff},
+		{"@WEEKLY", bitsOf(0), bitsOf(0), 0xfffffffe, 0x1ffe, bitsOf(0)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if s.minute != tt.minute || s.hour != tt.hour || s.dom != tt.dom || s.month != tt.month || s.dow != tt.dow {
+			t.Errorf("Parse(%q) = %x %x %x %x %x, want %x %x %x %x %x", tt.expr,
+				s.minute, s.hour, s.dom, s.month, s.dow, tt.minute, tt.hour, tt.dom, tt.month, tt.dow)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1- * * * *",
+		"1,,2 * * * *",
+		"@reboot",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			v, err = time.Parse("2006-01-02 15:04:05", s)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name, expr, from, want string
+	}{
+		{"same day", "30 3 * * *", "2026-10-17 01:00", "2026-10-17 03:30"},
+		{"next day", "30 3 * * *", "2026-10-17 04:00", "2026-10-18 03:30"},
+		{"strictly after", "*/15 * * * *", "2026-10-17 10:15", "2026-10-17 10:30"},
+		{"seconds truncated", "*/15 * * * *", "2026-10-17 10:07:42", "2026-10-17 10:15"},
+		{"hour rollover", "0 * * * *", "2026-10-17 23:30", "2026-10-18 00:00"},
+		{"month rollover", "0 0 1 * *", "2026-10-17 12:00", "2026-11-01 00:00"},
+		{"year rollover", "0 0 1 1 *", "2026-10-17 12:00", "2027-01-01 00:00"},
+		{"december to january", "59 23 31 12 *", "2026-12-31 23:59", "2027-12-31 23:59"},
+		{"skip short months", "0 0 31 * *", "2026-04-01 00:00", "2026-05-31 00:00"},
+		{"leap day", "0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+		{"weekdays only", "0 9 * * 1-5", "2026-10-17 12:00", "2026-10-19 09:00"},
+		{"sunday as 7", "0 0 * * 7", "2026-10-17 12:00", "2026-10-18 00:00"},
+		{"dom only", "0 0 13 * *", "2026-10-17 12:00", "2026-11-13 00:00"},
+		{"dom or dow, dow first", "0 0 13 * 5", "2026-10-17 12:00", "2026-10-23 00:00"},
+		{"dom or dow, dom first", "0 0 20 * 5", "2026-10-17 12:00", "2026-10-20 00:00"},
+		{"dom and star dow", "0 0 20 * *", "2026-10-21 00:00", "2026-11-20 00:00"},
+		{"month list", "0 0 1 3,9 *", "2026-10-17 12:00", "2027-03-01 00:00"},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: Parse(%q): %v", tt.name, tt.expr, err)
+		}
+		if got := s.Next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%s: Next(%q, %s) = %s, want %s", tt.name, tt.expr, tt.from, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("Next = %s, want the zero time", got)
+	}
+}
@@ -0,0 +1,369 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yingxiaomo/homeops/pkg/audit"
+	"github.com/yingxiaomo/homeops/pkg/lifecycle"
+	tele "gopkg.in/telebot.v3"
+)
+
+const SchedulesFile = "data/schedules.json"
+
+// runTimeout bounds a single run so that a hung SSH command cannot pile up
+// runs of the same job.
+const runTimeout = 10 * time.Minute
+
+// Action is something a job can do. Run returns a short human-readable
+// result that is reported to the job's chat.
+type Action struct {
+	Name      string
+	Label     string
+	Subsystem string
+	// ArgHint describes the argument; empty means the action takes none.
+	ArgHint string
+	Check   func(arg string) error
+	Run     func(ctx context.Context, arg string) (string, error)
+}
+
+// Job is a persisted recurring action.
+type Job struct {
+	ID        int       `json:"id"`
+	Spec      string    `json:"spec"`
+	Action    string    `json:"action"`
+	Arg       string    `json:"arg,omitempty"`
+	ChatID    int64     `json:"chat_id"`
+	CreatedBy int64     `json:"created_by"`
+	Paused    bool      `json:"paused,omitempty"`
+	LastRun   time.Time `json:"last_run,omitempty"`
+	LastOK    bool      `json:"last_ok,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+
+	sched   *Schedule
+	running bool
+}
+
+// Next returns when j runs next, or the zero time if it is paused.
+func (j Job) Next(now time.Time) time.Time {
+	if j.Paused || j.sched == nil {
+		return time.Time{}
+	}
+	return j.sched.Next(now)
+}
+
+var (
+	actions   = make(map[string]Action)
+	actionsMu sync.RWMutex
+
+	jobs   []*Job
+	jobsMu sync.Mutex
+	bot    *tele.Bot
+)
+
+// RegisterAction makes a available to jobs.
+func RegisterAction(a Action) {
+	actionsMu.Lock()
+	defer actionsMu.Unlock()
+	actions[a.Name] = a
+}
+
+// Actions returns the registered actions sorted by name.
+func Actions() []Action {
+	actionsMu.RLock()
+	defer actionsMu.RUnlock()
+	list := make([]Action, 0, len(actions))
+	for _, a := range actions {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func getAction(name string) (Action, bool) {
+	actionsMu.RLock()
+	defer actionsMu.RUnlock()
+	a, ok := actions[name]
+	return a, ok
+}
+
+// Start loads the saved jobs and runs them until shutdown, reporting each
+// run to the job's chat through b.
+func Start(b *tele.Bot) {
+	bot = b
+	load()
+
+	lifecycle.Go(func(ctx context.Context) {
+		for {
+			now := time.Now()
+			wait := now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			tick(time.Now().Truncate(time.Minute))
+		}
+	})
+	log.Printf("Scheduler started with %d jobs.", len(List()))
+}
+
+func tick(now time.Time) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range jobs {
+		if j.Paused || j.running || j.sched == nil || !j.sched.Match(now) {
+			continue
+		}
+		startRun(j)
+	}
+}
+
+// startRun runs j in the background. jobsMu must be held.
+func startRun(j *Job) {
+	j.running = true
+	job := *j
+	lifecycle.Go(func(ctx context.Context) {
+		runJob(ctx, job)
+	})
+}
+
+func runJob(ctx context.Context, j Job) {
+	a, ok := getAction(j.Action)
+	var out string
+	var err error
+	if !ok {
+		err = fmt.Errorf("未知动作 %s", j.Action)
+	} else {
+		runCtx, cancel := context.WithTimeout(ctx, runTimeout)
+		out, err = a.Run(runCtx, j.Arg)
+		cancel()
+	}
+
+	log.Printf("Scheduled job #%d (%s) finished: err=%v", j.ID, j.Action, err)
+	e := audit.Entry{
+		UserID:    j.CreatedBy,
+		Username:  "scheduler",
+		Subsystem: a.Subsystem,
+		Action:    j.Action,
+		Target:    j.Arg,
+		Params:    map[string]string{"job": fmt.Sprint(j.ID)},
+		OK:        err == nil,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	audit.Record(e)
+
+	jobsMu.Lock()
+	if cur := find(j.ID); cur != nil {
+		cur.running = false
+		cur.LastRun = time.Now()
+		cur.LastOK = err == nil
+		cur.LastError = ""
+		if err != nil {
+			cur.LastError = err.Error()
+		}
+		save()
+	}
+	jobsMu.Unlock()
+
+	report(j, a, out, err)
+}
+
+func report(j Job, a Action, out string, err error) {
+	if bot == nil || j.ChatID == 0 {
+		return
+	}
+	label := j.Action
+	if a.Label != "" {
+		label = a.Label
+	}
+	if j.Arg != "" {
+		label += " " + j.Arg
+	}
+
+	var msg string
+	if err != nil {
+		msg = fmt.Sprintf("❌ 定时任务 #%d %s 执行失败\n%v", j.ID, label, err)
+	} else {
+		msg = fmt.Sprintf("✅ 定时任务 #%d %s 执行完成", j.ID, label)
+	}
+	if out != "" {
+		if len(out) > 3000 {
+			out = out[:3000] + "\n... (输出过长已截断)"
+		}
+		msg += "\n\n" + out
+	}
+	if _, err := bot.Send(&tele.Chat{ID: j.ChatID}, msg); err != nil {
+		log.Printf("Failed to report scheduled job #%d: %v", j.ID, err)
+	}
+}
+
+// Add validates and saves a new job.
+func Add(spec, action, arg string, chatID, userID int64) (*Job, error) {
+	sched, err := Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if sched.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("表达式 %q 永远不会触发", spec)
+	}
+	a, ok := getAction(action)
+	if !ok {
+		return nil, fmt.Errorf("未知动作 %s", action)
+	}
+	if a.ArgHint == "" && arg != "" {
+		return nil, fmt.Errorf("动作 %s 不需要参数", action)
+	}
+	if a.Check != nil {
+		if err := a.Check(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	id := 1
+	for _, j := range jobs {
+		if j.ID >= id {
+			id = j.ID + 1
+		}
+	}
+	j := &Job{ID: id, Spec: spec, Action: action, Arg: arg, ChatID: chatID, CreatedBy: userID, sched: sched}
+	jobs = append(jobs, j)
+	save()
+	job := *j
+	return &job, nil
+}
+
+// List returns copies of all jobs ordered by ID.
+func List() []Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	list := make([]Job, len(jobs))
+	for i, j := range jobs {
+		list[i] = *j
+	}
+	return list
+}
+
+// Get returns a copy of the job with the given ID.
+func Get(id int) (Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if j := find(id); j != nil {
+		return *j, true
+	}
+	return Job{}, false
+}
+
+// SetPaused pauses or resumes a job.
+func SetPaused(id int, paused bool) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j := find(id)
+	if j == nil {
+		return fmt.Errorf("任务 #%d 不存在", id)
+	}
+	j.Paused = paused
+	save()
+	return nil
+}
+
+// Delete removes a job.
+func Delete(id int) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for i, j := range jobs {
+		if j.ID == id {
+			jobs = append(jobs[:i], jobs[i+1:]...)
+			save()
+			return nil
+		}
+	}
+	return fmt.Errorf("任务 #%d 不存在", id)
+}
+
+// RunNow starts a job immediately, outside its schedule.
+func RunNow(id int) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j := find(id)
+	if j == nil {
+		return fmt.Errorf("任务 #%d 不存在", id)
+	}
+	if j.running {
+		return fmt.Errorf("任务 #%d 正在运行", id)
+	}
+	startRun(j)
+	return nil
+}
+
+// find returns the job with the given ID. jobsMu must be held.
+func find(id int) *Job {
+	for _, j := range jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func load() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	data, err := os.ReadFile(SchedulesFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading schedules: %v", err)
+		return
+	}
+	var loaded []*Job
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		log.Printf("Error parsing schedules: %v", err)
+		return
+	}
+
+	jobs = jobs[:0]
+	for _, j := range loaded {
+		sched, err := Parse(j.Spec)
+		if err != nil {
+			log.Printf("Skipping scheduled job #%d with invalid spec %q: %v", j.ID, j.Spec, err)
+			continue
+		}
+		j.sched = sched
+		jobs = append(jobs, j)
+	}
+}
+
+// save writes all jobs to SchedulesFile. jobsMu must be held.
+func save() {
+	if err := os.MkdirAll(filepath.Dir(SchedulesFile), 0755); err != nil {
+		log.Printf("Error creating schedules dir: %v", err)
+		return
+	}
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		log.Printf("Error marshalling schedules: %v", err)
+		return
+	}
+	tmp := SchedulesFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("Error saving schedules: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, SchedulesFile); err != nil {
+		log.Printf("Error saving schedules: %v", err)
+	}
+}
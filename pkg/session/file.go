@@ -0,0 +1,130 @@
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileStore is a MemoryStore that snapshots itself to a JSON file. Restored
+// values are kept as json.RawMessage until read through GetAs.
+type FileStore struct {
+	*MemoryStore
+	path string
+
+	dirtyMu sync.Mutex
+	dirty   bool
+}
+
+type fileEntry struct {
+	Value     json.RawMessage `json:"value"`
+	ExpiresAt time.Time       `json:"expires_at,omitempty"`
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(),
+		path:        path,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot map[int64]map[string]fileEntry
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for userID, entries := range snapshot {
+		for key, fe := range entries {
+			e := entry{Value: fe.Value, ExpiresAt: fe.ExpiresAt}
+			if e.expired(now) {
+				continue
+			}
+			if _, ok := s.data[userID]; !ok {
+				s.data[userID] = make(map[string]entry)
+			}
+			s.data[userID][key] = e
+		}
+	}
+	return s, nil
+}
+
+func (s *FileStore) markDirty() {
+	s.dirtyMu.Lock()
+	s.dirty = true
+	s.dirtyMu.Unlock()
+}
+
+func (s *FileStore) Set(userID int64, key string, value interface{}) {
+	s.SetWithTTL(userID, key, value, 0)
+}
+
+func (s *FileStore) SetWithTTL(userID int64, key string, value interface{}, ttl time.Duration) {
+	s.MemoryStore.SetWithTTL(userID, key, value, ttl)
+	s.markDirty()
+}
+
+func (s *FileStore) Delete(userID int64, key string) {
+	s.MemoryStore.Delete(userID, key)
+	s.markDirty()
+}
+
+func (s *FileStore) Sweep() []Expired {
+	removed := s.MemoryStore.Sweep()
+	if len(removed) > 0 {
+		s.markDirty()
+	}
+	return removed
+}
+
+// Flush writes the snapshot if anything changed since the last flush.
+func (s *FileStore) Flush() error {
+	s.dirtyMu.Lock()
+	defer s.dirtyMu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+
+	snapshot := make(map[int64]map[string]fileEntry)
+	s.mu.RLock()
+	for userID, userStore := range s.data {
+		for key, e := range userStore {
+			raw, err := json.Marshal(e.Value)
+			if err != nil {
+				continue
+			}
+			if _, ok := snapshot[userID]; !ok {
+				snapshot[userID] = make(map[string]fileEntry)
+			}
+			snapshot[userID][key] = fileEntry{Value: raw, ExpiresAt: e.ExpiresAt}
+		}
+	}
+	s.mu.RUnlock()
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,96 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	Value     interface{}
+	ExpiresAt time.Time
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
+}
+
+// MemoryStore is a Store that lives only as long as the process.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	data map[int64]map[string]entry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data: make(map[int64]map[string]entry),
+	}
+}
+
+func (s *MemoryStore) Get(userID int64, key string) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if userStore, ok := s.data[userID]; ok {
+		if e, ok := userStore[key]; ok && !e.expired(time.Now()) {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Set(userID int64, key string, value interface{}) {
+	s.SetWithTTL(userID, key, value, 0)
+}
+
+func (s *MemoryStore) SetWithTTL(userID int64, key string, value interface{}, ttl time.Duration) {
+	if value == nil {
+		s.Delete(userID, key)
+		return
+	}
+
+	e := entry{Value: value}
+	if ttl > 0 {
+		e.ExpiresAt = time.Now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[userID]; !ok {
+		s.data[userID] = make(map[string]entry)
+	}
+	s.data[userID][key] = e
+}
+
+func (s *MemoryStore) Delete(userID int64, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if userStore, ok := s.data[userID]; ok {
+		delete(userStore, key)
+		if len(userStore) == 0 {
+			delete(s.data, userID)
+		}
+	}
+}
+
+func (s *MemoryStore) Sweep() []Expired {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var removed []Expired
+	for userID, userStore := range s.data {
+		for key, e := range userStore {
+			if e.expired(now) {
+				delete(userStore, key)
+				removed = append(removed, Expired{UserID: userID, Key: key})
+			}
+		}
+		if len(userStore) == 0 {
+			delete(s.data, userID)
+		}
+	}
+	return removed
+}
+
+func (s *MemoryStore) Flush() error {
+	return nil
+}
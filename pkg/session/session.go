@@ -1,48 +1,94 @@
 package session
 
 import (
-	"sync"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
 )
 
-type SessionStore struct {
-	mu   sync.RWMutex
-	Data map[int64]map[string]interface{}
-}
+// WizardTTL bounds how long a half-finished multi-step input may wait for
+// the user before it is discarded.
+const WizardTTL = 10 * time.Minute
 
-var GlobalStore *SessionStore
+// Store keeps per-user conversation state. Values may carry a TTL after which
+// they are treated as absent and removed by the janitor.
+type Store interface {
+	Get(userID int64, key string) interface{}
+	// Set stores value without expiry. A nil value deletes the key.
+	Set(userID int64, key string, value interface{})
+	SetWithTTL(userID int64, key string, value interface{}, ttl time.Duration)
+	Delete(userID int64, key string)
+	// Sweep removes expired entries and reports what was removed.
+	Sweep() []Expired
+	// Flush persists pending changes. It is a no-op for in-memory stores.
+	Flush() error
+}
 
-func init() {
-	GlobalStore = NewSessionStore()
+// Expired identifies an entry removed by Sweep.
+type Expired struct {
+	UserID int64
+	Key    string
 }
 
-func NewSessionStore() *SessionStore {
-	return &SessionStore{
-		Data: make(map[int64]map[string]interface{}),
-	}
+var GlobalStore Store
+
+func init() {
+	GlobalStore = NewMemoryStore()
 }
 
-func (s *SessionStore) Get(userID int64, key string) interface{} {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	if userStore, ok := s.Data[userID]; ok {
-		return userStore[key]
+// NewStore builds the store selected by backend ("memory" or "file").
+func NewStore(backend, path string) (Store, error) {
+	switch backend {
+	case "", "file":
+		return NewFileStore(path)
+	case "memory":
+		return NewMemoryStore(), nil
 	}
-	return nil
+	return nil, fmt.Errorf("unknown session backend %q", backend)
 }
 
-func (s *SessionStore) Set(userID int64, key string, value interface{}) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, ok := s.Data[userID]; !ok {
-		s.Data[userID] = make(map[string]interface{})
+// GetAs returns the value under key as T. Values restored from disk are held
+// as raw JSON and decoded here, so callers get the same type either way.
+func GetAs[T any](s Store, userID int64, key string) (T, bool) {
+	var zero T
+	switch v := s.Get(userID, key).(type) {
+	case nil:
+		return zero, false
+	case T:
+		return v, true
+	case json.RawMessage:
+		var out T
+		if err := json.Unmarshal(v, &out); err != nil {
+			log.Printf("Session value %s for %d is not a %T: %v", key, userID, zero, err)
+			return zero, false
+		}
+		return out, true
 	}
-	s.Data[userID][key] = value
+	return zero, false
 }
 
-func (s *SessionStore) Delete(userID int64, key string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if userStore, ok := s.Data[userID]; ok {
-		delete(userStore, key)
-	}
+// StartJanitor periodically sweeps expired entries, hands them to onExpire
+// and flushes the store until ctx is cancelled.
+func StartJanitor(ctx context.Context, s Store, interval time.Duration, onExpire func(Expired)) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, e := range s.Sweep() {
+				if onExpire != nil {
+					onExpire(e)
+				}
+			}
+			if err := s.Flush(); err != nil {
+				log.Printf("Error flushing session store: %v", err)
+			}
+		}
+	}()
 }
@@ -0,0 +1,190 @@
+package session
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type wizard struct {
+	Step int    `json:"step"`
+	Name string `json:"name"`
+}
+
+// expire backdates the entry under key so that it is already expired.
+func expire(s *MemoryStore, userID int64, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.data[userID][key]
+	e.ExpiresAt = time.Now().Add(-time.Second)
+	s.data[userID][key] = e
+}
+
+func TestMemoryStoreTTL(t *testing.T) {
+	s := NewMemoryStore()
+	s.Set(1, "forever", "a")
+	s.SetWithTTL(1, "short", "b", time.Hour)
+	s.SetWithTTL(2, "gone", "c", time.Hour)
+	expire(s, 1, "short")
+	expire(s, 2, "gone")
+
+	tests := []struct {
+		userID int64
+		key    string
+		want   interface{}
+	}{
+		{1, "forever", "a"},
+		{1, "short", nil},
+		{2, "gone", nil},
+		{3, "missing", nil},
+	}
+	for _, tt := range tests {
+		if got := s.Get(tt.userID, tt.key); got != tt.want {
+			t.Errorf("Get(%d, %s) = %v, want %v", tt.userID, tt.key, got, tt.want)
+		}
+	}
+
+	removed := s.Sweep()
+	if len(removed) != 2 {
+		t.Fatalf("Sweep = %v, want the two expired entries", removed)
+	}
+	if _, ok := s.data[2]; ok {
+		t.Error("Sweep left an empty map for user 2")
+	}
+	if s.Get(1, "forever") != "a" {
+		t.Error("Sweep removed an entry without TTL")
+	}
+	if removed := s.Sweep(); len(removed) != 0 {
+		t.Errorf("second Sweep = %v, want nothing", removed)
+	}
+
+	s.Set(1, "forever", nil)
+	if s.Get(1, "forever") != nil {
+		t.Error("Set with a nil value did not delete the key")
+	}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions", "store.json")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetWithTTL(1, "wizard", wizard{Step: 2, Name: "lan"}, time.Hour)
+	s.Set(1, "router", "edge")
+	s.SetWithTTL(2, "stale", "x", time.Hour)
+	expire(s.MemoryStore, 2, "stale")
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := GetAs[wizard](r, 1, "wizard"); !ok || got != (wizard{Step: 2, Name: "lan"}) {
+		t.Errorf("wizard = %+v, %v; want the stored value", got, ok)
+	}
+	if got, ok := GetAs[string](r, 1, "router"); !ok || got != "edge" {
+		t.Errorf("router = %q, %v; want edge", got, ok)
+	}
+	if _, ok := GetAs[int](r, 1, "router"); ok {
+		t.Error("GetAs[int] of a string succeeded")
+	}
+	if _, ok := r.data[2]; ok {
+		t.Error("an expired entry was restored")
+	}
+
+	// Without changes Flush does not rewrite the file.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Flush of a clean store wrote %s", path)
+	}
+	r.Delete(1, "router")
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Flush after Delete: %v", err)
+	}
+}
+
+func TestNewFileStoreErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(path); err == nil {
+		t.Error("NewFileStore of a corrupt file succeeded")
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	dir := t.TempDir()
+	for backend, ok := range map[string]bool{"": true, "file": true, "memory": true, "redis": false} {
+		_, err := NewStore(backend, filepath.Join(dir, "store.json"))
+		if (err == nil) != ok {
+			t.Errorf("NewStore(%q) error = %v, want ok %v", backend, err, ok)
+		}
+	}
+}
+
+func TestStartJanitor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetWithTTL(7, "wizard", "x", time.Hour)
+	expire(s.MemoryStore, 7, "wizard")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	expired := make(chan Expired, 1)
+	StartJanitor(ctx, s, 10*time.Millisecond, func(e Expired) { expired <- e })
+
+	select {
+	case e := <-expired:
+		if e != (Expired{UserID: 7, Key: "wizard"}) {
+			t.Errorf("expired %+v, want user 7 wizard", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("janitor did not sweep the expired entry")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not flush the store")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	old := GlobalStore
+	GlobalStore = NewMemoryStore()
+	t.Cleanup(func() { GlobalStore = old })
+
+	long := "🚀 节点选择|" + string(make([]byte, 100))
+	id := PutToken(long)
+	if len(id) != tokenLen || PutToken(long) != id {
+		t.Errorf("PutToken = %q, want a stable %d-character id", id, tokenLen)
+	}
+	if got, ok := ResolveToken(id); !ok || got != long {
+		t.Errorf("ResolveToken(%q) = %q, %v; want the payload", id, got, ok)
+	}
+	if _, ok := ResolveToken("forged"); ok {
+		t.Error("ResolveToken of an unknown id succeeded")
+	}
+}
@@ -0,0 +1,31 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+)
+
+// Telegram limits callback data to 64 bytes, so long payloads such as proxy
+// node names are kept server-side and buttons carry a short token instead.
+// Tokens live in the session store under a reserved user ID so they share its
+// persistence and expiry.
+const (
+	tokenOwner int64 = 0
+	tokenTTL         = 7 * 24 * time.Hour
+	tokenLen         = 12
+)
+
+// PutToken stores payload and returns an opaque ID for it. The same payload
+// always yields the same ID, so re-rendering a menu does not grow the table.
+func PutToken(payload string) string {
+	sum := sha256.Sum256([]byte(payload))
+	id := base64.RawURLEncoding.EncodeToString(sum[:])[:tokenLen]
+	GlobalStore.SetWithTTL(tokenOwner, "cbt:"+id, payload, tokenTTL)
+	return id
+}
+
+// ResolveToken returns the payload stored for id by PutToken.
+func ResolveToken(id string) (string, bool) {
+	return GetAs[string](GlobalStore, tokenOwner, "cbt:"+id)
+}
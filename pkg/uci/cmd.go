@@ -0,0 +1,91 @@
+package uci
+
+import (
+	"regexp"
+	"strings"
+)
+
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ValidName reports whether s may be used as a package, named section or
+// option name. Package names may also contain '-'.
+func ValidName(s string) bool {
+	return nameRe.MatchString(s)
+}
+
+// ValidPackage reports whether s may be used as a package name.
+func ValidPackage(s string) bool {
+	return nameRe.MatchString(strings.ReplaceAll(s, "-", "_"))
+}
+
+// ValidSection reports whether s names a section or an anonymous @type[n]
+// section.
+func ValidSection(s string) bool {
+	return nameRe.MatchString(s) || anonymousRe.MatchString(s)
+}
+
+// Quote quotes s as a single POSIX shell word.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Path joins package, section and option into a UCI path.
+func Path(parts ...string) string {
+	return strings.Join(parts, ".")
+}
+
+// Set returns a command setting path to value. With a package.section path
+// it creates the section with value as its type.
+func Set(path, value string) string {
+	return "uci set " + Quote(path+"="+value)
+}
+
+// AddList returns a command appending value to the list at path.
+func AddList(path, value string) string {
+	return "uci add_list " + Quote(path+"="+value)
+}
+
+// DelList returns a command removing value from the list at path.
+func DelList(path, value string) string {
+	return "uci del_list " + Quote(path+"="+value)
+}
+
+// Delete returns a command deleting the section or option at path.
+func Delete(path string) string {
+	return "uci delete " + Quote(path)
+}
+
+// Rename returns a command renaming the section or option at path.
+func Rename(path, name string) string {
+	return "uci rename " + Quote(path+"="+name)
+}
+
+// Commit returns a command writing the staged changes of pkg.
+func Commit(pkg string) string {
+	return "uci commit " + Quote(pkg)
+}
+
+// Revert returns a command discarding the staged changes of pkg.
+func Revert(pkg string) string {
+	return "uci revert " + Quote(pkg)
+}
+
+// Changes returns a command listing the staged changes of pkg.
+func Changes(pkg string) string {
+	return "uci changes " + Quote(pkg)
+}
+
+// MaskChange masks the value in a line of "uci changes" output, such as
+// "wireless.wlan0.key='secret'" or "-wireless.wlan0.key", when it sets a
+// sensitive option.
+func MaskChange(line string) string {
+	key, _, ok := strings.Cut(line, "=")
+	if !ok {
+		return line
+	}
+	parts := strings.Split(strings.TrimRight(key, "+-"), ".")
+	if len(parts) != 3 || !Sensitive(parts[2]) {
+		return line
+	}
+	return key + "=" + Masked
+}
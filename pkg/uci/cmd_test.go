@@ -0,0 +1,21 @@
+package uci
+
+import "testing"
+
+func TestMaskChange(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"wireless.wlan0.key='secret'", "wireless.wlan0.key=" + Masked},
+		{"wireless.wlan0.key+='secret'", "wireless.wlan0.key+=" + Masked},
+		{"wireless.wlan0.ssid='home'", "wireless.wlan0.ssid='home'"},
+		{"wireless.wlan0=wifi-iface", "wireless.wlan0=wifi-iface"},
+		{"-wireless.wlan0.key", "-wireless.wlan0.key"},
+		{"network.wan.password='pw'", "network.wan.password=" + Masked},
+	}
+	for _, tt := range tests {
+		if got := MaskChange(tt.line); got != tt.want {
+			t.Errorf("MaskChange(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package uci
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ChangeKind says what happened to a section or option.
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Modified
+)
+
+// Change is one difference between two versions of a package. Option is
+// empty for changes of a whole section.
+type Change struct {
+	Kind    ChangeKind
+	Package string
+	Section string
+	Type    string
+	Option  string
+	Old     []string
+	New     []string
+}
+
+// String describes the change. Values of sensitive options are masked.
+func (c Change) String() string {
+	path := c.Package + "." + c.Section
+	if c.Option == "" {
+		switch c.Kind {
+		case Added:
+			return fmt.Sprintf("➕ 新增 %s (%s)", path, c.Type)
+		case Removed:
+			return fmt.Sprintf("➖ 删除 %s (%s)", path, c.Type)
+		}
+		return fmt.Sprintf("✏️ %s 类型 %s → %s", path, c.Old[0], c.New[0])
+	}
+	path += "." + c.Option
+	values := quoteValues
+	if Sensitive(c.Option) {
+		values = func([]string) string { return Masked }
+	}
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("➕ %s = %s", path, values(c.New))
+	case Removed:
+		return fmt.Sprintf("➖ %s (原 %s)", path, values(c.Old))
+	}
+	return fmt.Sprintf("✏️ %s: %s → %s", path, values(c.Old), values(c.New))
+}
+
+func quoteValues(vals []string) string {
+	q := make([]string, len(vals))
+	for i, v := range vals {
+		q[i] = "'" + v + "'"
+	}
+	return strings.Join(q, " ")
+}
+
+// Diff lists the sections and options that differ between old and new.
+// Sections are matched by ID, so anonymous sections are compared by their
+// position among sections of the same type.
+func Diff(old, new *Package) []Change {
+	var changes []Change
+	oldIDs := sectionIDs(old)
+	newIDs := sectionIDs(new)
+
+	for i, s := range old.Sections {
+		id := old.ID(i)
+		if _, ok := newIDs[id]; !ok {
+			changes = append(changes, Change{Kind: Removed, Package: old.Name, Section: id, Type: s.Type})
+		}
+	}
+	for i, s := range new.Sections {
+		id := new.ID(i)
+		j, ok := oldIDs[id]
+		if !ok {
+			changes = append(changes, Change{Kind: Added, Package: new.Name, Section: id, Type: s.Type})
+			for _, o := range s.Options {
+				changes = append(changes, Change{Kind: Added, Package: new.Name, Section: id, Type: s.Type, Option: o.Name, New: o.Values})
+			}
+			continue
+		}
+		changes = append(changes, diffSection(new.Name, id, old.Sections[j], s)...)
+	}
+	return changes
+}
+
+func diffSection(pkg, id string, old, new *Section) []Change {
+	var changes []Change
+	if old.Type != new.Type {
+		changes = append(changes, Change{Kind: Modified, Package: pkg, Section: id, Type: new.Type, Old: []string{old.Type}, New: []string{new.Type}})
+	}
+	for _, o := range old.Options {
+		if new.Option(o.Name) == nil {
+			changes = append(changes, Change{Kind: Removed, Package: pkg, Section: id, Type: new.Type, Option: o.Name, Old: o.Values})
+		}
+	}
+	for _, o := range new.Options {
+		prev := old.Option(o.Name)
+		switch {
+		case prev == nil:
+			changes = append(changes, Change{Kind: Added, Package: pkg, Section: id, Type: new.Type, Option: o.Name, New: o.Values})
+		case !slices.Equal(prev.Values, o.Values) || prev.List != o.List:
+			changes = append(changes, Change{Kind: Modified, Package: pkg, Section: id, Type: new.Type, Option: o.Name, Old: prev.Values, New: o.Values})
+		}
+	}
+	return changes
+}
+
+func sectionIDs(p *Package) map[string]int {
+	ids := make(map[string]int, len(p.Sections))
+	for i := range p.Sections {
+		ids[p.ID(i)] = i
+	}
+	return ids
+}
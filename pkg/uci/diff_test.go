@@ -0,0 +1,88 @@
+package uci
+
+import (
+	"slices"
+	"testing"
+)
+
+func mustParse(t *testing.T, text string) *Package {
+	t.Helper()
+	pkgs, err := ParseExport("package firewall\n" + text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkgs[0]
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     []string
+	}{
+		{
+			name: "unchanged",
+			old:  "config rule\n\toption name 'a'\n",
+			new:  "config rule\n\toption name 'a'\n",
+		},
+		{
+			name: "section added",
+			old:  "config defaults\n",
+			new:  "config defaults\nconfig redirect 'web'\n\toption src_dport '80'\n",
+			want: []string{"➕ 新增 firewall.web (redirect)", "➕ firewall.web.src_dport = '80'"},
+		},
+		{
+			name: "section removed",
+			old:  "config defaults\nconfig redirect 'web'\n\toption src_dport '80'\n",
+			new:  "config defaults\n",
+			want: []string{"➖ 删除 firewall.web (redirect)"},
+		},
+		{
+			name: "type changed",
+			old:  "config rule 'ssh'\n",
+			new:  "config redirect 'ssh'\n",
+			want: []string{"✏️ firewall.ssh 类型 rule → redirect"},
+		},
+		{
+			name: "options changed",
+			old:  "config zone 'lan'\n\toption input 'ACCEPT'\n\toption mtu_fix '1'\n",
+			new:  "config zone 'lan'\n\toption input 'DROP'\n\tlist network 'lan'\n\tlist network 'guest'\n",
+			want: []string{
+				"➖ firewall.lan.mtu_fix (原 '1')",
+				"✏️ firewall.lan.input: 'ACCEPT' → 'DROP'",
+				"➕ firewall.lan.network = 'lan' 'guest'",
+			},
+		},
+		{
+			name: "option became a list",
+			old:  "config zone 'lan'\n\toption network 'lan'\n",
+			new:  "config zone 'lan'\n\tlist network 'lan'\n",
+			want: []string{"✏️ firewall.lan.network: 'lan' → 'lan'"},
+		},
+		{
+			// Anonymous sections are matched by position, so deleting the
+			// first rule shows up as changes to the rules after it.
+			name: "anonymous section deleted",
+			old:  "config rule\n\toption name 'a'\nconfig rule\n\toption name 'b'\n",
+			new:  "config rule\n\toption name 'b'\n",
+			want: []string{"➖ 删除 firewall.@rule[1] (rule)", "✏️ firewall.@rule[0].name: 'a' → 'b'"},
+		},
+		{
+			name: "sensitive values masked",
+			old:  "config wifi-iface 'wlan0'\n\toption key 'old-secret'\n",
+			new:  "config wifi-iface 'wlan0'\n\toption key 'new-secret'\n\toption sae_password 'pw'\n",
+			want: []string{"✏️ firewall.wlan0.key: ****** → ******", "➕ firewall.wlan0.sae_password = ******"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, c := range Diff(mustParse(t, tt.old), mustParse(t, tt.new)) {
+				got = append(got, c.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Diff =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
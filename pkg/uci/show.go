@@ -0,0 +1,77 @@
+package uci
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var anonymousRe = regexp.MustCompile(`^@([A-Za-z0-9_-]+)\[(-?\d+)\]$`)
+
+// ParseShow parses the output of "uci show". Sections shown as @type[n] are
+// anonymous. "uci show" prints one-element lists like plain options, so
+// only options with several values are marked as lists.
+func ParseShow(text string) ([]*Package, error) {
+	var pkgs []*Package
+	byName := make(map[string]*Package)
+	sections := make(map[string]*Section)
+
+	sc := bufio.NewScanner(strings.NewReader(text))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing '='", n)
+		}
+		parts := strings.SplitN(key, ".", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("line %d: malformed key %q", n, key)
+		}
+		values, err := splitWords(value)
+		if err != nil {
+			values = []string{""}
+			if strings.TrimSpace(value) != "" {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+		}
+
+		pkg, ok := byName[parts[0]]
+		if !ok {
+			pkg = &Package{Name: parts[0]}
+			byName[pkg.Name] = pkg
+			pkgs = append(pkgs, pkg)
+		}
+		secKey := parts[0] + "." + parts[1]
+
+		if len(parts) == 2 {
+			sec := &Section{Type: values[0]}
+			if !anonymousRe.MatchString(parts[1]) {
+				sec.Name = parts[1]
+			}
+			pkg.Sections = append(pkg.Sections, sec)
+			sections[secKey] = sec
+			continue
+		}
+		sec, ok := sections[secKey]
+		if !ok {
+			return nil, fmt.Errorf("line %d: option of unknown section %s", n, secKey)
+		}
+		sec.Options = append(sec.Options, &Option{Name: parts[2], Values: values, List: len(values) > 1})
+	}
+	return pkgs, sc.Err()
+}
+
+// Find returns the package with the given name or nil.
+func Find(pkgs []*Package, name string) *Package {
+	for _, p := range pkgs {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
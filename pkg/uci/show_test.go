@@ -0,0 +1,99 @@
+package uci
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseShow(t *testing.T) {
+	text := `firewall.@defaults[0]=defaults
+firewall.@defaults[0].input='ACCEPT'
+firewall.lan=zone
+firewall.lan.network='lan'
+firewall.@rule[0]=rule
+firewall.@rule[0].name='it'\''s'
+firewall.@rule[0].proto='tcp' 'udp'
+firewall.@rule[1]=rule
+firewall.@rule[1].enabled='0'
+network.loopback=interface
+network.loopback.ipaddr='127.0.0.1'
+`
+	pkgs, err := ParseShow(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 || pkgs[0].Name != "firewall" || pkgs[1].Name != "network" {
+		t.Fatalf("packages = %v, want firewall and network", pkgs)
+	}
+	fw := Find(pkgs, "firewall")
+	if Find(pkgs, "dhcp") != nil {
+		t.Error("Find(dhcp) should be nil")
+	}
+
+	for i, want := range []struct{ name, typ string }{{"", "defaults"}, {"lan", "zone"}, {"", "rule"}, {"", "rule"}} {
+		s := fw.Sections[i]
+		if s.Name != want.name || s.Type != want.typ {
+			t.Errorf("section %d = %s/%s, want %s/%s", i, s.Name, s.Type, want.name, want.typ)
+		}
+	}
+
+	tests := []struct {
+		section, option string
+		values          []string
+		list            bool
+	}{
+		// A one-element list cannot be told from an option.
+		{"lan", "network", []string{"lan"}, false},
+		{"@rule[0]", "proto", []string{"tcp", "udp"}, true},
+		{"@rule[0]", "name", []string{"it's"}, false},
+		{"@rule[1]", "enabled", []string{"0"}, false},
+	}
+	for _, tt := range tests {
+		o := fw.Section(tt.section).Option(tt.option)
+		if o == nil || !slices.Equal(o.Values, tt.values) || o.List != tt.list {
+			t.Errorf("%s.%s = %+v, want %q (list %v)", tt.section, tt.option, o, tt.values, tt.list)
+		}
+	}
+}
+
+func TestParseShowWireless(t *testing.T) {
+	text := `wireless.radio0=wifi-device
+wireless.radio0.channel='36'
+wireless.@wifi-device[1]=wifi-device
+wireless.@wifi-device[1].channel='1'
+wireless.@wifi-iface[0]=wifi-iface
+wireless.@wifi-iface[0].device='radio0'
+wireless.@wifi-iface[0].ssid='home'
+wireless.@wifi-iface[1]=wifi-iface
+wireless.@wifi-iface[1].ssid='guest'
+`
+	pkgs, err := ParseShow(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := Find(pkgs, "wireless")
+	for i, want := range []string{"radio0", "@wifi-device[1]", "@wifi-iface[0]", "@wifi-iface[1]"} {
+		if got := w.ID(i); got != want {
+			t.Errorf("ID(%d) = %q, want %q", i, got, want)
+		}
+	}
+	for id, ssid := range map[string]string{"@wifi-iface[0]": "home", "@wifi-iface[1]": "guest"} {
+		if s := w.Section(id); s == nil || s.Name != "" || s.Get("ssid") != ssid {
+			t.Errorf("%s = %+v, want anonymous section with ssid %q", id, s, ssid)
+		}
+	}
+}
+
+func TestParseShowErrors(t *testing.T) {
+	tests := []string{
+		"firewall.lan\n",
+		"firewall=package\n",
+		"firewall.lan.name='lan'\n",
+		"firewall.lan=zone\nfirewall.lan.name='open\n",
+	}
+	for _, text := range tests {
+		if _, err := ParseShow(text); err == nil {
+			t.Errorf("ParseShow(%q) succeeded, want an error", text)
+		}
+	}
+}
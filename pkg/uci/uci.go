@@ -0,0 +1,206 @@
+// Package uci models OpenWrt UCI configuration: packages made of typed
+// sections holding options and lists.
+package uci
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Masked is shown in place of the values of sensitive options.
+const Masked = "******"
+
+var sensitiveRe = regexp.MustCompile(`(?i)(key|pass|psk|secret|token)`)
+
+// Sensitive reports whether the named option holds secrets such as Wi-Fi
+// keys or passwords, whose values must not be shown or logged.
+func Sensitive(option string) bool {
+	return sensitiveRe.MatchString(option)
+}
+
+// Option is a single option or, when List is set, a list of values.
+type Option struct {
+	Name   string
+	Values []string
+	List   bool
+}
+
+// Value returns the value of a plain option or the list values joined by
+// spaces.
+func (o *Option) Value() string {
+	return strings.Join(o.Values, " ")
+}
+
+// Section is a "config" block. Anonymous sections have an empty Name.
+type Section struct {
+	Name    string
+	Type    string
+	Options []*Option
+}
+
+// Option returns the named option or nil.
+func (s *Section) Option(name string) *Option {
+	for _, o := range s.Options {
+		if o.Name == name {
+			return o
+		}
+	}
+	return nil
+}
+
+// Get returns the value of the named option, or "" if it is not set.
+func (s *Section) Get(name string) string {
+	if o := s.Option(name); o != nil {
+		return o.Value()
+	}
+	return ""
+}
+
+// Package is one configuration file such as /etc/config/firewall.
+type Package struct {
+	Name     string
+	Sections []*Section
+}
+
+// Section returns the section with the given name or @type[index] id.
+func (p *Package) Section(id string) *Section {
+	for i, s := range p.Sections {
+		if p.ID(i) == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// ID returns the name of the i-th section or, for anonymous sections, its
+// @type[n] form counting only sections of the same type.
+func (p *Package) ID(i int) string {
+	s := p.Sections[i]
+	if s.Name != "" {
+		return s.Name
+	}
+	n := 0
+	for _, o := range p.Sections[:i] {
+		if o.Type == s.Type {
+			n++
+		}
+	}
+	return fmt.Sprintf("@%s[%d]", s.Type, n)
+}
+
+// ParseExport parses the output of "uci export", which may hold several
+// packages.
+func ParseExport(text string) ([]*Package, error) {
+	var pkgs []*Package
+	var pkg *Package
+	var sec *Section
+
+	sc := bufio.NewScanner(strings.NewReader(text))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words, err := splitWords(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+
+		switch words[0] {
+		case "package":
+			if len(words) != 2 {
+				return nil, fmt.Errorf("line %d: malformed package", n)
+			}
+			pkg = &Package{Name: words[1]}
+			pkgs = append(pkgs, pkg)
+			sec = nil
+		case "config":
+			if len(words) < 2 || len(words) > 3 {
+				return nil, fmt.Errorf("line %d: malformed section", n)
+			}
+			if pkg == nil {
+				return nil, fmt.Errorf("line %d: section outside a package", n)
+			}
+			sec = &Section{Type: words[1]}
+			if len(words) == 3 {
+				sec.Name = words[2]
+			}
+			pkg.Sections = append(pkg.Sections, sec)
+		case "option", "list":
+			if len(words) != 3 {
+				return nil, fmt.Errorf("line %d: malformed %s", n, words[0])
+			}
+			if sec == nil {
+				return nil, fmt.Errorf("line %d: %s outside a section", n, words[0])
+			}
+			if words[0] == "option" {
+				sec.Options = append(sec.Options, &Option{Name: words[1], Values: []string{words[2]}})
+				continue
+			}
+			if o := sec.Option(words[1]); o != nil && o.List {
+				o.Values = append(o.Values, words[2])
+			} else {
+				sec.Options = append(sec.Options, &Option{Name: words[1], Values: []string{words[2]}, List: true})
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown keyword %q", n, words[0])
+		}
+	}
+	return pkgs, sc.Err()
+}
+
+// splitWords splits a line into shell-like words, honouring single and
+// double quotes and backslash escapes.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var cur strings.Builder
+	inWord := false
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		case ch == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			cur.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case ch == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				cur.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			inWord = true
+		case ch == '\\' && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+			inWord = true
+		default:
+			cur.WriteByte(ch)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty line")
+	}
+	return words, nil
+}
@@ -0,0 +1,163 @@
+package uci
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{`option name 'lan'`, []string{"option", "name", "lan"}},
+		{`option name 'it'\''s'`, []string{"option", "name", "it's"}},
+		{`option name ''`, []string{"option", "name", ""}},
+		{`option name "say \"hi\""`, []string{"option", "name", `say "hi"`}},
+		{`option name a\ b`, []string{"option", "name", "a b"}},
+		{"list\tproto  'tcp'", []string{"list", "proto", "tcp"}},
+	}
+	for _, tt := range tests {
+		got, err := splitWords(tt.line)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, %v; want %q", tt.line, got, err, tt.want)
+		}
+	}
+
+	for _, line := range []string{`option name 'open`, `option name "open`, "   "} {
+		if got, err := splitWords(line); err == nil {
+			t.Errorf("splitWords(%q) = %q, want an error", line, got)
+		}
+	}
+}
+
+const firewallExport = `package firewall
+
+config defaults
+	option input 'ACCEPT'
+
+config zone 'lan'
+	option name 'lan'
+	list network 'lan'
+	list network 'guest'
+
+config rule
+	option name 'Allow-SSH'
+	option dest_port '22'
+
+config rule
+	option name 'it'\''s'
+	list proto 'tcp'
+
+config redirect 'homeops_nas'
+	option src_dport '8443'
+`
+
+func TestParseExport(t *testing.T) {
+	pkgs, err := ParseExport(firewallExport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Name != "firewall" {
+		t.Fatalf("packages = %v, want firewall", pkgs)
+	}
+	p := pkgs[0]
+
+	var ids []string
+	for i := range p.Sections {
+		ids = append(ids, p.ID(i))
+	}
+	if want := []string{"@defaults[0]", "lan", "@rule[0]", "@rule[1]", "homeops_nas"}; !slices.Equal(ids, want) {
+		t.Errorf("section IDs = %q, want %q", ids, want)
+	}
+
+	tests := []struct {
+		section, option string
+		values          []string
+		list            bool
+	}{
+		{"@defaults[0]", "input", []string{"ACCEPT"}, false},
+		{"lan", "network", []string{"lan", "guest"}, true},
+		{"@rule[0]", "dest_port", []string{"22"}, false},
+		{"@rule[1]", "name", []string{"it's"}, false},
+		{"@rule[1]", "proto", []string{"tcp"}, true},
+		{"homeops_nas", "src_dport", []string{"8443"}, false},
+	}
+	for _, tt := range tests {
+		s := p.Section(tt.section)
+		if s == nil {
+			t.Errorf("section %s missing", tt.section)
+			continue
+		}
+		o := s.Option(tt.option)
+		if o == nil || !slices.Equal(o.Values, tt.values) || o.List != tt.list {
+			t.Errorf("%s.%s = %+v, want %q (list %v)", tt.section, tt.option, o, tt.values, tt.list)
+		}
+	}
+	if got := p.Section("lan").Get("network"); got != "lan guest" {
+		t.Errorf("Get(network) = %q, want %q", got, "lan guest")
+	}
+}
+
+func TestParseExportErrors(t *testing.T) {
+	tests := []string{
+		"config rule\n",
+		"package firewall\noption name 'x'\n",
+		"package firewall\nconfig rule\noption name\n",
+		"package firewall\nconfig rule 'a' 'b'\n",
+		"package firewall\nbogus line\n",
+		"package firewall\nconfig rule\noption name 'open\n",
+	}
+	for _, text := range tests {
+		if _, err := ParseExport(text); err == nil {
+			t.Errorf("ParseExport(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestAnonymousRenumbering(t *testing.T) {
+	pkgs, err := ParseExport(firewallExport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pkgs[0]
+
+	// Deleting the first rule shifts the index of the rules after it.
+	p.Sections = slices.Delete(p.Sections, 2, 3)
+	if got := p.ID(2); got != "@rule[0]" {
+		t.Errorf("ID(2) = %q, want @rule[0]", got)
+	}
+	if got := p.Section("@rule[0]").Get("name"); got != "it's" {
+		t.Errorf("@rule[0] name = %q, want the former @rule[1]", got)
+	}
+	if s := p.Section("@rule[1]"); s != nil {
+		t.Errorf("@rule[1] = %+v, want nil", s)
+	}
+	if got := p.ID(3); got != "homeops_nas" {
+		t.Errorf("ID(3) = %q, want homeops_nas", got)
+	}
+}
+
+func TestValidSection(t *testing.T) {
+	for s, want := range map[string]bool{
+		"lan": true, "homeops_nas": true, "@rule[0]": true, "@rule[-1]": true,
+		"@wifi-iface[0]": true, "@wifi-device[1]": true,
+		"": false, "wifi-iface": false, "@rule": false, "@rule[x]": false,
+		"lan;reboot": false, "@rule[0].name": false,
+	} {
+		if got := ValidSection(s); got != want {
+			t.Errorf("ValidSection(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestSensitive(t *testing.T) {
+	for name, want := range map[string]bool{
+		"key": true, "sae_password": true, "psk": true, "auth_secret": true, "Token": true,
+		"ssid": false, "encryption": false, "name": false,
+	} {
+		if got := Sensitive(name); got != want {
+			t.Errorf("Sensitive(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/yingxiaomo/homeops/pkg/lifecycle"
+	tele "gopkg.in/telebot.v3"
+)
+
+const ctxKey = "ctx"
+
+// Ctx returns the context for remote work done on behalf of c. Unless a
+// handler narrowed it with WithCtx it is cancelled on shutdown.
+func Ctx(c tele.Context) context.Context {
+	if ctx, ok := c.Get(ctxKey).(context.Context); ok {
+		return ctx
+	}
+	return lifecycle.Context()
+}
+
+// WithCtx sets the context that Ctx returns for c.
+func WithCtx(c tele.Context, ctx context.Context) {
+	c.Set(ctxKey, ctx)
+}
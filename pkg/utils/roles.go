@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Role is the access level of a user. Higher roles include lower ones.
+type Role int
+
+const (
+	RoleNone Role = iota
+	// RoleViewer can open menus and read status and lists.
+	RoleViewer
+	// RoleOperator can additionally toggle features and restart services.
+	RoleOperator
+	// RoleAdmin can additionally reboot, edit firewall rules and grant roles.
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleNone:     "none",
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("role(%d)", int(r))
+}
+
+// ParseRole parses a role name such as "operator".
+func ParseRole(s string) (Role, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for r, name := range roleNames {
+		if r != RoleNone && name == s {
+			return r, true
+		}
+	}
+	return RoleNone, false
+}
+
+func (r Role) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *Role) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	role, ok := ParseRole(s)
+	if !ok {
+		return fmt.Errorf("unknown role %q", s)
+	}
+	*r = role
+	return nil
+}
+
+// RequireRole reports whether the sender holds at least role. If not, the
+// callback is answered (or the command replied to) with a denial.
+func RequireRole(c tele.Context, role Role) bool {
+	user := c.Sender()
+	if user != nil && HasRole(user.ID, role) {
+		return true
+	}
+
+	msg := fmt.Sprintf("⛔ 此操作需要 %s 角色", role)
+	if c.Callback() != nil {
+		c.Respond(&tele.CallbackResponse{Text: msg, ShowAlert: true})
+	} else {
+		c.Send(msg)
+	}
+	return false
+}
@@ -16,10 +16,16 @@ import (
 
 var (
 	permMutex   sync.RWMutex
-	permissions map[string][]string
+	permissions map[string]*UserGrant
 	permFile    = "permissions.json"
 )
 
+// UserGrant is the permissions.json entry for one user.
+type UserGrant struct {
+	Role     Role     `json:"role"`
+	Features []string `json:"features"`
+}
+
 func init() {
 	LoadPermissions()
 }
@@ -28,7 +34,7 @@ func LoadPermissions() {
 	permMutex.Lock()
 	defer permMutex.Unlock()
 
-	permissions = make(map[string][]string)
+	permissions = make(map[string]*UserGrant)
 	data, err := ioutil.ReadFile(permFile)
 	if err != nil {
 		if !os.IsNotExist(err) {
@@ -37,8 +43,31 @@ func LoadPermissions() {
 		return
 	}
 
-	if err := json.Unmarshal(data, &permissions); err != nil {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
 		log.Printf("Error unmarshalling permissions: %v", err)
+		return
+	}
+
+	for uid, entry := range raw {
+		// Older files store a bare feature list per user. Those users could
+		// press every button before roles existed, so they become operators.
+		var legacy []string
+		if err := json.Unmarshal(entry, &legacy); err == nil {
+			permissions[uid] = &UserGrant{Role: RoleOperator, Features: legacy}
+			log.Printf("Migrated legacy permissions for %s to role %s", uid, RoleOperator)
+			continue
+		}
+
+		var grant UserGrant
+		if err := json.Unmarshal(entry, &grant); err != nil {
+			log.Printf("Error unmarshalling permissions for %s: %v", uid, err)
+			continue
+		}
+		if grant.Role == RoleNone {
+			grant.Role = RoleViewer
+		}
+		permissions[uid] = &grant
 	}
 }
 
@@ -57,8 +86,40 @@ func SavePermissions() {
 	}
 }
 
+// IsConfigAdmin reports whether userID is listed in ADMIN_ID. Such admins
+// cannot be demoted from within the bot.
+func IsConfigAdmin(userID int64) bool {
+	for _, id := range config.Get().AdminIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
 func IsAdmin(userID int64) bool {
-	return userID == config.AppConfig.AdminID
+	return HasRole(userID, RoleAdmin)
+}
+
+// GetRole returns the role of userID, or RoleNone for unknown users.
+func GetRole(userID int64) Role {
+	if IsConfigAdmin(userID) {
+		return RoleAdmin
+	}
+
+	permMutex.RLock()
+	defer permMutex.RUnlock()
+
+	grant, ok := permissions[strconv.FormatInt(userID, 10)]
+	if !ok {
+		return RoleNone
+	}
+	return grant.Role
+}
+
+// HasRole reports whether userID holds role or a higher one.
+func HasRole(userID int64, role Role) bool {
+	return GetRole(userID) >= role
 }
 
 func HasPermission(userID int64, feature string) bool {
@@ -70,7 +131,7 @@ func HasPermission(userID int64, feature string) bool {
 	defer permMutex.RUnlock()
 
 	uidStr := strconv.FormatInt(userID, 10)
-	features, ok := permissions[uidStr]
+	grant, ok := permissions[uidStr]
 	if !ok {
 		return false
 	}
@@ -79,8 +140,9 @@ func HasPermission(userID int64, feature string) bool {
 		return true
 	}
 
-	for _, f := range features {
-		if f == feature || f == "all" {
+	// Features are hierarchical: a grant for "wrt" also covers "wrt.firewall".
+	for _, f := range grant.Features {
+		if f == feature || f == "all" || strings.HasPrefix(feature, f+".") {
 			return true
 		}
 	}
@@ -92,19 +154,19 @@ func GrantPermission(userIDStr string, feature string) bool {
 	permMutex.Lock()
 	defer permMutex.Unlock()
 
-	features, ok := permissions[userIDStr]
+	grant, ok := permissions[userIDStr]
 	if !ok {
-		features = []string{}
+		grant = &UserGrant{Role: RoleViewer}
+		permissions[userIDStr] = grant
 	}
 
-	for _, f := range features {
+	for _, f := range grant.Features {
 		if f == feature {
 			return false // Already has permission
 		}
 	}
 
-	features = append(features, feature)
-	permissions[userIDStr] = features
+	grant.Features = append(grant.Features, feature)
 	return true
 }
 
@@ -112,14 +174,14 @@ func RevokePermission(userIDStr string, feature string) bool {
 	permMutex.Lock()
 	defer permMutex.Unlock()
 
-	features, ok := permissions[userIDStr]
+	grant, ok := permissions[userIDStr]
 	if !ok {
 		return false
 	}
 
 	newFeatures := []string{}
 	found := false
-	for _, f := range features {
+	for _, f := range grant.Features {
 		if f == feature {
 			found = true
 			continue
@@ -131,23 +193,53 @@ func RevokePermission(userIDStr string, feature string) bool {
 		return false
 	}
 
-	if len(newFeatures) == 0 {
+	if len(newFeatures) == 0 && grant.Role == RoleViewer {
 		delete(permissions, userIDStr)
 	} else {
-		permissions[userIDStr] = newFeatures
+		grant.Features = newFeatures
 	}
 
 	return true
 }
 
-func GetPermissions() map[string][]string {
+// SetRole assigns role to a user, creating the entry if needed. It returns
+// false if the user already had that role.
+func SetRole(userIDStr string, role Role) bool {
+	permMutex.Lock()
+	defer permMutex.Unlock()
+
+	grant, ok := permissions[userIDStr]
+	if !ok {
+		permissions[userIDStr] = &UserGrant{Role: role}
+		return true
+	}
+	if grant.Role == role {
+		return false
+	}
+	grant.Role = role
+	return true
+}
+
+// RemoveUser deletes every grant of a user.
+func RemoveUser(userIDStr string) bool {
+	permMutex.Lock()
+	defer permMutex.Unlock()
+
+	if _, ok := permissions[userIDStr]; !ok {
+		return false
+	}
+	delete(permissions, userIDStr)
+	return true
+}
+
+func GetPermissions() map[string]UserGrant {
 	permMutex.RLock()
 	defer permMutex.RUnlock()
 
 	// Return copy
-	copy := make(map[string][]string)
+	copy := make(map[string]UserGrant)
 	for k, v := range permissions {
-		copy[k] = v
+		copy[k] = UserGrant{Role: v.Role, Features: append([]string(nil), v.Features...)}
 	}
 	return copy
 }